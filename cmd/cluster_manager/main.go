@@ -1,18 +1,133 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/rxanders35/graphene/pkg/auth"
 	"github.com/rxanders35/graphene/pkg/cluster_manager"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"github.com/rxanders35/graphene/pkg/tracing"
 )
 
 func main() {
 	masterAddr := flag.String("master-addr", "localhost:9090", "master's grpc address")
+	httpAddr := flag.String("http-addr", "localhost:9091", "master's admin http address")
+	metaDir := flag.String("meta-dir", "./meta", "directory the bucket ledger is kept in")
+	rebalanceInterval := flag.Duration("rebalance-interval", time.Hour, "time between automatic volume rebalances, 0 to only rebalance on demand")
+	rebalanceRate := flag.Int64("rebalance-rate", 32<<20, "max bytes per second a rebalance copies, 0 for unlimited")
+	rebalanceThreshold := flag.Float64("rebalance-threshold", 0.1, "how far above its share of the volume bytes a server may go before volumes move off it")
+	rebalanceLocationTTL := flag.Duration("rebalance-location-ttl", time.Minute, "how long old copies of moved or drained volumes are kept for gateways with cached locations, match the gateways' -location-ttl")
+	volumeServerName := flag.String("tls-volume-server-name", "", "name volume server client certificates must be issued to, empty accepts any certificate -tls-ca signed")
+	lifecycleInterval := flag.Duration("lifecycle-interval", time.Hour, "time between passes applying the buckets' lifecycle rules, 0 disables them")
+	eventWebhooksFile := flag.String("event-webhooks", "", "JSON file of the webhooks object events are posted to, empty for none")
+	eventMaxAttempts := flag.Int("event-max-attempts", 5, "attempts to deliver an event to a webhook before it's dead-lettered")
+	eventRetryBackoff := flag.Duration("event-retry-backoff", time.Second, "wait before retrying a webhook delivery, doubling after each attempt")
+	writeTokenSecretFile := flag.String("write-token-secret-file", "", "file of secrets shared with the volume servers to sign write tokens with, one per line, the first signs; empty hands out no tokens")
+	writeTokenTTL := flag.Duration("write-token-ttl", 5*time.Minute, "how long a write token stays valid")
+	writeTokenMaxBytes := flag.Int64("write-token-max-bytes", 1<<30, "largest upload a write token allows")
+	tlsConfig := tlsconfig.RegisterFlags(tlsconfig.ClientAuthRequire)
+	traceConfig := tracing.RegisterFlags()
 
 	flag.Parse()
 	log.Printf("Starting")
 
-	s := cluster_manager.NewGRPCServer(*masterAddr)
-	s.Run()
+	shutdownTracing, err := tracing.Setup(context.Background(), "cluster_manager", *traceConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing. Why: %v", err)
+	}
+
+	certs, err := tlsconfig.Load(*tlsConfig)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificates. Why: %v", err)
+	}
+
+	writeTokens := cluster_manager.WriteTokenConfig{
+		Validity: *writeTokenTTL,
+		MaxBytes: *writeTokenMaxBytes,
+	}
+	if *writeTokenSecretFile != "" {
+		writeTokens.Secrets, err = auth.LoadTokenSecrets(*writeTokenSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to load write token secrets. Why: %v", err)
+		}
+	}
+
+	s := cluster_manager.NewGRPCServer(*masterAddr, cluster_manager.RebalanceConfig{
+		Interval:    *rebalanceInterval,
+		BytesPerSec: *rebalanceRate,
+		Threshold:   *rebalanceThreshold,
+		LocationTTL: *rebalanceLocationTTL,
+	}, cluster_manager.TLSConfig{
+		Certs:            certs,
+		VolumeServerName: *volumeServerName,
+	}, writeTokens)
+
+	dedup, err := cluster_manager.NewDedup(*metaDir, s)
+	if err != nil {
+		log.Fatalf("Failed to load the dedup index. Why: %v", err)
+	}
+
+	if *eventMaxAttempts < 1 {
+		log.Fatalf("-event-max-attempts must be at least 1")
+	}
+	eventsConfig := cluster_manager.EventsConfig{
+		MaxAttempts:  *eventMaxAttempts,
+		RetryBackoff: *eventRetryBackoff,
+	}
+	if *eventWebhooksFile != "" {
+		eventsConfig.Webhooks, err = cluster_manager.LoadWebhooks(*eventWebhooksFile)
+		if err != nil {
+			log.Fatalf("Failed to load event webhooks. Why: %v", err)
+		}
+	}
+	events, err := cluster_manager.NewEvents(*metaDir, s, eventsConfig)
+	if err != nil {
+		log.Fatalf("Failed to start event delivery. Why: %v", err)
+	}
+
+	ledger, err := cluster_manager.NewLedger(*metaDir, s, dedup, events)
+	if err != nil {
+		log.Fatalf("Failed to load the ledger. Why: %v", err)
+	}
+	if *lifecycleInterval > 0 {
+		go ledger.RunLifecycle(*lifecycleInterval)
+	}
+
+	h := cluster_manager.NewHTTPServer(*httpAddr, s, ledger)
+	go func() {
+		if err := h.Run(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("admin http server run error. Why: %v", err)
+		}
+	}()
+
+	go s.Run()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shut down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ledger.Close(); err != nil {
+		log.Printf("Failed to close the ledger. Why: %v", err)
+	}
+	if err := dedup.Close(); err != nil {
+		log.Printf("Failed to close the dedup index. Why: %v", err)
+	}
+	if err := events.Close(); err != nil {
+		log.Printf("Failed to close the dead letter log. Why: %v", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush spans. Why: %v", err)
+	}
 }
@@ -10,22 +10,72 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/rxanders35/graphene/pkg/auth"
 	"github.com/rxanders35/graphene/pkg/gateway"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"github.com/rxanders35/graphene/pkg/tracing"
 )
 
 func main() {
 	masterAddr := flag.String("master-addr", "localhost:9090", "master's grpc address")
 	gatewayAddr := flag.String("gateway-addr", "127.0.0.1:8081", "gateway's http address")
+	locationTTL := flag.Duration("location-ttl", time.Minute, "how long volume locations are cached")
+	negativeTTL := flag.Duration("negative-location-ttl", 5*time.Second, "how long unknown volumes are cached as missing")
+	watchTopology := flag.Bool("watch-topology", false, "keep the location cache fresh by streaming topology changes from the master")
+	dedup := flag.Bool("dedup", false, "store identical uploads once, as needles shared through the master's dedup index")
+	credentials := flag.String("credentials", "", "JSON file of API keys and their bucket grants, requests aren't authenticated without it")
+	tokenSecretFile := flag.String("write-token-secret-file", "", "file of the master's write token secrets, one per line; signs the deletes sent to volume servers that don't trust the gateway's certificate")
+	// clients authenticate with API keys and presigned URLs rather than certificates
+	tlsConfig := tlsconfig.RegisterFlags(tlsconfig.ClientAuthNone)
+	traceConfig := tracing.RegisterFlags()
 
 	flag.Parse()
 
-	m, err := gateway.NewMasterclient(*masterAddr)
+	shutdownTracing, err := tracing.Setup(context.Background(), "gateway", *traceConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing. Why: %v", err)
+	}
+
+	certs, err := tlsconfig.Load(*tlsConfig)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificates. Why: %v", err)
+	}
+
+	m, err := gateway.NewMasterclient(*masterAddr, certs)
 	if err != nil {
 		log.Fatalf("Failed to init master client on API gateway. Why: %v", err)
 	}
 
-	h, err := gateway.NewGatewayHandler(m)
-	s, err := gateway.NewGatewayServer(*gatewayAddr, h)
+	l := gateway.NewLocationCache(m, *locationTTL, *negativeTTL)
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if *watchTopology {
+		go l.Watch(watchCtx)
+	}
+
+	var authenticator *gateway.Authenticator
+	if *credentials != "" {
+		keys, err := auth.LoadKeyring(*credentials)
+		if err != nil {
+			log.Fatalf("Failed to load API keys. Why: %v", err)
+		}
+		authenticator = gateway.NewAuthenticator(keys)
+	}
+
+	var tokens *auth.TokenSecrets
+	if *tokenSecretFile != "" {
+		tokens, err = auth.LoadTokenSecrets(*tokenSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to load write token secrets. Why: %v", err)
+		}
+	}
+
+	h, err := gateway.NewGatewayHandler(m, l, certs, *dedup, tokens)
+	if err != nil {
+		log.Fatalf("Failed to init API gateway handler. Why: %v", err)
+	}
+	s, err := gateway.NewGatewayServer(*gatewayAddr, h, certs, authenticator)
 	if err != nil {
 		log.Fatalf("Failed to init API gateway. Why: %v", err)
 	}
@@ -48,4 +98,7 @@ func main() {
 	if err := s.Shutdown(ctx); err != nil {
 		log.Fatalf("graceful shutdown failed. Why: %v", err)
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush spans. Why: %v", err)
+	}
 }
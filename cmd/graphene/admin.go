@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+)
+
+func (c *cli) master() (pb.MasterServiceClient, func(), error) {
+	conn, err := grpc.NewClient(c.masterAddr, c.certs.DialOption())
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialing master: %w", err)
+	}
+	return pb.NewMasterServiceClient(conn), func() { conn.Close() }, nil
+}
+
+func rpcContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+func (c *cli) clusterStatus(args []string) error {
+	if _, err := subcommand("cluster status", args, 0, 0, nil); err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	st, err := m.GetClusterStatus(ctx, &pb.GetClusterStatusRequest{})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(st)
+	}
+
+	t := newTable("FIELD", "VALUE")
+	t.row("servers alive", st.GetServersAlive())
+	t.row("servers down", st.GetServersDown())
+	t.row("volumes", st.GetVolumes())
+	t.row("volumes read-only", st.GetVolumesReadOnly())
+	t.row("needles", st.GetNeedleCount())
+	t.row("stored", humanBytes(st.GetSizeBytes()))
+	t.row("live", humanBytes(st.GetLiveBytes()))
+	t.row("disk total", humanBytes(st.GetDiskTotalBytes()))
+	t.row("disk free", humanBytes(st.GetDiskFreeBytes()))
+	t.row("corrupt needles", st.GetCorruptNeedles())
+	return t.flush()
+}
+
+func (c *cli) listServers(args []string) error {
+	if _, err := subcommand("server list", args, 0, 0, nil); err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := m.ListVolumeServers(ctx, &pb.ListVolumeServersRequest{})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp)
+	}
+
+	return c.printServers(resp.GetServers()...)
+}
+
+func (c *cli) printServers(servers ...*pb.VolumeServerStatus) error {
+	t := newTable("SERVER", "ADDRESS", "STATE", "CLASS", "LAST HEARTBEAT", "DISK FREE", "DISK TOTAL", "VOLUMES")
+	for _, s := range servers {
+		t.row(s.GetServerId(), s.GetHttpAddress(), s.GetState(), s.GetStorageClass(), ago(s.GetLastHeartbeat()),
+			humanBytes(s.GetDiskFreeBytes()), humanBytes(s.GetDiskTotalBytes()), len(s.GetVolumeIds()))
+	}
+	return t.flush()
+}
+
+func (c *cli) listVolumes(args []string) error {
+	if _, err := subcommand("volume list", args, 0, 0, nil); err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := m.ListVolumes(ctx, &pb.ListVolumesRequest{})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp)
+	}
+
+	t := newTable("VOLUME", "SERVERS", "SIZE", "GARBAGE", "NEEDLES", "STATE", "TTL")
+	for _, v := range resp.GetVolumes() {
+		t.row(v.GetVolumeId(), replicaAddrs(v), humanBytes(v.GetSizeBytes()), percent(v.GetGarbageRatio()),
+			v.GetNeedleCount(), volumeState(v), ttl(v.GetTtlSeconds()))
+	}
+	return t.flush()
+}
+
+func (c *cli) describeVolume(args []string) error {
+	args, err := subcommand("volume describe", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	v, err := m.DescribeVolume(ctx, &pb.DescribeVolumeRequest{VolumeId: args[0]})
+	if err != nil {
+		return err
+	}
+	return c.printVolume(v)
+}
+
+func (c *cli) printVolume(v *pb.VolumeStatus) error {
+	if c.output == "json" {
+		return printJSON(v)
+	}
+
+	t := newTable("FIELD", "VALUE")
+	t.row("volume", v.GetVolumeId())
+	for _, r := range v.GetReplicas() {
+		t.row("replica", fmt.Sprintf("%s (%s)", r.GetHttpAddress(), r.GetServerId()))
+	}
+	t.row("state", volumeState(v))
+	t.row("size", humanBytes(v.GetSizeBytes()))
+	t.row("live", humanBytes(v.GetLiveBytes()))
+	t.row("garbage", percent(v.GetGarbageRatio()))
+	t.row("needles", v.GetNeedleCount())
+	t.row("ttl", ttl(v.GetTtlSeconds()))
+	if v.GetExpiresAt() != 0 {
+		t.row("expires", time.Unix(v.GetExpiresAt(), 0).Format(time.RFC3339))
+	}
+	return t.flush()
+}
+
+func replicaAddrs(v *pb.VolumeStatus) string {
+	addrs := make([]string, 0, len(v.GetReplicas()))
+	for _, r := range v.GetReplicas() {
+		addrs = append(addrs, r.GetHttpAddress())
+	}
+	return strings.Join(addrs, ",")
+}
+
+func volumeState(v *pb.VolumeStatus) string {
+	switch {
+	case v.GetReadOnly():
+		return "read-only"
+	case v.GetSealed():
+		return "sealed"
+	}
+	return "writable"
+}
+
+func ttl(seconds uint32) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+)
+
+var eventTypes = map[string]pb.ObjectEvent_Type{
+	"ObjectCreated": pb.ObjectEvent_OBJECT_CREATED,
+	"ObjectDeleted": pb.ObjectEvent_OBJECT_DELETED,
+}
+
+func (c *cli) watchEvents(args []string) error {
+	req := &pb.SubscribeEventsRequest{}
+	var eventType string
+	if _, err := subcommand("events watch", args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&req.Bucket, "bucket", "", "only events in this bucket")
+		fs.StringVar(&req.Prefix, "prefix", "", "only events for paths under this prefix")
+		fs.StringVar(&eventType, "type", "", "only ObjectCreated or ObjectDeleted events")
+	}); err != nil {
+		return err
+	}
+	if eventType != "" {
+		t, ok := eventTypes[eventType]
+		if !ok {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+		req.Types = []pb.ObjectEvent_Type{t}
+	}
+
+	conn, err := grpc.NewClient(c.masterAddr, c.certs.DialOption())
+	if err != nil {
+		return fmt.Errorf("dialing master: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stream, err := pb.NewEventServiceClient(conn).Subscribe(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if c.output == "json" {
+			line, err := jsonOpts.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			continue
+		}
+		var name string
+		for n, t := range eventTypes {
+			if t == ev.GetType() {
+				name = n
+			}
+		}
+		line := fmt.Sprintf("%s  %-13s  %s/%s  version=%s", ev.GetTime().AsTime().Format(time.RFC3339), name, ev.GetBucket(), ev.GetPath(), ev.GetVersionId())
+		if ev.GetDeleteMarker() {
+			line += "  delete-marker"
+		}
+		if ev.GetFatId() != "" {
+			line += fmt.Sprintf("  id=%s  size=%d  etag=%s", ev.GetFatId(), ev.GetSizeBytes(), ev.GetEtag())
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+)
+
+const usage = `graphene operates a graphene cluster.
+
+Usage:
+  graphene [flags] <command> [args]
+
+Commands:
+  put [-ttl d] [-direct] [-idempotency-key k] [-content-addressed] <file|->
+                                        upload a file (or stdin) through the gateway, or straight to a volume server
+  get [-out file] <id>                  download an object to stdout or a file
+  rm <id>...                            delete objects
+  cluster status                        cluster-wide totals
+  server list                           volume servers with heartbeat, capacity and volumes
+  server drain [-wait] <id>             move a volume server's volumes away and remove it
+  volume list                           every volume the master knows about
+  volume describe <id>                  replicas, size, garbage ratio and state of a volume
+  volume vacuum [-threshold r] [id]...  compact the given volumes, or all above the garbage threshold
+  volume seal <id>                      stop assigning writes to a volume
+  volume rebalance [-dry-run]           move sealed volumes to even out bytes across servers
+  fsck [-scrub]                         report problems in the cluster, exits 1 if any are errors
+  events watch [-bucket b] [-prefix p] [-type t]
+                                        stream object events until interrupted
+  quota list                            usage and quotas of every bucket and tenant
+  quota set-bucket [-tenant t] [limits] <bucket>
+                                        replace a bucket's quota and the tenant it belongs to
+  quota set-tenant [limits] <tenant>    replace the quota shared by a tenant's buckets
+
+Flags:
+`
+
+type cli struct {
+	masterAddr  string
+	gatewayAddr string
+	output      string
+	certs       *tlsconfig.Certs
+	httpClient  *http.Client
+}
+
+type command func(c *cli, args []string) error
+
+var commands = map[string]command{
+	"put":              (*cli).put,
+	"get":              (*cli).get,
+	"rm":               (*cli).rm,
+	"cluster status":   (*cli).clusterStatus,
+	"server list":      (*cli).listServers,
+	"server drain":     (*cli).drainServer,
+	"volume list":      (*cli).listVolumes,
+	"volume describe":  (*cli).describeVolume,
+	"volume vacuum":    (*cli).vacuumVolumes,
+	"volume seal":      (*cli).sealVolume,
+	"volume rebalance": (*cli).rebalanceVolumes,
+	"fsck":             (*cli).fsck,
+	"events watch":     (*cli).watchEvents,
+	"quota list":       (*cli).listUsage,
+	"quota set-bucket": (*cli).setBucketQuota,
+	"quota set-tenant": (*cli).setTenantQuota,
+}
+
+func main() {
+	c := &cli{}
+	flag.StringVar(&c.masterAddr, "master-addr", "localhost:9090", "master's grpc address")
+	flag.StringVar(&c.gatewayAddr, "gateway-addr", "127.0.0.1:8081", "gateway's http address")
+	flag.StringVar(&c.output, "o", "table", "output format: table or json")
+	apiKey := flag.String("api-key", os.Getenv("GRAPHENE_API_KEY"), "gateway API key as id:secret, defaults to $GRAPHENE_API_KEY")
+	sign := flag.Bool("sign", false, "sign gateway requests with the API key instead of sending its secret")
+	tlsConfig := tlsconfig.RegisterClientFlags()
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if c.output != "table" && c.output != "json" {
+		fail(fmt.Errorf("unknown output format %q", c.output))
+	}
+
+	certs, err := tlsconfig.Load(*tlsConfig)
+	if err != nil {
+		fail(err)
+	}
+	c.certs = certs
+	c.httpClient = &http.Client{Timeout: time.Minute, Transport: certs.Transport()}
+	if *apiKey != "" {
+		id, secret, ok := strings.Cut(*apiKey, ":")
+		if !ok {
+			fail(fmt.Errorf("-api-key must be id:secret"))
+		}
+		c.httpClient.Transport = &authTransport{base: c.httpClient.Transport, keyID: id, secret: secret, sign: *sign}
+	}
+
+	args := flag.Args()
+	var cmd command
+	if len(args) >= 2 {
+		if cmd = commands[args[0]+" "+args[1]]; cmd != nil {
+			args = args[2:]
+		}
+	}
+	if cmd == nil && len(args) >= 1 {
+		if cmd = commands[args[0]]; cmd != nil {
+			args = args[1:]
+		}
+	}
+	if cmd == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(c, args); err != nil {
+		if errors.Is(err, errIssuesFound) {
+			// the report already says what is wrong
+			os.Exit(1)
+		}
+		fail(err)
+	}
+}
+
+// authTransport authenticates requests to the gateway.
+type authTransport struct {
+	base   http.RoundTripper
+	keyID  string
+	secret string
+	sign   bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.sign {
+		auth.Sign(req, t.keyID, t.secret, time.Now())
+	} else {
+		req.Header.Set("Authorization", auth.SchemeBearer+" "+t.keyID+":"+t.secret)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (c *cli) gatewayURL(format string, args ...any) string {
+	return fmt.Sprintf("%s://%s", c.certs.Scheme(), c.gatewayAddr) + fmt.Sprintf(format, args...)
+}
+
+// subcommand parses a command's own flags and checks its positional argument count.
+func subcommand(name string, args []string, minArgs, maxArgs int, setup func(fs *flag.FlagSet)) ([]string, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if setup != nil {
+		setup(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s, see graphene -h", name)
+	}
+	return fs.Args(), nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "graphene: %v\n", err)
+	os.Exit(1)
+}
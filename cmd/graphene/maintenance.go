@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+const drainPollInterval = time.Second
+
+func (c *cli) drainServer(args []string) error {
+	var wait bool
+	args, err := subcommand("server drain", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.BoolVar(&wait, "wait", false, "wait for the drain to finish, printing its progress")
+	})
+	if err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	var s *pb.VolumeServerStatus
+	var last string
+	for {
+		ctx, cancel := rpcContext()
+		s, err = m.DrainServer(ctx, &pb.DrainServerRequest{ServerId: args[0]})
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !wait || s.GetDrain().GetState() != "RUNNING" {
+			break
+		}
+
+		if line := drainLine(s.GetDrain()); line != last {
+			fmt.Fprintln(os.Stderr, line)
+			last = line
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	if c.output == "json" {
+		return printJSON(s)
+	}
+	if err := c.printServers(s); err != nil {
+		return err
+	}
+	if d := s.GetDrain(); d != nil {
+		fmt.Println(drainLine(d))
+		for _, f := range d.GetFailures() {
+			fmt.Printf("  volume %s: %s\n", f.GetVolumeId(), f.GetReason())
+		}
+	}
+	return nil
+}
+
+func drainLine(d *pb.DrainProgress) string {
+	line := fmt.Sprintf("drain %s: moved %d/%d volumes", d.GetState(), d.GetVolumesMoved(), d.GetVolumesTotal())
+	if d.GetCurrentVolume() != "" {
+		line += ", moving " + d.GetCurrentVolume()
+	}
+	if n := len(d.GetFailures()); n > 0 {
+		line += fmt.Sprintf(", %d failed", n)
+	}
+	return line
+}
+
+func (c *cli) sealVolume(args []string) error {
+	args, err := subcommand("volume seal", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	v, err := m.SealVolume(ctx, &pb.SealVolumeRequest{VolumeId: args[0]})
+	if err != nil {
+		return err
+	}
+	return c.printVolume(v)
+}
+
+func (c *cli) vacuumVolumes(args []string) error {
+	var threshold float64
+	args, err := subcommand("volume vacuum", args, 0, -1, func(fs *flag.FlagSet) {
+		fs.Float64Var(&threshold, "threshold", 0.3, "garbage ratio above which volumes are vacuumed when no ids are given")
+	})
+	if err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	volumeIds := args
+	if len(volumeIds) == 0 {
+		ctx, cancel := rpcContext()
+		resp, err := m.ListVolumes(ctx, &pb.ListVolumesRequest{})
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, v := range resp.GetVolumes() {
+			if v.GetGarbageRatio() >= threshold && !v.GetReadOnly() {
+				volumeIds = append(volumeIds, v.GetVolumeId())
+			}
+		}
+	}
+
+	// compaction rewrites whole volumes, so no deadline here
+	var results []*pb.VacuumVolumeResponse
+	var failed int
+	for _, id := range volumeIds {
+		resp, err := m.VacuumVolume(context.Background(), &pb.VacuumVolumeRequest{VolumeId: id})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "graphene: vacuum of volume %s failed: %v\n", id, err)
+			failed++
+			continue
+		}
+		results = append(results, resp)
+	}
+
+	if c.output == "json" {
+		if err := printJSONList(results); err != nil {
+			return err
+		}
+	} else {
+		t := newTable("VOLUME", "BEFORE", "AFTER", "RECLAIMED")
+		for _, r := range results {
+			t.row(r.GetVolumeId(), humanBytes(r.GetSizeBeforeBytes()), humanBytes(r.GetSizeAfterBytes()),
+				humanBytes(r.GetSizeBeforeBytes()-r.GetSizeAfterBytes()))
+		}
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d volumes failed to vacuum", failed, len(volumeIds))
+	}
+	return nil
+}
+
+func (c *cli) rebalanceVolumes(args []string) error {
+	var dryRun bool
+	var maxMoves uint
+	if _, err := subcommand("volume rebalance", args, 0, 0, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "only print the planned moves")
+		fs.UintVar(&maxMoves, "max-moves", 0, "move at most this many volumes, 0 for as many as it takes")
+	}); err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	plan, err := m.RebalanceVolumes(ctx, &pb.RebalanceVolumesRequest{DryRun: dryRun, MaxMoves: uint32(maxMoves)})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(plan)
+	}
+
+	t := newTable("SERVER", "ADDRESS", "BYTES", "TARGET", "PLANNED")
+	for _, s := range plan.GetServers() {
+		t.row(s.GetServerId(), s.GetHttpAddress(), humanBytes(s.GetVolumeBytes()), humanBytes(s.GetTargetBytes()), humanBytes(s.GetPlannedBytes()))
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	if len(plan.GetMoves()) == 0 {
+		fmt.Println("volumes are balanced, nothing to move")
+		return nil
+	}
+	t = newTable("VOLUME", "FROM", "TO", "SIZE")
+	for _, mv := range plan.GetMoves() {
+		t.row(mv.GetVolumeId(), mv.GetFromAddress(), mv.GetToAddress(), humanBytes(mv.GetSizeBytes()))
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if plan.GetStarted() {
+		fmt.Println("\nmoves started, follow them with volume list")
+	}
+	return nil
+}
+
+// errIssuesFound makes fsck exit 1 once its report is printed.
+var errIssuesFound = errors.New("fsck found errors")
+
+func (c *cli) fsck(args []string) error {
+	var scrub bool
+	if _, err := subcommand("fsck", args, 0, 0, func(fs *flag.FlagSet) {
+		fs.BoolVar(&scrub, "scrub", false, "also start a scrub on every volume server, run fsck again once it finishes to see its findings")
+	}); err != nil {
+		return err
+	}
+
+	m, closeConn, err := c.master()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := m.CheckCluster(ctx, &pb.CheckClusterRequest{Scrub: scrub})
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		if err := printJSON(resp); err != nil {
+			return err
+		}
+	} else {
+		t := newTable("SEVERITY", "VOLUME", "SERVER", "NEEDLE", "MESSAGE")
+		for _, i := range resp.GetIssues() {
+			t.row(i.GetSeverity(), dash(i.GetVolumeId()), dash(i.GetServerId()), dash(i.GetNeedleId()), i.GetMessage())
+		}
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range resp.GetIssues() {
+		if i.GetSeverity() == "ERROR" {
+			return errIssuesFound
+		}
+	}
+	return nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/rxanders35/graphene/pkg/auth"
+)
+
+const (
+	// retries of an upload with the same key return the object of the first one
+	idempotencyKeyHeader = "Idempotency-Key"
+	// content-addressed uploads are placed by the hex SHA-256 of their data
+	contentSHA256Header = "X-Graphene-Content-SHA256"
+)
+
+type putResult struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+type rmResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *cli) put(args []string) error {
+	var ttl, key string
+	var direct, contentAddressed bool
+	args, err := subcommand("put", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&ttl, "ttl", "", "expire the object after this long, e.g. 90s, 12h or 7d")
+		fs.BoolVar(&direct, "direct", false, "upload straight to the volume server with a write token instead of through the gateway")
+		fs.StringVar(&key, "idempotency-key", "", "rerunning with the same key returns the object of the first upload instead of storing it again")
+		fs.BoolVar(&contentAddressed, "content-addressed", false, "name the object after its SHA-256 so the same data is only stored once")
+	})
+	if err != nil {
+		return err
+	}
+
+	var in io.ReadSeeker = os.Stdin
+	var size int64
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		in, size = f, info.Size()
+	}
+
+	// the hash goes ahead of the data, a file is read twice and stdin held in memory
+	var sha string
+	if contentAddressed {
+		if args[0] == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			in, size = bytes.NewReader(data), int64(len(data))
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, in); err != nil {
+			return err
+		}
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		sha = hex.EncodeToString(h.Sum(nil))
+	}
+	body := &countingReader{r: in}
+
+	q := url.Values{}
+	if ttl != "" {
+		q.Set("ttl", ttl)
+	}
+	if contentAddressed {
+		q.Set("content_addressed", "true")
+	}
+
+	var id string
+	if direct {
+		id, err = c.putDirect(q, key, sha, size, body)
+	} else {
+		id, err = c.putThroughGateway(q, key, sha, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	res := putResult{ID: id, File: args[0], Size: body.n}
+	if c.output == "json" {
+		return printValue(res)
+	}
+	t := newTable("ID", "FILE", "SIZE")
+	t.row(res.ID, res.File, humanBytes(uint64(res.Size)))
+	return t.flush()
+}
+
+func (c *cli) putThroughGateway(q url.Values, key, sha string, body io.Reader) (string, error) {
+	writeURL := c.gatewayURL("/v1/gateway/write")
+	if len(q) > 0 {
+		writeURL += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, writeURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	if sha != "" {
+		req.Header.Set(contentSHA256Header, sha)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("invalid response from gateway: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gateway returned %d: %s", resp.StatusCode, out.Error)
+	}
+	return out.ID, nil
+}
+
+// putDirect asks the gateway for a volume and a write token, then uploads to the
+// volume server itself. size caps the token, 0 when it isn't known up front.
+func (c *cli) putDirect(q url.Values, key, sha string, size int64, body io.Reader) (string, error) {
+	if size > 0 {
+		q.Set("size", strconv.FormatInt(size, 10))
+	}
+	assignURL := c.gatewayURL("/v1/gateway/assign")
+	if len(q) > 0 {
+		assignURL += "?" + q.Encode()
+	}
+
+	assignReq, err := http.NewRequest(http.MethodPost, assignURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		assignReq.Header.Set(idempotencyKeyHeader, key)
+	}
+	if sha != "" {
+		assignReq.Header.Set(contentSHA256Header, sha)
+	}
+
+	resp, err := c.httpClient.Do(assignReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var assigned struct {
+		VolumeID       string `json:"volume_id"`
+		URL            string `json:"url"`
+		WriteToken     string `json:"write_token"`
+		IdempotencyKey string `json:"idempotency_key"`
+		Error          string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&assigned); err != nil {
+		return "", fmt.Errorf("invalid response from gateway: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gateway returned %d: %s", resp.StatusCode, assigned.Error)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, assigned.URL, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(auth.WriteTokenHeader, assigned.WriteToken)
+	if assigned.IdempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, assigned.IdempotencyKey)
+	}
+	if sha != "" {
+		req.Header.Set(contentSHA256Header, sha)
+	}
+	if size > 0 {
+		req.ContentLength = size
+	}
+
+	// the API key stays with the gateway, the token is all the volume server needs
+	volumeClient := &http.Client{Timeout: c.httpClient.Timeout, Transport: c.certs.Transport()}
+	volumeResp, err := volumeClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer volumeResp.Body.Close()
+
+	var out struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(volumeResp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("invalid response from volume server: %w", err)
+	}
+	if volumeResp.StatusCode != http.StatusCreated && volumeResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("volume server returned %d: %s", volumeResp.StatusCode, out.Error)
+	}
+	return assigned.VolumeID + ":" + out.ID, nil
+}
+
+func (c *cli) get(args []string) error {
+	var outPath string
+	args, err := subcommand("get", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&outPath, "out", "", "write the object to this file instead of stdout")
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Get(c.gatewayURL("/v1/gateway/read/%s", url.PathEscape(args[0])))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gateway returned %d: %s", resp.StatusCode, errorMessage(resp.Body))
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func (c *cli) rm(args []string) error {
+	args, err := subcommand("rm", args, 1, -1, nil)
+	if err != nil {
+		return err
+	}
+
+	results := make([]rmResult, 0, len(args))
+	var failed int
+	for _, id := range args {
+		res := rmResult{ID: id}
+		if err := c.deleteObject(id); err != nil {
+			res.Error = err.Error()
+			failed++
+		}
+		results = append(results, res)
+	}
+
+	if c.output == "json" {
+		if err := printValue(results); err != nil {
+			return err
+		}
+	} else {
+		t := newTable("ID", "STATUS")
+		for _, r := range results {
+			if r.Error == "" {
+				r.Error = "deleted"
+			}
+			t.row(r.ID, r.Error)
+		}
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d objects failed to delete", failed, len(args))
+	}
+	return nil
+}
+
+func (c *cli) deleteObject(id string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.gatewayURL("/v1/gateway/delete/%s", url.PathEscape(id)), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("gateway returned %d: %s", resp.StatusCode, errorMessage(resp.Body))
+	}
+	return nil
+}
+
+func errorMessage(r io.Reader) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil || body.Error == "" {
+		return "no details"
+	}
+	return body.Error
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
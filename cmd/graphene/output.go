@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type table struct {
+	w *tabwriter.Writer
+}
+
+func newTable(headers ...string) *table {
+	t := &table{w: tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)}
+	fmt.Fprintln(t.w, strings.Join(headers, "\t"))
+	return t
+}
+
+func (t *table) row(cols ...any) {
+	strs := make([]string, len(cols))
+	for i, c := range cols {
+		strs[i] = fmt.Sprint(c)
+	}
+	fmt.Fprintln(t.w, strings.Join(strs, "\t"))
+}
+
+func (t *table) flush() error {
+	return t.w.Flush()
+}
+
+var jsonOpts = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+func printJSON(m proto.Message) error {
+	body, err := jsonOpts.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return printIndented(body)
+}
+
+func printJSONList[T proto.Message](msgs []T) error {
+	list := make([]json.RawMessage, 0, len(msgs))
+	for _, m := range msgs {
+		body, err := jsonOpts.Marshal(m)
+		if err != nil {
+			return err
+		}
+		list = append(list, body)
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return printIndented(body)
+}
+
+func printValue(v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return printIndented(body)
+}
+
+func printIndented(body []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return err
+	}
+	_, err := fmt.Println(buf.String())
+	return err
+}
+
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func percent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+func ago(unix int64) string {
+	if unix == 0 {
+		return "never"
+	}
+	return time.Since(time.Unix(unix, 0)).Round(time.Second).String() + " ago"
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+)
+
+func (c *cli) ledger() (pb.LedgerServiceClient, func(), error) {
+	conn, err := grpc.NewClient(c.masterAddr, c.certs.DialOption())
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialing master: %w", err)
+	}
+	return pb.NewLedgerServiceClient(conn), func() { conn.Close() }, nil
+}
+
+// quotaFlags registers the limits of a quota, all of them default to no limit.
+func quotaFlags(fs *flag.FlagSet, q *pb.Quota) {
+	fs.Int64Var(&q.MaxBytes, "max-bytes", 0, "bytes writes are rejected past, 0 for no limit")
+	fs.Int64Var(&q.MaxObjects, "max-objects", 0, "object versions writes are rejected past, 0 for no limit")
+	fs.Int64Var(&q.SoftMaxBytes, "soft-max-bytes", 0, "bytes writes are warned about past, 0 for no limit")
+	fs.Int64Var(&q.SoftMaxObjects, "soft-max-objects", 0, "object versions writes are warned about past, 0 for no limit")
+}
+
+func (c *cli) listUsage(args []string) error {
+	if _, err := subcommand("quota list", args, 0, 0, nil); err != nil {
+		return err
+	}
+
+	l, closeConn, err := c.ledger()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := l.ListUsage(ctx, &pb.ListUsageRequest{})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(resp)
+	}
+
+	t := newTable("KIND", "NAME", "TENANT", "BYTES", "MAX BYTES", "OBJECTS", "MAX OBJECTS")
+	for _, tq := range resp.GetTenants() {
+		t.row("tenant", tq.GetTenant(), "-", humanBytes(uint64(tq.GetUsage().GetBytes())), limit(tq.GetQuota().GetMaxBytes(), true),
+			tq.GetUsage().GetObjects(), limit(tq.GetQuota().GetMaxObjects(), false))
+	}
+	for _, bq := range resp.GetBuckets() {
+		tenant := bq.GetTenant()
+		if tenant == "" {
+			tenant = "-"
+		}
+		t.row("bucket", bq.GetBucket(), tenant, humanBytes(uint64(bq.GetUsage().GetBytes())), limit(bq.GetQuota().GetMaxBytes(), true),
+			bq.GetUsage().GetObjects(), limit(bq.GetQuota().GetMaxObjects(), false))
+	}
+	return t.flush()
+}
+
+func limit(n int64, bytes bool) string {
+	switch {
+	case n == 0:
+		return "-"
+	case bytes:
+		return humanBytes(uint64(n))
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+func (c *cli) setBucketQuota(args []string) error {
+	req := &pb.BucketQuota{Quota: &pb.Quota{}}
+	args, err := subcommand("quota set-bucket", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&req.Tenant, "tenant", "", "tenant whose quota the bucket also counts against, empty for none")
+		quotaFlags(fs, req.Quota)
+	})
+	if err != nil {
+		return err
+	}
+	req.Bucket = args[0]
+
+	l, closeConn, err := c.ledger()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := l.SetBucketQuota(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func (c *cli) setTenantQuota(args []string) error {
+	req := &pb.TenantQuota{Quota: &pb.Quota{}}
+	args, err := subcommand("quota set-tenant", args, 1, 1, func(fs *flag.FlagSet) {
+		quotaFlags(fs, req.Quota)
+	})
+	if err != nil {
+		return err
+	}
+	req.Tenant = args[0]
+
+	l, closeConn, err := c.ledger()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := rpcContext()
+	defer cancel()
+
+	resp, err := l.SetTenantQuota(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+)
+
+const usage = `volume_inspect looks inside a volume's .dat and .idx files without a running volume server.
+
+Usage:
+  volume_inspect [flags] dump <volume>                  list every needle in the .dat file
+  volume_inspect [flags] check [--repair] <volume>      cross-check the .idx against the .dat
+  volume_inspect [flags] extract [--out file] <volume> <needle id>
+                                                        write a single needle to a file or stdout
+
+<volume> is a volume id, resolved inside -data-dir, or the path of its .dat or .idx file.
+--repair rewrites the volume with only its intact, indexed needles. Stop the volume server first.
+
+Flags:
+`
+
+var (
+	dataDir = flag.String("data-dir", "./data", "volume server data directory volume ids are resolved in")
+	output  = flag.String("o", "table", "output format: table or json")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "dump":
+		err = dump(args)
+	case "check":
+		err = check(args)
+	case "extract":
+		err = extract(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "volume_inspect: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// volumeBase resolves a volume argument to its path without extension.
+func volumeBase(arg string) (string, error) {
+	if id, err := uuid.Parse(arg); err == nil {
+		return filepath.Join(*dataDir, needle.VolumeFileName(id)), nil
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(arg, needle.DataFileExtension), needle.IdxFileExtension)
+	if _, err := os.Stat(base + needle.DataFileExtension); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+type needleRow struct {
+	Offset    uint64 `json:"offset"`
+	ID        string `json:"id"`
+	Size      uint32 `json:"size"`
+	ExpiresAt uint64 `json:"expires_at"`
+	Checksum  string `json:"checksum"`
+	CRC       string `json:"crc"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
+type problemRow struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Offset uint64 `json:"offset"`
+	Detail string `json:"detail"`
+}
+
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: volume_inspect dump <volume>")
+	}
+
+	base, err := volumeBase(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r, err := needle.CheckVolume(base)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]needleRow, 0, len(r.Needles))
+	for _, n := range r.Needles {
+		row := needleRow{
+			Offset:    n.Offset,
+			ID:        uuid.UUID(n.ID).String(),
+			Size:      n.Size,
+			ExpiresAt: n.ExpiresAt,
+			Checksum:  fmt.Sprintf("%08x", n.Checksum),
+			CRC:       "ok",
+			State:     n.State,
+		}
+		if n.Err != nil {
+			row.CRC, row.Error = "bad", n.Err.Error()
+		}
+		rows = append(rows, row)
+	}
+
+	if *output == "json" {
+		return printJSON(rows)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OFFSET\tID\tSIZE\tEXPIRES\tCHECKSUM\tCRC\tSTATE")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%s\n", row.Offset, row.ID, row.Size, expires(row.ExpiresAt), row.Checksum, row.CRC, row.State)
+	}
+	return w.Flush()
+}
+
+func check(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "rewrite the volume with only its intact, indexed needles")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: volume_inspect check [--repair] <volume>")
+	}
+
+	base, err := volumeBase(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r, err := needle.CheckVolume(base)
+	if err != nil {
+		return err
+	}
+
+	states := make(map[string]int)
+	for _, n := range r.Needles {
+		states[n.State]++
+	}
+	problems := make([]problemRow, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		problems = append(problems, problemRow{Kind: p.Kind, ID: uuid.UUID(p.ID).String(), Offset: p.Offset, Detail: p.Detail})
+	}
+
+	if *output == "json" {
+		err = printJSON(map[string]any{
+			"volume":      filepath.Base(base),
+			"data_bytes":  r.DataSize,
+			"idx_records": r.IdxRecords,
+			"needles":     states,
+			"problems":    problems,
+		})
+	} else {
+		err = printCheck(base, r, states, problems)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *repair {
+		if r.Clean() {
+			fmt.Fprintln(os.Stderr, "Volume is clean, nothing to repair")
+			return nil
+		}
+		res, err := needle.RepairVolume(base)
+		if err != nil {
+			return fmt.Errorf("repair failed: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Repaired volume: kept %d needles, dropped %d, %d -> %d bytes\n", res.Kept, res.Dropped, res.SizeBefore, res.SizeAfter)
+		return nil
+	}
+
+	if !r.Clean() {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func printCheck(base string, r *needle.CheckReport, states map[string]int, problems []problemRow) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "volume\t%s\n", filepath.Base(base))
+	fmt.Fprintf(w, "data bytes\t%d\n", r.DataSize)
+	fmt.Fprintf(w, "idx records\t%d\n", r.IdxRecords)
+	for _, state := range []string{needle.NeedleLive, needle.NeedleExpired, needle.NeedleDeleted, needle.NeedleSuperseded, needle.NeedleOrphan, needle.NeedleCorrupt} {
+		fmt.Fprintf(w, "%s needles\t%d\n", state, states[state])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("\nNo problems found")
+		return nil
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tID\tOFFSET\tDETAIL")
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", p.Kind, p.ID, p.Offset, p.Detail)
+	}
+	return w.Flush()
+}
+
+func extract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	outPath := fs.String("out", "", "write the needle to this file instead of stdout")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: volume_inspect extract [--out file] <volume> <needle id>")
+	}
+
+	base, err := volumeBase(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	needleId, err := parseNeedleID(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	data, _, err := needle.ExtractNeedle(base, needleId)
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*outPath, data, 0644)
+}
+
+// parseNeedleID accepts a bare needle id or a volumeId:needleId object id.
+func parseNeedleID(s string) (uuid.UUID, error) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid needle id %q", s)
+	}
+	return id, nil
+}
+
+func expires(unix uint64) string {
+	if unix == 0 {
+		return "never"
+	}
+	return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
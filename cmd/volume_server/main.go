@@ -9,10 +9,14 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"github.com/rxanders35/graphene/pkg/tracing"
 	"github.com/rxanders35/graphene/pkg/volume_server"
 	"github.com/rxanders35/graphene/pkg/volume_server/needle"
 )
@@ -20,30 +24,83 @@ import (
 func main() {
 	mastergRPCAddr := flag.String("master-addr", "localhost:9090", "master's grpc address")
 	volumeHTTPAddr := flag.String("addr", ":8080", "volume's http address")
+	volumeGRPCAddr := flag.String("grpc-addr", ":8070", "volume's grpc address, other volume servers copy volumes from it")
 	dataDir := flag.String("data-dir", "./data", "volume's data directory")
+	indexKind := flag.String("index", "memory", "index backend for new volumes: memory or sorted (mmap'd sorted file, low RAM)")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 5*time.Second, "how often the volume server reports to the master")
+	cacheSize := flag.Int64("cache-size", 64<<20, "bytes of hot needles cached in memory, 0 disables the read cache")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Minute, "how often volume indexes are checkpointed to speed up startup, 0 only checkpoints on shutdown")
+	scrubRate := flag.Int64("scrub-rate", 8<<20, "max bytes per second the background scrubber reads, 0 for unlimited")
+	scrubInterval := flag.Duration("scrub-interval", 24*time.Hour, "time between scrub passes, 0 to only scrub on demand")
+	storageClass := flag.String("storage-class", "", "storage class of the disks behind this server, e.g. erasure-coded; lifecycle rules move objects between classes. Empty for standard")
+	writeTokenSecretFile := flag.String("write-token-secret-file", "", "file of the master's write token secrets, one per line; when set every write needs a token, and deletes and admin calls without a trusted certificate need a request signed with them")
+	trustedNames := flag.String("tls-trusted-names", "", "comma-separated names the master's and gateways' client certificates are issued to, only they may delete needles and call the admin API; empty trusts any certificate -tls-ca signed")
+	tlsConfig := tlsconfig.RegisterFlags(tlsconfig.ClientAuthRequire)
+	// clients upload straight to the HTTP listener with write tokens, the master's and
+	// gateways' certificates are still checked when they send one
+	tlsConfig.RegisterHTTPFlag(tlsconfig.ClientAuthOptional)
+	traceConfig := tracing.RegisterFlags()
 
 	flag.Parse()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), "volume_server", *traceConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing. Why: %v", err)
+	}
+
 	serverId, err := getOrCreateServerID(*dataDir)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	volume, err := needle.NewVolume(*dataDir, serverId)
+	kind, err := needle.ParseIndexKind(*indexKind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := volume_server.NewStore(*dataDir, serverId, kind, volume_server.NewReadCache(*cacheSize))
 	if err != nil {
 		log.Fatalf("Couldn't init volume backend. Why: %v", err)
 	}
 
-	masterClient, err := volume_server.NewMasterClient(*mastergRPCAddr)
+	certs, err := tlsconfig.Load(*tlsConfig)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificates. Why: %v", err)
+	}
+
+	masterClient, err := volume_server.NewMasterClient(*mastergRPCAddr, certs, *storageClass)
 	if err != nil {
 		log.Fatalf("Couldn't connect to master. Why: %v", err)
 	}
 
-	httpSrv, err := volume_server.NewHTTPServer(*volumeHTTPAddr, volume, masterClient, serverId)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	go store.RunCheckpoints(bgCtx, *checkpointInterval)
+
+	scrubber := volume_server.NewScrubber(store, masterClient, serverId, *scrubRate, *scrubInterval)
+	go scrubber.Run(bgCtx)
+
+	copySrv := volume_server.NewCopyServer(*volumeGRPCAddr, store, certs)
+	go func() {
+		if err := copySrv.Run(); err != nil {
+			log.Fatalf("copy server run error. Why: %v", err)
+		}
+	}()
+
+	var tokens *auth.TokenSecrets
+	if *writeTokenSecretFile != "" {
+		tokens, err = auth.LoadTokenSecrets(*writeTokenSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to load write token secrets. Why: %v", err)
+		}
+	}
+
+	httpSrv, err := volume_server.NewHTTPServer(*volumeHTTPAddr, *volumeGRPCAddr, store, masterClient, serverId, scrubber, certs, tokens, splitNames(*trustedNames))
 	if err != nil {
 		log.Fatalf("Couldn't init volume server. Why: %v", err)
 	}
 
+	go masterClient.RunHeartbeats(bgCtx, *heartbeatInterval, serverId, *volumeHTTPAddr, *volumeGRPCAddr, store)
+
 	go func() {
 		if err := httpSrv.Run(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server run error. Why: %v", err)
@@ -57,6 +114,7 @@ func main() {
 	<-quit
 
 	log.Println("Shut down")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -64,6 +122,24 @@ func main() {
 	if err := httpSrv.Shutdown(ctx); err != nil {
 		log.Fatalf("graceful shutdown failed. Why: %v", err)
 	}
+	copySrv.Stop()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush spans. Why: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		log.Fatalf("Couldn't close volumes. Why: %v", err)
+	}
+}
+
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 func getOrCreateServerID(dataDir string) (uuid.UUID, error) {
@@ -0,0 +1,166 @@
+// Package auth holds the gateway's API keys, the request signing scheme and the
+// write tokens clients upload straight to volume servers with.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpDelete = "delete"
+	OpList   = "list"
+
+	// the keyring file is checked for changes at most this often
+	reloadCheckInterval = time.Second
+)
+
+// Grant allows ops on buckets matching Bucket: an exact name, a prefix ending in *,
+// or * for every bucket. Objects addressed by fat id belong to no bucket, only * covers them.
+type Grant struct {
+	Bucket string   `json:"bucket"`
+	Ops    []string `json:"ops"`
+}
+
+// Key is an API key. Its secret is sent as a bearer token or used to sign requests.
+type Key struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	Grants    []Grant   `json:"grants"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero never expires, set it on the old key while rotating
+}
+
+// Allows reports whether the key grants op on bucket.
+func (k *Key) Allows(bucket, op string) bool {
+	for _, g := range k.Grants {
+		if !matchBucket(g.Bucket, bucket) {
+			continue
+		}
+		for _, o := range g.Ops {
+			if o == op || o == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+func matchBucket(pattern, bucket string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if bucket == "" {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(bucket, prefix)
+	}
+	return pattern == bucket
+}
+
+type keyringFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Keyring is the set of API keys read from a JSON file. Edits to the file are picked
+// up without a restart, so keys are rotated by adding the new one, moving clients
+// over and removing or expiring the old one.
+type Keyring struct {
+	path string
+
+	mu      sync.Mutex
+	keys    map[string]*Key
+	mtime   time.Time
+	checked time.Time
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	r := &Keyring{path: path}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+func (r *Keyring) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parsing %s: %w", r.path, err)
+	}
+
+	keys := make(map[string]*Key, len(f.Keys))
+	for _, k := range f.Keys {
+		if err := validateKey(k); err != nil {
+			return fmt.Errorf("key %q in %s: %w", k.ID, r.path, err)
+		}
+		if _, dup := keys[k.ID]; dup {
+			return fmt.Errorf("key %q is listed twice in %s", k.ID, r.path)
+		}
+		keys[k.ID] = k
+	}
+
+	r.keys, r.mtime = keys, info.ModTime()
+	return nil
+}
+
+func validateKey(k *Key) error {
+	if k.ID == "" || strings.ContainsAny(k.ID, ":, ") {
+		return errors.New("id must be set and can't contain ':', ',' or spaces")
+	}
+	if len(k.Secret) < 16 {
+		return errors.New("secret must be at least 16 characters")
+	}
+	for _, g := range k.Grants {
+		for _, op := range g.Ops {
+			switch op {
+			case OpRead, OpWrite, OpDelete, OpList, "*":
+			default:
+				return fmt.Errorf("unknown op %q", op)
+			}
+		}
+	}
+	return nil
+}
+
+// Key looks up a key by id, reloading the file first if it changed. A file that
+// fails to load keeps the previous keys, it may be halfway rewritten.
+func (r *Keyring) Key(id string) (*Key, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= reloadCheckInterval {
+		r.checked = time.Now()
+		if info, err := os.Stat(r.path); err == nil && !info.ModTime().Equal(r.mtime) {
+			if err := r.load(); err != nil {
+				log.Printf("Failed to reload API keys, keeping the old ones. Why: %v", err)
+			} else {
+				log.Printf("Reloaded %d API keys", len(r.keys))
+			}
+		}
+	}
+
+	k, ok := r.keys[id]
+	return k, ok
+}
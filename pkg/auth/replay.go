@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers signatures until they expire, so a captured request or
+// token can't be used a second time.
+type ReplayCache struct {
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	lastPruned time.Time
+	// how often expired signatures are dropped
+	pruneEvery time.Duration
+}
+
+func NewReplayCache(pruneEvery time.Duration) *ReplayCache {
+	return &ReplayCache{
+		seen:       make(map[string]time.Time),
+		lastPruned: time.Now(),
+		pruneEvery: pruneEvery,
+	}
+}
+
+// FirstUse records a signature until expires, reporting false if it was seen before.
+func (r *ReplayCache) FirstUse(signature string, expires, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastPruned) > r.pruneEvery {
+		for sig, exp := range r.seen {
+			if now.After(exp) {
+				delete(r.seen, sig)
+			}
+		}
+		r.lastPruned = now
+	}
+
+	if _, ok := r.seen[signature]; ok {
+		return false
+	}
+	r.seen[signature] = expires
+	return true
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A write token lets a client upload straight to a volume server, sent as
+//
+//	X-Graphene-Write-Token: <base64url claims>.<base64url HMAC-SHA256>
+//
+// The master signs it with a secret it shares with the volume servers.
+const WriteTokenHeader = "X-Graphene-Write-Token"
+
+var (
+	ErrInvalidWriteToken = errors.New("invalid write token")
+	ErrWriteTokenExpired = errors.New("write token has expired")
+)
+
+// WriteClaims is what a write token allows: one upload of at most MaxBytes to
+// VolumeID with TTLSeconds and IdempotencyKey, or of the data hashing to ContentSHA256,
+// before ExpiresAt.
+type WriteClaims struct {
+	VolumeID       string `json:"vid"`
+	MaxBytes       int64  `json:"max"`
+	TTLSeconds     uint32 `json:"ttl,omitempty"`
+	IdempotencyKey string `json:"key,omitempty"`
+	ContentSHA256  string `json:"sha,omitempty"`
+	ExpiresAt      int64  `json:"exp"` // unix seconds
+	Nonce          string `json:"n"`   // tells apart tokens with the same claims so each can only be used once
+}
+
+// TokenSecrets signs and verifies write tokens. A nil *TokenSecrets issues no tokens.
+type TokenSecrets struct {
+	secrets [][]byte
+}
+
+// LoadTokenSecrets reads a file of secrets, one per line. The first signs new tokens and
+// all of them verify, so a secret is rotated by putting a new one first everywhere, then
+// removing the old one once the tokens it signed have expired.
+func LoadTokenSecrets(path string) (*TokenSecrets, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &TokenSecrets{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) < 16 {
+			return nil, fmt.Errorf("secrets in %s must be at least 16 characters", path)
+		}
+		s.secrets = append(s.secrets, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.secrets) == 0 {
+		return nil, fmt.Errorf("no secrets in %s", path)
+	}
+	return s, nil
+}
+
+// Issue signs a token for claims, filling in its nonce.
+func (s *TokenSecrets) Issue(claims WriteClaims) string {
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	claims.Nonce = hex.EncodeToString(nonce)
+
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(s.secrets[0], encoded))
+}
+
+// Verify checks a token was signed with one of the secrets and hasn't expired.
+func (s *TokenSecrets) Verify(token string, now time.Time) (WriteClaims, error) {
+	var claims WriteClaims
+
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, ErrInvalidWriteToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return claims, ErrInvalidWriteToken
+	}
+	valid := false
+	for _, secret := range s.secrets {
+		if hmac.Equal(mac, tokenMAC(secret, encoded)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return claims, ErrInvalidWriteToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, ErrInvalidWriteToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidWriteToken
+	}
+	if now.Unix() > claims.ExpiresAt {
+		return claims, ErrWriteTokenExpired
+	}
+	return claims, nil
+}
+
+func tokenMAC(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
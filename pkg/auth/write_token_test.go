@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTokenSecrets(t *testing.T, secrets ...string) *TokenSecrets {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte("# signing secret first\n"+strings.Join(secrets, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadTokenSecrets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWriteTokens(t *testing.T) {
+	const (
+		current  = "current-secret-0123456789"
+		previous = "previous-secret-0123456789"
+	)
+	now := time.Now()
+	claims := WriteClaims{VolumeID: "vol", MaxBytes: 1 << 20, TTLSeconds: 60, IdempotencyKey: "key", ExpiresAt: now.Add(time.Minute).Unix()}
+
+	tests := []struct {
+		name    string
+		issuer  []string
+		tamper  func(token string) string
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", issuer: []string{current}},
+		{name: "signed with the previous secret", issuer: []string{previous}},
+		{name: "expired", issuer: []string{current}, now: now.Add(2 * time.Minute), wantErr: ErrWriteTokenExpired},
+		{name: "unknown secret", issuer: []string{"other-secret-0123456789"}, wantErr: ErrInvalidWriteToken},
+		{name: "claims changed", issuer: []string{current}, tamper: func(token string) string {
+			_, sig, _ := strings.Cut(token, ".")
+			forged := claims
+			forged.MaxBytes = 1 << 40
+			return encodeClaims(t, forged) + "." + sig
+		}, wantErr: ErrInvalidWriteToken},
+		{name: "signature flipped", issuer: []string{current}, tamper: func(token string) string {
+			b := []byte(token)
+			b[len(b)-2] ^= 1
+			return string(b)
+		}, wantErr: ErrInvalidWriteToken},
+		{name: "no signature", issuer: []string{current}, tamper: func(token string) string {
+			encoded, _, _ := strings.Cut(token, ".")
+			return encoded
+		}, wantErr: ErrInvalidWriteToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newTokenSecrets(t, tt.issuer...).Issue(claims)
+			if tt.tamper != nil {
+				token = tt.tamper(token)
+			}
+			at := now
+			if !tt.now.IsZero() {
+				at = tt.now
+			}
+
+			got, err := newTokenSecrets(t, current, previous).Verify(token, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got.Nonce = ""
+			if got != claims {
+				t.Fatalf("Verify = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestWriteTokensAreUnique(t *testing.T) {
+	s := newTokenSecrets(t, "current-secret-0123456789")
+	claims := WriteClaims{VolumeID: "vol", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	if s.Issue(claims) == s.Issue(claims) {
+		t.Fatal("two tokens for the same claims are the same, the second couldn't be used")
+	}
+}
+
+func encodeClaims(t *testing.T, claims WriteClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func TestClusterRequests(t *testing.T) {
+	const secret = "cluster-secret-0123456789"
+	now := time.Now()
+	const target = "http://volume/v1/volume/delete/needle?volume=vol"
+
+	tests := []struct {
+		name    string
+		request func(s *TokenSecrets) *http.Request
+		wantErr bool
+	}{
+		{name: "signed", request: func(s *TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			s.SignRequest(req, now)
+			return req
+		}},
+		{name: "unsigned", wantErr: true, request: func(*TokenSecrets) *http.Request {
+			return httptest.NewRequest(http.MethodDelete, target, nil)
+		}},
+		{name: "signed with another secret", wantErr: true, request: func(*TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			newTokenSecrets(t, "other-secret-0123456789").SignRequest(req, now)
+			return req
+		}},
+		{name: "signed with an API key's credential", wantErr: true, request: func(*TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			Sign(req, "gateway-key", secret, now)
+			return req
+		}},
+		{name: "other needle", wantErr: true, request: func(s *TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			s.SignRequest(req, now)
+			req.URL.Path = "/v1/volume/delete/other"
+			return req
+		}},
+		{name: "nonce dropped", wantErr: true, request: func(s *TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			s.SignRequest(req, now)
+			req.URL.RawQuery = "volume=vol"
+			return req
+		}},
+		{name: "stale", wantErr: true, request: func(s *TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			s.SignRequest(req, now.Add(-MaxClockSkew-time.Minute))
+			return req
+		}},
+		{name: "date changed", wantErr: true, request: func(s *TokenSecrets) *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, target, nil)
+			s.SignRequest(req, now)
+			req.Header.Set(DateHeader, strconv.FormatInt(now.Unix()-1, 10))
+			return req
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTokenSecrets(t, secret)
+			sig, expires, err := s.VerifyRequest(tt.request(s), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyRequest = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (sig == "" || !expires.After(now)) {
+				t.Fatalf("VerifyRequest = %q, %v", sig, expires)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package cluster_manager
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serverStateAlive    = "ALIVE"
+	serverStateDraining = "DRAINING"
+	serverStateDrained  = "DRAINED"
+	serverStateDown     = "DOWN"
+)
+
+func (g *GRPCServer) ListVolumeServers(ctx context.Context, req *pb.ListVolumeServersRequest) (*pb.ListVolumeServersResponse, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	volumesByServer := make(map[uuid.UUID][]string)
+	for _, v := range g.volumes {
+		volumesByServer[v.server] = append(volumesByServer[v.server], v.id.String())
+	}
+
+	resp := &pb.ListVolumeServersResponse{}
+	for id := range g.volumeServers {
+		resp.Servers = append(resp.Servers, g.serverStatus(id, volumesByServer[id]))
+	}
+
+	sort.Slice(resp.Servers, func(i, j int) bool {
+		return resp.Servers[i].HttpAddress < resp.Servers[j].HttpAddress
+	})
+	return resp, nil
+}
+
+func (g *GRPCServer) ListVolumes(ctx context.Context, req *pb.ListVolumesRequest) (*pb.ListVolumesResponse, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	resp := &pb.ListVolumesResponse{}
+	for _, v := range g.volumes {
+		resp.Volumes = append(resp.Volumes, g.volumeStatus(v))
+	}
+
+	sort.Slice(resp.Volumes, func(i, j int) bool {
+		return resp.Volumes[i].VolumeId < resp.Volumes[j].VolumeId
+	})
+	return resp, nil
+}
+
+func (g *GRPCServer) DescribeVolume(ctx context.Context, req *pb.DescribeVolumeRequest) (*pb.VolumeStatus, error) {
+	volumeId, err := uuid.Parse(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	v, ok := g.volumes[volumeId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume id not found: %s", volumeId)
+	}
+	return g.volumeStatus(v), nil
+}
+
+func (g *GRPCServer) GetClusterStatus(ctx context.Context, req *pb.GetClusterStatusRequest) (*pb.ClusterStatus, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	resp := &pb.ClusterStatus{
+		Volumes:        uint32(len(g.volumes)),
+		CorruptNeedles: uint32(len(g.corruptNeedles)),
+	}
+
+	for id := range g.volumeServers {
+		if g.serverAlive(id) {
+			resp.ServersAlive++
+		} else {
+			resp.ServersDown++
+		}
+		if st, ok := g.servers[id]; ok {
+			resp.DiskTotalBytes += st.diskTotal
+			resp.DiskFreeBytes += st.diskFree
+		}
+	}
+
+	for _, v := range g.volumes {
+		if v.readOnly {
+			resp.VolumesReadOnly++
+		}
+		resp.NeedleCount += v.needleCount
+		resp.SizeBytes += v.sizeBytes
+		resp.LiveBytes += v.liveBytes
+	}
+
+	return resp, nil
+}
+
+// serverStatus renders a volume server for the admin API. Callers must hold g.mu.
+func (g *GRPCServer) serverStatus(id uuid.UUID, volumeIds []string) *pb.VolumeServerStatus {
+	s := &pb.VolumeServerStatus{
+		ServerId:    id.String(),
+		HttpAddress: g.volumeServers[id],
+		State:       serverStateAlive,
+		VolumeIds:   volumeIds,
+	}
+	sort.Strings(s.VolumeIds)
+	if st, ok := g.servers[id]; ok {
+		s.LastHeartbeat = st.lastHeartbeat.Unix()
+		s.DiskTotalBytes = st.diskTotal
+		s.DiskFreeBytes = st.diskFree
+		s.GrpcAddress = st.grpcAddr
+		s.StorageClass = st.storageClass
+		if st.draining {
+			s.State = serverStateDraining
+		}
+	}
+	if !g.serverAlive(id) {
+		s.State = serverStateDown
+	}
+	if d, ok := g.drains[id]; ok {
+		s.Drain = d.proto()
+		if d.state == drainDone {
+			s.HttpAddress, s.GrpcAddress = d.addr, d.grpcAddr
+			s.State = serverStateDrained
+		}
+	}
+	return s
+}
+
+// volumeStatus renders a volume for the admin API. Callers must hold g.mu.
+func (g *GRPCServer) volumeStatus(v *volume) *pb.VolumeStatus {
+	s := &pb.VolumeStatus{
+		VolumeId: v.id.String(),
+		Replicas: []*pb.VolumeReplica{{
+			ServerId:    v.server.String(),
+			HttpAddress: g.volumeServers[v.server],
+		}},
+		SizeBytes:    v.sizeBytes,
+		LiveBytes:    v.liveBytes,
+		GarbageRatio: v.garbageRatio(),
+		NeedleCount:  v.needleCount,
+		ReadOnly:     v.readOnly,
+		Sealed:       v.sealed,
+		TtlSeconds:   uint32(v.ttl.Seconds()),
+	}
+	if !v.expiresAt.IsZero() {
+		s.ExpiresAt = v.expiresAt.Unix()
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package cluster_manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TLSConfig secures the master's listeners and its calls to volume servers.
+type TLSConfig struct {
+	Certs            *tlsconfig.Certs // nil for plaintext
+	VolumeServerName string           // name volume server certificates must be issued to, empty accepts any the CA signed
+}
+
+// authorizeVolumeServer checks that the caller of an RPC only volume servers make
+// presented a volume server certificate. With TLS off anyone is let through.
+func (g *GRPCServer) authorizeVolumeServer(ctx context.Context) error {
+	if g.tls.Certs == nil {
+		return nil
+	}
+
+	cert, err := tlsconfig.PeerCertificate(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "volume servers must present a client certificate")
+	}
+	if g.tls.VolumeServerName != "" && !tlsconfig.HasName(cert, g.tls.VolumeServerName) {
+		return status.Errorf(codes.PermissionDenied, "certificate of %q is not a volume server's", cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// signVolumeRequest signs a needle delete or admin call to a volume server with the
+// write token secret, for volume servers that don't trust the master's certificate.
+func (g *GRPCServer) signVolumeRequest(req *http.Request) {
+	if g.writeTokens.Secrets != nil {
+		g.writeTokens.Secrets.SignRequest(req, time.Now())
+	}
+}
+
+// volumeURL builds the URL of a volume server HTTP API path.
+func (g *GRPCServer) volumeURL(addr, format string, args ...any) string {
+	return fmt.Sprintf("%s://%s", g.tls.Certs.Scheme(), addr) + fmt.Sprintf(format, args...)
+}
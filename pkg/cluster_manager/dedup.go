@@ -0,0 +1,420 @@
+package cluster_manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const dedupFileName = "content.log"
+
+// content is a needle shared by every upload of the same bytes. Each upload holds
+// its own reference, so releasing one twice can't take another's.
+type content struct {
+	needle    needleKey
+	sizeBytes int64
+	refs      map[uuid.UUID]struct{}
+}
+
+// dedupRecord is one line of the dedup index's log. It adds a reference to the
+// content with the hash, or releases or drops one.
+type dedupRecord struct {
+	Hash     string `json:"sha256"`
+	Ref      string `json:"ref,omitempty"`
+	VolumeId string `json:"volume_id,omitempty"`
+	NeedleId string `json:"needle_id,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Release  bool   `json:"release,omitempty"` // drops Ref, the last one drops the hash
+	Drop     bool   `json:"drop,omitempty"`    // drops the hash, its needle is gone
+}
+
+// Dedup indexes needles by the SHA-256 of their content across the cluster. A
+// needle stays as long as something references it: a bucket object or an id handed
+// to a client. The index lives in memory and is persisted like the Ledger's.
+//
+// Needles deleted straight through a volume server's API stay in it until the next
+// upload of their content finds them gone.
+type Dedup struct {
+	file     *os.File
+	byHash   map[[sha256.Size]byte]*content
+	byNeedle map[needleKey][sha256.Size]byte
+	byRef    map[uuid.UUID][sha256.Size]byte
+	// exists asks the needle's volume server whether it still holds it
+	exists func(ctx context.Context, n needleKey) (bool, error)
+	mu     sync.Mutex
+	pb.UnimplementedDedupServiceServer
+}
+
+// NewDedup loads the dedup index kept in dir and serves it next to the master's RPCs.
+func NewDedup(dir string, master *GRPCServer) (*Dedup, error) {
+	d, err := loadDedup(dir, master.needleExists)
+	if err != nil {
+		return nil, err
+	}
+	pb.RegisterDedupServiceServer(master.srv, d)
+	return d, nil
+}
+
+// loadDedup replays and compacts the log kept in dir, then opens it for appending.
+func loadDedup(dir string, exists func(ctx context.Context, n needleKey) (bool, error)) (*Dedup, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &Dedup{
+		byHash:   make(map[[sha256.Size]byte]*content),
+		byNeedle: make(map[needleKey][sha256.Size]byte),
+		byRef:    make(map[uuid.UUID][sha256.Size]byte),
+		exists:   exists,
+	}
+	path := filepath.Join(dir, dedupFileName)
+	if err := d.replay(path); err != nil {
+		return nil, fmt.Errorf("replaying %s: %w", path, err)
+	}
+	if err := d.rewrite(path); err != nil {
+		return nil, fmt.Errorf("rewriting %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d.file = f
+	return d, nil
+}
+
+func (d *Dedup) replay(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec dedupRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("Skipping unreadable dedup record on line %d. Why: %v", line, err)
+			continue
+		}
+		if err := d.apply(rec); err != nil {
+			log.Printf("Skipping invalid dedup record on line %d. Why: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// rewrite replaces the log with one record per reference held.
+func (d *Dedup) rewrite(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for hash, c := range d.byHash {
+		for ref := range c.refs {
+			if err := enc.Encode(refRecord(hash, c.needle, c.sizeBytes, ref)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func refRecord(hash [sha256.Size]byte, n needleKey, size int64, ref uuid.UUID) dedupRecord {
+	return dedupRecord{
+		Hash:     hex.EncodeToString(hash[:]),
+		Ref:      ref.String(),
+		VolumeId: n.volume.String(),
+		NeedleId: n.needle.String(),
+		Size:     size,
+	}
+}
+
+// apply updates the in-memory index with a record. Callers must hold d.mu.
+func (d *Dedup) apply(rec dedupRecord) error {
+	raw, err := hex.DecodeString(rec.Hash)
+	if err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("invalid hash %q", rec.Hash)
+	}
+	hash := [sha256.Size]byte(raw)
+
+	if rec.Drop {
+		d.forget(hash)
+		return nil
+	}
+	ref, err := uuid.Parse(rec.Ref)
+	if err != nil {
+		return fmt.Errorf("invalid ref %q", rec.Ref)
+	}
+
+	c, ok := d.byHash[hash]
+	if rec.Release {
+		if ok && d.byRef[ref] == hash {
+			delete(c.refs, ref)
+			delete(d.byRef, ref)
+			if len(c.refs) == 0 {
+				d.forget(hash)
+			}
+		}
+		return nil
+	}
+
+	if !ok {
+		n, err := parseNeedleKey(rec.VolumeId, rec.NeedleId)
+		if err != nil {
+			return err
+		}
+		c = &content{needle: n, sizeBytes: rec.Size, refs: make(map[uuid.UUID]struct{})}
+		d.byHash[hash] = c
+		d.byNeedle[n] = hash
+	}
+	c.refs[ref] = struct{}{}
+	d.byRef[ref] = hash
+	return nil
+}
+
+// forget drops a hash and every reference to it. Callers must hold d.mu.
+func (d *Dedup) forget(hash [sha256.Size]byte) {
+	c, ok := d.byHash[hash]
+	if !ok {
+		return
+	}
+	for ref := range c.refs {
+		delete(d.byRef, ref)
+	}
+	delete(d.byNeedle, c.needle)
+	delete(d.byHash, hash)
+}
+
+// commit persists a record and applies it. Callers must hold d.mu.
+func (d *Dedup) commit(rec dedupRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := d.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	return d.apply(rec)
+}
+
+// add references the needle holding content with the given hash, registering the
+// uploaded one if there's none yet or the one indexed is gone from its volume. It
+// returns the upload's reference, uuid.Nil if it couldn't tell whether the indexed
+// needle is still there and left the upload out of the index. dup reports the upload
+// is redundant.
+func (d *Dedup) add(ctx context.Context, hash [sha256.Size]byte, uploaded needleKey, size int64) (needleKey, uuid.UUID, bool, error) {
+	d.mu.Lock()
+	c, ok := d.byHash[hash]
+	var indexed needleKey
+	if ok {
+		indexed = c.needle
+	}
+	d.mu.Unlock()
+
+	stale := false
+	if ok && indexed != uploaded {
+		exists, err := d.exists(ctx, indexed)
+		if err != nil {
+			log.Printf("Can't check needle %s is still stored, keeping needle %s unshared. Why: %v", indexed.needle, uploaded.needle, err)
+			return uploaded, uuid.Nil, false, nil
+		}
+		stale = !exists
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok = d.byHash[hash]
+	if ok && stale && c.needle == indexed {
+		log.Printf("Needle %s is gone from volume %s, dropping it from the dedup index", indexed.needle, indexed.volume)
+		if err := d.commit(dedupRecord{Hash: hex.EncodeToString(hash[:]), Drop: true}); err != nil {
+			return needleKey{}, uuid.Nil, false, err
+		}
+		ok = false
+	}
+
+	ref := uuid.New()
+	if !ok {
+		return uploaded, ref, false, d.commit(refRecord(hash, uploaded, size, ref))
+	}
+	if err := d.commit(refRecord(hash, c.needle, c.sizeBytes, ref)); err != nil {
+		return needleKey{}, uuid.Nil, false, err
+	}
+	return c.needle, ref, c.needle != uploaded, nil
+}
+
+// release drops an upload's reference to a needle. Needles the index doesn't know
+// about aren't shared, tracked is false for them. released is false for references
+// that aren't held, such as ones released already, and refs is what's left.
+func (d *Dedup) release(n needleKey, ref uuid.UUID) (tracked, released bool, refs int64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hash, ok := d.byNeedle[n]
+	if !ok {
+		return false, false, 0, nil
+	}
+	c := d.byHash[hash]
+	if _, held := c.refs[ref]; !held {
+		return true, false, int64(len(c.refs)), nil
+	}
+	if err := d.commit(dedupRecord{Hash: hex.EncodeToString(hash[:]), Ref: ref.String(), Release: true}); err != nil {
+		return true, false, int64(len(c.refs)), err
+	}
+	return true, true, int64(len(c.refs)), nil
+}
+
+// held reports whether an upload's reference to a needle is still held.
+func (d *Dedup) held(n needleKey, ref uuid.UUID) (tracked, held bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hash, ok := d.byNeedle[n]
+	if !ok {
+		return false, false
+	}
+	_, held = d.byHash[hash].refs[ref]
+	return true, held
+}
+
+func (d *Dedup) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+func (d *Dedup) AddReference(ctx context.Context, req *pb.AddReferenceRequest) (*pb.AddReferenceResponse, error) {
+	if len(req.GetSha256()) != sha256.Size {
+		return nil, status.Errorf(codes.InvalidArgument, "sha256 must be %d bytes", sha256.Size)
+	}
+	uploaded, err := parseNeedleKey(req.GetVolumeId(), req.GetNeedleId())
+	if err != nil {
+		return nil, err
+	}
+
+	n, ref, dup, err := d.add(ctx, [sha256.Size]byte(req.GetSha256()), uploaded, req.GetSizeBytes())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record reference: %v", err)
+	}
+	resp := &pb.AddReferenceResponse{
+		VolumeId:     n.volume.String(),
+		NeedleId:     n.needle.String(),
+		Deduplicated: dup,
+	}
+	if ref != uuid.Nil {
+		resp.RefId = ref.String()
+	}
+	return resp, nil
+}
+
+func (d *Dedup) ReleaseReference(ctx context.Context, req *pb.ReleaseReferenceRequest) (*pb.ReleaseReferenceResponse, error) {
+	n, err := parseNeedleKey(req.GetVolumeId(), req.GetNeedleId())
+	if err != nil {
+		return nil, err
+	}
+	ref := uuid.Nil
+	if req.GetRefId() != "" {
+		if ref, err = uuid.Parse(req.GetRefId()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ref id format")
+		}
+	}
+
+	tracked, released, refs, err := d.release(n, ref)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record release: %v", err)
+	}
+	return &pb.ReleaseReferenceResponse{Tracked: tracked, Released: released, Refs: refs}, nil
+}
+
+func (d *Dedup) LookupReference(ctx context.Context, req *pb.LookupReferenceRequest) (*pb.LookupReferenceResponse, error) {
+	n, err := parseNeedleKey(req.GetVolumeId(), req.GetNeedleId())
+	if err != nil {
+		return nil, err
+	}
+	ref, err := uuid.Parse(req.GetRefId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ref id format")
+	}
+
+	tracked, held := d.held(n, ref)
+	return &pb.LookupReferenceResponse{Tracked: tracked, Held: held}, nil
+}
+
+// needleExists asks the volume server holding a needle whether it still has it.
+// Volumes the master has no location for are an error, they may just not have
+// reported in since a restart.
+func (g *GRPCServer) needleExists(ctx context.Context, n needleKey) (bool, error) {
+	addr := g.volumeAddr(n.volume)
+	if addr == "" {
+		return false, fmt.Errorf("volume %s has no known location", n.volume)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, g.volumeURL(addr, "/v1/volume/read/%s?volume=%s", n.needle, n.volume), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("volume server returned status %d", resp.StatusCode)
+	}
+}
+
+func parseNeedleKey(volumeIdStr, needleIdStr string) (needleKey, error) {
+	volumeId, err := uuid.Parse(volumeIdStr)
+	if err != nil {
+		return needleKey{}, status.Errorf(codes.InvalidArgument, "invalid volume id format")
+	}
+	needleId, err := uuid.Parse(needleIdStr)
+	if err != nil {
+		return needleKey{}, status.Errorf(codes.InvalidArgument, "invalid needle id format")
+	}
+	return needleKey{volume: volumeId, needle: needleId}, nil
+}
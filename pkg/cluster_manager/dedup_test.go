@@ -0,0 +1,169 @@
+package cluster_manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newNeedleKey() needleKey {
+	return needleKey{volume: uuid.New(), needle: uuid.New()}
+}
+
+// storedNeedles stands in for the volume servers, gone marks needles deleted behind
+// the index's back and err fails every check.
+type storedNeedles struct {
+	gone map[needleKey]bool
+	err  error
+}
+
+func (s *storedNeedles) exists(ctx context.Context, n needleKey) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return !s.gone[n], nil
+}
+
+func newTestDedup(t *testing.T, dir string, stored *storedNeedles) *Dedup {
+	t.Helper()
+	d, err := loadDedup(dir, stored.exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func mustAdd(t *testing.T, d *Dedup, hash [sha256.Size]byte, uploaded needleKey) (needleKey, uuid.UUID, bool) {
+	t.Helper()
+	n, ref, dup, err := d.add(context.Background(), hash, uploaded, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, ref, dup
+}
+
+func TestDedupReleasesEachReferenceOnce(t *testing.T) {
+	d := newTestDedup(t, t.TempDir(), &storedNeedles{})
+	hash := sha256.Sum256([]byte("hello"))
+	first, second := newNeedleKey(), newNeedleKey()
+
+	n, firstRef, dup := mustAdd(t, d, hash, first)
+	if n != first || dup || firstRef == uuid.Nil {
+		t.Fatalf("first add = %v %v %v, want the upload registered", n, firstRef, dup)
+	}
+	n, secondRef, dup := mustAdd(t, d, hash, second)
+	if n != first || !dup || secondRef == uuid.Nil || secondRef == firstRef {
+		t.Fatalf("second add = %v %v %v, want a new reference to the first needle", n, secondRef, dup)
+	}
+
+	steps := []struct {
+		name         string
+		ref          uuid.UUID
+		wantTracked  bool
+		wantReleased bool
+		wantRefs     int64
+	}{
+		{name: "first reference", ref: firstRef, wantTracked: true, wantReleased: true, wantRefs: 1},
+		{name: "first reference again", ref: firstRef, wantTracked: true, wantRefs: 1},
+		{name: "no reference", ref: uuid.Nil, wantTracked: true, wantRefs: 1},
+		{name: "unknown reference", ref: uuid.New(), wantTracked: true, wantRefs: 1},
+		{name: "last reference", ref: secondRef, wantTracked: true, wantReleased: true, wantRefs: 0},
+		{name: "last reference again", ref: secondRef},
+	}
+	for _, s := range steps {
+		tracked, released, refs, err := d.release(first, s.ref)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if tracked != s.wantTracked || released != s.wantReleased || refs != s.wantRefs {
+			t.Fatalf("%s: release = %v %v %d, want %v %v %d", s.name, tracked, released, refs, s.wantTracked, s.wantReleased, s.wantRefs)
+		}
+		if _, held := d.held(first, s.ref); held {
+			t.Fatalf("%s: reference is still held after its release", s.name)
+		}
+	}
+}
+
+func TestDedupChecksIndexedNeedleExists(t *testing.T) {
+	hash := sha256.Sum256([]byte("hello"))
+
+	tests := []struct {
+		name       string
+		stored     storedNeedles
+		wantShared bool
+		wantRef    bool
+		wantStale  bool
+	}{
+		{name: "still stored", wantShared: true, wantRef: true},
+		{name: "gone", stored: storedNeedles{gone: map[needleKey]bool{}}, wantRef: true, wantStale: true},
+		{name: "volume server unreachable", stored: storedNeedles{err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := tt.stored
+			d := newTestDedup(t, t.TempDir(), &stored)
+			indexed, uploaded := newNeedleKey(), newNeedleKey()
+			_, indexedRef, _ := mustAdd(t, d, hash, indexed)
+			if stored.gone != nil {
+				stored.gone[indexed] = true
+			}
+
+			n, ref, dup := mustAdd(t, d, hash, uploaded)
+			if dup != tt.wantShared || (ref != uuid.Nil) != tt.wantRef {
+				t.Fatalf("add = %v %v %v, want shared %v with a reference %v", n, ref, dup, tt.wantShared, tt.wantRef)
+			}
+			want := uploaded
+			if tt.wantShared {
+				want = indexed
+			}
+			if n != want {
+				t.Fatalf("add returned needle %v, want %v", n, want)
+			}
+
+			tracked, _, _, err := d.release(indexed, indexedRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tracked == tt.wantStale {
+				t.Fatalf("indexed needle tracked = %v after the add, want %v", tracked, !tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestDedupReplaysReferences(t *testing.T) {
+	dir := t.TempDir()
+	stored := &storedNeedles{}
+	hash := sha256.Sum256([]byte("hello"))
+	needle := newNeedleKey()
+
+	d, err := loadDedup(dir, stored.exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, released, _ := mustAdd(t, d, hash, needle)
+	_, kept, _ := mustAdd(t, d, hash, newNeedleKey())
+	if _, _, _, err := d.release(needle, released); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	// opened twice, the second time from the rewritten log
+	for range 2 {
+		d = newTestDedup(t, dir, stored)
+		if _, ok, _, _ := d.release(needle, released); ok {
+			t.Fatal("released reference came back after a restart")
+		}
+		d.Close()
+	}
+
+	d = newTestDedup(t, dir, stored)
+	tracked, ok, refs, err := d.release(needle, kept)
+	if err != nil || !tracked || !ok || refs != 0 {
+		t.Fatalf("release of the kept reference = %v %v %d %v, want it released last", tracked, ok, refs, err)
+	}
+}
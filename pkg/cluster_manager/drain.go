@@ -0,0 +1,307 @@
+package cluster_manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	drainRunning = "RUNNING"
+	drainFailed  = "FAILED"
+	drainDone    = "DONE"
+)
+
+var errNoDrainTarget = errors.New("no other live volume server with enough free disk to take it")
+
+// drainProgress tracks the evacuation of a volume server. Guarded by g.mu.
+type drainProgress struct {
+	addr       string
+	grpcAddr   string
+	state      string
+	total      int
+	moved      int
+	current    uuid.UUID
+	failures   map[uuid.UUID]string
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+func (d *drainProgress) proto() *pb.DrainProgress {
+	p := &pb.DrainProgress{
+		State:        d.state,
+		VolumesTotal: uint32(d.total),
+		VolumesMoved: uint32(d.moved),
+		StartedAt:    d.startedAt.Unix(),
+	}
+	if d.current != uuid.Nil {
+		p.CurrentVolume = d.current.String()
+	}
+	if !d.finishedAt.IsZero() {
+		p.FinishedAt = d.finishedAt.Unix()
+	}
+	for id, reason := range d.failures {
+		p.Failures = append(p.Failures, &pb.DrainFailure{VolumeId: id.String(), Reason: reason})
+	}
+	sort.Slice(p.Failures, func(i, j int) bool {
+		return p.Failures[i].VolumeId < p.Failures[j].VolumeId
+	})
+	return p
+}
+
+// DrainServer evacuates a volume server so it can be decommissioned. It stops
+// assigning writes to the server, moves each of its volumes to another server and
+// finally removes it from the cluster. The drain runs in the background, calling
+// DrainServer again reports its progress or retries one that failed.
+func (g *GRPCServer) DrainServer(ctx context.Context, req *pb.DrainServerRequest) (*pb.VolumeServerStatus, error) {
+	serverId, err := uuid.Parse(req.GetServerId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid server id format")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var volumeIds []string
+	for _, v := range g.volumes {
+		if v.server == serverId {
+			volumeIds = append(volumeIds, v.id.String())
+		}
+	}
+
+	d, draining := g.drains[serverId]
+	if !draining || d.state == drainFailed {
+		st, ok := g.servers[serverId]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "volume server not found: %s", serverId)
+		}
+		st.draining = true
+
+		d = &drainProgress{
+			addr:      g.volumeServers[serverId],
+			grpcAddr:  st.grpcAddr,
+			state:     drainRunning,
+			total:     len(volumeIds),
+			failures:  make(map[uuid.UUID]string),
+			startedAt: time.Now(),
+		}
+		g.drains[serverId] = d
+		log.Printf("Draining volume server %s, no new writes will be assigned to it", serverId)
+		go g.drain(serverId, d)
+	}
+
+	return g.serverStatus(serverId, volumeIds), nil
+}
+
+// drained reports whether a volume server was drained and removed. Callers must hold g.mu.
+func (g *GRPCServer) drained(serverId uuid.UUID) bool {
+	d, ok := g.drains[serverId]
+	return ok && d.state == drainDone
+}
+
+func (g *GRPCServer) drain(serverId uuid.UUID, d *drainProgress) {
+	ctx := context.Background()
+
+	g.mu.Lock()
+	var volumes []*volume
+	for _, v := range g.volumes {
+		if v.server == serverId {
+			volumes = append(volumes, v)
+		}
+	}
+	d.total = len(volumes)
+	g.mu.Unlock()
+
+	var moved []*volume
+	switched := make(map[uuid.UUID]time.Time)
+	for _, v := range volumes {
+		g.mu.Lock()
+		d.current = v.id
+		g.mu.Unlock()
+
+		g.mu.RLock()
+		target, ok := g.drainTarget(serverId, v.sizeBytes)
+		g.mu.RUnlock()
+		if !ok {
+			g.drainFailure(d, v.id, errNoDrainTarget)
+			continue
+		}
+
+		if err := g.moveVolume(ctx, v, serverId, target, 0); err != nil {
+			g.drainFailure(d, v.id, err)
+			continue
+		}
+
+		g.mu.Lock()
+		d.moved++
+		g.mu.Unlock()
+		moved = append(moved, v)
+		switched[v.id] = time.Now()
+	}
+
+	// pick up whatever reached the old copies through stale locations, once no
+	// gateway still has them cached
+	for _, v := range moved {
+		g.waitOutLocations(switched[v.id])
+		g.mu.RLock()
+		target := g.volumeServers[v.server]
+		g.mu.RUnlock()
+		if err := g.pullVolume(ctx, target, d.grpcAddr, v.id, 0); err != nil {
+			g.drainFailure(d, v.id, fmt.Errorf("final catch-up failed: %w", err))
+		}
+	}
+
+	g.mu.Lock()
+	d.current = uuid.Nil
+	for _, v := range g.volumes {
+		switch {
+		case v.server == serverId:
+			if _, failed := d.failures[v.id]; !failed {
+				d.failures[v.id] = "volume is still on the server"
+			}
+		case !g.serverAlive(v.server):
+			for _, m := range moved {
+				if m == v {
+					d.failures[v.id] = "volume server it moved to is down, removing this copy would leave it unavailable"
+				}
+			}
+		}
+	}
+	if len(d.failures) > 0 {
+		d.state = drainFailed
+		d.finishedAt = time.Now()
+		g.mu.Unlock()
+		log.Printf("Drain of volume server %s failed, %d volumes couldn't be moved. The server stays in the cluster", serverId, len(d.failures))
+		return
+	}
+	g.mu.Unlock()
+
+	for _, v := range moved {
+		if err := g.removeCopy(d.addr, v.id); err != nil {
+			log.Printf("Failed to remove the old copy of volume %s from drained volume server %s. Why: %v", v.id, serverId, err)
+		}
+	}
+
+	g.mu.Lock()
+	delete(g.volumeServers, serverId)
+	delete(g.servers, serverId)
+	d.state = drainDone
+	d.finishedAt = time.Now()
+	g.publishServer(pb.TopologyEvent_SERVER_REMOVED, serverId)
+	g.mu.Unlock()
+	log.Printf("Drained volume server %s, moved %d volumes and removed it from the cluster", serverId, len(moved))
+}
+
+func (g *GRPCServer) drainFailure(d *drainProgress, volumeId uuid.UUID, err error) {
+	log.Printf("Failed to move volume %s off drained volume server. Why: %v", volumeId, err)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	d.failures[volumeId] = err.Error()
+}
+
+// moveVolume copies a volume from source to target at up to bytesPerSec, points the
+// cluster at the new copy and catches it up with writes that reached the old one
+// in the meantime. The old copy is left in place.
+func (g *GRPCServer) moveVolume(ctx context.Context, v *volume, source, target uuid.UUID, bytesPerSec int64) error {
+	g.mu.RLock()
+	sourceAddr, targetAddr := g.volumeServers[source], g.volumeServers[target]
+	copyAddr := g.servers[source].grpcAddr
+	g.mu.RUnlock()
+
+	start := time.Now()
+	if err := g.pullVolume(ctx, targetAddr, copyAddr, v.id, bytesPerSec); err != nil {
+		return fmt.Errorf("copy to %s failed: %w", targetAddr, err)
+	}
+
+	g.mu.Lock()
+	v.server = target
+	// it's no longer the target's primary volume, new writes go elsewhere
+	v.sealed = true
+	g.publish(pb.TopologyEvent_VOLUME_MOVED, v)
+	g.mu.Unlock()
+
+	if err := g.pullVolume(ctx, targetAddr, copyAddr, v.id, bytesPerSec); err != nil {
+		return fmt.Errorf("catch-up on %s failed: %w", targetAddr, err)
+	}
+	log.Printf("Moved volume %s from %s to %s in %s", v.id, sourceAddr, targetAddr, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// drainTarget picks the live, undrained volume server of the same storage class with
+// the most free disk that can hold size more bytes. Callers must hold g.mu.
+func (g *GRPCServer) drainTarget(exclude uuid.UUID, size uint64) (uuid.UUID, bool) {
+	var best uuid.UUID
+	var bestFree uint64
+	found := false
+	for id := range g.volumeServers {
+		st := g.servers[id]
+		if id == exclude || !g.acceptsWrites(id) || st.storageClass != g.servers[exclude].storageClass {
+			continue
+		}
+		if st.diskTotal > 0 && st.diskFree <= size {
+			continue
+		}
+		if !found || st.diskFree > bestFree {
+			best, bestFree, found = id, st.diskFree, true
+		}
+	}
+	return best, found
+}
+
+// pullVolume has the volume server at target copy a volume from the VolumeService at
+// source, or catch up the copy it already has. A bytesPerSec of 0 doesn't throttle the copy.
+func (g *GRPCServer) pullVolume(ctx context.Context, target, source string, volumeId uuid.UUID, bytesPerSec int64) error {
+	url := g.volumeURL(target, "/v1/volume/admin/pull/%s?source=%s&rate=%d", volumeId, source, bytesPerSec)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	g.signVolumeRequest(req)
+
+	// copies take as long as they take, no client timeout
+	resp, err := g.adminClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("volume server returned status %d: %s", resp.StatusCode, body.Error)
+	}
+	return nil
+}
+
+func (g *GRPCServer) removeCopy(addr string, volumeId uuid.UUID) error {
+	req, err := http.NewRequest(http.MethodDelete, g.volumeURL(addr, "/v1/volume/admin/volumes/%s", volumeId), nil)
+	if err != nil {
+		return err
+	}
+	g.signVolumeRequest(req)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("volume server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
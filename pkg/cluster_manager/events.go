@@ -0,0 +1,437 @@
+package cluster_manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	deadLetterFileName = "events.dead.log"
+
+	// events waiting per webhook before new ones go straight to the dead letters
+	webhookQueueLen = 10000
+	webhookTimeout  = 10 * time.Second
+	maxRetryBackoff = 5 * time.Minute
+
+	eventHeader     = "X-Graphene-Event"
+	eventIdHeader   = "X-Graphene-Event-Id"
+	signatureHeader = "X-Graphene-Signature"
+)
+
+// names of the event types in webhook payloads and rules
+var eventNames = map[pb.ObjectEvent_Type]string{
+	pb.ObjectEvent_OBJECT_CREATED: "ObjectCreated",
+	pb.ObjectEvent_OBJECT_DELETED: "ObjectDeleted",
+}
+
+// Webhook is a URL events matching its filter are POSTed to as JSON.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Bucket string   `json:"bucket,omitempty"` // empty matches every bucket
+	Prefix string   `json:"prefix,omitempty"`
+	Events []string `json:"events,omitempty"` // "ObjectCreated", "ObjectDeleted", empty for both
+	Secret string   `json:"secret,omitempty"` // signs payloads with HMAC-SHA256 in X-Graphene-Signature when set
+}
+
+type webhooksFile struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// LoadWebhooks reads the webhooks events are delivered to from a JSON file.
+func LoadWebhooks(path string) ([]Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f webhooksFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	ids := make(map[string]bool, len(f.Webhooks))
+	for _, w := range f.Webhooks {
+		if err := validateWebhook(w); err != nil {
+			return nil, fmt.Errorf("webhook %q in %s: %w", w.ID, path, err)
+		}
+		if ids[w.ID] {
+			return nil, fmt.Errorf("webhook %q is listed twice in %s", w.ID, path)
+		}
+		ids[w.ID] = true
+	}
+	return f.Webhooks, nil
+}
+
+func validateWebhook(w Webhook) error {
+	if w.ID == "" {
+		return errors.New("id must be set")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", w.URL)
+	}
+	if _, err := parseEventTypes(w.Events); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseEventTypes(names []string) ([]pb.ObjectEvent_Type, error) {
+	var types []pb.ObjectEvent_Type
+	for _, name := range names {
+		found := false
+		for t, n := range eventNames {
+			if n == name {
+				types, found = append(types, t), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event %q", name)
+		}
+	}
+	return types, nil
+}
+
+// EventsConfig is where the master delivers object events and how hard it tries.
+type EventsConfig struct {
+	Webhooks     []Webhook
+	MaxAttempts  int           // per event and webhook before it's dead-lettered
+	RetryBackoff time.Duration // before the second attempt, doubling after each
+}
+
+// eventFilter picks the events a webhook or subscriber gets.
+type eventFilter struct {
+	bucket string
+	prefix string
+	types  []pb.ObjectEvent_Type
+}
+
+func (f eventFilter) matches(ev *pb.ObjectEvent) bool {
+	if f.bucket != "" && f.bucket != ev.GetBucket() {
+		return false
+	}
+	if !strings.HasPrefix(ev.GetPath(), f.prefix) {
+		return false
+	}
+	if len(f.types) == 0 {
+		return true
+	}
+	for _, t := range f.types {
+		if t == ev.GetType() {
+			return true
+		}
+	}
+	return false
+}
+
+type webhook struct {
+	Webhook
+	filter eventFilter
+	queue  chan *pb.ObjectEvent
+}
+
+// Events publishes the ledger's object changes to webhooks and gRPC subscribers.
+// Each webhook gets its events in order from its own queue and an event that can't
+// be delivered after every retry is appended to the dead letter log in the meta dir.
+// Events still queued when the master stops are lost.
+//
+// Subscribers get events from when they subscribe and are cut off if they fall behind.
+type Events struct {
+	config      EventsConfig
+	webhooks    []*webhook
+	httpClient  *http.Client
+	subscribers map[chan *pb.ObjectEvent]eventFilter
+	mu          sync.Mutex
+
+	deadLetters *os.File
+	deadMu      sync.Mutex
+	pb.UnimplementedEventServiceServer
+}
+
+// NewEvents starts delivering to the configured webhooks and serves subscriptions
+// next to the master's RPCs.
+func NewEvents(dir string, master *GRPCServer, config EventsConfig) (*Events, error) {
+	e, err := startEvents(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	pb.RegisterEventServiceServer(master.srv, e)
+	return e, nil
+}
+
+// startEvents opens the dead letter log in dir and starts a delivery loop per webhook.
+func startEvents(dir string, config EventsConfig) (*Events, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, deadLetterFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Events{
+		config:      config,
+		httpClient:  &http.Client{Timeout: webhookTimeout},
+		subscribers: make(map[chan *pb.ObjectEvent]eventFilter),
+		deadLetters: f,
+	}
+	for _, w := range config.Webhooks {
+		types, err := parseEventTypes(w.Events)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("webhook %q: %w", w.ID, err)
+		}
+		wh := &webhook{
+			Webhook: w,
+			filter:  eventFilter{bucket: w.Bucket, prefix: w.Prefix, types: types},
+			queue:   make(chan *pb.ObjectEvent, webhookQueueLen),
+		}
+		e.webhooks = append(e.webhooks, wh)
+		go e.deliver(wh)
+	}
+	return e, nil
+}
+
+// objectEvent describes a change to one version of a bucket path.
+func objectEvent(t pb.ObjectEvent_Type, bucket, path string, o *object) *pb.ObjectEvent {
+	ev := &pb.ObjectEvent{
+		Type:         t,
+		Bucket:       bucket,
+		Path:         path,
+		VersionId:    o.versionId,
+		DeleteMarker: o.deleteMarker,
+	}
+	if !o.deleteMarker {
+		ev.FatId = fmt.Sprintf("%s:%s", o.volumeId, o.needleId)
+		ev.SizeBytes, ev.Etag = o.sizeBytes, o.etag
+	}
+	return ev
+}
+
+// publish hands an event to every webhook and subscriber it matches without
+// waiting on any of them.
+func (e *Events) publish(ev *pb.ObjectEvent) {
+	ev.Id, ev.Time = uuid.NewString(), timestamppb.Now()
+	eventsPublished.WithLabelValues(eventNames[ev.GetType()]).Inc()
+
+	for _, w := range e.webhooks {
+		if !w.filter.matches(ev) {
+			continue
+		}
+		select {
+		case w.queue <- ev:
+		default:
+			e.deadLetter(w, ev, 0, errors.New("delivery queue is full"))
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch, filter := range e.subscribers {
+		if !filter.matches(ev) {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+			close(ch)
+			delete(e.subscribers, ch)
+		}
+	}
+}
+
+// Subscribe streams the events matching a filter until the client goes away.
+func (e *Events) Subscribe(req *pb.SubscribeEventsRequest, stream pb.EventService_SubscribeServer) error {
+	ch := make(chan *pb.ObjectEvent, watcherBuffer)
+	e.mu.Lock()
+	e.subscribers[ch] = eventFilter{bucket: req.GetBucket(), prefix: req.GetPrefix(), types: req.GetTypes()}
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.subscribers, ch)
+		e.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "subscriber fell behind, events were dropped")
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// deliver posts a webhook's events one at a time, retrying each with backoff.
+func (e *Events) deliver(w *webhook) {
+	for ev := range w.queue {
+		backoff := e.config.RetryBackoff
+		for attempt := 1; ; attempt++ {
+			err := e.post(w, ev)
+			if err == nil {
+				webhookDeliveries.WithLabelValues(w.ID, "delivered").Inc()
+				break
+			}
+			var permanent *permanentError
+			if attempt >= e.config.MaxAttempts || errors.As(err, &permanent) {
+				e.deadLetter(w, ev, attempt, err)
+				break
+			}
+
+			log.Printf("Failed to deliver event %s to webhook %s, retrying in %v. Why: %v", ev.GetId(), w.ID, backoff, err)
+			webhookDeliveries.WithLabelValues(w.ID, "retried").Inc()
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxRetryBackoff)
+		}
+	}
+}
+
+// permanentError is a response retrying the same payload won't change.
+type permanentError struct {
+	statusCode int
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("webhook rejected the event with status %d", e.statusCode)
+}
+
+// eventPayload is the JSON an event is posted to webhooks as.
+type eventPayload struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Bucket       string    `json:"bucket"`
+	Path         string    `json:"path"`
+	FatId        string    `json:"fat_id,omitempty"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	VersionId    string    `json:"version_id"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+func newEventPayload(ev *pb.ObjectEvent) eventPayload {
+	return eventPayload{
+		ID:           ev.GetId(),
+		Type:         eventNames[ev.GetType()],
+		Bucket:       ev.GetBucket(),
+		Path:         ev.GetPath(),
+		FatId:        ev.GetFatId(),
+		Size:         ev.GetSizeBytes(),
+		ETag:         ev.GetEtag(),
+		VersionId:    ev.GetVersionId(),
+		DeleteMarker: ev.GetDeleteMarker(),
+		Time:         ev.GetTime().AsTime(),
+	}
+}
+
+func (e *Events) post(w *webhook, ev *pb.ObjectEvent) error {
+	body, err := json.Marshal(newEventPayload(ev))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, eventNames[ev.GetType()])
+	req.Header.Set(eventIdHeader, ev.GetId())
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &permanentError{statusCode: resp.StatusCode}
+	default:
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+}
+
+// deadLetterRecord is one line of the dead letter log.
+type deadLetterRecord struct {
+	Webhook  string       `json:"webhook"`
+	URL      string       `json:"url"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	Event    eventPayload `json:"event"`
+	Time     time.Time    `json:"time"`
+}
+
+// deadLetter records an event a webhook never got so it can be replayed by hand.
+func (e *Events) deadLetter(w *webhook, ev *pb.ObjectEvent, attempts int, cause error) {
+	log.Printf("Dead-lettering event %s for webhook %s after %d attempts. Why: %v", ev.GetId(), w.ID, attempts, cause)
+	webhookDeliveries.WithLabelValues(w.ID, "dead_lettered").Inc()
+
+	line, err := json.Marshal(deadLetterRecord{
+		Webhook:  w.ID,
+		URL:      w.URL,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Event:    newEventPayload(ev),
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to encode dead letter for event %s. Why: %v", ev.GetId(), err)
+		return
+	}
+
+	e.deadMu.Lock()
+	defer e.deadMu.Unlock()
+	if e.deadLetters == nil {
+		log.Printf("Dead letter log is closed, dropping event %s", ev.GetId())
+		return
+	}
+	if _, err := e.deadLetters.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write dead letter for event %s. Why: %v", ev.GetId(), err)
+	}
+}
+
+func (e *Events) Close() error {
+	e.deadMu.Lock()
+	defer e.deadMu.Unlock()
+	err := e.deadLetters.Close()
+	e.deadLetters = nil
+	return err
+}
@@ -0,0 +1,129 @@
+package cluster_manager
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+const webhookSecret = "webhook-secret"
+
+// readDeadLetters waits up to a few seconds for want records in the dead letter log.
+func readDeadLetters(t *testing.T, dir string, want int) []deadLetterRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := os.Open(filepath.Join(dir, deadLetterFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []deadLetterRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec deadLetterRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, rec)
+		}
+		f.Close()
+		if len(records) >= want || time.Now().After(deadline) {
+			return records
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []int // per attempt, the last one repeats
+		wantAttempts int
+		wantDead     bool
+	}{
+		{name: "delivered", responses: []int{http.StatusOK}, wantAttempts: 1},
+		{name: "delivered after retries", responses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusNoContent}, wantAttempts: 3},
+		{name: "rate limited then delivered", responses: []int{http.StatusTooManyRequests, http.StatusOK}, wantAttempts: 2},
+		{name: "dead-lettered after every attempt", responses: []int{http.StatusBadGateway}, wantAttempts: 4, wantDead: true},
+		{name: "rejected", responses: []int{http.StatusBadRequest}, wantAttempts: 1, wantDead: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			delivered := make(chan eventPayload, 1)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				body, _ := io.ReadAll(r.Body)
+
+				mac := hmac.New(sha256.New, []byte(webhookSecret))
+				mac.Write(body)
+				if r.Header.Get(signatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+					t.Errorf("attempt %d: signature %q doesn't match the body", n, r.Header.Get(signatureHeader))
+				}
+
+				code := tt.responses[min(n, len(tt.responses))-1]
+				w.WriteHeader(code)
+				if code < 300 {
+					var payload eventPayload
+					if err := json.Unmarshal(body, &payload); err != nil {
+						t.Errorf("payload: %v", err)
+					}
+					delivered <- payload
+				}
+			}))
+			defer receiver.Close()
+
+			dir := t.TempDir()
+			e, err := startEvents(dir, EventsConfig{
+				Webhooks:     []Webhook{{ID: "hook", URL: receiver.URL, Secret: webhookSecret, Bucket: "photos"}},
+				MaxAttempts:  4,
+				RetryBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+
+			// only the second matches the webhook's bucket
+			e.publish(&pb.ObjectEvent{Type: pb.ObjectEvent_OBJECT_CREATED, Bucket: "videos", Path: "cat.mp4"})
+			e.publish(&pb.ObjectEvent{Type: pb.ObjectEvent_OBJECT_CREATED, Bucket: "photos", Path: "cat.jpg", VersionId: nullVersionId})
+
+			if tt.wantDead {
+				records := readDeadLetters(t, dir, 1)
+				if len(records) != 1 {
+					t.Fatalf("dead letters = %+v, want 1", records)
+				}
+				if rec := records[0]; rec.Webhook != "hook" || rec.Attempts != tt.wantAttempts || rec.Event.Path != "cat.jpg" {
+					t.Fatalf("dead letter = %+v, want cat.jpg after %d attempts", rec, tt.wantAttempts)
+				}
+			} else {
+				select {
+				case payload := <-delivered:
+					if payload.Type != "ObjectCreated" || payload.Bucket != "photos" || payload.Path != "cat.jpg" {
+						t.Fatalf("delivered %+v", payload)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("event wasn't delivered")
+				}
+				if records := readDeadLetters(t, dir, 0); len(records) != 0 {
+					t.Fatalf("dead letters = %+v, want none", records)
+				}
+			}
+			if got := int(attempts.Load()); got != tt.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
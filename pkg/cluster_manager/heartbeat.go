@@ -0,0 +1,94 @@
+package cluster_manager
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A volume server that hasn't heartbeated for this long is considered down
+const heartbeatTimeout = 15 * time.Second
+
+type serverState struct {
+	lastHeartbeat time.Time
+	down          bool
+	draining      bool // no new writes are assigned to it
+	diskTotal     uint64
+	diskFree      uint64
+	grpcAddr      string // where other volume servers copy its volumes from
+	storageClass  string
+}
+
+func (g *GRPCServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if err := g.authorizeVolumeServer(ctx); err != nil {
+		return nil, err
+	}
+
+	serverId, err := uuid.FromBytes(req.GetServerId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid server id format")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.drained(serverId) {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume server %s was drained and removed from the cluster", serverId)
+	}
+
+	// also (re-)registers servers a restarted master hasn't heard of yet
+	g.syncServer(serverId, req.GetHttpAddress(), req.GetGrpcAddress(), req.GetStorageClass(), req.GetVolumes())
+	st := g.servers[serverId]
+	st.diskTotal, st.diskFree = req.GetDiskTotalBytes(), req.GetDiskFreeBytes()
+
+	return &pb.HeartbeatResponse{}, nil
+}
+
+// markAlive records a sign of life from a volume server. Callers must hold g.mu.
+func (g *GRPCServer) markAlive(serverId uuid.UUID) {
+	st, ok := g.servers[serverId]
+	if !ok {
+		st = &serverState{}
+		g.servers[serverId] = st
+	}
+	if st.down {
+		log.Printf("Volume server %s is back up", serverId)
+	}
+	st.lastHeartbeat = time.Now()
+	st.down = false
+}
+
+// serverAlive reports whether a volume server is heartbeating. Callers must hold g.mu.
+func (g *GRPCServer) serverAlive(serverId uuid.UUID) bool {
+	st, ok := g.servers[serverId]
+	return ok && !st.down
+}
+
+// acceptsWrites reports whether new writes may be assigned to a volume server. Callers must hold g.mu.
+func (g *GRPCServer) acceptsWrites(serverId uuid.UUID) bool {
+	st, ok := g.servers[serverId]
+	return ok && !st.down && !st.draining
+}
+
+func (g *GRPCServer) monitorHeartbeats() {
+	ticker := time.NewTicker(heartbeatTimeout / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.mu.Lock()
+		for id, st := range g.servers {
+			if st.down || time.Since(st.lastHeartbeat) < heartbeatTimeout {
+				continue
+			}
+			st.down = true
+			log.Printf("Volume server %s missed its heartbeats, marking it down", id)
+			g.publishServer(pb.TopologyEvent_SERVER_DOWN, id)
+		}
+		g.mu.Unlock()
+	}
+}
@@ -0,0 +1,216 @@
+package cluster_manager
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/metrics"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var jsonOpts = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// HTTPServer exposes the master's admin RPCs as HTTP/JSON for curl and dashboards.
+type HTTPServer struct {
+	addr   string
+	engine *gin.Engine
+	srv    *http.Server
+	master *GRPCServer
+	ledger *Ledger
+}
+
+func NewHTTPServer(addr string, m *GRPCServer, l *Ledger) *HTTPServer {
+	engine := gin.New()
+	engine.Use(gin.Logger(), gin.Recovery(), metrics.Middleware())
+
+	h := &HTTPServer{
+		addr:   addr,
+		engine: engine,
+		master: m,
+		ledger: l,
+	}
+	h.registerRoutes()
+
+	return h
+}
+
+func (h *HTTPServer) registerRoutes() {
+	h.engine.GET("/metrics", metrics.Handler())
+
+	v1 := h.engine.Group("/v1")
+
+	admin := v1.Group("/admin")
+
+	admin.GET("/cluster", h.clusterStatus)
+	admin.GET("/servers", h.listVolumeServers)
+	admin.GET("/volumes", h.listVolumes)
+	admin.GET("/volumes/:volume_id", h.describeVolume)
+	admin.POST("/volumes/:volume_id/vacuum", h.vacuumVolume)
+	admin.POST("/volumes/:volume_id/seal", h.sealVolume)
+	admin.POST("/servers/:server_id/drain", h.drainServer)
+	admin.GET("/fsck", h.checkCluster)
+	admin.POST("/rebalance", h.rebalanceVolumes)
+	admin.GET("/usage", h.listUsage)
+	admin.GET("/quotas/buckets/:bucket", h.getBucketQuota)
+	admin.PUT("/quotas/buckets/:bucket", h.setBucketQuota)
+	admin.GET("/quotas/tenants/:tenant", h.getTenantQuota)
+	admin.PUT("/quotas/tenants/:tenant", h.setTenantQuota)
+}
+
+func (h *HTTPServer) Run() error {
+	h.srv = &http.Server{
+		Addr:    h.addr,
+		Handler: h.engine,
+	}
+	return h.master.tls.Certs.ListenAndServe(h.srv)
+}
+
+func (h *HTTPServer) Shutdown(ctx context.Context) error {
+	if h.srv == nil {
+		return nil
+	}
+	return h.srv.Shutdown(ctx)
+}
+
+func (h *HTTPServer) clusterStatus(c *gin.Context) {
+	resp, err := h.master.GetClusterStatus(c, &pb.GetClusterStatusRequest{})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) listVolumeServers(c *gin.Context) {
+	resp, err := h.master.ListVolumeServers(c, &pb.ListVolumeServersRequest{})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) listVolumes(c *gin.Context) {
+	resp, err := h.master.ListVolumes(c, &pb.ListVolumesRequest{})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) describeVolume(c *gin.Context) {
+	resp, err := h.master.DescribeVolume(c, &pb.DescribeVolumeRequest{VolumeId: c.Param("volume_id")})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) vacuumVolume(c *gin.Context) {
+	resp, err := h.master.VacuumVolume(c, &pb.VacuumVolumeRequest{VolumeId: c.Param("volume_id")})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) sealVolume(c *gin.Context) {
+	resp, err := h.master.SealVolume(c, &pb.SealVolumeRequest{VolumeId: c.Param("volume_id")})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) drainServer(c *gin.Context) {
+	resp, err := h.master.DrainServer(c, &pb.DrainServerRequest{ServerId: c.Param("server_id")})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) checkCluster(c *gin.Context) {
+	resp, err := h.master.CheckCluster(c, &pb.CheckClusterRequest{Scrub: c.Query("scrub") == "true"})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) rebalanceVolumes(c *gin.Context) {
+	maxMoves, err := strconv.ParseUint(c.DefaultQuery("max_moves", "0"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_moves"})
+		return
+	}
+
+	resp, err := h.master.RebalanceVolumes(c, &pb.RebalanceVolumesRequest{
+		DryRun:   c.Query("dry_run") == "true",
+		MaxMoves: uint32(maxMoves),
+	})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) listUsage(c *gin.Context) {
+	resp, err := h.ledger.ListUsage(c, &pb.ListUsageRequest{})
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) getBucketQuota(c *gin.Context) {
+	resp, err := h.ledger.GetBucketQuota(c, &pb.GetBucketQuotaRequest{Bucket: c.Param("bucket")})
+	writeProto(c, resp, err)
+}
+
+// setBucketQuota takes a BucketQuota as JSON, the bucket comes from the path.
+func (h *HTTPServer) setBucketQuota(c *gin.Context) {
+	req := &pb.BucketQuota{}
+	if !readProto(c, req) {
+		return
+	}
+	req.Bucket = c.Param("bucket")
+	resp, err := h.ledger.SetBucketQuota(c, req)
+	writeProto(c, resp, err)
+}
+
+func (h *HTTPServer) getTenantQuota(c *gin.Context) {
+	resp, err := h.ledger.GetTenantQuota(c, &pb.GetTenantQuotaRequest{Tenant: c.Param("tenant")})
+	writeProto(c, resp, err)
+}
+
+// setTenantQuota takes a TenantQuota as JSON, the tenant comes from the path.
+func (h *HTTPServer) setTenantQuota(c *gin.Context) {
+	req := &pb.TenantQuota{}
+	if !readProto(c, req) {
+		return
+	}
+	req.Tenant = c.Param("tenant")
+	resp, err := h.ledger.SetTenantQuota(c, req)
+	writeProto(c, resp, err)
+}
+
+// readProto decodes a JSON request body into m, answering 400 if it can't.
+func readProto(c *gin.Context, m proto.Message) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		err = protojson.Unmarshal(body, m)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeProto(c *gin.Context, m proto.Message, err error) {
+	if err != nil {
+		st, _ := status.FromError(err)
+		c.JSON(httpStatus(st.Code()), gin.H{"error": st.Message()})
+		return
+	}
+
+	body, err := jsonOpts.Marshal(m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
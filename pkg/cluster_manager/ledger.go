@@ -0,0 +1,826 @@
+package cluster_manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	ledgerFileName = "objects.log"
+
+	defaultMaxKeys = 1000
+	maxPathLen     = 1024
+	maxTags        = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+
+	ledgerOpPut         = "put"
+	ledgerOpMarker      = "delete_marker"
+	ledgerOpDelete      = "delete" // of one version, or of every version without an id
+	ledgerOpVersioning  = "versioning"
+	ledgerOpTransition  = "transition" // moves a version to another needle in place
+	ledgerOpLifecycle   = "lifecycle"
+	ledgerOpQuota       = "quota" // of a bucket, with the tenant it belongs to
+	ledgerOpTenantQuota = "tenant_quota"
+
+	// the version of objects put while a bucket isn't versioned, each put replaces it
+	nullVersionId = "null"
+)
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// object is a version of what a bucket path points at.
+type object struct {
+	versionId    string
+	deleteMarker bool // hides the versions before it, has no needle
+	volumeId     uuid.UUID
+	needleId     uuid.UUID
+	refId        uuid.UUID // its reference in the dedup index, uuid.Nil if the needle isn't shared
+	sizeBytes    int64
+	mimeType     string
+	etag         string
+	modifiedAt   time.Time
+	tags         map[string]string
+	storageClass string // empty for standard
+}
+
+func (o *object) key() needleKey {
+	return needleKey{volume: o.volumeId, needle: o.needleId}
+}
+
+func (o *object) info(path string, latest bool) *pb.ObjectInfo {
+	info := &pb.ObjectInfo{
+		Path:         path,
+		SizeBytes:    o.sizeBytes,
+		MimeType:     o.mimeType,
+		Etag:         o.etag,
+		ModifiedAt:   timestamppb.New(o.modifiedAt),
+		VersionId:    o.versionId,
+		DeleteMarker: o.deleteMarker,
+		IsLatest:     latest,
+		Tags:         o.tags,
+	}
+	if !o.deleteMarker {
+		info.VolumeId, info.NeedleId = o.volumeId.String(), o.needleId.String()
+		info.StorageClass = storageClass(o.storageClass)
+	}
+	return info
+}
+
+// bucket holds the versions of every path in a bucket, oldest first.
+type bucket struct {
+	versioning  pb.BucketVersioning_Status
+	maxVersions int
+	lifecycle   []lifecycleRule
+	tenant      string
+	quota       quota
+	usage       usage
+	objects     map[string][]*object
+}
+
+// latest is the newest version of a path, possibly a delete marker.
+func (b *bucket) latest(path string) *object {
+	if b == nil {
+		return nil
+	}
+	versions := b.objects[path]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// current is the version of a path reads get, nil if it's deleted.
+func (b *bucket) current(path string) *object {
+	o := b.latest(path)
+	if o == nil || o.deleteMarker {
+		return nil
+	}
+	return o
+}
+
+func (b *bucket) version(path, versionId string) *object {
+	if b == nil {
+		return nil
+	}
+	for _, o := range b.objects[path] {
+		if o.versionId == versionId {
+			return o
+		}
+	}
+	return nil
+}
+
+// newVersionId names the version a put or delete creates in the bucket.
+func (b *bucket) newVersionId() string {
+	if b != nil && b.versioning == pb.BucketVersioning_ENABLED {
+		return uuid.NewString()
+	}
+	return nullVersionId
+}
+
+// ledgerRecord is one line of the ledger's log.
+type ledgerRecord struct {
+	Op           string            `json:"op"`
+	Bucket       string            `json:"bucket"`
+	Path         string            `json:"path,omitempty"`
+	VersionId    string            `json:"version_id,omitempty"` // "null" when missing, logs predate versioning
+	VolumeId     string            `json:"volume_id,omitempty"`
+	NeedleId     string            `json:"needle_id,omitempty"`
+	RefId        string            `json:"ref_id,omitempty"`
+	Size         int64             `json:"size,omitempty"`
+	MimeType     string            `json:"mime_type,omitempty"`
+	Etag         string            `json:"etag,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Versioning   string            `json:"versioning,omitempty"`
+	MaxVersions  int               `json:"max_versions,omitempty"`
+	Rules        []lifecycleRule   `json:"rules,omitempty"`
+	Tenant       string            `json:"tenant,omitempty"`
+	Quota        quota             `json:"quota,omitzero"`
+	Time         time.Time         `json:"time"`
+}
+
+// Ledger maps bucket paths to needles. Buckets are created by their first write.
+// The mapping lives in memory and is persisted as an append-only log that's
+// replayed and rewritten without the overwritten entries on startup.
+//
+// Versioned buckets keep every put of a path as a version with its own needle,
+// and deletes add a delete marker instead of removing anything.
+type Ledger struct {
+	master  *GRPCServer
+	dedup   *Dedup
+	events  *Events
+	file    *os.File
+	buckets map[string]*bucket
+	tenants map[string]quota
+	mu      sync.RWMutex
+	pb.UnimplementedLedgerServiceServer
+}
+
+// NewLedger loads the ledger kept in dir and serves it next to the master's RPCs.
+// Changes to objects are published to events.
+func NewLedger(dir string, master *GRPCServer, dedup *Dedup, events *Events) (*Ledger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{
+		master:  master,
+		dedup:   dedup,
+		events:  events,
+		buckets: make(map[string]*bucket),
+		tenants: make(map[string]quota),
+	}
+
+	path := filepath.Join(dir, ledgerFileName)
+	if err := l.replay(path); err != nil {
+		return nil, fmt.Errorf("replaying %s: %w", path, err)
+	}
+	if err := l.rewrite(path); err != nil {
+		return nil, fmt.Errorf("rewriting %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+
+	pb.RegisterLedgerServiceServer(master.srv, l)
+	return l, nil
+}
+
+func (l *Ledger) replay(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec ledgerRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a crash can cut the last record short, nothing was acknowledged for it
+			log.Printf("Skipping unreadable ledger record on line %d. Why: %v", line, err)
+			continue
+		}
+		if err := l.apply(rec); err != nil {
+			log.Printf("Skipping invalid ledger record on line %d. Why: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// rewrite replaces the log with each bucket's settings and one record per version.
+func (l *Ledger) rewrite(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for tenant, q := range l.tenants {
+		if err := enc.Encode(tenantQuotaRecord(tenant, q)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	for name, b := range l.buckets {
+		if b.versioning != pb.BucketVersioning_UNVERSIONED || b.maxVersions != 0 {
+			if err := enc.Encode(versioningRecord(name, b)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if len(b.lifecycle) > 0 {
+			if err := enc.Encode(lifecycleRecord(name, b.lifecycle)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if b.tenant != "" || b.quota != (quota{}) {
+			if err := enc.Encode(bucketQuotaRecord(name, b)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		for p, versions := range b.objects {
+			for _, o := range versions {
+				if err := enc.Encode(putRecord(name, p, o)); err != nil {
+					f.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func putRecord(bucket, path string, o *object) ledgerRecord {
+	if o.deleteMarker {
+		return markerRecord(bucket, path, o.versionId, o.modifiedAt)
+	}
+	return ledgerRecord{
+		Op:           ledgerOpPut,
+		Bucket:       bucket,
+		Path:         path,
+		VersionId:    o.versionId,
+		VolumeId:     o.volumeId.String(),
+		NeedleId:     o.needleId.String(),
+		RefId:        refString(o.refId),
+		Size:         o.sizeBytes,
+		MimeType:     o.mimeType,
+		Etag:         o.etag,
+		Tags:         o.tags,
+		StorageClass: o.storageClass,
+		Time:         o.modifiedAt,
+	}
+}
+
+// refString is how a dedup reference is logged, empty for none.
+func refString(ref uuid.UUID) string {
+	if ref == uuid.Nil {
+		return ""
+	}
+	return ref.String()
+}
+
+func markerRecord(bucket, path, versionId string, t time.Time) ledgerRecord {
+	return ledgerRecord{Op: ledgerOpMarker, Bucket: bucket, Path: path, VersionId: versionId, Time: t}
+}
+
+func versioningRecord(name string, b *bucket) ledgerRecord {
+	return ledgerRecord{
+		Op:          ledgerOpVersioning,
+		Bucket:      name,
+		Versioning:  b.versioning.String(),
+		MaxVersions: b.maxVersions,
+		Time:        time.Now().UTC(),
+	}
+}
+
+// bucket returns a bucket, creating it. Callers must hold l.mu.
+func (l *Ledger) bucket(name string) *bucket {
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &bucket{objects: make(map[string][]*object)}
+		l.buckets[name] = b
+	}
+	return b
+}
+
+// apply updates the in-memory mapping with a record. Callers must hold l.mu.
+func (l *Ledger) apply(rec ledgerRecord) error {
+	versionId := rec.VersionId
+	if versionId == "" {
+		versionId = nullVersionId
+	}
+
+	switch rec.Op {
+	case ledgerOpPut, ledgerOpMarker:
+		o := &object{
+			versionId:    versionId,
+			sizeBytes:    rec.Size,
+			mimeType:     rec.MimeType,
+			etag:         rec.Etag,
+			modifiedAt:   rec.Time,
+			tags:         rec.Tags,
+			storageClass: rec.StorageClass,
+		}
+		if rec.Op == ledgerOpMarker {
+			o.deleteMarker = true
+		} else {
+			var err error
+			if o.volumeId, err = uuid.Parse(rec.VolumeId); err != nil {
+				return err
+			}
+			if o.needleId, err = uuid.Parse(rec.NeedleId); err != nil {
+				return err
+			}
+			if rec.RefId != "" {
+				if o.refId, err = uuid.Parse(rec.RefId); err != nil {
+					return err
+				}
+			}
+		}
+		b := l.bucket(rec.Bucket)
+		versions := b.objects[rec.Path]
+		if versionId == nullVersionId {
+			b.usage.add(b.version(rec.Path, nullVersionId), -1)
+			versions = withoutVersion(versions, nullVersionId)
+		}
+		b.objects[rec.Path] = append(versions, o)
+		b.usage.add(o, 1)
+	case ledgerOpDelete:
+		b, ok := l.buckets[rec.Bucket]
+		if !ok {
+			return nil
+		}
+		if rec.VersionId == "" {
+			for _, o := range b.objects[rec.Path] {
+				b.usage.add(o, -1)
+			}
+			delete(b.objects, rec.Path)
+			return nil
+		}
+		b.usage.add(b.version(rec.Path, rec.VersionId), -1)
+		if versions := withoutVersion(b.objects[rec.Path], rec.VersionId); len(versions) > 0 {
+			b.objects[rec.Path] = versions
+		} else {
+			delete(b.objects, rec.Path)
+		}
+	case ledgerOpVersioning:
+		status, ok := pb.BucketVersioning_Status_value[rec.Versioning]
+		if !ok {
+			return fmt.Errorf("unknown versioning status %q", rec.Versioning)
+		}
+		b := l.bucket(rec.Bucket)
+		b.versioning = pb.BucketVersioning_Status(status)
+		b.maxVersions = rec.MaxVersions
+	case ledgerOpTransition:
+		b, ok := l.buckets[rec.Bucket]
+		if !ok {
+			return fmt.Errorf("no bucket %s to transition in", rec.Bucket)
+		}
+		versions := b.objects[rec.Path]
+		for i, o := range versions {
+			if o.versionId != versionId {
+				continue
+			}
+			moved := *o
+			var err error
+			if moved.volumeId, err = uuid.Parse(rec.VolumeId); err != nil {
+				return err
+			}
+			if moved.needleId, err = uuid.Parse(rec.NeedleId); err != nil {
+				return err
+			}
+			moved.refId = uuid.Nil
+			moved.storageClass = rec.StorageClass
+			versions[i] = &moved
+			return nil
+		}
+		return fmt.Errorf("no version %s of %s/%s to transition", versionId, rec.Bucket, rec.Path)
+	case ledgerOpLifecycle:
+		l.bucket(rec.Bucket).lifecycle = rec.Rules
+	case ledgerOpQuota:
+		b := l.bucket(rec.Bucket)
+		b.tenant, b.quota = rec.Tenant, rec.Quota
+	case ledgerOpTenantQuota:
+		if rec.Quota == (quota{}) {
+			delete(l.tenants, rec.Tenant)
+		} else {
+			l.tenants[rec.Tenant] = rec.Quota
+		}
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+	return nil
+}
+
+func withoutVersion(versions []*object, versionId string) []*object {
+	for i, o := range versions {
+		if o.versionId == versionId {
+			return append(versions[:i:i], versions[i+1:]...)
+		}
+	}
+	return versions
+}
+
+// commit persists a record and applies it. Callers must hold l.mu.
+func (l *Ledger) commit(rec ledgerRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	return l.apply(rec)
+}
+
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func validateBucketName(bucket string) error {
+	if !bucketNamePattern.MatchString(bucket) {
+		return status.Errorf(codes.InvalidArgument, "invalid bucket name %q: use 3-63 lowercase letters, digits, '.' or '-'", bucket)
+	}
+	return nil
+}
+
+func validateObjectPath(bucket, path string) error {
+	if err := validateBucketName(bucket); err != nil {
+		return err
+	}
+	if path == "" || len(path) > maxPathLen || strings.HasPrefix(path, "/") {
+		return status.Errorf(codes.InvalidArgument, "invalid object path %q", path)
+	}
+	return nil
+}
+
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return status.Errorf(codes.InvalidArgument, "objects can have at most %d tags", maxTags)
+	}
+	for k, v := range tags {
+		if k == "" || len(k) > maxTagKeyLen || len(v) > maxTagValueLen {
+			return status.Errorf(codes.InvalidArgument, "invalid tag %q: keys are 1-%d characters, values up to %d", k, maxTagKeyLen, maxTagValueLen)
+		}
+	}
+	return nil
+}
+
+// PrepareWrite picks the volume a new object is uploaded to.
+func (l *Ledger) PrepareWrite(ctx context.Context, req *pb.PrepareWriteRequest) (*pb.PrepareWriteResponse, error) {
+	if err := validateObjectPath(req.GetBucket(), req.GetPath()); err != nil {
+		return nil, err
+	}
+	// Apply checks again with the uploaded size, this only saves uploading what can't fit
+	l.mu.RLock()
+	bytes, objects := growth(l.buckets[req.GetBucket()], req.GetPath(), req.GetSizeBytes())
+	_, err := l.checkQuota(req.GetBucket(), bytes, objects)
+	l.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := l.master.AssignVolume(ctx, &pb.AssignVolumeRequest{IdempotencyKey: req.GetIdempotencyKey()})
+	if err != nil {
+		return nil, err
+	}
+	volumeId, err := uuid.FromBytes(resp.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid volume id assigned")
+	}
+
+	return &pb.PrepareWriteResponse{
+		VolumeId:    volumeId.String(),
+		HttpAddress: resp.GetHttpAddress(),
+		WriteToken:  resp.GetWriteToken(),
+	}, nil
+}
+
+// Apply points a bucket path at an uploaded needle, or at the needle already holding
+// the same content if the upload's hash is given. In a versioned bucket that's a new
+// version, otherwise it replaces the "null" version and releases its needle. An
+// upload that doesn't fit the bucket's or its tenant's quota is released instead.
+func (l *Ledger) Apply(ctx context.Context, req *pb.ApplyRequest) (*pb.ApplyResponse, error) {
+	if err := validateObjectPath(req.GetBucket(), req.GetPath()); err != nil {
+		return nil, err
+	}
+	if err := validateTags(req.GetTags()); err != nil {
+		return nil, err
+	}
+	uploaded, err := parseNeedleKey(req.GetVolumeId(), req.GetNeedleId())
+	if err != nil {
+		return nil, err
+	}
+
+	target, ref, deduplicated := uploaded, uuid.Nil, false
+	hashed := len(req.GetSha256()) > 0
+	if hashed {
+		if len(req.GetSha256()) != sha256.Size {
+			return nil, status.Errorf(codes.InvalidArgument, "sha256 must be %d bytes", sha256.Size)
+		}
+		target, ref, deduplicated, err = l.dedup.add(ctx, [sha256.Size]byte(req.GetSha256()), uploaded, req.GetSizeBytes())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to record reference: %v", err)
+		}
+	}
+	if deduplicated {
+		go l.deleteNeedle(context.Background(), &object{volumeId: uploaded.volume, needleId: uploaded.needle})
+	}
+
+	l.mu.Lock()
+	b := l.buckets[req.GetBucket()]
+	// a retry with an idempotency key uploads the same needle again, it only holds
+	// a second reference if the dedup index counted one
+	if !hashed && b != nil {
+		for _, o := range b.objects[req.GetPath()] {
+			if !o.deleteMarker && o.key() == target {
+				l.mu.Unlock()
+				return &pb.ApplyResponse{VolumeId: target.volume.String(), NeedleId: target.needle.String(), VersionId: o.versionId}, nil
+			}
+		}
+	}
+
+	bytes, objects := growth(b, req.GetPath(), req.GetSizeBytes())
+	warnings, err := l.checkQuota(req.GetBucket(), bytes, objects)
+	if err != nil {
+		l.mu.Unlock()
+		go l.releaseNeedle(context.Background(), &object{volumeId: target.volume, needleId: target.needle, refId: ref})
+		return nil, err
+	}
+
+	versionId := b.newVersionId()
+	var released []*object
+	if old := b.version(req.GetPath(), versionId); old != nil {
+		released = append(released, old)
+	}
+	err = l.commit(ledgerRecord{
+		Op:        ledgerOpPut,
+		Bucket:    req.GetBucket(),
+		Path:      req.GetPath(),
+		VersionId: versionId,
+		VolumeId:  target.volume.String(),
+		NeedleId:  target.needle.String(),
+		RefId:     refString(ref),
+		Size:      req.GetSizeBytes(),
+		MimeType:  req.GetMimeType(),
+		Etag:      req.GetEtag(),
+		Tags:      req.GetTags(),
+		Time:      time.Now().UTC(),
+	})
+	var created *object
+	if err == nil {
+		created = l.buckets[req.GetBucket()].version(req.GetPath(), versionId)
+		released = append(released, l.prune(req.GetBucket(), req.GetPath())...)
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record object: %v", err)
+	}
+
+	l.events.publish(objectEvent(pb.ObjectEvent_OBJECT_CREATED, req.GetBucket(), req.GetPath(), created))
+	go l.releaseNeedles(context.Background(), released)
+	return &pb.ApplyResponse{
+		VolumeId:      target.volume.String(),
+		NeedleId:      target.needle.String(),
+		Deduplicated:  deduplicated,
+		VersionId:     versionId,
+		QuotaWarnings: warnings,
+	}, nil
+}
+
+// GetObjectLocation finds the latest version of an object, or the version asked for.
+func (l *Ledger) GetObjectLocation(ctx context.Context, req *pb.GetObjectLocationRequest) (*pb.GetObjectLocationResponse, error) {
+	l.mu.RLock()
+	b := l.buckets[req.GetBucket()]
+	var o *object
+	if req.GetVersionId() != "" {
+		o = b.version(req.GetPath(), req.GetVersionId())
+	} else {
+		o = b.current(req.GetPath())
+	}
+	l.mu.RUnlock()
+	if o == nil {
+		return nil, status.Errorf(codes.NotFound, "no object %s in bucket %s", req.GetPath(), req.GetBucket())
+	}
+	if o.deleteMarker {
+		return nil, status.Errorf(codes.FailedPrecondition, "version %s of %s is a delete marker", o.versionId, req.GetPath())
+	}
+
+	return &pb.GetObjectLocationResponse{
+		HttpAddress:  l.master.volumeAddr(o.volumeId),
+		NeedleId:     o.needleId.String(),
+		VolumeId:     o.volumeId.String(),
+		SizeBytes:    o.sizeBytes,
+		MimeType:     o.mimeType,
+		Etag:         o.etag,
+		ModifiedAt:   timestamppb.New(o.modifiedAt),
+		VersionId:    o.versionId,
+		Tags:         o.tags,
+		StorageClass: storageClass(o.storageClass),
+	}, nil
+}
+
+// DeleteObject hides an object behind a delete marker if its bucket is versioned, or
+// else removes it and releases its needle. A version id removes that version for good.
+func (l *Ledger) DeleteObject(ctx context.Context, req *pb.DeleteObjectRequest) (*pb.DeleteObjectResponse, error) {
+	if req.GetVersionId() != "" {
+		return l.deleteVersion(ctx, req.GetBucket(), req.GetPath(), req.GetVersionId(), nil)
+	}
+	return l.deleteCurrent(ctx, req.GetBucket(), req.GetPath(), nil)
+}
+
+// deleteCurrent deletes the current version of an object, only if it's still expect
+// when that's set.
+func (l *Ledger) deleteCurrent(ctx context.Context, bucketName, path string, expect *object) (*pb.DeleteObjectResponse, error) {
+	l.mu.Lock()
+	b := l.buckets[bucketName]
+	cur := b.current(path)
+	if cur == nil || (expect != nil && cur != expect) {
+		l.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "no object %s in bucket %s", path, bucketName)
+	}
+
+	resp := &pb.DeleteObjectResponse{VersionId: nullVersionId}
+	var released []*object
+	var err error
+	if b.versioning == pb.BucketVersioning_UNVERSIONED {
+		released = append(released, b.latest(path))
+		err = l.commit(ledgerRecord{Op: ledgerOpDelete, Bucket: bucketName, Path: path, VersionId: nullVersionId, Time: time.Now().UTC()})
+	} else {
+		resp.VersionId, resp.DeleteMarker = b.newVersionId(), true
+		if old := b.version(path, resp.VersionId); old != nil {
+			released = append(released, old)
+		}
+		err = l.commit(markerRecord(bucketName, path, resp.VersionId, time.Now().UTC()))
+		if err == nil {
+			released = append(released, l.prune(bucketName, path)...)
+		}
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record delete: %v", err)
+	}
+
+	// a delete marker's event describes the version it hides
+	ev := objectEvent(pb.ObjectEvent_OBJECT_DELETED, bucketName, path, cur)
+	ev.VersionId, ev.DeleteMarker = resp.VersionId, resp.DeleteMarker
+	l.events.publish(ev)
+	l.releaseNeedles(ctx, released)
+	return resp, nil
+}
+
+// deleteVersion removes one version of an object, only if it's still expect when
+// that's set. If it was the latest, the one before it becomes the latest.
+func (l *Ledger) deleteVersion(ctx context.Context, bucketName, path, versionId string, expect *object) (*pb.DeleteObjectResponse, error) {
+	l.mu.Lock()
+	o := l.buckets[bucketName].version(path, versionId)
+	if expect != nil && o != expect {
+		o = nil
+	}
+	var err error
+	if o != nil {
+		err = l.commit(ledgerRecord{Op: ledgerOpDelete, Bucket: bucketName, Path: path, VersionId: o.versionId, Time: time.Now().UTC()})
+	}
+	l.mu.Unlock()
+	if o == nil {
+		return nil, status.Errorf(codes.NotFound, "no version %s of object %s in bucket %s", versionId, path, bucketName)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record delete: %v", err)
+	}
+
+	l.events.publish(objectEvent(pb.ObjectEvent_OBJECT_DELETED, bucketName, path, o))
+	l.releaseNeedles(ctx, []*object{o})
+	return &pb.DeleteObjectResponse{VersionId: o.versionId, DeleteMarker: o.deleteMarker}, nil
+}
+
+// ListObjects returns a bucket's objects under a prefix in path order.
+func (l *Ledger) ListObjects(ctx context.Context, req *pb.ListObjectsRequest) (*pb.ListObjectsResponse, error) {
+	maxKeys := int(req.GetMaxKeys())
+	if maxKeys <= 0 || maxKeys > defaultMaxKeys {
+		maxKeys = defaultMaxKeys
+	}
+
+	l.mu.RLock()
+	b := l.buckets[req.GetBucket()]
+	var paths []string
+	if b != nil {
+		for p := range b.objects {
+			if strings.HasPrefix(p, req.GetPrefix()) && p > req.GetStartAfter() && b.current(p) != nil {
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	resp := &pb.ListObjectsResponse{}
+	if len(paths) > maxKeys {
+		paths, resp.Truncated = paths[:maxKeys], true
+	}
+	for _, p := range paths {
+		resp.Objects = append(resp.Objects, b.current(p).info(p, true))
+	}
+	l.mu.RUnlock()
+
+	return resp, nil
+}
+
+// releaseNeedles releases the needles of versions that were removed.
+func (l *Ledger) releaseNeedles(ctx context.Context, removed []*object) {
+	for _, o := range removed {
+		if !o.deleteMarker {
+			l.releaseNeedle(ctx, o)
+		}
+	}
+}
+
+// releaseNeedle drops an object's reference to its needle, deleting the needle
+// once nothing references it. A shared needle is kept if the object's reference
+// was released already.
+func (l *Ledger) releaseNeedle(ctx context.Context, o *object) {
+	tracked, released, refs, err := l.dedup.release(o.key(), o.refId)
+	if err != nil {
+		log.Printf("Failed to release needle %s, keeping it. Why: %v", o.needleId, err)
+		return
+	}
+	if tracked && (!released || refs > 0) {
+		return
+	}
+	l.deleteNeedle(ctx, o)
+}
+
+// deleteNeedle removes an object's needle from its volume server. The ledger no
+// longer points at it, so a failure only leaves garbage for a vacuum.
+func (l *Ledger) deleteNeedle(ctx context.Context, o *object) {
+	addr := l.master.volumeAddr(o.volumeId)
+	if addr == "" {
+		log.Printf("Can't delete needle %s, volume %s has no known location", o.needleId, o.volumeId)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, l.master.volumeURL(addr, "/v1/volume/delete/%s?volume=%s", o.needleId, o.volumeId), nil)
+	if err != nil {
+		log.Printf("Failed to build delete req for needle %s. Why: %v", o.needleId, err)
+		return
+	}
+	l.master.signVolumeRequest(req)
+	resp, err := l.master.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to delete needle %s from volume %s. Why: %v", o.needleId, o.volumeId, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		log.Printf("Volume server returned status %d deleting needle %s", resp.StatusCode, o.needleId)
+	}
+}
@@ -0,0 +1,380 @@
+package cluster_manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxLifecycleRules  = 100
+	maxLifecycleRuleID = 255
+)
+
+// lifecycleRule is a LifecycleRule as the ledger keeps it. Ages are in seconds.
+type lifecycleRule struct {
+	ID                    string            `json:"id"`
+	Disabled              bool              `json:"disabled,omitempty"`
+	Prefix                string            `json:"prefix,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+	ExpireAfter           int64             `json:"expire_after,omitempty"`
+	NoncurrentExpireAfter int64             `json:"noncurrent_expire_after,omitempty"`
+	TransitionAfter       int64             `json:"transition_after,omitempty"`
+	StorageClass          string            `json:"storage_class,omitempty"`
+	AbortMultipartAfter   int64             `json:"abort_multipart_after,omitempty"` // kept, never acted on
+}
+
+func lifecycleRuleFromProto(r *pb.LifecycleRule) lifecycleRule {
+	return lifecycleRule{
+		ID:                    r.GetId(),
+		Disabled:              r.GetDisabled(),
+		Prefix:                r.GetPrefix(),
+		Tags:                  r.GetTags(),
+		ExpireAfter:           r.GetExpireAfterSeconds(),
+		NoncurrentExpireAfter: r.GetNoncurrentExpireAfterSeconds(),
+		TransitionAfter:       r.GetTransitionAfterSeconds(),
+		StorageClass:          r.GetTransitionStorageClass(),
+		AbortMultipartAfter:   r.GetAbortMultipartAfterSeconds(),
+	}
+}
+
+func (r lifecycleRule) proto() *pb.LifecycleRule {
+	return &pb.LifecycleRule{
+		Id:                           r.ID,
+		Disabled:                     r.Disabled,
+		Prefix:                       r.Prefix,
+		Tags:                         r.Tags,
+		ExpireAfterSeconds:           r.ExpireAfter,
+		NoncurrentExpireAfterSeconds: r.NoncurrentExpireAfter,
+		TransitionAfterSeconds:       r.TransitionAfter,
+		TransitionStorageClass:       r.StorageClass,
+		AbortMultipartAfterSeconds:   r.AbortMultipartAfter,
+	}
+}
+
+func validateLifecycleRule(r *pb.LifecycleRule) error {
+	if r.GetId() == "" || len(r.GetId()) > maxLifecycleRuleID {
+		return fmt.Errorf("rule ids are 1-%d characters", maxLifecycleRuleID)
+	}
+	if r.GetExpireAfterSeconds() < 0 || r.GetNoncurrentExpireAfterSeconds() < 0 || r.GetTransitionAfterSeconds() < 0 || r.GetAbortMultipartAfterSeconds() < 0 {
+		return fmt.Errorf("rule %s: ages can't be negative", r.GetId())
+	}
+	if (r.GetTransitionAfterSeconds() > 0) != (r.GetTransitionStorageClass() != "") {
+		return fmt.Errorf("rule %s: a transition needs both an age and a storage class", r.GetId())
+	}
+	// abort_multipart_after counts as an action so configurations written for other
+	// stores are taken as they are, there are no multipart uploads for it to abort
+	if r.GetExpireAfterSeconds() == 0 && r.GetNoncurrentExpireAfterSeconds() == 0 && r.GetTransitionAfterSeconds() == 0 && r.GetAbortMultipartAfterSeconds() == 0 {
+		return fmt.Errorf("rule %s has no action", r.GetId())
+	}
+	if len(r.GetTags()) > maxTags {
+		return fmt.Errorf("rule %s: filters match at most %d tags", r.GetId(), maxTags)
+	}
+	return nil
+}
+
+// matches reports whether a version falls under the rule's filters. Delete markers
+// have no tags, only rules without tag filters match them.
+func (r *lifecycleRule) matches(path string, o *object) bool {
+	if r.Disabled || !strings.HasPrefix(path, r.Prefix) {
+		return false
+	}
+	for k, v := range r.Tags {
+		if tag, ok := o.tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+type lifecycleAction int
+
+// in the order they win when several rules match a version
+const (
+	lifecycleExpire lifecycleAction = iota
+	lifecycleExpireNoncurrent
+	lifecycleTransition
+	lifecycleNone
+)
+
+func (a lifecycleAction) String() string {
+	switch a {
+	case lifecycleExpire:
+		return "expire"
+	case lifecycleExpireNoncurrent:
+		return "expire_noncurrent"
+	case lifecycleTransition:
+		return "transition"
+	default:
+		return "none"
+	}
+}
+
+// lifecycleTask is an action a lifecycle rule takes on one version of an object.
+type lifecycleTask struct {
+	action       lifecycleAction
+	bucket       string
+	path         string
+	version      *object
+	storageClass string
+}
+
+// action works out what the rule does to the version at index i of a path's versions.
+func (r *lifecycleRule) action(versions []*object, i int, now time.Time) lifecycleAction {
+	o := versions[i]
+	latest := i == len(versions)-1
+	age := func(since time.Time, after int64) bool {
+		return after > 0 && now.Sub(since) >= time.Duration(after)*time.Second
+	}
+
+	switch {
+	case latest && !o.deleteMarker && age(o.modifiedAt, r.ExpireAfter):
+		return lifecycleExpire
+	case !latest && age(versions[i+1].modifiedAt, r.NoncurrentExpireAfter):
+		return lifecycleExpireNoncurrent
+	case latest && o.deleteMarker && len(versions) == 1 && r.NoncurrentExpireAfter > 0:
+		// nothing is left behind the marker
+		return lifecycleExpireNoncurrent
+	case !o.deleteMarker && age(o.modifiedAt, r.TransitionAfter):
+		// planLifecycle picks between the transitions that are due
+		return lifecycleTransition
+	}
+	return lifecycleNone
+}
+
+// planLifecycle finds the versions the buckets' lifecycle rules act on. Of the
+// transitions due on a version the one with the longest age wins, so a version
+// only ever moves on to the next tier and never back to one it has aged past.
+func (l *Ledger) planLifecycle(now time.Time) []lifecycleTask {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var tasks []lifecycleTask
+	for name, b := range l.buckets {
+		if len(b.lifecycle) == 0 {
+			continue
+		}
+		for path, versions := range b.objects {
+			for i, o := range versions {
+				task := lifecycleTask{action: lifecycleNone, bucket: name, path: path, version: o}
+				var transition *lifecycleRule
+				for _, r := range b.lifecycle {
+					if !r.matches(path, o) {
+						continue
+					}
+					a := r.action(versions, i, now)
+					if a == lifecycleTransition && (transition == nil || r.TransitionAfter > transition.TransitionAfter) {
+						transition = &r
+					}
+					if a < task.action {
+						task.action, task.storageClass = a, r.StorageClass
+					}
+				}
+				if task.action == lifecycleTransition {
+					task.storageClass = transition.StorageClass
+					if storageClass(o.storageClass) == task.storageClass {
+						task.action = lifecycleNone
+					}
+				}
+				if task.action != lifecycleNone {
+					tasks = append(tasks, task)
+				}
+			}
+		}
+	}
+	return tasks
+}
+
+// RunLifecycle applies the buckets' lifecycle rules every interval. Objects are
+// expired like deletes through the gateway, and transitioned by copying their
+// needle to a volume server of the rule's storage class.
+func (l *Ledger) RunLifecycle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tasks := l.planLifecycle(time.Now())
+		done := 0
+		for _, t := range tasks {
+			err := l.runLifecycleTask(context.Background(), t)
+			if status.Code(err) == codes.NotFound {
+				// overwritten or deleted since the pass was planned
+				continue
+			}
+			if err != nil {
+				log.Printf("Lifecycle %s of %s/%s (version %s) failed. Why: %v", t.action, t.bucket, t.path, t.version.versionId, err)
+				continue
+			}
+			lifecycleActions.WithLabelValues(t.action.String()).Inc()
+			done++
+		}
+		if len(tasks) > 0 {
+			log.Printf("Lifecycle pass took %d of %d actions", done, len(tasks))
+		}
+	}
+}
+
+// runLifecycleTask takes a planned action. It fails with NotFound if the version
+// changed in the meantime.
+func (l *Ledger) runLifecycleTask(ctx context.Context, t lifecycleTask) error {
+	var err error
+	switch t.action {
+	case lifecycleExpire:
+		_, err = l.deleteCurrent(ctx, t.bucket, t.path, t.version)
+	case lifecycleExpireNoncurrent:
+		_, err = l.deleteVersion(ctx, t.bucket, t.path, t.version.versionId, t.version)
+	case lifecycleTransition:
+		err = l.transition(ctx, t.bucket, t.path, t.version, t.storageClass)
+	}
+	return err
+}
+
+// transition copies a version's needle to a volume server of the storage class and
+// points the version at the copy, then releases the old needle.
+func (l *Ledger) transition(ctx context.Context, bucketName, path string, o *object, class string) error {
+	assigned, err := l.master.AssignVolume(ctx, &pb.AssignVolumeRequest{StorageClass: class, MaxBytes: o.sizeBytes})
+	if err != nil {
+		return err
+	}
+	volumeId, err := uuid.FromBytes(assigned.GetVolumeId())
+	if err != nil {
+		return err
+	}
+	needleId, err := l.copyNeedle(ctx, o, assigned, volumeId)
+	if err != nil {
+		return err
+	}
+	moved := &object{volumeId: volumeId, needleId: needleId}
+
+	l.mu.Lock()
+	if l.buckets[bucketName].version(path, o.versionId) != o {
+		l.mu.Unlock()
+		l.deleteNeedle(ctx, moved)
+		return status.Errorf(codes.NotFound, "version %s of %s changed during the copy", o.versionId, path)
+	}
+	err = l.commit(ledgerRecord{
+		Op:           ledgerOpTransition,
+		Bucket:       bucketName,
+		Path:         path,
+		VersionId:    o.versionId,
+		VolumeId:     volumeId.String(),
+		NeedleId:     needleId.String(),
+		StorageClass: class,
+		Time:         time.Now().UTC(),
+	})
+	l.mu.Unlock()
+	if err != nil {
+		l.deleteNeedle(ctx, moved)
+		return err
+	}
+
+	l.releaseNeedle(ctx, o)
+	return nil
+}
+
+// copyNeedle streams a version's needle from its volume server to the assigned volume.
+func (l *Ledger) copyNeedle(ctx context.Context, o *object, assigned *pb.AssignVolumeResponse, volumeId uuid.UUID) (uuid.UUID, error) {
+	src := l.master.volumeAddr(o.volumeId)
+	if src == "" {
+		return uuid.Nil, fmt.Errorf("volume %s has no known location", o.volumeId)
+	}
+	readReq, err := http.NewRequestWithContext(ctx, http.MethodGet, l.master.volumeURL(src, "/v1/volume/read/%s?volume=%s", o.needleId, o.volumeId), nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	readResp, err := l.master.adminClient.Do(readReq)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer readResp.Body.Close()
+	if readResp.StatusCode != http.StatusOK {
+		return uuid.Nil, fmt.Errorf("volume server returned status %d reading needle %s", readResp.StatusCode, o.needleId)
+	}
+
+	writeReq, err := http.NewRequestWithContext(ctx, http.MethodPost, l.master.volumeURL(assigned.GetHttpAddress(), "/v1/volume/write?volume=%s", volumeId), readResp.Body)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	writeReq.ContentLength = readResp.ContentLength
+	writeReq.Header.Set("Content-Type", readResp.Header.Get("Content-Type"))
+	if token := assigned.GetWriteToken(); token != "" {
+		writeReq.Header.Set(auth.WriteTokenHeader, token)
+	}
+	writeResp, err := l.master.adminClient.Do(writeReq)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer writeResp.Body.Close()
+	if writeResp.StatusCode != http.StatusCreated {
+		return uuid.Nil, fmt.Errorf("volume server returned status %d writing the copy", writeResp.StatusCode)
+	}
+
+	var written struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(writeResp.Body).Decode(&written); err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(written.ID)
+}
+
+func lifecycleRecord(name string, rules []lifecycleRule) ledgerRecord {
+	return ledgerRecord{Op: ledgerOpLifecycle, Bucket: name, Rules: rules, Time: time.Now().UTC()}
+}
+
+// SetBucketLifecycle replaces a bucket's lifecycle rules.
+func (l *Ledger) SetBucketLifecycle(ctx context.Context, req *pb.BucketLifecycle) (*pb.BucketLifecycle, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+	if len(req.GetRules()) > maxLifecycleRules {
+		return nil, status.Errorf(codes.InvalidArgument, "buckets can have at most %d lifecycle rules", maxLifecycleRules)
+	}
+	ids := make(map[string]bool)
+	var rules []lifecycleRule
+	for _, r := range req.GetRules() {
+		if err := validateLifecycleRule(r); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if ids[r.GetId()] {
+			return nil, status.Errorf(codes.InvalidArgument, "rule id %s is used twice", r.GetId())
+		}
+		ids[r.GetId()] = true
+		rules = append(rules, lifecycleRuleFromProto(r))
+	}
+
+	l.mu.Lock()
+	err := l.commit(lifecycleRecord(req.GetBucket(), rules))
+	l.mu.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record lifecycle: %v", err)
+	}
+	return req, nil
+}
+
+func (l *Ledger) GetBucketLifecycle(ctx context.Context, req *pb.GetBucketLifecycleRequest) (*pb.BucketLifecycle, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	resp := &pb.BucketLifecycle{Bucket: req.GetBucket()}
+	if b, ok := l.buckets[req.GetBucket()]; ok {
+		for _, r := range b.lifecycle {
+			resp.Rules = append(resp.Rules, r.proto())
+		}
+	}
+	return resp, nil
+}
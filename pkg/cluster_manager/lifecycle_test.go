@@ -0,0 +1,76 @@
+package cluster_manager
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+func TestPlanLifecycleTransitions(t *testing.T) {
+	const day = 24 * 60 * 60
+	warm := lifecycleRule{ID: "warm", TransitionAfter: 30 * day, StorageClass: "warm"}
+	cold := lifecycleRule{ID: "cold", TransitionAfter: 90 * day, StorageClass: "cold"}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		ageDays   int
+		class     string
+		wantClass string // empty for no transition
+	}{
+		{name: "too young for either", ageDays: 10},
+		{name: "due for warm", ageDays: 40, wantClass: "warm"},
+		{name: "already warm", ageDays: 40, class: "warm"},
+		{name: "due for cold from standard", ageDays: 100, wantClass: "cold"},
+		{name: "due for cold from warm", ageDays: 100, class: "warm", wantClass: "cold"},
+		{name: "already cold", ageDays: 100, class: "cold"},
+	}
+	for _, rules := range [][]lifecycleRule{{warm, cold}, {cold, warm}} {
+		for _, tt := range tests {
+			t.Run(rules[0].ID+" first/"+tt.name, func(t *testing.T) {
+				l := &Ledger{buckets: make(map[string]*bucket)}
+				b := l.bucket("photos")
+				b.lifecycle = rules
+				b.objects["a.jpg"] = []*object{{
+					versionId:    nullVersionId,
+					modifiedAt:   now.Add(-time.Duration(tt.ageDays) * 24 * time.Hour),
+					storageClass: tt.class,
+				}}
+
+				tasks := l.planLifecycle(now)
+				if tt.wantClass == "" {
+					if len(tasks) != 0 {
+						t.Fatalf("planned %+v, want nothing", tasks)
+					}
+					return
+				}
+				if len(tasks) != 1 || tasks[0].action != lifecycleTransition || tasks[0].storageClass != tt.wantClass {
+					t.Fatalf("planned %+v, want a transition to %s", tasks, tt.wantClass)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateLifecycleRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *pb.LifecycleRule
+		wantErr bool
+	}{
+		{name: "expiry", rule: &pb.LifecycleRule{Id: "r", ExpireAfterSeconds: 60}},
+		{name: "only abort_multipart_after", rule: &pb.LifecycleRule{Id: "r", AbortMultipartAfterSeconds: 60}},
+		{name: "abort_multipart_after with an expiry", rule: &pb.LifecycleRule{Id: "r", ExpireAfterSeconds: 60, AbortMultipartAfterSeconds: 60}},
+		{name: "negative abort_multipart_after", rule: &pb.LifecycleRule{Id: "r", AbortMultipartAfterSeconds: -1}, wantErr: true},
+		{name: "no action", rule: &pb.LifecycleRule{Id: "r"}, wantErr: true},
+		{name: "transition without a class", rule: &pb.LifecycleRule{Id: "r", TransitionAfterSeconds: 60}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLifecycleRule(tt.rule); (err != nil) != tt.wantErr {
+				t.Fatalf("validateLifecycleRule = %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package cluster_manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	issueError   = "ERROR"
+	issueWarning = "WARNING"
+
+	// CheckCluster flags volumes above this garbage ratio for a vacuum
+	vacuumGarbageThreshold = 0.5
+
+	// CheckCluster flags volume servers with less free disk than this share
+	lowDiskFreeRatio = 0.1
+)
+
+func (g *GRPCServer) VacuumVolume(ctx context.Context, req *pb.VacuumVolumeRequest) (*pb.VacuumVolumeResponse, error) {
+	volumeId, err := uuid.Parse(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
+	}
+
+	g.mu.RLock()
+	v, ok := g.volumes[volumeId]
+	var addr string
+	if ok {
+		addr = g.volumeServers[v.server]
+		if !g.serverAlive(v.server) {
+			g.mu.RUnlock()
+			return nil, status.Errorf(codes.Unavailable, "volume server %s is down", v.server)
+		}
+	}
+	g.mu.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume id not found: %s", volumeId)
+	}
+
+	// compaction rewrites the whole volume, don't hold it to the regular http timeout
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.volumeURL(addr, "/v1/volume/admin/vacuum/%s", volumeId), nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to build vacuum request: %v", err)
+	}
+	g.signVolumeRequest(httpReq)
+	resp, err := g.adminClient.Do(httpReq)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "volume server unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		SizeBefore uint64 `json:"size_before"`
+		SizeAfter  uint64 `json:"size_after"`
+		Error      string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid response from volume server: %v", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, status.Errorf(codes.NotFound, "volume server has no volume %s", volumeId)
+	case http.StatusConflict, http.StatusServiceUnavailable:
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", body.Error)
+	default:
+		return nil, status.Errorf(codes.Internal, "volume server failed to vacuum: %s", body.Error)
+	}
+
+	g.mu.Lock()
+	v.sizeBytes = body.SizeAfter
+	v.liveBytes = min(v.liveBytes, body.SizeAfter)
+	g.mu.Unlock()
+	log.Printf("Vacuumed volume %s, %d -> %d bytes", volumeId, body.SizeBefore, body.SizeAfter)
+
+	return &pb.VacuumVolumeResponse{
+		VolumeId:        volumeId.String(),
+		SizeBeforeBytes: body.SizeBefore,
+		SizeAfterBytes:  body.SizeAfter,
+	}, nil
+}
+
+func (g *GRPCServer) SealVolume(ctx context.Context, req *pb.SealVolumeRequest) (*pb.VolumeStatus, error) {
+	volumeId, err := uuid.Parse(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	v, ok := g.volumes[volumeId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume id not found: %s", volumeId)
+	}
+	if !v.sealed {
+		v.sealed = true
+		g.publish(pb.TopologyEvent_VOLUME_SEALED, v)
+		log.Printf("Sealed volume %s", volumeId)
+	}
+	return g.volumeStatus(v), nil
+}
+
+// CheckCluster reports everything in the master's view of the cluster that needs an operator.
+func (g *GRPCServer) CheckCluster(ctx context.Context, req *pb.CheckClusterRequest) (*pb.CheckClusterResponse, error) {
+	resp := &pb.CheckClusterResponse{Issues: g.clusterIssues(time.Now())}
+
+	if req.GetScrub() {
+		resp.Issues = append(resp.Issues, g.triggerScrubs(ctx)...)
+	}
+
+	sort.SliceStable(resp.Issues, func(i, j int) bool {
+		return resp.Issues[i].Severity == issueError && resp.Issues[j].Severity != issueError
+	})
+	return resp, nil
+}
+
+func (g *GRPCServer) clusterIssues(now time.Time) []*pb.ClusterIssue {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var issues []*pb.ClusterIssue
+	for id := range g.volumeServers {
+		st, ok := g.servers[id]
+		switch {
+		case !g.serverAlive(id):
+			issues = append(issues, &pb.ClusterIssue{Severity: issueError, ServerId: id.String(), Message: "volume server is down"})
+		case ok && st.diskTotal > 0 && float64(st.diskFree) < lowDiskFreeRatio*float64(st.diskTotal):
+			issues = append(issues, &pb.ClusterIssue{Severity: issueWarning, ServerId: id.String(),
+				Message: fmt.Sprintf("only %d of %d disk bytes free", st.diskFree, st.diskTotal)})
+		}
+	}
+
+	for _, v := range g.volumes {
+		issue := &pb.ClusterIssue{VolumeId: v.id.String(), ServerId: v.server.String()}
+		switch {
+		case g.volumeServers[v.server] == "":
+			issue.Severity, issue.Message = issueError, "volume is placed on an unregistered volume server"
+		case !g.serverAlive(v.server):
+			issue.Severity, issue.Message = issueError, "volume is unavailable, its volume server is down"
+		case v.readOnly:
+			issue.Severity, issue.Message = issueWarning, "volume server marked the volume read-only after a failed write"
+		case !v.expiresAt.IsZero() && v.expiresAt.Before(now.Add(-ttlReapGrace)):
+			issue.Severity, issue.Message = issueWarning, "volume has expired but wasn't dropped yet"
+		case v.garbageRatio() >= vacuumGarbageThreshold:
+			issue.Severity, issue.Message = issueWarning, fmt.Sprintf("volume is %.0f%% garbage, vacuum it", v.garbageRatio()*100)
+		default:
+			continue
+		}
+		issues = append(issues, issue)
+	}
+
+	for key, n := range g.corruptNeedles {
+		issues = append(issues, &pb.ClusterIssue{
+			Severity: issueError,
+			VolumeId: key.volume.String(),
+			ServerId: n.server.String(),
+			NeedleId: key.needle.String(),
+			Message:  "corrupt needle: " + n.reason,
+		})
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].VolumeId != issues[j].VolumeId {
+			return issues[i].VolumeId < issues[j].VolumeId
+		}
+		return issues[i].NeedleId < issues[j].NeedleId
+	})
+	return issues
+}
+
+// triggerScrubs queues a scrub on every live volume server. Whatever they find is
+// reported back through ReportCorruptNeedles.
+func (g *GRPCServer) triggerScrubs(ctx context.Context) []*pb.ClusterIssue {
+	g.mu.RLock()
+	addrs := make(map[uuid.UUID]string)
+	for id, addr := range g.volumeServers {
+		if g.serverAlive(id) {
+			addrs[id] = addr
+		}
+	}
+	g.mu.RUnlock()
+
+	var issues []*pb.ClusterIssue
+	for id, addr := range addrs {
+		if err := g.triggerScrub(ctx, addr); err != nil {
+			issues = append(issues, &pb.ClusterIssue{Severity: issueError, ServerId: id.String(),
+				Message: fmt.Sprintf("couldn't start a scrub: %v", err)})
+		}
+	}
+	return issues
+}
+
+func (g *GRPCServer) triggerScrub(ctx context.Context, addr string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.volumeURL(addr, "/v1/volume/admin/scrub"), nil)
+	if err != nil {
+		return err
+	}
+	g.signVolumeRequest(req)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("volume server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cluster_manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rxanders35/graphene/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_rpc_duration_seconds",
+		Help:      "Time spent serving unary master RPCs, by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	assignments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_assignments_total",
+		Help:      "Writes assigned to volume servers, by server.",
+	}, []string{"server"})
+
+	lifecycleActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_lifecycle_actions_total",
+		Help:      "Object versions expired or transitioned by bucket lifecycle rules, by action.",
+	}, []string{"action"})
+
+	eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_events_published_total",
+		Help:      "Object events published, by type.",
+	}, []string{"type"})
+
+	quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_quota_rejections_total",
+		Help:      "Bucket writes rejected for going over a hard quota, by whether the bucket's or its tenant's.",
+	}, []string{"scope"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_webhook_deliveries_total",
+		Help:      "Attempts to deliver events to webhooks, by webhook and result: delivered, retried or dead_lettered.",
+	}, []string{"webhook", "result"})
+)
+
+// timeRPC is a unary interceptor recording how long every master RPC takes.
+func timeRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// registerLiveServers exports how many volume servers are heartbeating.
+func (g *GRPCServer) registerLiveServers() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "master_live_volume_servers",
+		Help:      "Volume servers that are heartbeating.",
+	}, func() float64 {
+		g.mu.RLock()
+		defer g.mu.RUnlock()
+
+		live := 0
+		for id := range g.volumeServers {
+			if g.serverAlive(id) {
+				live++
+			}
+		}
+		return float64(live)
+	})
+}
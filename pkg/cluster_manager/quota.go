@@ -0,0 +1,272 @@
+package cluster_manager
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// quota limits a bucket's or a tenant's usage, zero fields don't limit anything.
+type quota struct {
+	MaxBytes       int64 `json:"max_bytes,omitempty"`
+	MaxObjects     int64 `json:"max_objects,omitempty"`
+	SoftMaxBytes   int64 `json:"soft_max_bytes,omitempty"`
+	SoftMaxObjects int64 `json:"soft_max_objects,omitempty"`
+}
+
+func quotaFromProto(q *pb.Quota) (quota, error) {
+	parsed := quota{
+		MaxBytes:       q.GetMaxBytes(),
+		MaxObjects:     q.GetMaxObjects(),
+		SoftMaxBytes:   q.GetSoftMaxBytes(),
+		SoftMaxObjects: q.GetSoftMaxObjects(),
+	}
+	if parsed.MaxBytes < 0 || parsed.MaxObjects < 0 || parsed.SoftMaxBytes < 0 || parsed.SoftMaxObjects < 0 {
+		return quota{}, status.Errorf(codes.InvalidArgument, "quota limits can't be negative")
+	}
+	if parsed.MaxBytes > 0 && parsed.SoftMaxBytes > parsed.MaxBytes {
+		return quota{}, status.Errorf(codes.InvalidArgument, "soft byte limit is above the hard one")
+	}
+	if parsed.MaxObjects > 0 && parsed.SoftMaxObjects > parsed.MaxObjects {
+		return quota{}, status.Errorf(codes.InvalidArgument, "soft object limit is above the hard one")
+	}
+	return parsed, nil
+}
+
+func (q quota) proto() *pb.Quota {
+	return &pb.Quota{
+		MaxBytes:       q.MaxBytes,
+		MaxObjects:     q.MaxObjects,
+		SoftMaxBytes:   q.SoftMaxBytes,
+		SoftMaxObjects: q.SoftMaxObjects,
+	}
+}
+
+// check returns a warning for usage growing past a soft limit, or a ResourceExhausted
+// error if it would go past a hard one. Shrinking is always allowed.
+func (q quota) check(scope string, u usage, bytes, objects int64) (string, error) {
+	if bytes > 0 && q.MaxBytes > 0 && u.bytes+bytes > q.MaxBytes {
+		return "", status.Errorf(codes.ResourceExhausted, "%s would use %d bytes, over its quota of %d", scope, u.bytes+bytes, q.MaxBytes)
+	}
+	if objects > 0 && q.MaxObjects > 0 && u.objects+objects > q.MaxObjects {
+		return "", status.Errorf(codes.ResourceExhausted, "%s would have %d objects, over its quota of %d", scope, u.objects+objects, q.MaxObjects)
+	}
+	if q.SoftMaxBytes > 0 && u.bytes+bytes > q.SoftMaxBytes {
+		return fmt.Sprintf("%s uses %d bytes, over its soft limit of %d", scope, u.bytes+bytes, q.SoftMaxBytes), nil
+	}
+	if q.SoftMaxObjects > 0 && u.objects+objects > q.SoftMaxObjects {
+		return fmt.Sprintf("%s has %d objects, over its soft limit of %d", scope, u.objects+objects, q.SoftMaxObjects), nil
+	}
+	return "", nil
+}
+
+// usage is what the versions of a bucket's objects take up.
+type usage struct {
+	bytes   int64
+	objects int64
+}
+
+// add counts n more of a version, delete markers take up nothing.
+func (u *usage) add(o *object, n int64) {
+	if o == nil || o.deleteMarker {
+		return
+	}
+	u.bytes += n * o.sizeBytes
+	u.objects += n
+}
+
+func (u usage) proto() *pb.Usage {
+	return &pb.Usage{Bytes: u.bytes, Objects: u.objects}
+}
+
+func bucketQuotaRecord(name string, b *bucket) ledgerRecord {
+	return ledgerRecord{Op: ledgerOpQuota, Bucket: name, Tenant: b.tenant, Quota: b.quota, Time: time.Now().UTC()}
+}
+
+func tenantQuotaRecord(tenant string, q quota) ledgerRecord {
+	return ledgerRecord{Op: ledgerOpTenantQuota, Tenant: tenant, Quota: q, Time: time.Now().UTC()}
+}
+
+// tenantUsage adds up the usage of a tenant's buckets. Callers must hold l.mu.
+func (l *Ledger) tenantUsage(tenant string) (usage, []string) {
+	var u usage
+	var buckets []string
+	for name, b := range l.buckets {
+		if b.tenant == tenant {
+			u.bytes += b.usage.bytes
+			u.objects += b.usage.objects
+			buckets = append(buckets, name)
+		}
+	}
+	sort.Strings(buckets)
+	return u, buckets
+}
+
+// growth is how much putting size bytes at a path adds to its bucket's usage, less
+// the version the put replaces. Callers must hold l.mu.
+func growth(b *bucket, path string, size int64) (int64, int64) {
+	if b == nil || b.versioning == pb.BucketVersioning_ENABLED {
+		return size, 1
+	}
+	if old := b.version(path, nullVersionId); old != nil && !old.deleteMarker {
+		return size - old.sizeBytes, 0
+	}
+	return size, 1
+}
+
+// checkQuota checks a bucket's and its tenant's quotas for a write growing the bucket
+// by bytes and objects, returning the soft limits it goes past. Callers must hold l.mu.
+func (l *Ledger) checkQuota(bucketName string, bytes, objects int64) ([]string, error) {
+	b := l.buckets[bucketName]
+	if b == nil {
+		return nil, nil
+	}
+
+	var warnings []string
+	warning, err := b.quota.check(fmt.Sprintf("bucket %s", bucketName), b.usage, bytes, objects)
+	if err != nil {
+		quotaRejections.WithLabelValues("bucket").Inc()
+		return nil, err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	if b.tenant == "" {
+		return warnings, nil
+	}
+	u, _ := l.tenantUsage(b.tenant)
+	warning, err = l.tenants[b.tenant].check(fmt.Sprintf("tenant %s", b.tenant), u, bytes, objects)
+	if err != nil {
+		quotaRejections.WithLabelValues("tenant").Inc()
+		return nil, err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	return warnings, nil
+}
+
+// SetBucketQuota replaces a bucket's quota and the tenant it belongs to. Usage
+// already past the new limits stays, only writes adding to it are rejected.
+func (l *Ledger) SetBucketQuota(ctx context.Context, req *pb.BucketQuota) (*pb.BucketQuota, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+	if req.GetTenant() != "" {
+		if err := validateTenantName(req.GetTenant()); err != nil {
+			return nil, err
+		}
+	}
+	q, err := quotaFromProto(req.GetQuota())
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(req.GetBucket())
+	if err := l.commit(bucketQuotaRecord(req.GetBucket(), &bucket{tenant: req.GetTenant(), quota: q})); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record quota: %v", err)
+	}
+	log.Printf("Set quota of bucket %s, tenant %q: %+v", req.GetBucket(), b.tenant, b.quota)
+	return &pb.BucketQuota{Bucket: req.GetBucket(), Tenant: b.tenant, Quota: b.quota.proto(), Usage: b.usage.proto()}, nil
+}
+
+func (l *Ledger) GetBucketQuota(ctx context.Context, req *pb.GetBucketQuotaRequest) (*pb.BucketQuota, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	resp := &pb.BucketQuota{Bucket: req.GetBucket(), Quota: &pb.Quota{}, Usage: &pb.Usage{}}
+	if b, ok := l.buckets[req.GetBucket()]; ok {
+		resp.Tenant, resp.Quota, resp.Usage = b.tenant, b.quota.proto(), b.usage.proto()
+	}
+	return resp, nil
+}
+
+// SetTenantQuota replaces the quota shared by every bucket assigned to a tenant.
+func (l *Ledger) SetTenantQuota(ctx context.Context, req *pb.TenantQuota) (*pb.TenantQuota, error) {
+	if err := validateTenantName(req.GetTenant()); err != nil {
+		return nil, err
+	}
+	q, err := quotaFromProto(req.GetQuota())
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.commit(tenantQuotaRecord(req.GetTenant(), q)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record quota: %v", err)
+	}
+	log.Printf("Set quota of tenant %s: %+v", req.GetTenant(), q)
+	return l.tenantQuota(req.GetTenant()), nil
+}
+
+func (l *Ledger) GetTenantQuota(ctx context.Context, req *pb.GetTenantQuotaRequest) (*pb.TenantQuota, error) {
+	if err := validateTenantName(req.GetTenant()); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tenantQuota(req.GetTenant()), nil
+}
+
+// tenantQuota describes a tenant, one nothing was set for has no limits. Callers
+// must hold l.mu.
+func (l *Ledger) tenantQuota(tenant string) *pb.TenantQuota {
+	u, buckets := l.tenantUsage(tenant)
+	return &pb.TenantQuota{Tenant: tenant, Quota: l.tenants[tenant].proto(), Usage: u.proto(), Buckets: buckets}
+}
+
+// ListUsage returns every bucket and every tenant with a quota or a bucket, by name.
+func (l *Ledger) ListUsage(ctx context.Context, req *pb.ListUsageRequest) (*pb.ListUsageResponse, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	resp := &pb.ListUsageResponse{}
+	tenants := make(map[string]bool)
+	for t := range l.tenants {
+		tenants[t] = true
+	}
+	for name, b := range l.buckets {
+		resp.Buckets = append(resp.Buckets, &pb.BucketQuota{Bucket: name, Tenant: b.tenant, Quota: b.quota.proto(), Usage: b.usage.proto()})
+		if b.tenant != "" {
+			tenants[b.tenant] = true
+		}
+	}
+	for t := range tenants {
+		resp.Tenants = append(resp.Tenants, l.tenantQuota(t))
+	}
+
+	sort.Slice(resp.Buckets, func(i, j int) bool {
+		return resp.Buckets[i].Bucket < resp.Buckets[j].Bucket
+	})
+	sort.Slice(resp.Tenants, func(i, j int) bool {
+		return resp.Tenants[i].Tenant < resp.Tenants[j].Tenant
+	})
+	return resp, nil
+}
+
+func validateTenantName(tenant string) error {
+	if !tenantNamePattern.MatchString(tenant) {
+		return status.Errorf(codes.InvalidArgument, "invalid tenant name %q: use 1-63 letters, digits, '.', '_' or '-'", tenant)
+	}
+	return nil
+}
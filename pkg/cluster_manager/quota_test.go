@@ -0,0 +1,144 @@
+package cluster_manager
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	q := quota{MaxBytes: 100, MaxObjects: 10, SoftMaxBytes: 80, SoftMaxObjects: 8}
+
+	tests := []struct {
+		name        string
+		q           quota
+		used        usage
+		bytes       int64
+		objects     int64
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "no limits", q: quota{}, used: usage{bytes: 1 << 40, objects: 1 << 20}, bytes: 1, objects: 1},
+		{name: "under the limits", q: q, used: usage{bytes: 10, objects: 1}, bytes: 10, objects: 1},
+		{name: "up to the byte limit", q: q, used: usage{bytes: 90, objects: 1}, bytes: 10, objects: 1, wantWarning: true},
+		{name: "past the byte limit", q: q, used: usage{bytes: 90, objects: 1}, bytes: 11, objects: 1, wantErr: true},
+		{name: "past the object limit", q: q, used: usage{bytes: 10, objects: 10}, bytes: 1, objects: 1, wantErr: true},
+		{name: "past the soft byte limit", q: q, used: usage{bytes: 70, objects: 1}, bytes: 11, objects: 1, wantWarning: true},
+		{name: "past the soft object limit", q: q, used: usage{bytes: 10, objects: 8}, bytes: 1, objects: 1, wantWarning: true},
+		{name: "shrinking while over", q: q, used: usage{bytes: 150, objects: 12}, bytes: -10, wantWarning: true},
+		{name: "overwrite growing while over", q: q, used: usage{bytes: 150, objects: 12}, bytes: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning, err := tt.q.check("bucket photos", tt.used, tt.bytes, tt.objects)
+			if tt.wantErr {
+				if status.Code(err) != codes.ResourceExhausted {
+					t.Fatalf("check = %v, want ResourceExhausted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("check = %v", err)
+			}
+			if (warning != "") != tt.wantWarning {
+				t.Fatalf("check warning = %q, want one %v", warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+// newQuotaLedger is an in-memory ledger with two buckets of tenant acme holding
+// 60 bytes in 3 objects, and a tenant-less bucket.
+func newQuotaLedger(t *testing.T) *Ledger {
+	t.Helper()
+	l := &Ledger{buckets: make(map[string]*bucket), tenants: make(map[string]quota)}
+	records := []ledgerRecord{
+		{Op: ledgerOpQuota, Bucket: "photos", Tenant: "acme", Quota: quota{MaxBytes: 50, MaxObjects: 3}},
+		{Op: ledgerOpQuota, Bucket: "videos", Tenant: "acme"},
+		{Op: ledgerOpTenantQuota, Tenant: "acme", Quota: quota{MaxBytes: 100, SoftMaxObjects: 3}},
+		{Op: ledgerOpPut, Bucket: "photos", Path: "a.jpg", Size: 20},
+		{Op: ledgerOpPut, Bucket: "photos", Path: "b.jpg", Size: 20},
+		{Op: ledgerOpPut, Bucket: "videos", Path: "a.mp4", Size: 20},
+		{Op: ledgerOpPut, Bucket: "scratch", Path: "big.bin", Size: 1000},
+	}
+	for _, rec := range records {
+		if rec.Op == ledgerOpPut {
+			rec.VolumeId, rec.NeedleId = uuid.NewString(), uuid.NewString()
+		}
+		if err := l.apply(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l
+}
+
+func TestLedgerCheckQuota(t *testing.T) {
+	tests := []struct {
+		name         string
+		versioned    bool
+		bucket       string
+		path         string
+		size         int64
+		wantWarnings int
+		wantErr      bool
+	}{
+		{name: "new object within both quotas", bucket: "photos", path: "c.jpg", size: 10, wantWarnings: 1},
+		{name: "new object past the bucket's bytes", bucket: "photos", path: "c.jpg", size: 11, wantErr: true},
+		{name: "overwrite counts only the growth", bucket: "photos", path: "a.jpg", size: 30},
+		{name: "overwrite in a versioned bucket keeps the old version", versioned: true, bucket: "photos", path: "a.jpg", size: 20, wantErr: true},
+		{name: "past the tenant's bytes from another bucket", bucket: "videos", path: "b.mp4", size: 41, wantErr: true},
+		{name: "within the tenant's bytes", bucket: "videos", path: "b.mp4", size: 40, wantWarnings: 1},
+		{name: "bucket without a quota or tenant", bucket: "scratch", path: "bigger.bin", size: 1 << 30},
+		{name: "bucket that doesn't exist yet", bucket: "new", path: "a", size: 1 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newQuotaLedger(t)
+			b := l.buckets[tt.bucket]
+			if tt.versioned {
+				b.versioning = pb.BucketVersioning_ENABLED
+			}
+
+			bytes, objects := growth(b, tt.path, tt.size)
+			warnings, err := l.checkQuota(tt.bucket, bytes, objects)
+			if tt.wantErr {
+				if status.Code(err) != codes.ResourceExhausted {
+					t.Fatalf("checkQuota = %v, want ResourceExhausted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkQuota = %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Fatalf("checkQuota warnings = %q, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestUsageFollowsVersions(t *testing.T) {
+	l := newQuotaLedger(t)
+	if u := l.buckets["photos"].usage; u != (usage{bytes: 40, objects: 2}) {
+		t.Fatalf("photos usage = %+v, want 40 bytes in 2 objects", u)
+	}
+
+	// an overwrite replaces the null version, a delete marker takes up nothing
+	for _, rec := range []ledgerRecord{
+		{Op: ledgerOpPut, Bucket: "photos", Path: "a.jpg", Size: 5, VolumeId: uuid.NewString(), NeedleId: uuid.NewString()},
+		{Op: ledgerOpMarker, Bucket: "photos", Path: "b.jpg", VersionId: uuid.NewString()},
+	} {
+		if err := l.apply(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u := l.buckets["photos"].usage; u != (usage{bytes: 25, objects: 2}) {
+		t.Fatalf("photos usage = %+v, want 25 bytes in 2 objects", u)
+	}
+	if u, buckets := l.tenantUsage("acme"); u != (usage{bytes: 45, objects: 3}) || len(buckets) != 2 {
+		t.Fatalf("acme usage = %+v over %v, want 45 bytes in 3 objects over 2 buckets", u, buckets)
+	}
+}
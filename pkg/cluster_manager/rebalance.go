@@ -0,0 +1,294 @@
+package cluster_manager
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RebalanceConfig controls how the master evens out volume bytes across volume servers.
+//
+// Placement is limited to what the cluster knows about its servers: volumes stay within
+// their storage class and no move takes a server below its free disk floor. There are
+// no rack or zone labels, so rules spreading copies across failure domains are out of scope.
+type RebalanceConfig struct {
+	Interval    time.Duration // time between automatic rebalances, 0 to only rebalance on demand
+	BytesPerSec int64         // max copy rate of a move, 0 for unlimited
+	Threshold   float64       // how far above its target share a server may be before volumes move off it
+	LocationTTL time.Duration // how long gateways cache volume locations, old copies are kept that long after a move or drain
+}
+
+// serverLoad is a volume server's share of the volume bytes while a rebalance is planned.
+type serverLoad struct {
+	id       uuid.UUID
+	addr     string
+	class    string // volumes only move between servers of the same storage class
+	capacity uint64
+	diskFree uint64
+	diskMin  uint64 // free disk a move may not take the server below
+	current  uint64
+	planned  uint64
+	target   uint64
+	volumes  []*volume
+}
+
+func (l *serverLoad) excess() float64 {
+	return float64(l.planned) - float64(l.target)
+}
+
+// RebalanceVolumes plans moves of sealed volumes from volume servers holding more
+// than their share of the cluster's bytes to ones holding less, and unless it's a
+// dry run starts them in the background, one at a time.
+func (g *GRPCServer) RebalanceVolumes(ctx context.Context, req *pb.RebalanceVolumesRequest) (*pb.RebalancePlan, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.rebalancing && !req.GetDryRun() {
+		return nil, status.Errorf(codes.FailedPrecondition, "a rebalance is already running")
+	}
+
+	moves, loads := g.planRebalance(int(req.GetMaxMoves()))
+	plan := rebalancePlan(moves, loads)
+	if req.GetDryRun() || len(moves) == 0 {
+		return plan, nil
+	}
+
+	g.rebalancing = true
+	plan.Started = true
+	go g.runMoves(moves)
+	return plan, nil
+}
+
+// planRebalance works out which volumes to move so each server's bytes end up close
+// to its share of the total, weighted by disk size. Callers must hold g.mu.
+func (g *GRPCServer) planRebalance(maxMoves int) ([]*pb.VolumeMove, []*serverLoad) {
+	var loads []*serverLoad
+	byServer := make(map[uuid.UUID]*serverLoad)
+	for id, addr := range g.volumeServers {
+		st := g.servers[id]
+		// servers that haven't reported their disk yet can't be weighed
+		if !g.acceptsWrites(id) || st.diskTotal == 0 {
+			continue
+		}
+		l := &serverLoad{
+			id:       id,
+			addr:     addr,
+			class:    st.storageClass,
+			capacity: st.diskTotal,
+			diskFree: st.diskFree,
+			diskMin:  uint64(lowDiskFreeRatio * float64(st.diskTotal)),
+		}
+		loads = append(loads, l)
+		byServer[id] = l
+	}
+
+	totalBytes, totalCapacity := make(map[string]uint64), make(map[string]uint64)
+	for _, v := range g.volumes {
+		l, ok := byServer[v.server]
+		if !ok {
+			continue
+		}
+		l.current += v.sizeBytes
+		totalBytes[l.class] += v.sizeBytes
+		if g.movable(v) {
+			l.volumes = append(l.volumes, v)
+		}
+	}
+	for _, l := range loads {
+		l.planned = l.current
+		totalCapacity[l.class] += l.capacity
+	}
+	for _, l := range loads {
+		l.target = uint64(float64(totalBytes[l.class]) * float64(l.capacity) / float64(totalCapacity[l.class]))
+	}
+
+	var moves []*pb.VolumeMove
+	for maxMoves == 0 || len(moves) < maxMoves {
+		m := g.nextMove(loads)
+		if m == nil {
+			break
+		}
+		moves = append(moves, m)
+	}
+
+	sort.Slice(loads, func(i, j int) bool {
+		return loads[i].id.String() < loads[j].id.String()
+	})
+	return moves, loads
+}
+
+// movable reports whether the rebalancer may move a volume. Primary volumes belong to
+// their server, unsealed ones still take writes, TTL volumes are dropped soon anyway
+// and ones with corrupt needles wait for a repair from where they are. Callers must hold g.mu.
+func (g *GRPCServer) movable(v *volume) bool {
+	if v.id == v.server || !v.sealed || v.readOnly || v.ttl > 0 || !v.expiresAt.IsZero() {
+		return false
+	}
+	for key := range g.corruptNeedles {
+		if key.volume == v.id {
+			return false
+		}
+	}
+	return true
+}
+
+// nextMove picks the volume whose move does the most to even out the most loaded
+// server and the least loaded one that can take it, and applies it to loads.
+func (g *GRPCServer) nextMove(loads []*serverLoad) *pb.VolumeMove {
+	sources := append([]*serverLoad(nil), loads...)
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].excess() > sources[j].excess()
+	})
+
+	for _, src := range sources {
+		if src.excess() <= g.rebalance.Threshold*float64(src.target) {
+			break
+		}
+
+		for i := len(sources) - 1; i >= 0 && sources[i].excess() < 0; i-- {
+			dst := sources[i]
+			if dst.class != src.class {
+				continue
+			}
+
+			best, bestGain := -1, 0.0
+			for j, v := range src.volumes {
+				if dst.diskFree < dst.diskMin+v.sizeBytes {
+					continue
+				}
+				size := float64(v.sizeBytes)
+				gain := abs(src.excess()) + abs(dst.excess()) - abs(src.excess()-size) - abs(dst.excess()+size)
+				if gain > bestGain {
+					best, bestGain = j, gain
+				}
+			}
+			if best < 0 {
+				continue
+			}
+
+			v := src.volumes[best]
+			src.volumes = append(src.volumes[:best], src.volumes[best+1:]...)
+			src.planned -= v.sizeBytes
+			dst.planned += v.sizeBytes
+			dst.diskFree -= v.sizeBytes
+			return &pb.VolumeMove{
+				VolumeId:     v.id.String(),
+				FromServerId: src.id.String(),
+				FromAddress:  src.addr,
+				ToServerId:   dst.id.String(),
+				ToAddress:    dst.addr,
+				SizeBytes:    v.sizeBytes,
+			}
+		}
+	}
+	return nil
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func rebalancePlan(moves []*pb.VolumeMove, loads []*serverLoad) *pb.RebalancePlan {
+	plan := &pb.RebalancePlan{Moves: moves}
+	for _, l := range loads {
+		plan.Servers = append(plan.Servers, &pb.ServerLoad{
+			ServerId:     l.id.String(),
+			HttpAddress:  l.addr,
+			VolumeBytes:  l.current,
+			TargetBytes:  l.target,
+			PlannedBytes: l.planned,
+		})
+	}
+	return plan
+}
+
+// movedCopy is a volume the cluster was switched over to a new copy of, with its
+// old copy still in place.
+type movedCopy struct {
+	move     *pb.VolumeMove
+	copyAddr string // VolumeService of the old copy
+	switched time.Time
+}
+
+// waitOutLocations waits until gateways dropped the locations they cached before a
+// volume was switched over to its new copy. Gateways that don't watch the topology
+// send deletes to the old copy until their cached location expires.
+func (g *GRPCServer) waitOutLocations(switched time.Time) {
+	time.Sleep(time.Until(switched.Add(g.rebalance.LocationTTL)))
+}
+
+// runMoves carries out planned moves one after the other: copy the volume, switch
+// the cluster over to the new copy and catch it up. Once gateways had time to drop
+// the old location, what still reached the old copy is caught up and it's dropped.
+func (g *GRPCServer) runMoves(moves []*pb.VolumeMove) {
+	defer func() {
+		g.mu.Lock()
+		g.rebalancing = false
+		g.mu.Unlock()
+	}()
+
+	ctx := context.Background()
+	start := time.Now()
+	var switched []movedCopy
+	for _, m := range moves {
+		volumeId, from, to := uuid.MustParse(m.VolumeId), uuid.MustParse(m.FromServerId), uuid.MustParse(m.ToServerId)
+
+		// the cluster may have changed since the plan was made
+		g.mu.RLock()
+		v, ok := g.volumes[volumeId]
+		stale := !ok || v.server != from || !g.movable(v) || !g.acceptsWrites(from) || !g.acceptsWrites(to)
+		copyAddr := g.servers[from].grpcAddr
+		g.mu.RUnlock()
+		if stale {
+			log.Printf("Skipping move of volume %s, the cluster changed since it was planned", volumeId)
+			continue
+		}
+
+		if err := g.moveVolume(ctx, v, from, to, g.rebalance.BytesPerSec); err != nil {
+			log.Printf("Failed to move volume %s from %s to %s. Why: %v", volumeId, m.FromAddress, m.ToAddress, err)
+			continue
+		}
+		switched = append(switched, movedCopy{move: m, copyAddr: copyAddr, switched: time.Now()})
+	}
+
+	moved := 0
+	for _, c := range switched {
+		volumeId := uuid.MustParse(c.move.VolumeId)
+
+		g.waitOutLocations(c.switched)
+		if err := g.pullVolume(ctx, c.move.ToAddress, c.copyAddr, volumeId, g.rebalance.BytesPerSec); err != nil {
+			log.Printf("Final catch-up of volume %s on %s failed, keeping its old copy on %s. Why: %v", volumeId, c.move.ToAddress, c.move.FromAddress, err)
+			continue
+		}
+		if err := g.removeCopy(c.move.FromAddress, volumeId); err != nil {
+			log.Printf("Failed to remove the old copy of volume %s from %s. Why: %v", volumeId, c.move.FromAddress, err)
+		}
+		moved++
+	}
+	log.Printf("Rebalance moved %d of %d planned volumes in %s", moved, len(moves), time.Since(start).Round(time.Second))
+}
+
+// rebalanceVolumes starts a rebalance every interval when the cluster is uneven.
+func (g *GRPCServer) rebalanceVolumes() {
+	ticker := time.NewTicker(g.rebalance.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		plan, err := g.RebalanceVolumes(context.Background(), &pb.RebalanceVolumesRequest{})
+		if err != nil {
+			continue
+		}
+		if plan.Started {
+			log.Printf("Rebalancing volumes, %d moves planned", len(plan.Moves))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cluster_manager
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type needleKey struct {
+	volume uuid.UUID
+	needle uuid.UUID
+}
+
+// corruptNeedle is a scrubber finding waiting to be repaired from a healthy replica.
+type corruptNeedle struct {
+	server     uuid.UUID
+	reason     string
+	detectedAt time.Time
+}
+
+// ReportCorruptNeedles takes what a volume server's scrub pass found. The pass checked
+// every needle the server holds, so what it reported before and didn't find again
+// was deleted, vacuumed away or repaired.
+func (g *GRPCServer) ReportCorruptNeedles(ctx context.Context, req *pb.ReportCorruptNeedlesRequest) (*pb.ReportCorruptNeedlesResponse, error) {
+	if err := g.authorizeVolumeServer(ctx); err != nil {
+		return nil, err
+	}
+
+	serverId, err := uuid.FromBytes(req.GetServerId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid server id format")
+	}
+
+	found := make(map[needleKey]*pb.CorruptNeedle, len(req.GetNeedles()))
+	for _, n := range req.GetNeedles() {
+		volumeId, err := uuid.FromBytes(n.GetVolumeId())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
+		}
+		needleId, err := uuid.FromBytes(n.GetNeedleId())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid needle id format")
+		}
+		found[needleKey{volume: volumeId, needle: needleId}] = n
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	known := make(map[needleKey]bool)
+	for key, n := range g.corruptNeedles {
+		if n.server != serverId {
+			continue
+		}
+		known[key] = true
+		delete(g.corruptNeedles, key)
+		if _, ok := found[key]; !ok {
+			log.Printf("Needle %s in volume %s on volume server %s is no longer corrupt", key.needle, key.volume, serverId)
+		}
+	}
+	for key, n := range found {
+		g.corruptNeedles[key] = corruptNeedle{
+			server:     serverId,
+			reason:     n.GetReason(),
+			detectedAt: time.Unix(n.GetDetectedAt(), 0),
+		}
+		if !known[key] {
+			log.Printf("Needle %s in volume %s on volume server %s is corrupt, queued for repair. Why: %s", key.needle, key.volume, serverId, n.GetReason())
+		}
+	}
+
+	return &pb.ReportCorruptNeedlesResponse{}, nil
+}
@@ -0,0 +1,49 @@
+package cluster_manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+func TestReportCorruptNeedlesReplacesServerReport(t *testing.T) {
+	g := &GRPCServer{corruptNeedles: make(map[needleKey]corruptNeedle)}
+	server, other := uuid.New(), uuid.New()
+	fixed, still, elsewhere := newNeedleKey(), newNeedleKey(), newNeedleKey()
+
+	report := func(server uuid.UUID, keys ...needleKey) {
+		t.Helper()
+		req := &pb.ReportCorruptNeedlesRequest{ServerId: server[:]}
+		for _, k := range keys {
+			req.Needles = append(req.Needles, &pb.CorruptNeedle{VolumeId: k.volume[:], NeedleId: k.needle[:], Reason: "checksum mismatch"})
+		}
+		if _, err := g.ReportCorruptNeedles(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		server uuid.UUID
+		keys   []needleKey
+		want   []needleKey
+	}{
+		{name: "first pass", server: server, keys: []needleKey{fixed, still}, want: []needleKey{fixed, still}},
+		{name: "another server", server: other, keys: []needleKey{elsewhere}, want: []needleKey{fixed, still, elsewhere}},
+		{name: "one repaired", server: server, keys: []needleKey{still}, want: []needleKey{still, elsewhere}},
+		{name: "clean pass", server: server, want: []needleKey{elsewhere}},
+	}
+	for _, s := range steps {
+		report(s.server, s.keys...)
+		if len(g.corruptNeedles) != len(s.want) {
+			t.Fatalf("%s: %d corrupt needles, want %d", s.name, len(g.corruptNeedles), len(s.want))
+		}
+		for _, k := range s.want {
+			if _, ok := g.corruptNeedles[k]; !ok {
+				t.Fatalf("%s: needle %v isn't queued for repair", s.name, k)
+			}
+		}
+	}
+}
@@ -2,40 +2,69 @@ package cluster_manager
 
 import (
 	"context"
+	"hash/fnv"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type GRPCServer struct {
-	addr          string
-	volumeServers map[uuid.UUID]string // volume server id -> addr
-	srv           *grpc.Server
-	mu            sync.RWMutex
-	rand          *rand.Rand
+	addr           string
+	volumeServers  map[uuid.UUID]string  // volume server id -> addr
+	volumes        map[uuid.UUID]*volume // volume id -> placement
+	servers        map[uuid.UUID]*serverState
+	watchers       map[chan *pb.TopologyEvent]struct{}
+	corruptNeedles map[needleKey]corruptNeedle
+	drains         map[uuid.UUID]*drainProgress
+	rebalance      RebalanceConfig
+	rebalancing    bool
+	tls            TLSConfig
+	writeTokens    WriteTokenConfig
+	srv            *grpc.Server
+	httpClient     *http.Client
+	adminClient    *http.Client // no timeout, for calls that last as long as the work they start
+	mu             sync.RWMutex
+	rand           *rand.Rand
 	pb.UnimplementedMasterServiceServer
 }
 
-func NewGRPCServer(addr string) *GRPCServer {
+func NewGRPCServer(addr string, rebalance RebalanceConfig, tls TLSConfig, writeTokens WriteTokenConfig) *GRPCServer {
 	volumeServers := make(map[uuid.UUID]string)
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(tls.Certs.ServerOption(), grpc.UnaryInterceptor(timeRPC), grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	transport := tls.Certs.Transport()
 	g := &GRPCServer{
-		addr:          addr,
-		volumeServers: volumeServers,
-		srv:           s,
-		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		addr:           addr,
+		volumeServers:  volumeServers,
+		volumes:        make(map[uuid.UUID]*volume),
+		servers:        make(map[uuid.UUID]*serverState),
+		watchers:       make(map[chan *pb.TopologyEvent]struct{}),
+		corruptNeedles: make(map[needleKey]corruptNeedle),
+		drains:         make(map[uuid.UUID]*drainProgress),
+		rebalance:      rebalance,
+		tls:            tls,
+		writeTokens:    writeTokens,
+		srv:            s,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+		},
+		adminClient: &http.Client{Transport: transport},
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
 	pb.RegisterMasterServiceServer(s, g)
+	g.registerLiveServers()
 
 	return g
 }
@@ -46,6 +75,12 @@ func (g *GRPCServer) Run() {
 		log.Fatalf("Failed to init tcp listener on addr: %s. Why: %v", g.addr, err)
 	}
 
+	go g.reapExpiredVolumes()
+	go g.monitorHeartbeats()
+	if g.rebalance.Interval > 0 {
+		go g.rebalanceVolumes()
+	}
+
 	log.Printf("Master server listening on %s", g.addr)
 	if err := g.srv.Serve(listener); err != nil {
 		log.Fatalf("Failed to init gRPC server on top of tcp listener. Why %v", err)
@@ -53,6 +88,10 @@ func (g *GRPCServer) Run() {
 }
 
 func (g *GRPCServer) RegisterVolume(ctx context.Context, req *pb.RegisterVolumeRequest) (*pb.RegisterVolumeResponse, error) {
+	if err := g.authorizeVolumeServer(ctx); err != nil {
+		return nil, err
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
@@ -62,27 +101,61 @@ func (g *GRPCServer) RegisterVolume(ctx context.Context, req *pb.RegisterVolumeR
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
 	}
 
-	g.volumeServers[volumeId] = volumeAddr
+	if g.drained(volumeId) {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume server %s was drained and removed from the cluster", volumeId)
+	}
+
+	g.syncServer(volumeId, volumeAddr, req.GetGrpcAddress(), req.GetStorageClass(), req.GetVolumes())
 	log.Printf("Volume %s at addr %s successfully registered", volumeId, volumeAddr)
 
 	return &pb.RegisterVolumeResponse{}, nil
 }
 
 func (g *GRPCServer) AssignVolume(ctx context.Context, req *pb.AssignVolumeRequest) (*pb.AssignVolumeResponse, error) {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
+	if err := g.checkWriteSize(req.GetMaxBytes()); err != nil {
+		return nil, err
+	}
 
-	if len(g.volumeServers) == 0 {
-		return nil, status.Errorf(codes.Unavailable, "no volume servers available")
+	placementKey := req.GetIdempotencyKey()
+	if sha := req.GetContentSha256(); sha != "" {
+		if placementKey != "" || req.GetTtlSeconds() > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "content-addressed writes can't have a ttl or an idempotency key")
+		}
+		placementKey = "sha256:" + sha
 	}
 
-	keys := make([]uuid.UUID, 0, len(g.volumeServers))
-	for k := range g.volumeServers {
-		keys = append(keys, k)
+	class := storageClass(req.GetStorageClass())
+	var resp *pb.AssignVolumeResponse
+	var err error
+	if ttl := time.Duration(req.GetTtlSeconds()) * time.Second; ttl > 0 {
+		if class != defaultStorageClass {
+			return nil, status.Errorf(codes.InvalidArgument, "writes with a ttl only go to %s storage", defaultStorageClass)
+		}
+		resp, err = g.assignTTLVolume(ttl, placementKey)
+	} else {
+		resp, err = g.assignPrimaryVolume(class, placementKey)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	randomKey := keys[g.rand.Intn(len(keys))]
+	g.attachWriteToken(resp, req)
+	return resp, nil
+}
+
+func (g *GRPCServer) assignPrimaryVolume(class, placementKey string) (*pb.AssignVolumeResponse, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	randomKey, ok := g.pickServer(class, placementKey)
+	if !ok {
+		if class != defaultStorageClass {
+			return nil, status.Errorf(codes.Unavailable, "no volume servers of storage class %s available", class)
+		}
+		return nil, status.Errorf(codes.Unavailable, "no volume servers available")
+	}
 	addr := g.volumeServers[randomKey]
+	assignments.WithLabelValues(randomKey.String()).Inc()
 
 	return &pb.AssignVolumeResponse{
 		HttpAddress: addr,
@@ -100,7 +173,11 @@ func (g *GRPCServer) GetVolumeLocation(ctx context.Context, req *pb.GetVolumeLoc
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume id format")
 	}
 
-	addr, ok := g.volumeServers[volumeId]
+	v, ok := g.volumes[volumeId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume id not found: %s", volumeId)
+	}
+	addr, ok := g.volumeServers[v.server]
 	if !ok {
 		return nil, status.Errorf(codes.NotFound, "volume id not found: %s", volumeId)
 	}
@@ -109,3 +186,126 @@ func (g *GRPCServer) GetVolumeLocation(ctx context.Context, req *pb.GetVolumeLoc
 		HttpAddress: addr,
 	}, nil
 }
+
+// volumeAddr returns the http address of the server holding a volume, empty if it's unknown.
+func (g *GRPCServer) volumeAddr(volumeId uuid.UUID) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	v, ok := g.volumes[volumeId]
+	if !ok {
+		return ""
+	}
+	return g.volumeServers[v.server]
+}
+
+// randomServer picks a live, undrained volume server of a storage class whose
+// primary volume takes writes. Callers must hold g.mu.
+func (g *GRPCServer) randomServer(class string) (uuid.UUID, bool) {
+	keys := g.writableServers(class)
+	if len(keys) == 0 {
+		return uuid.Nil, false
+	}
+	return keys[g.rand.Intn(len(keys))], true
+}
+
+// pickServer picks a server like randomServer, except that writes with an idempotency
+// key or a content hash go to the same server for as long as the writable servers stay
+// the same, so a retry finds the needle of the first attempt and the same data is
+// stored once. Callers must hold g.mu.
+func (g *GRPCServer) pickServer(class, placementKey string) (uuid.UUID, bool) {
+	if placementKey == "" {
+		return g.randomServer(class)
+	}
+	keys := g.writableServers(class)
+	if len(keys) == 0 {
+		return uuid.Nil, false
+	}
+	return rendezvous(placementKey, keys), true
+}
+
+// rendezvous picks the id that hashes highest together with key.
+func rendezvous(key string, ids []uuid.UUID) uuid.UUID {
+	var best uuid.UUID
+	var bestScore uint64
+	for i, id := range ids {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write(id[:])
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = id, score
+		}
+	}
+	return best
+}
+
+// writableServers lists the live, undrained volume servers of a storage class whose
+// primary volume takes writes. Callers must hold g.mu.
+func (g *GRPCServer) writableServers(class string) []uuid.UUID {
+	keys := make([]uuid.UUID, 0, len(g.volumeServers))
+	for k := range g.volumeServers {
+		if !g.acceptsWrites(k) || g.servers[k].storageClass != class {
+			continue
+		}
+		if v, ok := g.volumes[k]; ok && !v.writable() {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// syncServer records a volume server's addresses and the volumes it holds,
+// publishing whatever changed to topology watchers. Callers must hold g.mu.
+func (g *GRPCServer) syncServer(serverId uuid.UUID, addr, grpcAddr, class string, infos []*pb.VolumeInfo) {
+	g.volumeServers[serverId] = addr
+	g.markAlive(serverId)
+	g.servers[serverId].grpcAddr = grpcAddr
+	g.servers[serverId].storageClass = storageClass(class)
+
+	if _, ok := g.volumes[serverId]; !ok {
+		g.upsertVolume(serverId, &pb.VolumeInfo{VolumeId: serverId[:]})
+	}
+	for _, info := range infos {
+		g.upsertVolume(serverId, info)
+	}
+}
+
+func (g *GRPCServer) upsertVolume(serverId uuid.UUID, info *pb.VolumeInfo) {
+	id, err := uuid.FromBytes(info.GetVolumeId())
+	if err != nil {
+		return
+	}
+
+	v, ok := g.volumes[id]
+	if !ok {
+		v = &volume{
+			id:       id,
+			server:   serverId,
+			readOnly: info.GetReadOnly(),
+			// a non-primary volume we didn't hand out in this process' lifetime, take it as sealed
+			sealed: id != serverId,
+		}
+		if info.GetExpiresAt() != 0 {
+			v.expiresAt = time.Unix(int64(info.GetExpiresAt()), 0)
+		}
+		v.updateStats(info)
+		g.volumes[id] = v
+		g.publish(pb.TopologyEvent_VOLUME_ADDED, v)
+		return
+	}
+
+	if v.server != serverId {
+		if g.serverAlive(v.server) {
+			// a leftover copy, e.g. on a server that is being drained, the one we point at wins
+			return
+		}
+		v.server = serverId
+		g.publish(pb.TopologyEvent_VOLUME_MOVED, v)
+	}
+	v.updateStats(info)
+	if v.readOnly != info.GetReadOnly() {
+		v.readOnly = info.GetReadOnly()
+		g.publish(pb.TopologyEvent_VOLUME_READ_ONLY, v)
+	}
+}
@@ -0,0 +1,13 @@
+package cluster_manager
+
+// Volume servers are started with the storage class of the disks behind them, e.g.
+// erasure-coded or archive. Writes go to standard servers unless they ask for a class.
+const defaultStorageClass = "standard"
+
+// storageClass names the class of a server or write, empty meaning standard.
+func storageClass(class string) string {
+	if class == "" {
+		return defaultStorageClass
+	}
+	return class
+}
@@ -0,0 +1,92 @@
+package cluster_manager
+
+import (
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Events buffered per watcher before it's considered too slow and cut off
+const watcherBuffer = 256
+
+func (g *GRPCServer) WatchTopology(req *pb.WatchTopologyRequest, stream pb.MasterService_WatchTopologyServer) error {
+	ch := make(chan *pb.TopologyEvent, watcherBuffer)
+
+	// snapshot and subscribe under the same lock so no event falls in between
+	g.mu.Lock()
+	snapshot := &pb.TopologyEvent{Type: pb.TopologyEvent_SNAPSHOT}
+	for _, v := range g.volumes {
+		snapshot.Volumes = append(snapshot.Volumes, g.locationOf(v))
+	}
+	g.watchers[ch] = struct{}{}
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.watchers, ch)
+		g.mu.Unlock()
+	}()
+
+	if err := stream.Send(snapshot); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "watcher fell behind, resubscribe for a fresh snapshot")
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publish fans a volume change out to every watcher. Callers must hold g.mu.
+func (g *GRPCServer) publish(t pb.TopologyEvent_Type, v *volume) {
+	g.broadcast(&pb.TopologyEvent{
+		Type:    t,
+		Volumes: []*pb.VolumeLocation{g.locationOf(v)},
+	})
+}
+
+// publishServer tells watchers a volume server went down or was removed. Callers must hold g.mu.
+func (g *GRPCServer) publishServer(t pb.TopologyEvent_Type, serverId uuid.UUID) {
+	g.broadcast(&pb.TopologyEvent{
+		Type:     t,
+		ServerId: idBytes(serverId),
+	})
+}
+
+func (g *GRPCServer) broadcast(ev *pb.TopologyEvent) {
+	for ch := range g.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// dropping events silently would leave the watcher's view wrong forever
+			close(ch)
+			delete(g.watchers, ch)
+		}
+	}
+}
+
+// locationOf describes where a volume lives. Callers must hold g.mu.
+func (g *GRPCServer) locationOf(v *volume) *pb.VolumeLocation {
+	return &pb.VolumeLocation{
+		VolumeId:    idBytes(v.id),
+		ServerId:    idBytes(v.server),
+		HttpAddress: g.volumeServers[v.server],
+		Sealed:      v.sealed,
+		ReadOnly:    v.readOnly,
+	}
+}
+
+// idBytes copies an id so events never alias state that may change while they're being sent.
+func idBytes(id uuid.UUID) []byte {
+	return id[:]
+}
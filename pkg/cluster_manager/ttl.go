@@ -0,0 +1,231 @@
+package cluster_manager
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// How long a TTL volume keeps accepting writes before a fresh one is opened.
+	// Bounds how long the volume outlives its oldest needle.
+	maxTTLWriteWindow = 24 * time.Hour
+
+	// Slack on top of a TTL volume's expiry to absorb clock skew between master and volume servers
+	ttlReapGrace = 5 * time.Minute
+
+	ttlReapInterval = time.Minute
+)
+
+type volume struct {
+	id     uuid.UUID
+	server uuid.UUID
+
+	sealed   bool // no new writes are assigned to it
+	readOnly bool // reported by the volume server, e.g. after a failed write
+
+	// last reported by the volume server
+	sizeBytes   uint64
+	liveBytes   uint64
+	needleCount uint64
+
+	// zero for regular volumes
+	ttl           time.Duration
+	writableUntil time.Time
+	expiresAt     time.Time
+}
+
+func (v *volume) writable() bool {
+	return !v.sealed && !v.readOnly
+}
+
+func (v *volume) updateStats(info *pb.VolumeInfo) {
+	v.sizeBytes = info.GetSizeBytes()
+	v.liveBytes = info.GetLiveBytes()
+	v.needleCount = info.GetNeedleCount()
+}
+
+func (v *volume) garbageRatio() float64 {
+	if v.sizeBytes == 0 || v.liveBytes >= v.sizeBytes {
+		return 0
+	}
+	return float64(v.sizeBytes-v.liveBytes) / float64(v.sizeBytes)
+}
+
+func (v *volume) acceptsTTL(ttl time.Duration, now time.Time) bool {
+	return v.writable() && v.ttl == ttl && now.Before(v.writableUntil)
+}
+
+// assignTTLVolume hands out a volume reserved for needles sharing the same TTL,
+// so the whole volume expires at once and can be dropped instead of vacuumed.
+func (g *GRPCServer) assignTTLVolume(ttl time.Duration, idempotencyKey string) (*pb.AssignVolumeResponse, error) {
+	if resp, ok := g.openTTLVolume(ttl, idempotencyKey); ok {
+		return resp, nil
+	}
+
+	g.mu.RLock()
+	server, ok := g.pickServer(defaultStorageClass, idempotencyKey)
+	addr := g.volumeServers[server]
+	g.mu.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "no volume servers available")
+	}
+
+	// volume servers only take writes for volumes the master created on them
+	now := time.Now()
+	window := min(ttl, maxTTLWriteWindow)
+	v := &volume{
+		id:            uuid.New(),
+		server:        server,
+		ttl:           ttl,
+		writableUntil: now.Add(window),
+		expiresAt:     now.Add(window + ttl),
+	}
+	if err := g.createVolume(addr, v.id); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to create TTL volume on volume server %s: %v", server, err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.volumes[v.id] = v
+	g.publish(pb.TopologyEvent_VOLUME_ADDED, v)
+	log.Printf("Opened TTL volume %s (ttl %s) on volume server %s", v.id, ttl, v.server)
+	assignments.WithLabelValues(v.server.String()).Inc()
+
+	return &pb.AssignVolumeResponse{
+		HttpAddress: addr,
+		VolumeId:    v.id[:],
+	}, nil
+}
+
+// openTTLVolume picks one of the TTL volumes still taking writes with the given ttl.
+func (g *GRPCServer) openTTLVolume(ttl time.Duration, idempotencyKey string) (*pb.AssignVolumeResponse, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	now := time.Now()
+	var open []uuid.UUID
+	for _, v := range g.volumes {
+		if !v.acceptsTTL(ttl, now) || !g.acceptsWrites(v.server) || g.servers[v.server].storageClass != defaultStorageClass {
+			continue
+		}
+		if _, ok := g.volumeServers[v.server]; ok {
+			open = append(open, v.id)
+		}
+	}
+	if len(open) > 0 {
+		id := open[0]
+		if idempotencyKey != "" {
+			id = rendezvous(idempotencyKey, open)
+		}
+		v := g.volumes[id]
+		assignments.WithLabelValues(v.server.String()).Inc()
+		return &pb.AssignVolumeResponse{
+			HttpAddress: g.volumeServers[v.server],
+			VolumeId:    v.id[:],
+		}, true
+	}
+	return nil, false
+}
+
+// createVolume has the volume server at addr open an empty volume.
+func (g *GRPCServer) createVolume(addr string, volumeId uuid.UUID) error {
+	req, err := http.NewRequest(http.MethodPut, g.volumeURL(addr, "/v1/volume/admin/volumes/%s", volumeId), nil)
+	if err != nil {
+		return err
+	}
+	g.signVolumeRequest(req)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("volume server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (g *GRPCServer) reapExpiredVolumes() {
+	ticker := time.NewTicker(ttlReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.sealTTLVolumes(time.Now())
+
+		for _, v := range g.expiredVolumes(time.Now().Add(-ttlReapGrace)) {
+			if err := g.dropVolume(v); err != nil {
+				log.Printf("Failed to drop expired volume %s. Why: %v", v.id, err)
+				continue
+			}
+
+			g.mu.Lock()
+			delete(g.volumes, v.id)
+			g.publish(pb.TopologyEvent_VOLUME_REMOVED, v)
+			g.mu.Unlock()
+			log.Printf("Dropped expired volume %s", v.id)
+		}
+	}
+}
+
+// sealTTLVolumes closes TTL volumes whose write window has passed.
+func (g *GRPCServer) sealTTLVolumes(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, v := range g.volumes {
+		if v.ttl > 0 && !v.sealed && !now.Before(v.writableUntil) {
+			v.sealed = true
+			g.publish(pb.TopologyEvent_VOLUME_SEALED, v)
+		}
+	}
+}
+
+func (g *GRPCServer) expiredVolumes(cutoff time.Time) []*volume {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var expired []*volume
+	for _, v := range g.volumes {
+		if !v.expiresAt.IsZero() && v.expiresAt.Before(cutoff) {
+			expired = append(expired, v)
+		}
+	}
+	return expired
+}
+
+func (g *GRPCServer) dropVolume(v *volume) error {
+	g.mu.RLock()
+	addr, ok := g.volumeServers[v.server]
+	g.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("volume server %s is not registered", v.server)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, g.volumeURL(addr, "/v1/volume/%s", v.id), nil)
+	if err != nil {
+		return err
+	}
+	g.signVolumeRequest(req)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 404 means it's gone already
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("volume server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
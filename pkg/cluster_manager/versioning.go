@@ -0,0 +1,127 @@
+package cluster_manager
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// prune removes the oldest versions of a path beyond the bucket's limit and returns
+// them for their needles to be released. Callers must hold l.mu.
+func (l *Ledger) prune(bucketName, path string) []*object {
+	b := l.buckets[bucketName]
+	if b == nil || b.maxVersions <= 0 {
+		return nil
+	}
+
+	var pruned []*object
+	versions := b.objects[path]
+	for _, o := range versions[:max(len(versions)-b.maxVersions, 0)] {
+		if err := l.commit(ledgerRecord{Op: ledgerOpDelete, Bucket: bucketName, Path: path, VersionId: o.versionId, Time: time.Now().UTC()}); err != nil {
+			log.Printf("Failed to prune version %s of %s/%s. Why: %v", o.versionId, bucketName, path, err)
+			break
+		}
+		pruned = append(pruned, o)
+	}
+	return pruned
+}
+
+// SetBucketVersioning enables or suspends versioning of a bucket. Once enabled it
+// can't be turned off, only suspended. Lowering the limit prunes every path.
+func (l *Ledger) SetBucketVersioning(ctx context.Context, req *pb.BucketVersioning) (*pb.BucketVersioning, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+	if req.GetStatus() == pb.BucketVersioning_UNVERSIONED {
+		return nil, status.Errorf(codes.InvalidArgument, "versioning can only be enabled or suspended")
+	}
+	if req.GetMaxVersions() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "max versions can't be negative")
+	}
+
+	l.mu.Lock()
+	b := l.bucket(req.GetBucket())
+	err := l.commit(versioningRecord(req.GetBucket(), &bucket{versioning: req.GetStatus(), maxVersions: int(req.GetMaxVersions())}))
+	var pruned []*object
+	if err == nil {
+		for p := range b.objects {
+			pruned = append(pruned, l.prune(req.GetBucket(), p)...)
+		}
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to record versioning: %v", err)
+	}
+
+	go l.releaseNeedles(context.Background(), pruned)
+	return &pb.BucketVersioning{Bucket: req.GetBucket(), Status: req.GetStatus(), MaxVersions: req.GetMaxVersions()}, nil
+}
+
+func (l *Ledger) GetBucketVersioning(ctx context.Context, req *pb.GetBucketVersioningRequest) (*pb.BucketVersioning, error) {
+	if err := validateBucketName(req.GetBucket()); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	resp := &pb.BucketVersioning{Bucket: req.GetBucket()}
+	if b, ok := l.buckets[req.GetBucket()]; ok {
+		resp.Status, resp.MaxVersions = b.versioning, int32(b.maxVersions)
+	}
+	return resp, nil
+}
+
+// ListObjectVersions returns the versions of a bucket's objects under a prefix, in
+// path order and newest first within a path.
+func (l *Ledger) ListObjectVersions(ctx context.Context, req *pb.ListObjectVersionsRequest) (*pb.ListObjectVersionsResponse, error) {
+	maxKeys := int(req.GetMaxKeys())
+	if maxKeys <= 0 || maxKeys > defaultMaxKeys {
+		maxKeys = defaultMaxKeys
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	b := l.buckets[req.GetBucket()]
+	var paths []string
+	if b != nil {
+		for p := range b.objects {
+			if !strings.HasPrefix(p, req.GetPrefix()) || p < req.GetStartAfter() {
+				continue
+			}
+			if p == req.GetStartAfter() && req.GetStartAfterVersionId() == "" {
+				continue
+			}
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	resp := &pb.ListObjectVersionsResponse{}
+	for _, p := range paths {
+		versions := b.objects[p]
+		i := len(versions) - 1
+		if p == req.GetStartAfter() {
+			// resume after the version the last page ended with
+			for i >= 0 && versions[i].versionId != req.GetStartAfterVersionId() {
+				i--
+			}
+			i--
+		}
+		for ; i >= 0; i-- {
+			if len(resp.Versions) == maxKeys {
+				resp.Truncated = true
+				return resp, nil
+			}
+			resp.Versions = append(resp.Versions, versions[i].info(p, i == len(versions)-1))
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,54 @@
+package cluster_manager
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WriteTokenConfig has AssignVolume hand out tokens that let clients upload
+// straight to the assigned volume server instead of through the gateway.
+type WriteTokenConfig struct {
+	Secrets  *auth.TokenSecrets // nil hands out no tokens
+	Validity time.Duration
+	MaxBytes int64 // largest upload a token allows
+}
+
+func (g *GRPCServer) checkWriteSize(maxBytes int64) error {
+	if maxBytes < 0 {
+		return status.Errorf(codes.InvalidArgument, "max bytes can't be negative")
+	}
+	if g.writeTokens.Secrets != nil && maxBytes > g.writeTokens.MaxBytes {
+		return status.Errorf(codes.InvalidArgument, "uploads are limited to %d bytes", g.writeTokens.MaxBytes)
+	}
+	return nil
+}
+
+// attachWriteToken signs a token for one upload to the assigned volume.
+func (g *GRPCServer) attachWriteToken(resp *pb.AssignVolumeResponse, req *pb.AssignVolumeRequest) {
+	if g.writeTokens.Secrets == nil {
+		return
+	}
+
+	maxBytes := g.writeTokens.MaxBytes
+	if n := req.GetMaxBytes(); n > 0 {
+		maxBytes = n
+	}
+	expiresAt := time.Now().Add(g.writeTokens.Validity).Unix()
+	volumeId, _ := uuid.FromBytes(resp.GetVolumeId())
+
+	resp.WriteToken = g.writeTokens.Secrets.Issue(auth.WriteClaims{
+		VolumeID:       volumeId.String(),
+		MaxBytes:       maxBytes,
+		TTLSeconds:     req.GetTtlSeconds(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+		ContentSHA256:  req.GetContentSha256(),
+		ExpiresAt:      expiresAt,
+	})
+	resp.MaxBytes = maxBytes
+	resp.TokenExpiresAt = expiresAt
+}
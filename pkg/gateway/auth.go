@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+)
+
+// contextKeyID is where the authenticated key's id is left in the gin context.
+const contextKeyID = "auth_key_id"
+
+// Authenticator checks gateway requests against a keyring. A nil *Authenticator lets everything through.
+type Authenticator struct {
+	keys *auth.Keyring
+	// signatures seen within the clock skew window
+	replays *auth.ReplayCache
+}
+
+func NewAuthenticator(keys *auth.Keyring) *Authenticator {
+	return &Authenticator{
+		keys:    keys,
+		replays: auth.NewReplayCache(auth.MaxClockSkew),
+	}
+}
+
+// Require returns middleware that lets a request through only if its key grants every
+// op on the bucket in the route, or on every bucket for routes without one.
+func (a *Authenticator) Require(ops ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+
+		key, status, msg := a.authenticate(c.Request, time.Now())
+		if key == nil {
+			c.AbortWithStatusJSON(status, gin.H{"error": msg})
+			return
+		}
+
+		bucket := c.Param("bucket")
+		for _, op := range ops {
+			if !key.Allows(bucket, op) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "key " + key.ID + " may not " + op + " in " + bucketLabel(bucket)})
+				return
+			}
+		}
+
+		c.Set(contextKeyID, key.ID)
+		c.Next()
+	}
+}
+
+func bucketLabel(bucket string) string {
+	if bucket == "" {
+		return "objects stored by id"
+	}
+	return "bucket " + bucket
+}
+
+func (a *Authenticator) authenticate(req *http.Request, now time.Time) (*auth.Key, int, string) {
+	if q := req.URL.Query(); auth.IsPresigned(q) {
+		return a.verifyPresigned(req, q, now)
+	}
+
+	scheme, params, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	switch scheme {
+	case "":
+		return nil, http.StatusUnauthorized, "missing Authorization header"
+	case auth.SchemeBearer:
+		id, secret, _ := strings.Cut(params, ":")
+		key, ok := a.keys.Key(id)
+		if !ok || !auth.Equal(key.Secret, secret) {
+			return nil, http.StatusUnauthorized, "invalid API key"
+		}
+		if key.Expired(now) {
+			return nil, http.StatusUnauthorized, "API key has expired"
+		}
+		return key, 0, ""
+	case auth.SchemeHMAC:
+		return a.verifySignature(req, params, now)
+	default:
+		return nil, http.StatusUnauthorized, "unsupported Authorization scheme " + scheme
+	}
+}
+
+func (a *Authenticator) verifySignature(req *http.Request, params string, now time.Time) (*auth.Key, int, string) {
+	id, signature, ok := auth.ParseHMAC(params)
+	if !ok {
+		return nil, http.StatusUnauthorized, "malformed " + auth.SchemeHMAC + " Authorization header"
+	}
+
+	date := req.Header.Get(auth.DateHeader)
+	signedAt, err := auth.ParseDate(date, now)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err.Error()
+	}
+
+	key, ok := a.keys.Key(id)
+	if !ok {
+		return nil, http.StatusUnauthorized, "invalid API key"
+	}
+	if key.Expired(now) {
+		return nil, http.StatusUnauthorized, "API key has expired"
+	}
+
+	expected := auth.Signature(key.Secret, auth.StringToSign(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, date))
+	if !auth.Equal(expected, signature) {
+		return nil, http.StatusUnauthorized, "signature doesn't match"
+	}
+
+	if !a.replays.FirstUse(signature, signedAt.Add(auth.MaxClockSkew), now) {
+		return nil, http.StatusUnauthorized, "request was already used"
+	}
+	return key, 0, ""
+}
+
+func (a *Authenticator) verifyPresigned(req *http.Request, q url.Values, now time.Time) (*auth.Key, int, string) {
+	key, ok := a.keys.Key(auth.PresignedKeyID(q))
+	if !ok {
+		return nil, http.StatusUnauthorized, "invalid API key"
+	}
+	if key.Expired(now) {
+		return nil, http.StatusUnauthorized, "API key has expired"
+	}
+	if err := auth.VerifyPresigned(req.Method, req.URL.EscapedPath(), q, key.Secret, now); err != nil {
+		return nil, http.StatusForbidden, err.Error()
+	}
+	return key, 0, ""
+}
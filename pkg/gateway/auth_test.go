@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+)
+
+const (
+	liveSecret = "live-secret-0123456789"
+	oldSecret  = "old-secret-0123456789"
+	// writerSecret's key may only write to the photos bucket
+	writerSecret = "writer-secret-0123456789"
+
+	keyringJSON = `{"keys": [
+	{"id": "live", "secret": "` + liveSecret + `", "grants": [{"bucket": "*", "ops": ["*"]}]},
+	{"id": "old", "secret": "` + oldSecret + `", "grants": [{"bucket": "*", "ops": ["*"]}], "expires_at": "2000-01-01T00:00:00Z"},
+	{"id": "writer", "secret": "` + writerSecret + `", "grants": [{"bucket": "photos", "ops": ["write"]}]}
+]}`
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(keyringJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthenticator(keys)
+}
+
+func TestAuthenticateSignedRequests(t *testing.T) {
+	now := time.Now()
+	const target = "/v1/buckets/photos/objects/cat.jpg?version=2"
+
+	tests := []struct {
+		name       string
+		request    func() *http.Request
+		wantStatus int // 0 for authenticated
+	}{
+		{name: "bearer", request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("Authorization", "Bearer live:"+liveSecret)
+			return req
+		}},
+		{name: "bearer with the wrong secret", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("Authorization", "Bearer live:guess")
+			return req
+		}},
+		{name: "bearer with an expired key", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("Authorization", "Bearer old:"+oldSecret)
+			return req
+		}},
+		{name: "no Authorization header", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, target, nil)
+		}},
+		{name: "signed", request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			return req
+		}},
+		{name: "signed with the query reordered", request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target+"&part=1", nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.URL.RawQuery = "part=1&version=2"
+			return req
+		}},
+		{name: "signed with the wrong secret", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", "guess", now)
+			return req
+		}},
+		{name: "signed with an expired key", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "old", oldSecret, now)
+			return req
+		}},
+		{name: "signed with an unknown key", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "nobody", liveSecret, now)
+			return req
+		}},
+		{name: "path changed after signing", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.URL.Path = "/v1/buckets/photos/objects/dog.jpg"
+			return req
+		}},
+		{name: "query changed after signing", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.URL.RawQuery = "version=3"
+			return req
+		}},
+		{name: "method changed after signing", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.Method = http.MethodDelete
+			return req
+		}},
+		{name: "date changed after signing", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.Header.Set(auth.DateHeader, strconv.FormatInt(now.Unix()+1, 10))
+			return req
+		}},
+		{name: "signed too long ago", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now.Add(-auth.MaxClockSkew-time.Minute))
+			return req
+		}},
+		{name: "signed in the future", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now.Add(auth.MaxClockSkew+time.Minute))
+			return req
+		}},
+		{name: "malformed signature header", wantStatus: http.StatusUnauthorized, request: func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			auth.Sign(req, "live", liveSecret, now)
+			req.Header.Set("Authorization", auth.SchemeHMAC+" Credential=live")
+			return req
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthenticator(t)
+			key, status, msg := a.authenticate(tt.request(), now)
+			if status != tt.wantStatus {
+				t.Fatalf("authenticate = %d %q, want %d", status, msg, tt.wantStatus)
+			}
+			if (key != nil) != (tt.wantStatus == 0) {
+				t.Fatalf("authenticate returned key %v with status %d", key, status)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsReplays(t *testing.T) {
+	a := newTestAuthenticator(t)
+	now := time.Now()
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/buckets/photos/objects/cat.jpg", nil)
+	auth.Sign(req, "live", liveSecret, now)
+
+	if _, status, msg := a.authenticate(req, now); status != 0 {
+		t.Fatalf("first use = %d %q", status, msg)
+	}
+	if _, status, _ := a.authenticate(req, now.Add(time.Second)); status != http.StatusUnauthorized {
+		t.Fatalf("replay = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireEveryOp(t *testing.T) {
+	a := newTestAuthenticator(t)
+	engine := gin.New()
+	engine.PUT("/v1/buckets/:bucket/lifecycle", a.Require(auth.OpWrite, auth.OpDelete), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		credential string
+		wantStatus int
+	}{
+		{name: "write and delete", credential: "live:" + liveSecret, wantStatus: http.StatusOK},
+		{name: "write only", credential: "writer:" + writerSecret, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/v1/buckets/photos/lifecycle", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.credential)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the version of a bucket object a response is about, "null" if the bucket isn't versioned
+	versionIdHeader = "X-Graphene-Version-Id"
+	// one per soft quota limit a write went past
+	quotaWarningHeader = "X-Graphene-Quota-Warning"
+)
+
+type objectInfo struct {
+	Path         string            `json:"path"`
+	ID           string            `json:"id"`
+	VersionId    string            `json:"version_id"`
+	Size         int64             `json:"size"`
+	MimeType     string            `json:"mime_type"`
+	ETag         string            `json:"etag,omitempty"`
+	ModifiedAt   time.Time         `json:"modified_at"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	StorageClass string            `json:"storage_class"`
+}
+
+// objectPath is the path in a bucket route without the leading slash.
+func objectPath(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("path"), "/")
+}
+
+// ledgerError answers with the HTTP status matching a ledger RPC error.
+func ledgerError(c *gin.Context, err error) {
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		c.JSON(http.StatusBadRequest, gin.H{"error": st.Message()})
+	case codes.NotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+	case codes.FailedPrecondition:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": st.Message()})
+	case codes.ResourceExhausted:
+		c.JSON(http.StatusForbidden, gin.H{"error": st.Message(), "code": "QuotaExceeded"})
+	case codes.Unavailable:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
+	default:
+		log.Printf("Ledger request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "master server internal error"})
+	}
+}
+
+// applyRejected reports whether the master refused an Apply without recording the
+// object, leaving the uploaded needle to the gateway. A quota rejection releases
+// the needle on the master, and an Apply whose response was lost may have been
+// recorded.
+func applyRejected(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.PermissionDenied, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// PutObject uploads the body to a volume and points bucket/path at it.
+func (g *GatewayHandler) PutObject(c *gin.Context) {
+	bucket, path := c.Param("bucket"), objectPath(c)
+	// the size is checked against the bucket's quota before anything is stored
+	if c.Request.ContentLength < 0 {
+		c.JSON(http.StatusLengthRequired, gin.H{"error": "uploads to a bucket need a Content-Length"})
+		return
+	}
+	tags, err := parseTagging(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// a key only stands for one upload to this path, so a retry overwrites it with the same needle
+	var opts writeOptions
+	if key := c.GetHeader(idempotencyKeyHeader); key != "" {
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("idempotency key is longer than %d characters", maxIdempotencyKeyLen)})
+			return
+		}
+		opts.idempotencyKey = scopeIdempotencyKey(c, bucket+"/"+path+":"+key)
+	}
+
+	prep, err := g.masterClient.ledger.PrepareWrite(c, &pb.PrepareWriteRequest{
+		Bucket:         bucket,
+		Path:           path,
+		IdempotencyKey: opts.idempotencyKey,
+		SizeBytes:      c.Request.ContentLength,
+	})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	volumeId, err := uuid.Parse(prep.GetVolumeId())
+	if err != nil {
+		log.Printf("Master returned invalid volume id: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "master server returned invalid data"})
+		return
+	}
+	g.locations.Set(volumeId, prep.GetHttpAddress())
+
+	mimeType := c.ContentType()
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	body := newHashingReader(c.Request.Body)
+	needleId, _, err := g.writeToVolume(c.Request.Context(), prep.GetHttpAddress(), volumeId, prep.GetWriteToken(), opts, body, mimeType)
+	if err != nil {
+		writeVolumeError(c, err)
+		return
+	}
+
+	// the ledger points the path at the needle already holding this content, if any
+	etag := hex.EncodeToString(body.sum())
+	req := &pb.ApplyRequest{
+		Bucket:    bucket,
+		Path:      path,
+		VolumeId:  volumeId.String(),
+		NeedleId:  needleId,
+		SizeBytes: body.n,
+		MimeType:  mimeType,
+		Tags:      tags,
+		Etag:      etag,
+	}
+	if g.dedup {
+		req.Sha256 = body.sum()
+	}
+	applied, err := g.masterClient.ledger.Apply(c, req)
+	if err != nil {
+		// a retry with the idempotency key uploads the same needle, it may back a version already
+		if opts.idempotencyKey == "" && applyRejected(err) {
+			go func() {
+				if _, err := g.deleteFromVolume(context.Background(), volumeId, needleId); err != nil {
+					log.Printf("Failed to remove needle %s from volume %s after the ledger rejected it. Why: %v", needleId, volumeId, err)
+				}
+			}()
+		}
+		ledgerError(c, err)
+		return
+	}
+
+	resp := gin.H{
+		"bucket":     bucket,
+		"path":       path,
+		"id":         fmt.Sprintf("%s:%s", applied.GetVolumeId(), applied.GetNeedleId()),
+		"version_id": applied.GetVersionId(),
+		"size":       body.n,
+		"etag":       etag,
+	}
+	if applied.GetDeduplicated() {
+		resp["deduplicated"] = true
+	}
+	if warnings := applied.GetQuotaWarnings(); len(warnings) > 0 {
+		for _, w := range warnings {
+			log.Printf("Quota warning writing %s/%s: %s", bucket, path, w)
+			c.Writer.Header().Add(quotaWarningHeader, w)
+		}
+		resp["quota_warnings"] = warnings
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GetObject downloads the latest version of an object, or the one in ?versionId=.
+func (g *GatewayHandler) GetObject(c *gin.Context) {
+	loc, err := g.masterClient.ledger.GetObjectLocation(c, &pb.GetObjectLocationRequest{
+		Bucket:    c.Param("bucket"),
+		Path:      objectPath(c),
+		VersionId: c.Query("versionId"),
+	})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	volumeId, err := uuid.Parse(loc.GetVolumeId())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "master server returned invalid data"})
+		return
+	}
+	c.Header(versionIdHeader, loc.GetVersionId())
+	c.Header(storageClassHeader, loc.GetStorageClass())
+	if loc.GetEtag() != "" {
+		c.Header("ETag", `"`+loc.GetEtag()+`"`)
+	}
+	if len(loc.GetTags()) > 0 {
+		tags := make(url.Values, len(loc.GetTags()))
+		for k, v := range loc.GetTags() {
+			tags.Set(k, v)
+		}
+		c.Header(taggingHeader, tags.Encode())
+	}
+	g.serveNeedle(c, volumeId, loc.GetNeedleId(), loc.GetMimeType())
+}
+
+// DeleteObject deletes an object, leaving a delete marker in a versioned bucket.
+// With ?versionId= that version is removed for good.
+func (g *GatewayHandler) DeleteObject(c *gin.Context) {
+	resp, err := g.masterClient.ledger.DeleteObject(c, &pb.DeleteObjectRequest{
+		Bucket:    c.Param("bucket"),
+		Path:      objectPath(c),
+		VersionId: c.Query("versionId"),
+	})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.Header(versionIdHeader, resp.GetVersionId())
+	if resp.GetDeleteMarker() {
+		c.Header(deleteMarkerHeader, "true")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListObjects lists a bucket's objects under ?prefix=, a page of ?max_keys= at a time.
+// The next page starts after the last path of this one, passed as ?start_after=.
+func (g *GatewayHandler) ListObjects(c *gin.Context) {
+	req := &pb.ListObjectsRequest{
+		Bucket:     c.Param("bucket"),
+		Prefix:     c.Query("prefix"),
+		StartAfter: c.Query("start_after"),
+	}
+	if s := c.Query("max_keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_keys"})
+			return
+		}
+		req.MaxKeys = int32(n)
+	}
+
+	resp, err := g.masterClient.ledger.ListObjects(c, req)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	objects := make([]objectInfo, 0, len(resp.GetObjects()))
+	for _, o := range resp.GetObjects() {
+		objects = append(objects, objectInfo{
+			Path:         o.GetPath(),
+			ID:           fmt.Sprintf("%s:%s", o.GetVolumeId(), o.GetNeedleId()),
+			VersionId:    o.GetVersionId(),
+			Size:         o.GetSizeBytes(),
+			MimeType:     o.GetMimeType(),
+			ETag:         o.GetEtag(),
+			ModifiedAt:   o.GetModifiedAt().AsTime(),
+			Tags:         o.GetTags(),
+			StorageClass: o.GetStorageClass(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"bucket":    req.Bucket,
+		"objects":   objects,
+		"truncated": resp.GetTruncated(),
+	})
+}
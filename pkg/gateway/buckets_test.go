@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeLedger hands out one volume for every write and fails every Apply with err.
+type fakeLedger struct {
+	pb.LedgerServiceClient
+	volumeId string
+	addr     string
+	prepared int
+	err      error
+}
+
+func (f *fakeLedger) PrepareWrite(ctx context.Context, req *pb.PrepareWriteRequest, opts ...grpc.CallOption) (*pb.PrepareWriteResponse, error) {
+	f.prepared++
+	return &pb.PrepareWriteResponse{VolumeId: f.volumeId, HttpAddress: f.addr}, nil
+}
+
+func (f *fakeLedger) Apply(ctx context.Context, req *pb.ApplyRequest, opts ...grpc.CallOption) (*pb.ApplyResponse, error) {
+	return nil, f.err
+}
+
+func TestPutObjectCleansUpRejectedUploads(t *testing.T) {
+	tests := []struct {
+		name           string
+		applyErr       error
+		idempotencyKey string
+		chunked        bool
+		wantStatus     int
+		wantPrepared   bool
+		wantDelete     bool
+	}{
+		{name: "no Content-Length", chunked: true, wantStatus: http.StatusLengthRequired},
+		{name: "rejected by the ledger", applyErr: status.Error(codes.InvalidArgument, "bad tags"), wantStatus: http.StatusBadRequest, wantPrepared: true, wantDelete: true},
+		{name: "ledger failed to record it", applyErr: status.Error(codes.Internal, "disk full"), wantStatus: http.StatusInternalServerError, wantPrepared: true, wantDelete: true},
+		{name: "over quota, released by the master", applyErr: status.Error(codes.ResourceExhausted, "over quota"), wantStatus: http.StatusForbidden, wantPrepared: true},
+		{name: "master unreachable, may have applied it", applyErr: status.Error(codes.Unavailable, "down"), wantStatus: http.StatusServiceUnavailable, wantPrepared: true},
+		{name: "idempotent retry", applyErr: status.Error(codes.Internal, "disk full"), idempotencyKey: "k", wantStatus: http.StatusInternalServerError, wantPrepared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			needleId := uuid.NewString()
+			deleted := make(chan string, 1)
+			volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodDelete {
+					deleted <- strings.TrimPrefix(r.URL.Path, "/v1/volume/delete/")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"` + needleId + `"}`))
+			}))
+			defer volume.Close()
+
+			volumeId := uuid.New()
+			ledger := &fakeLedger{volumeId: volumeId.String(), addr: strings.TrimPrefix(volume.URL, "http://"), err: tt.applyErr}
+			m := &MasterClient{ledger: ledger}
+			g := &GatewayHandler{masterClient: m, locations: NewLocationCache(m, time.Minute, time.Minute), httpClient: volume.Client(), scheme: "http"}
+
+			engine := gin.New()
+			engine.PUT("/v1/buckets/:bucket/objects/*path", g.PutObject)
+			req := httptest.NewRequest(http.MethodPut, "/v1/buckets/photos/objects/cat.jpg", strings.NewReader("meow"))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			if tt.idempotencyKey != "" {
+				req.Header.Set(idempotencyKeyHeader, tt.idempotencyKey)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if (ledger.prepared > 0) != tt.wantPrepared {
+				t.Fatalf("PrepareWrite called %d times, want a call %v", ledger.prepared, tt.wantPrepared)
+			}
+
+			// the needle is removed in the background
+			wait := 100 * time.Millisecond
+			if tt.wantDelete {
+				wait = 5 * time.Second
+			}
+			select {
+			case id := <-deleted:
+				if !tt.wantDelete || id != needleId {
+					t.Fatalf("needle %s was deleted, want a delete of %s %v", id, needleId, tt.wantDelete)
+				}
+			case <-time.After(wait):
+				if tt.wantDelete {
+					t.Fatal("uploaded needle wasn't deleted")
+				}
+			}
+		})
+	}
+}
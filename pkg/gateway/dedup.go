@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+// hashingReader computes the SHA-256 and size of an upload as it streams through.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+func (h *hashingReader) sum() []byte {
+	return h.h.Sum(nil)
+}
+
+// deduplicates reports whether a write is looked up in the master's dedup index.
+// Writes with a ttl expire on their own, ones with an idempotency key would count a
+// retry as another reference and content-addressed ones are deduplicated by the volume.
+func (g *GatewayHandler) deduplicates(opts writeOptions) bool {
+	return g.dedup && opts.ttlSeconds == 0 && opts.idempotencyKey == "" && !opts.contentAddressed
+}
+
+// addReference records an uploaded needle in the dedup index and returns the id
+// clients should use instead, which names the upload's own reference. If the content
+// was already stored the upload is redundant and removed from its volume.
+func (g *GatewayHandler) addReference(ctx context.Context, volumeId uuid.UUID, needleId string, body *hashingReader) (string, bool) {
+	resp, err := g.masterClient.dedup.AddReference(ctx, &pb.AddReferenceRequest{
+		Sha256:    body.sum(),
+		VolumeId:  volumeId.String(),
+		NeedleId:  needleId,
+		SizeBytes: body.n,
+	})
+	if err != nil {
+		log.Printf("Failed to add needle %s to the dedup index, keeping it unshared. Why: %v", needleId, err)
+		return fatID(volumeId, needleId, ""), false
+	}
+
+	canonical, err := uuid.Parse(resp.GetVolumeId())
+	if err != nil {
+		log.Printf("Dedup index returned invalid volume id, keeping needle %s unshared. Why: %v", needleId, err)
+		return fatID(volumeId, needleId, ""), false
+	}
+	if resp.GetDeduplicated() {
+		go func() {
+			if _, err := g.deleteFromVolume(context.Background(), volumeId, needleId); err != nil {
+				log.Printf("Failed to remove duplicate needle %s from volume %s. Why: %v", needleId, volumeId, err)
+			}
+		}()
+	}
+	return fatID(canonical, resp.GetNeedleId(), resp.GetRefId()), resp.GetDeduplicated()
+}
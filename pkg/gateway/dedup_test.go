@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+)
+
+// fakeDedup holds references to one shared needle like the master's index.
+type fakeDedup struct {
+	pb.DedupServiceClient
+	refs map[string]bool
+}
+
+func (f *fakeDedup) ReleaseReference(ctx context.Context, req *pb.ReleaseReferenceRequest, opts ...grpc.CallOption) (*pb.ReleaseReferenceResponse, error) {
+	released := f.refs[req.GetRefId()]
+	delete(f.refs, req.GetRefId())
+	return &pb.ReleaseReferenceResponse{Tracked: true, Released: released, Refs: int64(len(f.refs))}, nil
+}
+
+func (f *fakeDedup) LookupReference(ctx context.Context, req *pb.LookupReferenceRequest, opts ...grpc.CallOption) (*pb.LookupReferenceResponse, error) {
+	return &pb.LookupReferenceResponse{Tracked: true, Held: f.refs[req.GetRefId()]}, nil
+}
+
+func TestReadAfterDeletingOneReference(t *testing.T) {
+	var volumeDeletes int
+	volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			volumeDeletes++
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer volume.Close()
+
+	volumeId, needleId := uuid.New(), uuid.NewString()
+	deleted, kept := uuid.NewString(), uuid.NewString()
+	m := &MasterClient{dedup: &fakeDedup{refs: map[string]bool{deleted: true, kept: true}}}
+	locations := NewLocationCache(m, time.Minute, time.Minute)
+	locations.Set(volumeId, strings.TrimPrefix(volume.URL, "http://"))
+	g := &GatewayHandler{masterClient: m, locations: locations, httpClient: volume.Client(), scheme: "http", dedup: true}
+
+	engine := gin.New()
+	engine.GET("/v1/gateway/read/:fat_id", g.Read)
+	engine.DELETE("/v1/gateway/delete/:fat_id", g.Delete)
+	do := func(method, ref string) *httptest.ResponseRecorder {
+		path := "/v1/gateway/read/"
+		if method == http.MethodDelete {
+			path = "/v1/gateway/delete/"
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, path+fatID(volumeId, needleId, ref), nil))
+		return w
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		ref        string
+		wantStatus int
+	}{
+		{name: "delete one reference", method: http.MethodDelete, ref: deleted, wantStatus: http.StatusNoContent},
+		{name: "read the deleted reference", method: http.MethodGet, ref: deleted, wantStatus: http.StatusNotFound},
+		{name: "read the reference still held", method: http.MethodGet, ref: kept, wantStatus: http.StatusOK},
+		{name: "delete the deleted reference again", method: http.MethodDelete, ref: deleted, wantStatus: http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if w := do(s.method, s.ref); w.Code != s.wantStatus {
+			t.Fatalf("%s: status = %d %s, want %d", s.name, w.Code, w.Body, s.wantStatus)
+		}
+	}
+	if volumeDeletes != 0 {
+		t.Fatalf("needle was deleted from its volume %d times while a reference was held", volumeDeletes)
+	}
+}
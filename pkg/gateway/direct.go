@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Assign picks a volume for a client to upload one needle straight to, so the bytes
+// don't pass through the gateway. The client POSTs the body to the returned url with
+// the write token header, and the object's id is the volume id and the needle id the
+// volume server answers with. ?ttl=, ?content_addressed= and the Idempotency-Key and
+// X-Graphene-Content-SHA256 headers are as for writes, the key to upload with is returned
+// and the hash header goes along with the upload. ?size= caps the upload in bytes. Like
+// a write's, the object belongs to no bucket and no quota limits it.
+func (g *GatewayHandler) Assign(c *gin.Context) {
+	opts, err := parseWriteOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var size int64
+	if s := c.Query("size"); s != "" {
+		size, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || size <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+			return
+		}
+	}
+
+	resp, err := g.masterClient.client.AssignVolume(c, &pb.AssignVolumeRequest{
+		TtlSeconds:     opts.ttlSeconds,
+		MaxBytes:       size,
+		IdempotencyKey: opts.idempotencyKey,
+		ContentSha256:  opts.contentSHA256,
+	})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.InvalidArgument:
+			c.JSON(http.StatusBadRequest, gin.H{"error": st.Message()})
+		case codes.Unavailable:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no storage volumes available"})
+		default:
+			log.Printf("Failed to get a volume from the master: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "master server internal error"})
+		}
+		return
+	}
+	if resp.GetWriteToken() == "" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "direct uploads need the master to have a write token secret"})
+		return
+	}
+
+	volumeId, err := uuid.FromBytes(resp.GetVolumeId())
+	if err != nil {
+		log.Printf("Master returned invalid volume UUID bytes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "master server returned invalid data"})
+		return
+	}
+	g.locations.Set(volumeId, resp.GetHttpAddress())
+
+	out := gin.H{
+		"volume_id":   volumeId.String(),
+		"url":         g.volumeWriteURL(resp.GetHttpAddress(), volumeId, opts),
+		"write_token": resp.GetWriteToken(),
+		"max_bytes":   resp.GetMaxBytes(),
+		"expires_at":  time.Unix(resp.GetTokenExpiresAt(), 0).UTC(),
+	}
+	if opts.idempotencyKey != "" {
+		out["idempotency_key"] = opts.idempotencyKey
+	}
+	c.JSON(http.StatusOK, out)
+}
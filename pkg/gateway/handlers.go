@@ -1,7 +1,10 @@
 package gateway
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,28 +14,57 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
 	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var (
+	errMasterUnavailable = errors.New("master server is unavailable")
+	errVolumeUnreachable = errors.New("could not write to volume server")
+	errVolumeRejected    = errors.New("volume server failed to store data")
+	errVolumeResponse    = errors.New("invalid response from volume server")
+	errVolumeConflict    = errors.New("idempotency key was already used for different data")
+)
+
 type GatewayHandler struct {
 	masterClient *MasterClient
+	locations    *LocationCache
 	httpClient   *http.Client
+	scheme       string
+	dedup        bool // share one needle between uploads of the same content
+	// signs deletes for volume servers that don't trust the gateway's certificate, nil doesn't sign
+	tokens *auth.TokenSecrets
 }
 
-func NewGatewayHandler(m *MasterClient) (*GatewayHandler, error) {
+func NewGatewayHandler(m *MasterClient, l *LocationCache, certs *tlsconfig.Certs, dedup bool, tokens *auth.TokenSecrets) (*GatewayHandler, error) {
 	g := &GatewayHandler{
 		masterClient: m,
+		locations:    l,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(certs.Transport()),
 		},
+		scheme: certs.Scheme(),
+		dedup:  dedup,
+		tokens: tokens,
 	}
 	return g, nil
 }
 
+// Write stores the body as an object addressed by its fat id. It belongs to no
+// bucket or tenant, so no quota limits it, only keys granted every bucket may write.
 func (g *GatewayHandler) Write(c *gin.Context) {
-	masterReq := &pb.AssignVolumeRequest{}
+	opts, err := parseWriteOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	masterReq := &pb.AssignVolumeRequest{TtlSeconds: opts.ttlSeconds, IdempotencyKey: opts.idempotencyKey, ContentSha256: opts.contentSHA256}
 	masterResp, err := g.masterClient.client.AssignVolume(c, masterReq)
 	if err != nil {
 		log.Printf("Failed to get a volume from the master: %v", err)
@@ -52,90 +84,344 @@ func (g *GatewayHandler) Write(c *gin.Context) {
 		return
 	}
 
-	volumeAddr := fmt.Sprintf("http://%s/v1/volume/write", masterResp.HttpAddress)
-	volumeReq, err := http.NewRequest("POST", volumeAddr, c.Request.Body)
+	g.locations.Set(volumeId, masterResp.HttpAddress)
+
+	body := newHashingReader(c.Request.Body)
+	needleId, existing, err := g.writeToVolume(c.Request.Context(), masterResp.HttpAddress, volumeId, masterResp.GetWriteToken(), opts, body, c.GetHeader("Content-Type"))
 	if err != nil {
-		log.Printf("Failed to build post req for volume server: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		if opts.contentAddressed && hex.EncodeToString(body.sum()) != opts.contentSHA256 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "data doesn't match its " + contentSHA256Header + " header"})
+			return
+		}
+		writeVolumeError(c, err)
+		return
+	}
+	if existing {
+		c.JSON(http.StatusOK, gin.H{"id": fatID(volumeId, needleId, ""), "existing": true})
 		return
 	}
-	volumeReq.Header.Set("Content-Type", c.GetHeader("Content-Type"))
+
+	if g.deduplicates(opts) {
+		id, deduplicated := g.addReference(c, volumeId, needleId, body)
+		if deduplicated {
+			c.JSON(http.StatusCreated, gin.H{"id": id, "deduplicated": true})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"id": id})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": fatID(volumeId, needleId, "")})
+}
+
+// volumeWriteURL is where a needle is uploaded to a volume.
+func (g *GatewayHandler) volumeWriteURL(addr string, volumeId uuid.UUID, opts writeOptions) string {
+	u := fmt.Sprintf("%s://%s/v1/volume/write?volume=%s", g.scheme, addr, volumeId)
+	if opts.ttlSeconds > 0 {
+		u += fmt.Sprintf("&ttl=%d", opts.ttlSeconds)
+	}
+	if opts.contentAddressed {
+		u += "&content_addressed=true"
+	}
+	return u
+}
+
+// writeToVolume uploads body as a needle and returns its id. existing reports the volume
+// already had it, from an earlier attempt with the idempotency key or the same content.
+// The write token is the master's, empty when it doesn't hand them out.
+func (g *GatewayHandler) writeToVolume(ctx context.Context, addr string, volumeId uuid.UUID, writeToken string, opts writeOptions, body io.Reader, contentType string) (string, bool, error) {
+	volumeReq, err := http.NewRequestWithContext(ctx, "POST", g.volumeWriteURL(addr, volumeId, opts), body)
+	if err != nil {
+		log.Printf("Failed to build post req for volume server: %v", err)
+		return "", false, err
+	}
+	volumeReq.Header.Set("Content-Type", contentType)
+	if writeToken != "" {
+		volumeReq.Header.Set(auth.WriteTokenHeader, writeToken)
+	}
+	if opts.idempotencyKey != "" {
+		volumeReq.Header.Set(idempotencyKeyHeader, opts.idempotencyKey)
+	}
+	if opts.contentSHA256 != "" {
+		volumeReq.Header.Set(contentSHA256Header, opts.contentSHA256)
+	}
 
 	volumeResp, err := g.httpClient.Do(volumeReq)
 	if err != nil {
 		log.Printf("Failed to send data to volume %s: %v", volumeId, err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "could not write to volume server"})
-		return
+		return "", false, errVolumeUnreachable
 	}
 	defer volumeResp.Body.Close()
 
-	if volumeResp.StatusCode != http.StatusCreated {
+	switch volumeResp.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+	case http.StatusConflict:
+		return "", false, errVolumeConflict
+	default:
 		log.Printf("Volume server returned non-201 status: %d", volumeResp.StatusCode)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "volume server failed to store data"})
-		return
+		return "", false, errVolumeRejected
 	}
 
-	body, err := io.ReadAll(volumeResp.Body)
+	respBody, err := io.ReadAll(volumeResp.Body)
 	if err != nil {
 		log.Printf("Failed to read volume server resp: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-		return
+		return "", false, err
 	}
 
 	var respData struct {
-		ID string `json:"id"`
+		ID       string `json:"id"`
+		Existing bool   `json:"existing"`
 	}
-	if err := json.Unmarshal(body, &respData); err != nil {
+	if err := json.Unmarshal(respBody, &respData); err != nil {
 		log.Printf("Failed to unmarshal JSON from volume server: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid response from volume server"})
-		return
+		return "", false, errVolumeResponse
 	}
+	return respData.ID, respData.Existing, nil
+}
 
-	fatID := fmt.Sprintf("%s:%s", volumeId.String(), respData.ID)
-	c.JSON(http.StatusCreated, gin.H{"id": fatID})
+func writeVolumeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errVolumeUnreachable), errors.Is(err, errVolumeRejected):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	case errors.Is(err, errVolumeConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errVolumeResponse):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
 }
 
+// Read streams a needle. An id naming a reference to a shared needle only reads it
+// while the reference is held, the needle outlives the ids deleted before the last.
 func (g *GatewayHandler) Read(c *gin.Context) {
-	fatID := c.Param("fat_id")
-
-	parts := strings.Split(fatID, ":")
-	if len(parts) != 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid object id format"})
+	volumeId, needleIdStr, ref, err := parseFatID(c.Param("fat_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	volumeIdStr, needleIdStr := parts[0], parts[1]
-	volumeId, err := uuid.Parse(volumeIdStr)
+	if g.dedup && ref != "" {
+		resp, err := g.masterClient.dedup.LookupReference(c, &pb.LookupReferenceRequest{VolumeId: volumeId.String(), NeedleId: needleIdStr, RefId: ref})
+		if err != nil {
+			log.Printf("Failed to look up reference %s in the dedup index: %v", ref, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
+			return
+		}
+		if !resp.GetHeld() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+			return
+		}
+	}
+
+	g.serveNeedle(c, volumeId, needleIdStr, "")
+}
+
+// serveNeedle streams a needle to the client, with contentType if set or else the volume server's.
+func (g *GatewayHandler) serveNeedle(c *gin.Context, volumeId uuid.UUID, needleIdStr, contentType string) {
+	// gin reuses c once the handler returns, while the transport may still be watching
+	// the request's context
+	volumeResp, err := g.readFromVolume(c.Request.Context(), volumeId, needleIdStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		if errors.Is(err, errVolumeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+			return
+		}
+		if errors.Is(err, errMasterUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
+			return
+		}
+		log.Printf("Failed to get data from volume %s: %v", volumeId, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read from volume server"})
 		return
 	}
-	if _, err := uuid.Parse(needleIdStr); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid needle id format"})
+	defer volumeResp.Body.Close()
+
+	if contentType == "" || volumeResp.StatusCode != http.StatusOK {
+		contentType = volumeResp.Header.Get("Content-Type")
+	}
+	c.DataFromReader(volumeResp.StatusCode, volumeResp.ContentLength, contentType, volumeResp.Body, nil)
+}
+
+// Delete removes a needle. With dedup on, it only drops the reference the id names
+// to a shared needle, which is removed with the last one. A shared needle can't be
+// deleted by an id without a reference, that would take every other upload's data.
+func (g *GatewayHandler) Delete(c *gin.Context) {
+	volumeId, needleIdStr, ref, err := parseFatID(c.Param("fat_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	masterReq := &pb.GetVolumeLocationRequest{VolumeId: volumeId[:]}
-	masterResp, err := g.masterClient.client.GetVolumeLocation(c, masterReq)
+	if g.dedup {
+		resp, err := g.masterClient.dedup.ReleaseReference(c, &pb.ReleaseReferenceRequest{VolumeId: volumeId.String(), NeedleId: needleIdStr, RefId: ref})
+		if err != nil {
+			log.Printf("Failed to release needle %s in the dedup index: %v", needleIdStr, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
+			return
+		}
+		if resp.GetTracked() {
+			switch {
+			case ref == "":
+				c.JSON(http.StatusConflict, gin.H{"error": "object is shared, delete it by the id its upload returned"})
+				return
+			case !resp.GetReleased():
+				c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+				return
+			case resp.GetRefs() > 0:
+				c.Status(http.StatusNoContent)
+				return
+			}
+		}
+	}
+
+	statusCode, err := g.deleteFromVolume(c.Request.Context(), volumeId, needleIdStr)
 	if err != nil {
-		log.Printf("Failed to get volume location from master: %v", err)
-		st, _ := status.FromError(err)
-		if st.Code() == codes.NotFound {
+		switch {
+		case errors.Is(err, errVolumeNotFound):
 			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
-			return
+		case errors.Is(err, errVolumeUnreachable):
+			c.JSON(http.StatusBadGateway, gin.H{"error": "could not delete from volume server"})
+		case errors.Is(err, errMasterUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		}
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "master server is unavailable"})
 		return
 	}
 
-	volumeAddr := fmt.Sprintf("http://%s/v1/volume/read/%s", masterResp.HttpAddress, needleIdStr)
-	volumeResp, err := g.httpClient.Get(volumeAddr)
+	switch statusCode {
+	case http.StatusNoContent:
+		c.Status(http.StatusNoContent)
+	case http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+	case http.StatusServiceUnavailable:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "volume is read-only"})
+	default:
+		log.Printf("Volume server returned status %d for delete", statusCode)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "volume server failed to delete data"})
+	}
+}
+
+// deleteFromVolume deletes a needle from the volume server holding it and returns
+// the volume server's status code. Like readFromVolume, a 404 or connection error
+// refreshes the location and the delete is retried once if the volume has moved.
+func (g *GatewayHandler) deleteFromVolume(ctx context.Context, volumeId uuid.UUID, needleIdStr string) (int, error) {
+	addr, err := g.lookupVolume(ctx, volumeId)
 	if err != nil {
-		log.Printf("Failed to get data from volume %s: %v", volumeId, err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read from volume server"})
-		return
+		return 0, err
 	}
-	defer volumeResp.Body.Close()
 
-	c.DataFromReader(volumeResp.StatusCode, volumeResp.ContentLength, volumeResp.Header.Get("Content-Type"), volumeResp.Body, nil)
+	statusCode, err := g.deleteNeedle(ctx, addr, volumeId, needleIdStr)
+	if err == nil && statusCode != http.StatusNotFound {
+		return statusCode, nil
+	}
+
+	g.locations.Invalidate(volumeId)
+	freshAddr, lookupErr := g.lookupVolume(ctx, volumeId)
+	if lookupErr != nil || freshAddr == addr {
+		// the volume hasn't moved, whatever the volume server said stands
+		return statusCode, err
+	}
+	return g.deleteNeedle(ctx, freshAddr, volumeId, needleIdStr)
+}
+
+func (g *GatewayHandler) deleteNeedle(ctx context.Context, addr string, volumeId uuid.UUID, needleIdStr string) (int, error) {
+	volumeAddr := fmt.Sprintf("%s://%s/v1/volume/delete/%s?volume=%s", g.scheme, addr, needleIdStr, volumeId)
+	volumeReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, volumeAddr, nil)
+	if err != nil {
+		log.Printf("Failed to build delete req for volume server: %v", err)
+		return 0, err
+	}
+	if g.tokens != nil {
+		g.tokens.SignRequest(volumeReq, time.Now())
+	}
+
+	volumeResp, err := g.httpClient.Do(volumeReq)
+	if err != nil {
+		log.Printf("Failed to delete from volume %s: %v", volumeId, err)
+		return 0, errVolumeUnreachable
+	}
+	volumeResp.Body.Close()
+	return volumeResp.StatusCode, nil
+}
+
+// fatID joins a volumeId:needleId object id, with :refId after it for uploads that
+// hold a reference to a shared needle.
+func fatID(volumeId uuid.UUID, needleId, ref string) string {
+	if ref == "" {
+		return fmt.Sprintf("%s:%s", volumeId, needleId)
+	}
+	return fmt.Sprintf("%s:%s:%s", volumeId, needleId, ref)
+}
+
+// parseFatID splits a volumeId:needleId object id, or a volumeId:needleId:refId one.
+// ref is empty for the first.
+func parseFatID(fatID string) (volumeId uuid.UUID, needleId, ref string, err error) {
+	parts := strings.Split(fatID, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return uuid.Nil, "", "", errors.New("invalid object id format")
+	}
+
+	volumeId, err = uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, "", "", errors.New("invalid volume id format")
+	}
+	if _, err := uuid.Parse(parts[1]); err != nil {
+		return uuid.Nil, "", "", errors.New("invalid needle id format")
+	}
+	if len(parts) == 3 {
+		if _, err := uuid.Parse(parts[2]); err != nil {
+			return uuid.Nil, "", "", errors.New("invalid ref id format")
+		}
+		ref = parts[2]
+	}
+	return volumeId, parts[1], ref, nil
+}
+
+// readFromVolume fetches a needle from the volume server the location cache points at.
+// A 404 or connection error may just mean the cached location is stale, so the
+// location is refreshed and the read retried once if the volume has moved.
+func (g *GatewayHandler) readFromVolume(ctx context.Context, volumeId uuid.UUID, needleIdStr string) (*http.Response, error) {
+	addr, err := g.lookupVolume(ctx, volumeId)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeResp, err := g.getNeedle(ctx, addr, volumeId, needleIdStr)
+	if err == nil && volumeResp.StatusCode != http.StatusNotFound {
+		return volumeResp, nil
+	}
+
+	g.locations.Invalidate(volumeId)
+	freshAddr, lookupErr := g.lookupVolume(ctx, volumeId)
+	if lookupErr != nil || freshAddr == addr {
+		// the volume hasn't moved, whatever the volume server said stands
+		if err != nil {
+			return nil, err
+		}
+		return volumeResp, nil
+	}
+
+	if volumeResp != nil {
+		volumeResp.Body.Close()
+	}
+	return g.getNeedle(ctx, freshAddr, volumeId, needleIdStr)
+}
+
+func (g *GatewayHandler) lookupVolume(ctx context.Context, volumeId uuid.UUID) (string, error) {
+	addr, err := g.locations.Lookup(ctx, volumeId)
+	if err != nil && !errors.Is(err, errVolumeNotFound) {
+		log.Printf("Failed to get volume location from master: %v", err)
+		return "", errMasterUnavailable
+	}
+	return addr, err
+}
+
+func (g *GatewayHandler) getNeedle(ctx context.Context, addr string, volumeId uuid.UUID, needleIdStr string) (*http.Response, error) {
+	volumeAddr := fmt.Sprintf("%s://%s/v1/volume/read/%s?volume=%s", g.scheme, addr, needleIdStr, volumeId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, volumeAddr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return g.httpClient.Do(req)
 }
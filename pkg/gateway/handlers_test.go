@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc"
+)
+
+// fakeMaster answers every volume lookup with one address.
+type fakeMaster struct {
+	pb.MasterServiceClient
+	addr string
+}
+
+func (f *fakeMaster) GetVolumeLocation(ctx context.Context, req *pb.GetVolumeLocationRequest, opts ...grpc.CallOption) (*pb.GetVolumeLocationResponse, error) {
+	return &pb.GetVolumeLocationResponse{HttpAddress: f.addr}, nil
+}
+
+// deleteServer is a volume server answering deletes with code and counting them.
+func deleteServer(t *testing.T, code int, deletes *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deletes.Add(1)
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeleteFollowsMovedVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		moved       bool
+		oldDown     bool
+		wantStatus  int
+		wantOld     int32
+		wantCurrent int32
+	}{
+		{name: "volume moved", moved: true, wantStatus: http.StatusNoContent, wantOld: 1, wantCurrent: 1},
+		{name: "old copy already removed", moved: true, oldDown: true, wantStatus: http.StatusNoContent, wantCurrent: 1},
+		{name: "volume didn't move", wantStatus: http.StatusNotFound, wantOld: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oldDeletes, currentDeletes atomic.Int32
+			old := deleteServer(t, http.StatusNotFound, &oldDeletes)
+			current := deleteServer(t, http.StatusNoContent, &currentDeletes)
+			oldAddr := strings.TrimPrefix(old.URL, "http://")
+			if tt.oldDown {
+				old.Close()
+			}
+
+			master := &fakeMaster{addr: oldAddr}
+			if tt.moved {
+				master.addr = strings.TrimPrefix(current.URL, "http://")
+			}
+			m := &MasterClient{client: master}
+			volumeId := uuid.New()
+			locations := NewLocationCache(m, time.Minute, time.Minute)
+			locations.Set(volumeId, oldAddr)
+			g := &GatewayHandler{masterClient: m, locations: locations, httpClient: current.Client(), scheme: "http"}
+
+			engine := gin.New()
+			engine.DELETE("/v1/gateway/delete/:fat_id", g.Delete)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/gateway/delete/"+fatID(volumeId, uuid.NewString(), ""), nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if oldDeletes.Load() != tt.wantOld || currentDeletes.Load() != tt.wantCurrent {
+				t.Fatalf("deletes = %d on the old copy and %d on the current one, want %d and %d", oldDeletes.Load(), currentDeletes.Load(), tt.wantOld, tt.wantCurrent)
+			}
+		})
+	}
+}
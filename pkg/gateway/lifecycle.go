@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+// tags an object is uploaded with, URL-encoded like a query string: a=1&b=2
+const taggingHeader = "X-Graphene-Tagging"
+
+// set on reads of bucket objects to the kind of storage holding them
+const storageClassHeader = "X-Graphene-Storage-Class"
+
+// lifecycleRule is a bucket lifecycle rule as clients send it. Ages take the same
+// units as a ttl, e.g. 12h, 30d or 2w.
+type lifecycleRule struct {
+	ID                    string            `json:"id"`
+	Disabled              bool              `json:"disabled,omitempty"`
+	Prefix                string            `json:"prefix,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+	ExpireAfter           string            `json:"expire_after,omitempty"`
+	NoncurrentExpireAfter string            `json:"noncurrent_expire_after,omitempty"`
+	TransitionAfter       string            `json:"transition_after,omitempty"`
+	StorageClass          string            `json:"storage_class,omitempty"`
+	AbortMultipartAfter   string            `json:"abort_multipart_after,omitempty"`
+}
+
+type bucketLifecycle struct {
+	Rules []lifecycleRule `json:"rules"`
+}
+
+// parseTagging reads the tags an object is uploaded with.
+func parseTagging(c *gin.Context) (map[string]string, error) {
+	header := c.GetHeader(taggingHeader)
+	if header == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %v", taggingHeader, err)
+	}
+	tags := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("tag %q is set more than once", k)
+		}
+		tags[k] = v[0]
+	}
+	return tags, nil
+}
+
+// parseAge turns a rule's age into seconds, 0 if it's unset.
+func parseAge(field, s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	age, err := parseTTL(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return int64(age / time.Second), nil
+}
+
+// formatAge renders seconds in whole days where it can.
+func formatAge(seconds int64) string {
+	if seconds == 0 {
+		return ""
+	}
+	const day = 24 * 60 * 60
+	if seconds%day == 0 {
+		return fmt.Sprintf("%dd", seconds/day)
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func (r lifecycleRule) proto() (*pb.LifecycleRule, error) {
+	rule := &pb.LifecycleRule{
+		Id:                     r.ID,
+		Disabled:               r.Disabled,
+		Prefix:                 r.Prefix,
+		Tags:                   r.Tags,
+		TransitionStorageClass: r.StorageClass,
+	}
+	var err error
+	if rule.ExpireAfterSeconds, err = parseAge("expire_after", r.ExpireAfter); err != nil {
+		return nil, err
+	}
+	if rule.NoncurrentExpireAfterSeconds, err = parseAge("noncurrent_expire_after", r.NoncurrentExpireAfter); err != nil {
+		return nil, err
+	}
+	if rule.TransitionAfterSeconds, err = parseAge("transition_after", r.TransitionAfter); err != nil {
+		return nil, err
+	}
+	if rule.AbortMultipartAfterSeconds, err = parseAge("abort_multipart_after", r.AbortMultipartAfter); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func lifecycleJSON(l *pb.BucketLifecycle) bucketLifecycle {
+	resp := bucketLifecycle{Rules: make([]lifecycleRule, 0, len(l.GetRules()))}
+	for _, r := range l.GetRules() {
+		resp.Rules = append(resp.Rules, lifecycleRule{
+			ID:                    r.GetId(),
+			Disabled:              r.GetDisabled(),
+			Prefix:                r.GetPrefix(),
+			Tags:                  r.GetTags(),
+			ExpireAfter:           formatAge(r.GetExpireAfterSeconds()),
+			NoncurrentExpireAfter: formatAge(r.GetNoncurrentExpireAfterSeconds()),
+			TransitionAfter:       formatAge(r.GetTransitionAfterSeconds()),
+			StorageClass:          r.GetTransitionStorageClass(),
+			AbortMultipartAfter:   formatAge(r.GetAbortMultipartAfterSeconds()),
+		})
+	}
+	return resp
+}
+
+func (g *GatewayHandler) GetBucketLifecycle(c *gin.Context) {
+	resp, err := g.masterClient.ledger.GetBucketLifecycle(c, &pb.GetBucketLifecycleRequest{Bucket: c.Param("bucket")})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lifecycleJSON(resp))
+}
+
+// SetBucketLifecycle replaces a bucket's lifecycle rules. The master applies them
+// in the background, expiring objects and moving them between storage classes.
+func (g *GatewayHandler) SetBucketLifecycle(c *gin.Context) {
+	var req bucketLifecycle
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lifecycle request: " + err.Error()})
+		return
+	}
+
+	lifecycle := &pb.BucketLifecycle{Bucket: c.Param("bucket")}
+	for _, r := range req.Rules {
+		rule, err := r.proto()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		lifecycle.Rules = append(lifecycle.Rules, rule)
+	}
+
+	resp, err := g.masterClient.ledger.SetBucketLifecycle(c, lifecycle)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lifecycleJSON(resp))
+}
+
+// DeleteBucketLifecycle removes a bucket's lifecycle rules.
+func (g *GatewayHandler) DeleteBucketLifecycle(c *gin.Context) {
+	if _, err := g.masterClient.ledger.SetBucketLifecycle(c, &pb.BucketLifecycle{Bucket: c.Param("bucket")}); err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rxanders35/graphene/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errVolumeNotFound = errors.New("volume not found")
+
+type location struct {
+	addr      string // empty for a cached "not found"
+	expiresAt time.Time
+}
+
+// LocationCache remembers which volume server holds a volume so reads don't
+// need a GetVolumeLocation round trip to the master every time. Misses are
+// cached too (for a shorter time) so unknown volumes can't hammer the master.
+type LocationCache struct {
+	master      *MasterClient
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[uuid.UUID]location
+	mu          sync.RWMutex
+}
+
+func NewLocationCache(m *MasterClient, ttl, negativeTTL time.Duration) *LocationCache {
+	return &LocationCache{
+		master:      m,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[uuid.UUID]location),
+	}
+}
+
+// Lookup returns the http address of the volume server holding volumeId,
+// asking the master only when the cached location is missing or stale.
+func (l *LocationCache) Lookup(ctx context.Context, volumeId uuid.UUID) (string, error) {
+	l.mu.RLock()
+	loc, ok := l.entries[volumeId]
+	l.mu.RUnlock()
+
+	if ok && time.Now().Before(loc.expiresAt) {
+		if loc.addr == "" {
+			return "", errVolumeNotFound
+		}
+		return loc.addr, nil
+	}
+
+	resp, err := l.master.client.GetVolumeLocation(ctx, &pb.GetVolumeLocationRequest{VolumeId: volumeId[:]})
+	if err != nil {
+		if st, _ := status.FromError(err); st.Code() == codes.NotFound {
+			l.store(volumeId, "", l.negativeTTL)
+			return "", errVolumeNotFound
+		}
+		return "", err
+	}
+
+	l.Set(volumeId, resp.GetHttpAddress())
+	return resp.GetHttpAddress(), nil
+}
+
+func (l *LocationCache) Set(volumeId uuid.UUID, addr string) {
+	l.store(volumeId, addr, l.ttl)
+}
+
+func (l *LocationCache) Invalidate(volumeId uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, volumeId)
+}
+
+func (l *LocationCache) store(volumeId uuid.UUID, addr string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[volumeId] = location{addr: addr, expiresAt: time.Now().Add(ttl)}
+}
+
+// Watch keeps the cache in sync with the master's WatchTopology stream until
+// ctx is done, resubscribing (and so resyncing from a fresh snapshot) on errors.
+func (l *LocationCache) Watch(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := l.watchOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Topology watch interrupted, resubscribing in %s. Why: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+func (l *LocationCache) watchOnce(ctx context.Context) error {
+	stream, err := l.master.client.WatchTopology(ctx, &pb.WatchTopologyRequest{})
+	if err != nil {
+		return err
+	}
+
+	serverAddrs := make(map[uuid.UUID]string)
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("master closed the stream")
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, loc := range ev.GetVolumes() {
+			volumeId, err := uuid.FromBytes(loc.GetVolumeId())
+			if err != nil {
+				continue
+			}
+			if serverId, err := uuid.FromBytes(loc.GetServerId()); err == nil {
+				serverAddrs[serverId] = loc.GetHttpAddress()
+			}
+
+			if ev.GetType() == pb.TopologyEvent_VOLUME_REMOVED {
+				l.store(volumeId, "", l.negativeTTL)
+				continue
+			}
+			l.Set(volumeId, loc.GetHttpAddress())
+		}
+
+		if t := ev.GetType(); t == pb.TopologyEvent_SERVER_DOWN || t == pb.TopologyEvent_SERVER_REMOVED {
+			if serverId, err := uuid.FromBytes(ev.GetServerId()); err == nil {
+				l.invalidateAddr(serverAddrs[serverId])
+			}
+		}
+	}
+}
+
+func (l *LocationCache) invalidateAddr(addr string) {
+	if addr == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, loc := range l.entries {
+		if loc.addr == addr {
+			delete(l.entries, id)
+		}
+	}
+}
@@ -3,19 +3,23 @@ package gateway
 import (
 	"log"
 
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
 	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type MasterClient struct {
 	masterAddr string
 	conn       *grpc.ClientConn
 	client     pb.MasterServiceClient
+	ledger     pb.LedgerServiceClient
+	dedup      pb.DedupServiceClient
 }
 
-func NewMasterclient(masterAddr string) (*MasterClient, error) {
-	conn, err := grpc.NewClient(masterAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewMasterclient(masterAddr string, certs *tlsconfig.Certs) (*MasterClient, error) {
+	conn, err := grpc.NewClient(masterAddr, certs.DialOption(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
 	if err != nil {
 		log.Printf("Failed dialing master. Why: %v", err)
 	}
@@ -24,6 +28,8 @@ func NewMasterclient(masterAddr string) (*MasterClient, error) {
 		masterAddr: masterAddr,
 		conn:       conn,
 		client:     pb.NewMasterServiceClient(conn),
+		ledger:     pb.NewLedgerServiceClient(conn),
+		dedup:      pb.NewDedupServiceClient(conn),
 	}
 	return c, nil
 }
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+)
+
+const defaultPresignExpiry = 15 * time.Minute
+
+var (
+	errPresignTarget = errors.New("presign either an id, a bucket and path, or nothing for a POST upload")
+	errPresignMethod = errors.New("method can't be presigned for this target")
+)
+
+type presignRequest struct {
+	Method    string `json:"method"`
+	ID        string `json:"id"`     // fat id, for GET and DELETE
+	Bucket    string `json:"bucket"` // bucket and path, for GET, PUT and DELETE
+	Path      string `json:"path"`
+	TTL       string `json:"ttl"`        // for a POST upload by id, the object's ttl
+	ExpiresIn int64  `json:"expires_in"` // seconds the URL is valid, 15 minutes if unset
+}
+
+// Presign hands an authenticated client a URL that lets anyone make one kind of
+// request until it expires: download, upload or delete a fat id or bucket path, or
+// upload a new object by id with a POST. The key has to be allowed that request itself.
+func (a *Authenticator) Presign(c *gin.Context) {
+	if a == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "presigned URLs need the gateway to run with -credentials"})
+		return
+	}
+	if auth.IsPresigned(c.Request.URL.Query()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "presigned URLs can't presign"})
+		return
+	}
+
+	key, status, msg := a.authenticate(c.Request, time.Now())
+	if key == nil {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	var req presignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid presign request: " + err.Error()})
+		return
+	}
+
+	expiresIn := time.Duration(req.ExpiresIn) * time.Second
+	if req.ExpiresIn == 0 {
+		expiresIn = defaultPresignExpiry
+	}
+	if expiresIn <= 0 || expiresIn > auth.MaxPresignExpiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be between 1s and " + auth.MaxPresignExpiry.String()})
+		return
+	}
+
+	method := strings.ToUpper(req.Method)
+	target, op, err := presignTarget(method, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !key.Allows(req.Bucket, op) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "key " + key.ID + " may not " + op + " in " + bucketLabel(req.Bucket)})
+		return
+	}
+
+	expires := time.Now().Add(expiresIn).Truncate(time.Second)
+	target.RawQuery = auth.Presign(method, target, key.ID, key.Secret, expires).Encode()
+	target.Scheme, target.Host = "http", c.Request.Host
+	if c.Request.TLS != nil {
+		target.Scheme = "https"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        target.String(),
+		"method":     method,
+		"expires_at": expires.UTC(),
+	})
+}
+
+// presignTarget maps a presign request to the route it unlocks and the op that takes.
+func presignTarget(method string, req presignRequest) (*url.URL, string, error) {
+	if req.ID != "" {
+		if req.Bucket != "" || req.Path != "" {
+			return nil, "", errPresignTarget
+		}
+		if _, _, _, err := parseFatID(req.ID); err != nil {
+			return nil, "", err
+		}
+		switch method {
+		case http.MethodGet:
+			return &url.URL{Path: "/v1/gateway/read/" + req.ID}, auth.OpRead, nil
+		case http.MethodDelete:
+			return &url.URL{Path: "/v1/gateway/delete/" + req.ID}, auth.OpDelete, nil
+		}
+		return nil, "", errPresignMethod
+	}
+
+	if req.Bucket == "" && req.Path == "" {
+		if method != http.MethodPost {
+			return nil, "", errPresignMethod
+		}
+		u := &url.URL{Path: "/v1/gateway/write"}
+		if req.TTL != "" {
+			if _, err := parseTTL(req.TTL); err != nil {
+				return nil, "", err
+			}
+			u.RawQuery = url.Values{"ttl": {req.TTL}}.Encode()
+		}
+		return u, auth.OpWrite, nil
+	}
+
+	if req.Bucket == "" || req.Path == "" || strings.HasPrefix(req.Path, "/") {
+		return nil, "", errPresignTarget
+	}
+	u := &url.URL{Path: "/v1/buckets/" + req.Bucket + "/objects/" + req.Path}
+	switch method {
+	case http.MethodGet:
+		return u, auth.OpRead, nil
+	case http.MethodPut:
+		return u, auth.OpWrite, nil
+	case http.MethodDelete:
+		return u, auth.OpDelete, nil
+	}
+	return nil, "", errPresignMethod
+}
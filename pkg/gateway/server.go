@@ -5,6 +5,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/metrics"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type GatewayServer struct {
@@ -13,16 +17,23 @@ type GatewayServer struct {
 	srv            *http.Server
 	gatewayHandler *GatewayHandler
 	masterClient   *MasterClient
+	certs          *tlsconfig.Certs
+	auth           *Authenticator
 }
 
-func NewGatewayServer(gatewayAddr string, h *GatewayHandler) (*GatewayServer, error) {
+// NewGatewayServer serves the gateway API, checking requests with a if it isn't nil.
+func NewGatewayServer(gatewayAddr string, h *GatewayHandler, certs *tlsconfig.Certs, a *Authenticator) (*GatewayServer, error) {
 	engine := gin.New()
-	engine.Use(gin.Logger(), gin.Recovery())
+	// handlers hand the gin context to outbound calls, let it carry the request's span
+	engine.ContextWithFallback = true
+	engine.Use(gin.Logger(), gin.Recovery(), metrics.Middleware(), otelgin.Middleware("gateway"))
 
 	g := &GatewayServer{
 		addr:           gatewayAddr,
 		engine:         engine,
 		gatewayHandler: h,
+		certs:          certs,
+		auth:           a,
 	}
 	g.registerRoutes()
 
@@ -30,14 +41,37 @@ func NewGatewayServer(gatewayAddr string, h *GatewayHandler) (*GatewayServer, er
 }
 
 func (g *GatewayServer) registerRoutes() {
+	g.engine.GET("/metrics", metrics.Handler())
+
 	v1 := g.engine.Group("/v1")
 
 	gateway := v1.Group("/gateway")
 
-	gateway.POST("/write", g.gatewayHandler.Write)
+	gateway.POST("/write", g.auth.Require(auth.OpWrite), g.gatewayHandler.Write)
 	// Encapsulates the entire write flow (req Master for volume addr -> forward to volume server)
-	gateway.GET("/read/:fat_id", g.gatewayHandler.Read)
+	gateway.GET("/read/:fat_id", g.auth.Require(auth.OpRead), g.gatewayHandler.Read)
 	// Encapsulates the entire read flow (parse fat_id -> req Master for volume addr -> forward to volume server)
+	gateway.DELETE("/delete/:fat_id", g.auth.Require(auth.OpDelete), g.gatewayHandler.Delete)
+	gateway.POST("/presign", g.auth.Presign)
+	gateway.POST("/assign", g.auth.Require(auth.OpWrite), g.gatewayHandler.Assign)
+	// Hands out a URL that authorizes a single kind of request until it expires
+
+	buckets := v1.Group("/buckets/:bucket")
+
+	buckets.GET("/objects", g.auth.Require(auth.OpList), g.gatewayHandler.ListObjects)
+	buckets.PUT("/objects/*path", g.auth.Require(auth.OpWrite), g.gatewayHandler.PutObject)
+	// Same flow as write, then records bucket/path -> fat id in the master's ledger
+	buckets.GET("/objects/*path", g.auth.Require(auth.OpRead), g.gatewayHandler.GetObject)
+	buckets.DELETE("/objects/*path", g.auth.Require(auth.OpDelete), g.gatewayHandler.DeleteObject)
+	buckets.GET("/versions", g.auth.Require(auth.OpList), g.gatewayHandler.ListObjectVersions)
+	buckets.GET("/versioning", g.auth.Require(auth.OpRead), g.gatewayHandler.GetBucketVersioning)
+	buckets.PUT("/versioning", g.auth.Require(auth.OpWrite), g.gatewayHandler.SetBucketVersioning)
+	// Versioned buckets keep every put of a path, reads and deletes can pick one with ?versionId=
+	buckets.GET("/lifecycle", g.auth.Require(auth.OpRead), g.gatewayHandler.GetBucketLifecycle)
+	// rules expire objects, so setting them takes deleting too
+	buckets.PUT("/lifecycle", g.auth.Require(auth.OpWrite, auth.OpDelete), g.gatewayHandler.SetBucketLifecycle)
+	buckets.DELETE("/lifecycle", g.auth.Require(auth.OpDelete), g.gatewayHandler.DeleteBucketLifecycle)
+	// Rules the master applies in the background to expire objects or move them to another storage class
 }
 
 func (g *GatewayServer) Run() error {
@@ -45,7 +79,7 @@ func (g *GatewayServer) Run() error {
 		Addr:    g.addr,
 		Handler: g.engine,
 	}
-	return g.srv.ListenAndServe()
+	return g.certs.ListenAndServe(g.srv)
 }
 
 func (g *GatewayServer) Shutdown(ctx context.Context) error {
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidTTL = errors.New("invalid ttl, expected e.g. 30m, 12h, 7d or 2w")
+
+// parseTTL accepts anything time.ParseDuration does, plus whole days (d) and weeks (w).
+func parseTTL(s string) (time.Duration, error) {
+	var ttl time.Duration
+
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
+		if err != nil {
+			return 0, errInvalidTTL
+		}
+		unit := 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			unit *= 7
+		}
+		if n > math.MaxUint32/uint64(unit/time.Second) {
+			return 0, errInvalidTTL
+		}
+		ttl = time.Duration(n) * unit
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, errInvalidTTL
+		}
+		ttl = d
+	}
+
+	if ttl < time.Second || ttl/time.Second > math.MaxUint32 {
+		return 0, errInvalidTTL
+	}
+	return ttl, nil
+}
@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+// set on deletes that hid an object behind a delete marker, or removed one
+const deleteMarkerHeader = "X-Graphene-Delete-Marker"
+
+type objectVersionInfo struct {
+	Path         string            `json:"path"`
+	VersionId    string            `json:"version_id"`
+	ID           string            `json:"id,omitempty"` // delete markers have no needle
+	Size         int64             `json:"size"`
+	MimeType     string            `json:"mime_type,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	ModifiedAt   time.Time         `json:"modified_at"`
+	IsLatest     bool              `json:"is_latest"`
+	DeleteMarker bool              `json:"delete_marker"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+}
+
+type bucketVersioning struct {
+	Status      string `json:"status"` // "enabled" or "suspended", "unversioned" until either was set
+	MaxVersions int32  `json:"max_versions"`
+}
+
+// ListObjectVersions lists every version of a bucket's objects under ?prefix=, newest
+// first within a path, a page of ?max_keys= at a time. The next page starts after
+// the last path and version of this one, passed as ?start_after= and ?start_after_version_id=.
+func (g *GatewayHandler) ListObjectVersions(c *gin.Context) {
+	req := &pb.ListObjectVersionsRequest{
+		Bucket:              c.Param("bucket"),
+		Prefix:              c.Query("prefix"),
+		StartAfter:          c.Query("start_after"),
+		StartAfterVersionId: c.Query("start_after_version_id"),
+	}
+	if s := c.Query("max_keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_keys"})
+			return
+		}
+		req.MaxKeys = int32(n)
+	}
+
+	resp, err := g.masterClient.ledger.ListObjectVersions(c, req)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	versions := make([]objectVersionInfo, 0, len(resp.GetVersions()))
+	for _, v := range resp.GetVersions() {
+		info := objectVersionInfo{
+			Path:         v.GetPath(),
+			VersionId:    v.GetVersionId(),
+			Size:         v.GetSizeBytes(),
+			MimeType:     v.GetMimeType(),
+			ETag:         v.GetEtag(),
+			ModifiedAt:   v.GetModifiedAt().AsTime(),
+			IsLatest:     v.GetIsLatest(),
+			DeleteMarker: v.GetDeleteMarker(),
+			Tags:         v.GetTags(),
+			StorageClass: v.GetStorageClass(),
+		}
+		if !v.GetDeleteMarker() {
+			info.ID = fmt.Sprintf("%s:%s", v.GetVolumeId(), v.GetNeedleId())
+		}
+		versions = append(versions, info)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"bucket":    req.Bucket,
+		"versions":  versions,
+		"truncated": resp.GetTruncated(),
+	})
+}
+
+func (g *GatewayHandler) GetBucketVersioning(c *gin.Context) {
+	resp, err := g.masterClient.ledger.GetBucketVersioning(c, &pb.GetBucketVersioningRequest{Bucket: c.Param("bucket")})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, versioningJSON(resp))
+}
+
+// SetBucketVersioning enables or suspends versioning of a bucket, and with
+// max_versions keeps only that many versions of each path.
+func (g *GatewayHandler) SetBucketVersioning(c *gin.Context) {
+	var req bucketVersioning
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid versioning request: " + err.Error()})
+		return
+	}
+	versioningStatus, ok := pb.BucketVersioning_Status_value[strings.ToUpper(req.Status)]
+	if !ok || versioningStatus == int32(pb.BucketVersioning_UNVERSIONED) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `status must be "enabled" or "suspended"`})
+		return
+	}
+
+	resp, err := g.masterClient.ledger.SetBucketVersioning(c, &pb.BucketVersioning{
+		Bucket:      c.Param("bucket"),
+		Status:      pb.BucketVersioning_Status(versioningStatus),
+		MaxVersions: req.MaxVersions,
+	})
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, versioningJSON(resp))
+}
+
+func versioningJSON(v *pb.BucketVersioning) bucketVersioning {
+	return bucketVersioning{
+		Status:      strings.ToLower(v.GetStatus().String()),
+		MaxVersions: v.GetMaxVersions(),
+	}
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// retries of a write with the same key get the object of the first one
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 128
+
+	// content-addressed writes are placed by the hex SHA-256 of their data, so it comes up front
+	contentSHA256Header = "X-Graphene-Content-SHA256"
+)
+
+// writeOptions are how a client asked for a needle to be written.
+type writeOptions struct {
+	ttlSeconds     uint32
+	idempotencyKey string // scoped, as sent to the master and volume server
+	// the needle is named after the SHA-256 of its data, uploads of the same data share it
+	contentAddressed bool
+	contentSHA256    string // lowercase hex, set for content-addressed writes
+}
+
+// parseWriteOptions reads ?ttl=, ?content_addressed=true and the Idempotency-Key and
+// X-Graphene-Content-SHA256 headers. Keys are scoped to the API key that sent them so
+// clients can't collide.
+func parseWriteOptions(c *gin.Context) (writeOptions, error) {
+	var opts writeOptions
+	if ttlStr := c.Query("ttl"); ttlStr != "" {
+		ttl, err := parseTTL(ttlStr)
+		if err != nil {
+			return opts, err
+		}
+		opts.ttlSeconds = uint32(ttl / time.Second)
+	}
+	opts.contentAddressed = c.Query("content_addressed") == "true"
+
+	key := c.GetHeader(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLen {
+		return opts, fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLen)
+	}
+	if opts.contentAddressed && (opts.ttlSeconds > 0 || key != "") {
+		return opts, errors.New("content-addressed writes can't have a ttl or an idempotency key")
+	}
+	if opts.contentAddressed {
+		sha := strings.ToLower(c.GetHeader(contentSHA256Header))
+		if b, err := hex.DecodeString(sha); err != nil || len(b) != sha256.Size {
+			return opts, errors.New("content-addressed writes need the hex SHA-256 of their data in the " + contentSHA256Header + " header")
+		}
+		opts.contentSHA256 = sha
+	}
+	if key != "" {
+		opts.idempotencyKey = scopeIdempotencyKey(c, key)
+	}
+	return opts, nil
+}
+
+// scopeIdempotencyKey prefixes a client's key with the API key it authenticated with.
+func scopeIdempotencyKey(c *gin.Context, key string) string {
+	return c.GetString(contextKeyID) + ":" + key
+}
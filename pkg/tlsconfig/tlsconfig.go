@@ -0,0 +1,349 @@
+// Package tlsconfig sets up TLS and mutual TLS between the graphene services.
+//
+// Every service uses one certificate both to serve and to call the others, so it
+// needs the serverAuth and clientAuth extended key usages. Certificates and the CA
+// are reloaded from disk when the files change, without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+	ClientAuthNone     = "none"
+
+	// files are checked for changes at most this often
+	reloadCheckInterval = time.Second
+)
+
+var ErrNoClientCert = errors.New("no verified client certificate")
+
+// Config points at the PEM files a service uses. Without any of them set it talks plaintext.
+type Config struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// require, optional or none: whether servers ask for and verify client certificates
+	ClientAuth string
+	// the same for an HTTP listener clients outside the cluster call, which prove
+	// themselves with API keys, presigned URLs or write tokens. Empty follows ClientAuth
+	HTTPClientAuth string
+}
+
+// RegisterClientFlags adds -tls-ca, -tls-cert and -tls-key to the default flag set.
+func RegisterClientFlags() *Config {
+	c := &Config{ClientAuth: ClientAuthNone}
+	flag.StringVar(&c.CAFile, "tls-ca", "", "PEM CA bundle peers' certificates are verified against, enables TLS")
+	flag.StringVar(&c.CertFile, "tls-cert", "", "PEM certificate presented to peers")
+	flag.StringVar(&c.KeyFile, "tls-key", "", "PEM private key of -tls-cert")
+	return c
+}
+
+// RegisterFlags adds the client flags and -tls-client-auth for services that also
+// serve, defaulting to clientAuth.
+func RegisterFlags(clientAuth string) *Config {
+	c := RegisterClientFlags()
+	flag.StringVar(&c.ClientAuth, "tls-client-auth", clientAuth, "client certificates the listeners demand: require, optional (verified if sent) or none")
+	return c
+}
+
+// RegisterHTTPFlag adds -tls-http-client-auth for services whose HTTP listener is
+// called by clients while their gRPC listener only serves the other services.
+func (c *Config) RegisterHTTPFlag(clientAuth string) {
+	flag.StringVar(&c.HTTPClientAuth, "tls-http-client-auth", clientAuth, "client certificates the HTTP listener demands, -tls-client-auth still covers gRPC: require, optional (verified if sent) or none")
+}
+
+func (c Config) httpClientAuth() string {
+	if c.HTTPClientAuth == "" {
+		return c.ClientAuth
+	}
+	return c.HTTPClientAuth
+}
+
+func (c Config) enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != ""
+}
+
+// Certs holds a service's certificate and CA pool. A nil *Certs means TLS is off and
+// every method falls back to plaintext.
+type Certs struct {
+	config Config
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	mtimes  [3]time.Time
+	checked time.Time
+}
+
+// Load reads the files c points at, it returns nil when TLS isn't configured.
+func Load(c Config) (*Certs, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("-tls-cert and -tls-key go together")
+	}
+	for _, mode := range []string{c.ClientAuth, c.httpClientAuth()} {
+		switch mode {
+		case ClientAuthRequire, ClientAuthOptional, ClientAuthNone:
+		default:
+			return nil, fmt.Errorf("unknown client auth mode %q", mode)
+		}
+		if mode != ClientAuthNone && c.CAFile == "" {
+			return nil, errors.New("verifying client certificates needs -tls-ca")
+		}
+	}
+
+	certs := &Certs{config: c}
+	if err := certs.load(); err != nil {
+		return nil, err
+	}
+	certs.checked = time.Now()
+	return certs, nil
+}
+
+func (c *Certs) load() error {
+	mtimes, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if c.config.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if c.config.CAFile != "" {
+		pem, err := os.ReadFile(c.config.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", c.config.CAFile)
+		}
+	}
+
+	c.cert, c.pool, c.mtimes = cert, pool, mtimes
+	return nil
+}
+
+func (c *Certs) modTimes() ([3]time.Time, error) {
+	var mtimes [3]time.Time
+	for i, path := range []string{c.config.CAFile, c.config.CertFile, c.config.KeyFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return mtimes, err
+		}
+		mtimes[i] = info.ModTime()
+	}
+	return mtimes, nil
+}
+
+// current returns the certificate and CA pool, reloading them first if the files
+// changed. A failed reload keeps the old ones, the files may be halfway rewritten.
+func (c *Certs) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < reloadCheckInterval {
+		return c.cert, c.pool
+	}
+	c.checked = time.Now()
+
+	mtimes, err := c.modTimes()
+	if err != nil || mtimes == c.mtimes {
+		return c.cert, c.pool
+	}
+	if err := c.load(); err != nil {
+		log.Printf("Failed to reload TLS certificates, keeping the old ones. Why: %v", err)
+		return c.cert, c.pool
+	}
+	log.Printf("Reloaded TLS certificates")
+	return c.cert, c.pool
+}
+
+func (c *Certs) serverConfig(clientAuth string, nextProtos ...string) (*tls.Config, error) {
+	cert, pool := c.current()
+	if cert == nil {
+		return nil, errors.New("serving TLS needs -tls-cert and -tls-key")
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		ClientCAs:    pool,
+		NextProtos:   nextProtos,
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch clientAuth {
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+func (c *Certs) clientConfig(serverName string) *tls.Config {
+	cert, pool := c.current()
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+// Scheme is the URL scheme of the services' HTTP APIs.
+func (c *Certs) Scheme() string {
+	if c == nil {
+		return "http"
+	}
+	return "https"
+}
+
+// ListenAndServe serves srv over TLS, or plaintext when TLS is off.
+func (c *Certs) ListenAndServe(srv *http.Server) error {
+	if c == nil {
+		return srv.ListenAndServe()
+	}
+	clientAuth := c.config.httpClientAuth()
+	if _, err := c.serverConfig(clientAuth); err != nil {
+		return err
+	}
+
+	srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.serverConfig(clientAuth, "http/1.1")
+		},
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// Transport returns an http.RoundTripper for calling the other services.
+func (c *Certs) Transport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c == nil {
+		return t
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, c.clientConfig(host))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return t
+}
+
+// DialOption returns the transport credentials for gRPC clients.
+func (c *Certs) DialOption() grpc.DialOption {
+	if c == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+	return grpc.WithTransportCredentials(&reloadingCredentials{certs: c})
+}
+
+// ServerOption returns the transport credentials for gRPC servers.
+func (c *Certs) ServerOption() grpc.ServerOption {
+	if c == nil {
+		return grpc.EmptyServerOption{}
+	}
+	return grpc.Creds(&reloadingCredentials{certs: c})
+}
+
+// reloadingCredentials builds the TLS credentials afresh for every handshake, so
+// long-lived gRPC servers and clients pick up reloaded certificates.
+type reloadingCredentials struct {
+	certs      *Certs
+	serverName string
+}
+
+func (r *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(r.certs.clientConfig(r.serverName)).ClientHandshake(ctx, authority, conn)
+}
+
+func (r *reloadingCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg, err := r.certs.serverConfig(r.certs.config.ClientAuth)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return credentials.NewTLS(cfg).ServerHandshake(conn)
+}
+
+func (r *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2"}
+}
+
+func (r *reloadingCredentials) Clone() credentials.TransportCredentials {
+	clone := *r
+	return &clone
+}
+
+func (r *reloadingCredentials) OverrideServerName(name string) error {
+	r.serverName = name
+	return nil
+}
+
+// PeerCertificate returns the verified client certificate of a gRPC call.
+func PeerCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoClientCert
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, ErrNoClientCert
+	}
+	return info.State.VerifiedChains[0][0], nil
+}
+
+// HasName reports whether cert was issued to name, as its common name or a DNS or IP SAN.
+func HasName(cert *x509.Certificate, name string) bool {
+	if cert.Subject.CommonName == name {
+		return true
+	}
+	return cert.VerifyHostname(name) == nil
+}
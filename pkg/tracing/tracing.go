@@ -0,0 +1,114 @@
+// Package tracing sets up OpenTelemetry for the graphene services.
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Config picks where a service's spans go.
+type Config struct {
+	// none, stdout, file, otlp-grpc or otlp-http
+	Exporter string
+	// file path for the file exporter, collector address for the otlp ones
+	// (empty falls back to the OTEL_EXPORTER_OTLP_* environment variables)
+	Endpoint string
+	// share of traces started here that are kept, spans from a sampled caller always are
+	SampleRatio float64
+}
+
+// RegisterFlags adds the -trace-* flags to the default flag set.
+func RegisterFlags() *Config {
+	c := &Config{}
+	flag.StringVar(&c.Exporter, "trace-exporter", "none", "where spans go: none, stdout, file, otlp-grpc or otlp-http")
+	flag.StringVar(&c.Endpoint, "trace-endpoint", "", "file path for the file exporter, collector host:port for the otlp exporters")
+	flag.Float64Var(&c.SampleRatio, "trace-sample-ratio", 1, "share of new traces that are recorded")
+	return c
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// The returned func flushes spans still buffered, call it before exiting.
+func Setup(ctx context.Context, service string, c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closeOutput, err := newExporter(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closeOutput != nil {
+			closeOutput.Close()
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, c Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch c.Exporter {
+	case "", "none":
+		return nil, nil, nil
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exp, nil, err
+	case "file":
+		if c.Endpoint == "" {
+			return nil, nil, fmt.Errorf("the file exporter needs -trace-endpoint set to a path")
+		}
+		f, err := os.OpenFile(c.Endpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		// one JSON span per line
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exp, f, nil
+	case "otlp-grpc":
+		var opts []otlptracegrpc.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(c.Endpoint), otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(ctx, opts...)
+		return exp, nil, err
+	case "otlp-http":
+		var opts []otlptracehttp.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint), otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		return exp, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", c.Exporter)
+	}
+}
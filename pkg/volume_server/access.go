@@ -0,0 +1,78 @@
+package volume_server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+)
+
+// ClusterAccess guards the routes only the master and gateways call: needle deletes,
+// dropping volumes and the admin API. A caller gets through with a client certificate
+// issued to one of the trusted names, or any the CA signed when there are none, or with
+// a request signed with the write token secrets. With neither TLS nor tokens configured
+// everyone gets through.
+type ClusterAccess struct {
+	certs        *tlsconfig.Certs
+	tokens       *auth.TokenSecrets
+	trustedNames []string
+	// signatures seen within the clock skew window
+	replays *auth.ReplayCache
+}
+
+func NewClusterAccess(certs *tlsconfig.Certs, tokens *auth.TokenSecrets, trustedNames []string) *ClusterAccess {
+	return &ClusterAccess{
+		certs:        certs,
+		tokens:       tokens,
+		trustedNames: trustedNames,
+		replays:      auth.NewReplayCache(auth.MaxClockSkew),
+	}
+}
+
+// Require is middleware that lets only the master and gateways through.
+func (a *ClusterAccess) Require(c *gin.Context) {
+	if a.certs == nil && a.tokens == nil {
+		c.Next()
+		return
+	}
+	if a.trustedCert(c.Request) {
+		c.Next()
+		return
+	}
+
+	if a.tokens == nil || c.GetHeader("Authorization") == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "only the master and gateways may do this, with their client certificate or a signed request"})
+		return
+	}
+
+	now := time.Now()
+	signature, expires, err := a.tokens.VerifyRequest(c.Request, now)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if !a.replays.FirstUse(signature, expires, now) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "request was already used"})
+		return
+	}
+	c.Next()
+}
+
+func (a *ClusterAccess) trustedCert(r *http.Request) bool {
+	if a.certs == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	if len(a.trustedNames) == 0 {
+		return true
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, name := range a.trustedNames {
+		if tlsconfig.HasName(cert, name) {
+			return true
+		}
+	}
+	return false
+}
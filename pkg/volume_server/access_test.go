@@ -0,0 +1,106 @@
+package volume_server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+)
+
+func newClusterTokens(t *testing.T) *auth.TokenSecrets {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte("cluster-secret-0123456789\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.LoadTokenSecrets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func withClientCert(req *http.Request, name string) *http.Request {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestClusterAccess(t *testing.T) {
+	tokens := newClusterTokens(t)
+	certs := &tlsconfig.Certs{}
+	const target = "/v1/volume/delete/needle?volume=vol"
+
+	unsigned := func() *http.Request { return httptest.NewRequest(http.MethodDelete, target, nil) }
+	signed := func() *http.Request {
+		req := unsigned()
+		tokens.SignRequest(req, time.Now())
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		certs        *tlsconfig.Certs
+		tokens       *auth.TokenSecrets
+		trustedNames []string
+		request      func() *http.Request
+		wantStatus   int
+	}{
+		{name: "open cluster", request: unsigned, wantStatus: http.StatusNoContent},
+		{name: "unsigned", tokens: tokens, request: unsigned, wantStatus: http.StatusUnauthorized},
+		{name: "signed", tokens: tokens, request: signed, wantStatus: http.StatusNoContent},
+		{name: "signed without tokens configured", certs: certs, request: signed, wantStatus: http.StatusUnauthorized},
+		{name: "no client certificate", certs: certs, request: unsigned, wantStatus: http.StatusUnauthorized},
+		{name: "any certificate the CA signed", certs: certs, request: func() *http.Request {
+			return withClientCert(unsigned(), "client")
+		}, wantStatus: http.StatusNoContent},
+		{name: "master's certificate", certs: certs, trustedNames: []string{"master", "gateway"}, request: func() *http.Request {
+			return withClientCert(unsigned(), "master")
+		}, wantStatus: http.StatusNoContent},
+		{name: "client's certificate", certs: certs, trustedNames: []string{"master", "gateway"}, request: func() *http.Request {
+			return withClientCert(unsigned(), "client")
+		}, wantStatus: http.StatusUnauthorized},
+		{name: "client's certificate on a signed request", certs: certs, tokens: tokens, trustedNames: []string{"master"}, request: func() *http.Request {
+			return withClientCert(signed(), "client")
+		}, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access := NewClusterAccess(tt.certs, tt.tokens, tt.trustedNames)
+			engine := gin.New()
+			engine.DELETE("/v1/volume/delete/:uuid", access.Require, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, tt.request())
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClusterAccessRejectsReplays(t *testing.T) {
+	tokens := newClusterTokens(t)
+	access := NewClusterAccess(nil, tokens, nil)
+	engine := gin.New()
+	engine.POST("/v1/volume/admin/scrub", access.Require, func(c *gin.Context) { c.Status(http.StatusAccepted) })
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/volume/admin/scrub", nil)
+	tokens.SignRequest(req, time.Now())
+	for n, want := range []int{http.StatusAccepted, http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("use %d: status = %d, want %d", n+1, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package volume_server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+)
+
+type AdminHandler struct {
+	store    *Store
+	scrubber *Scrubber
+	certs    *tlsconfig.Certs
+}
+
+func NewAdminHandler(store *Store, s *Scrubber, certs *tlsconfig.Certs) *AdminHandler {
+	return &AdminHandler{
+		store:    store,
+		scrubber: s,
+		certs:    certs,
+	}
+}
+
+func (a *AdminHandler) ScrubStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, a.scrubber.Status())
+}
+
+func (a *AdminHandler) StartScrub(c *gin.Context) {
+	a.scrubber.Trigger()
+	c.JSON(http.StatusAccepted, gin.H{"status": "scrub queued"})
+}
+
+func (a *AdminHandler) CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, a.store.CacheStats())
+}
+
+func (a *AdminHandler) Vacuum(c *gin.Context) {
+	volumeId, err := uuid.Parse(c.Param("volume_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	before, after, err := a.store.Vacuum(volumeId)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"size_before": before.SizeBytes, "size_after": after.SizeBytes})
+	case errors.Is(err, ErrVolumeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+	case errors.Is(err, needle.ErrReadOnly):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "volume is read-only"})
+	case errors.Is(err, needle.ErrCorrupted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Failed to vacuum volume %s. Why: %v", volumeId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to vacuum volume"})
+	}
+}
+
+// PullVolume copies a volume from the VolumeService of the volume server given as
+// ?source=, or catches up an existing copy with what was written there since.
+// ?rate= caps the copy at that many bytes per second.
+func (a *AdminHandler) PullVolume(c *gin.Context) {
+	volumeId, err := uuid.Parse(c.Param("volume_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+	source := c.Query("source")
+	if source == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing source"})
+		return
+	}
+
+	var rate int64
+	if r := c.Query("rate"); r != "" {
+		rate, err = strconv.ParseInt(r, 10, 64)
+		if err != nil || rate < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate"})
+			return
+		}
+	}
+
+	res, err := pullVolume(c, a.store, a.certs, source, volumeId, rate)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, res)
+	case errors.Is(err, needle.ErrVolumeExists), errors.Is(err, needle.ErrOffsetMismatch), errors.Is(err, ErrImportRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Failed to copy volume %s from %s. Why: %v", volumeId, source, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	}
+}
+
+// CreateVolume opens an empty volume the master assigned to this volume server, such
+// as a TTL volume. Creating one that exists already is a no-op.
+func (a *AdminHandler) CreateVolume(c *gin.Context) {
+	volumeId, err := uuid.Parse(c.Param("volume_id"))
+	if err != nil || volumeId == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	if _, err := a.store.GetOrCreate(volumeId); err != nil {
+		log.Printf("Failed to create volume %s. Why: %v", volumeId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create volume"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveVolume deletes a volume that has moved to another volume server.
+func (a *AdminHandler) RemoveVolume(c *gin.Context) {
+	volumeId, err := uuid.Parse(c.Param("volume_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	err = a.store.Remove(volumeId)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrVolumeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+	default:
+		log.Printf("Failed to remove volume %s. Why: %v", volumeId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove volume"})
+	}
+}
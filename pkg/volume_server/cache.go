@@ -0,0 +1,181 @@
+package volume_server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type cacheKey struct {
+	volume uuid.UUID
+	needle uuid.UUID
+}
+
+type cacheItem struct {
+	key       cacheKey
+	data      []byte
+	expiresAt uint64 // unix seconds, 0 never
+}
+
+type CacheStats struct {
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	Evictions     uint64  `json:"evictions"`
+	Invalidations uint64  `json:"invalidations"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Items         int     `json:"items"`
+	Bytes         int64   `json:"bytes"`
+	Capacity      int64   `json:"capacity"`
+}
+
+// ReadCache is a size-bounded LRU of needle payloads so hot objects skip the
+// ReadAt and checksum on every request. A nil *ReadCache caches nothing.
+type ReadCache struct {
+	capacity int64
+	maxItem  int64
+	size     int64
+	ll       *list.List
+	items    map[cacheKey]*list.Element
+	stats    CacheStats
+	gen      uint64 // bumped by every invalidation, see Add
+	mu       sync.Mutex
+}
+
+func NewReadCache(capacity int64) *ReadCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &ReadCache{
+		capacity: capacity,
+		// a single huge blob shouldn't flush everything else out
+		maxItem: capacity / 8,
+		ll:      list.New(),
+		items:   make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *ReadCache) Get(volumeID, needleID uuid.UUID) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[cacheKey{volume: volumeID, needle: needleID}]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	item := el.Value.(*cacheItem)
+	if item.expiresAt != 0 && uint64(time.Now().Unix()) >= item.expiresAt {
+		c.remove(el)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return item.data, true
+}
+
+// Generation returns what Add needs to tell if an invalidation raced the read it caches.
+func (c *ReadCache) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// Add caches data read after Generation returned gen. It's dropped if anything was
+// invalidated since, as the read may have beaten a delete it would bring back.
+func (c *ReadCache) Add(gen uint64, volumeID, needleID uuid.UUID, data []byte, expiresAt uint64) {
+	if c == nil || int64(len(data)) > c.maxItem {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	key := cacheKey{volume: volumeID, needle: needleID}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheItem{key: key, data: data, expiresAt: expiresAt})
+	c.size += int64(len(data))
+
+	for c.size > c.capacity {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *ReadCache) Invalidate(volumeID, needleID uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.items[cacheKey{volume: volumeID, needle: needleID}]; ok {
+		c.remove(el)
+		c.stats.Invalidations++
+	}
+}
+
+// InvalidateVolume drops every cached needle of a volume, e.g. after it was dropped or vacuumed.
+func (c *ReadCache) InvalidateVolume(volumeID uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key, el := range c.items {
+		if key.volume == volumeID {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+func (c *ReadCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.stats
+	st.Items = len(c.items)
+	st.Bytes = c.size
+	st.Capacity = c.capacity
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	return st
+}
+
+// remove unlinks an element. Callers must hold c.mu.
+func (c *ReadCache) remove(el *list.Element) {
+	item := c.ll.Remove(el).(*cacheItem)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.data))
+}
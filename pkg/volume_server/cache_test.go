@@ -0,0 +1,36 @@
+package volume_server
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReadCacheAddAfterInvalidation(t *testing.T) {
+	volumeID, needleID, other := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name       string
+		invalidate func(c *ReadCache)
+		wantCached bool
+	}{
+		{name: "nothing invalidated", invalidate: func(*ReadCache) {}, wantCached: true},
+		{name: "needle deleted", invalidate: func(c *ReadCache) { c.Invalidate(volumeID, needleID) }},
+		{name: "other needle deleted", invalidate: func(c *ReadCache) { c.Invalidate(volumeID, other) }},
+		{name: "volume dropped", invalidate: func(c *ReadCache) { c.InvalidateVolume(volumeID) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewReadCache(1 << 20)
+
+			// a read that started before the invalidation must not be cached after it
+			gen := c.Generation()
+			tt.invalidate(c)
+			c.Add(gen, volumeID, needleID, []byte("data"), 0)
+
+			if _, ok := c.Get(volumeID, needleID); ok != tt.wantCached {
+				t.Fatalf("cached = %v, want %v", ok, tt.wantCached)
+			}
+		})
+	}
+}
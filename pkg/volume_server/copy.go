@@ -0,0 +1,330 @@
+package volume_server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Catch-up copies are buffered in memory, anything bigger is refused
+	maxCatchUpBytes = 256 << 20
+
+	copyChunkSize = 1 << 20
+
+	// a broken transfer is resumed this many times before the pull gives up
+	copyAttempts = 4
+)
+
+type CopyResult struct {
+	Volume    string `json:"volume"`
+	Source    string `json:"source"`
+	CatchUp   bool   `json:"catch_up"`
+	Resumed   bool   `json:"resumed"`
+	Attempts  int    `json:"attempts"`
+	DataBytes int64  `json:"data_bytes"`
+	IdxBytes  int64  `json:"idx_bytes"`
+}
+
+// CopyServer serves the VolumeService other volume servers copy volumes from.
+type CopyServer struct {
+	addr  string
+	store *Store
+	srv   *grpc.Server
+	pb.UnimplementedVolumeServiceServer
+}
+
+func NewCopyServer(addr string, s *Store, certs *tlsconfig.Certs) *CopyServer {
+	srv := grpc.NewServer(certs.ServerOption(), grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	c := &CopyServer{
+		addr:  addr,
+		store: s,
+		srv:   srv,
+	}
+
+	pb.RegisterVolumeServiceServer(srv, c)
+
+	return c
+}
+
+func (c *CopyServer) Run() error {
+	listener, err := net.Listen("tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	return c.srv.Serve(listener)
+}
+
+// Stop breaks off running exports, their receivers resume them later.
+func (c *CopyServer) Stop() {
+	c.srv.Stop()
+}
+
+// ExportVolume streams a consistent snapshot of a volume from the requested offsets.
+// Offsets past the end of the volume, e.g. after it was compacted, fail with OutOfRange.
+func (c *CopyServer) ExportVolume(req *pb.ExportVolumeRequest, stream pb.VolumeService_ExportVolumeServer) error {
+	volumeId, err := uuid.FromBytes(req.GetVolumeId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid volume id format")
+	}
+
+	v, err := c.store.Volume(volumeId)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "volume not found: %s", volumeId)
+	}
+
+	snap, err := v.Snapshot()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to snapshot volume: %v", err)
+	}
+	defer snap.Close()
+
+	idxOffset, dataOffset := req.GetIdxOffset(), req.GetDataOffset()
+	if idxOffset < 0 || dataOffset < 0 || idxOffset > snap.IdxSize || dataOffset > snap.DataSize || !needle.IdxOffsetAligned(idxOffset) {
+		return status.Errorf(codes.OutOfRange, "%v: volume has %d bytes of .idx and %d of .dat", needle.ErrOffsetMismatch, snap.IdxSize, snap.DataSize)
+	}
+
+	idxLen, dataLen := snap.IdxSize-idxOffset, snap.DataSize-dataOffset
+	if err := stream.Send(&pb.VolumeChunk{IdxSize: idxLen, DataSize: dataLen, RefsSize: int64(len(snap.Refs))}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, copyChunkSize)
+	if err := sendSection(io.NewSectionReader(snap.Idx, idxOffset, idxLen), buf, func(p []byte) error {
+		return stream.Send(&pb.VolumeChunk{Idx: p})
+	}); err != nil {
+		return err
+	}
+	if err := sendSection(io.NewSectionReader(snap.Data, dataOffset, dataLen), buf, func(p []byte) error {
+		return stream.Send(&pb.VolumeChunk{Data: p})
+	}); err != nil {
+		return err
+	}
+	return sendSection(bytes.NewReader(snap.Refs), buf, func(p []byte) error {
+		return stream.Send(&pb.VolumeChunk{Refs: p})
+	})
+}
+
+func sendSection(r io.Reader, buf []byte, send func([]byte) error) error {
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := send(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read volume: %v", err)
+		}
+	}
+}
+
+// pullVolume copies a volume from the VolumeService at source, reading at most
+// bytesPerSec (0 for unlimited). If a copy already exists here only what the source
+// wrote since is fetched and appended. A broken transfer is resumed from what
+// already arrived.
+func pullVolume(ctx context.Context, store *Store, certs *tlsconfig.Certs, source string, id uuid.UUID, bytesPerSec int64) (CopyResult, error) {
+	res := CopyResult{Volume: id.String(), Source: source}
+
+	conn, err := grpc.NewClient(source, certs.DialOption(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	client := pb.NewVolumeServiceClient(conn)
+
+	for {
+		res.Attempts++
+		err = copyVolume(ctx, client, store, id, bytesPerSec, &res)
+		if err == nil || res.Attempts == copyAttempts || !resumable(err) {
+			return res, err
+		}
+
+		log.Printf("Copy of volume %s from %s broke off, resuming. Why: %v", id, source, err)
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(time.Duration(res.Attempts) * time.Second):
+		}
+	}
+}
+
+func resumable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Aborted, codes.OutOfRange:
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func copyVolume(ctx context.Context, client pb.VolumeServiceClient, store *Store, id uuid.UUID, bytesPerSec int64, res *CopyResult) error {
+	local, err := store.Volume(id)
+	if err == nil {
+		res.CatchUp = true
+		return catchUp(ctx, client, store, local, id, bytesPerSec, res)
+	}
+	if !errors.Is(err, ErrVolumeNotFound) {
+		return err
+	}
+
+	im, err := store.OpenImport(id)
+	if err != nil {
+		return err
+	}
+
+	idxOffset, dataOffset := im.Offsets()
+	if idxOffset > 0 || dataOffset > 0 {
+		res.Resumed = true
+	}
+
+	idxLen, dataLen, err := receiveVolume(ctx, client, id, idxOffset, dataOffset, bytesPerSec, im.WriteIdx, im.WriteData, im.WriteRefs)
+	res.IdxBytes += idxLen
+	res.DataBytes += dataLen
+	if err != nil {
+		// the source changed under the staged copy, e.g. it was compacted, start over next time
+		discard := status.Code(err) == codes.OutOfRange
+		if closeErr := store.CloseImport(id, im, discard); closeErr != nil {
+			log.Printf("Failed to keep the partial copy of volume %s. Why: %v", id, closeErr)
+		}
+		return err
+	}
+
+	_, err = store.CommitImport(id, im)
+	return err
+}
+
+func catchUp(ctx context.Context, client pb.VolumeServiceClient, store *Store, local *needle.Volume, id uuid.UUID, bytesPerSec int64, res *CopyResult) error {
+	snap, err := local.Snapshot()
+	if err != nil {
+		return err
+	}
+	idxOffset, dataOffset := snap.IdxSize, snap.DataSize
+	snap.Close()
+
+	var idxBuf, dataBuf, refsBuf []byte
+	idxLen, dataLen, err := receiveVolume(ctx, client, id, idxOffset, dataOffset, bytesPerSec,
+		func(p []byte) error {
+			idxBuf = append(idxBuf, p...)
+			return nil
+		},
+		func(p []byte) error {
+			if len(idxBuf)+len(dataBuf)+len(p) > maxCatchUpBytes {
+				return fmt.Errorf("catch-up is bigger than %d bytes, drop the copy and start over", maxCatchUpBytes)
+			}
+			dataBuf = append(dataBuf, p...)
+			return nil
+		},
+		func(p []byte) error {
+			refsBuf = append(refsBuf, p...)
+			return nil
+		})
+	res.IdxBytes += idxLen
+	res.DataBytes += dataLen
+	if err != nil {
+		return err
+	}
+	return store.AppendCopied(local, dataOffset, dataBuf, idxOffset, idxBuf, refsBuf)
+}
+
+// receiveVolume streams a volume from the given offsets into writeIdx and writeData,
+// and its reference counts into writeRefs.
+func receiveVolume(ctx context.Context, client pb.VolumeServiceClient, id uuid.UUID, idxOffset, dataOffset, bytesPerSec int64,
+	writeIdx, writeData, writeRefs func([]byte) error) (int64, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.ExportVolume(ctx, &pb.ExportVolumeRequest{VolumeId: id[:], IdxOffset: idxOffset, DataOffset: dataOffset})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	header, err := stream.Recv()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	t := throttle{bytesPerSec: bytesPerSec, start: time.Now()}
+	var idxLen, dataLen, refsLen int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return idxLen, dataLen, err
+		}
+
+		if len(chunk.GetIdx()) > 0 {
+			if err := writeIdx(chunk.GetIdx()); err != nil {
+				return idxLen, dataLen, err
+			}
+			idxLen += int64(len(chunk.GetIdx()))
+		}
+		if len(chunk.GetData()) > 0 {
+			if err := writeData(chunk.GetData()); err != nil {
+				return idxLen, dataLen, err
+			}
+			dataLen += int64(len(chunk.GetData()))
+		}
+		if len(chunk.GetRefs()) > 0 {
+			if err := writeRefs(chunk.GetRefs()); err != nil {
+				return idxLen, dataLen, err
+			}
+			refsLen += int64(len(chunk.GetRefs()))
+		}
+
+		if err := t.wait(ctx, idxLen+dataLen); err != nil {
+			return idxLen, dataLen, err
+		}
+	}
+
+	if idxLen != header.GetIdxSize() || dataLen != header.GetDataSize() || refsLen != header.GetRefsSize() {
+		return idxLen, dataLen, fmt.Errorf("%w: got %d of %d .idx, %d of %d .dat and %d of %d reference count bytes", io.ErrUnexpectedEOF,
+			idxLen, header.GetIdxSize(), dataLen, header.GetDataSize(), refsLen, header.GetRefsSize())
+	}
+	return idxLen, dataLen, nil
+}
+
+// throttle paces a transfer to bytesPerSec, 0 doesn't throttle.
+type throttle struct {
+	bytesPerSec int64
+	start       time.Time
+}
+
+// wait sleeps until sent bytes are due.
+func (t throttle) wait(ctx context.Context, sent int64) error {
+	if t.bytesPerSec <= 0 {
+		return nil
+	}
+
+	due := time.Duration(float64(sent) / float64(t.bytesPerSec) * float64(time.Second))
+	wait := due - time.Since(t.start)
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package volume_server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir(), uuid.New(), needle.MemoryIndex, NewReadCache(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// serveCopies starts a VolumeService for s and returns its address.
+func serveCopies(t *testing.T, s *Store) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCopyServer(lis.Addr().String(), s, nil)
+	go c.srv.Serve(lis)
+	t.Cleanup(c.Stop)
+	return lis.Addr().String()
+}
+
+// stagePartial leaves the first half of the source's files staged, as a broken
+// transfer would.
+func stagePartial(t *testing.T, src, dst *Store, id uuid.UUID) {
+	t.Helper()
+	base := filepath.Join(src.Dir(), needle.VolumeFileName(id))
+	idx, err := os.ReadFile(base + needle.IdxFileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := os.ReadFile(base + needle.DataFileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := dst.OpenImport(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := im.WriteIdx(idx[:len(idx)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.WriteData(dat[:len(dat)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.CloseImport(id, im, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPullVolume(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		before      func(t *testing.T, src, dst *Store, id uuid.UUID)
+		wantCatchUp bool
+		wantResumed bool
+	}{
+		{name: "fresh copy", before: func(*testing.T, *Store, *Store, uuid.UUID) {}},
+		{name: "resumed copy", before: stagePartial, wantResumed: true},
+		{name: "catch-up", before: func(t *testing.T, src, dst *Store, id uuid.UUID) {
+			if _, err := pullVolume(ctx, dst, nil, serveCopies(t, src), id, 0); err != nil {
+				t.Fatal(err)
+			}
+		}, wantCatchUp: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := newTestStore(t), newTestStore(t)
+			id := uuid.New()
+			v, err := src.GetOrCreate(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[uuid.UUID]string)
+			for _, data := range []string{"first", "second", "third", "fourth"} {
+				needleID := uuid.New()
+				if err := v.Write(ctx, needleID, []byte(data), 0); err != nil {
+					t.Fatal(err)
+				}
+				want[needleID] = data
+			}
+			tt.before(t, src, dst, id)
+
+			// what the source changes after an earlier pull has to reach the copy too,
+			// including needles the copy already has cached
+			var deleted uuid.UUID
+			for needleID := range want {
+				deleted = needleID
+				break
+			}
+			if tt.wantCatchUp {
+				if _, err := dst.Read(ctx, id, deleted); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := src.Delete(ctx, id, deleted); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, deleted)
+			added := uuid.New()
+			if err := v.Write(ctx, added, []byte("added"), 0); err != nil {
+				t.Fatal(err)
+			}
+			want[added] = "added"
+
+			res, err := pullVolume(ctx, dst, nil, serveCopies(t, src), id, 0)
+			if err != nil {
+				t.Fatalf("pullVolume: %v", err)
+			}
+			if res.CatchUp != tt.wantCatchUp || res.Resumed != tt.wantResumed {
+				t.Fatalf("catch-up %v resumed %v, want %v and %v", res.CatchUp, res.Resumed, tt.wantCatchUp, tt.wantResumed)
+			}
+
+			for needleID, data := range want {
+				got, err := dst.Read(ctx, id, needleID)
+				if err != nil || string(got) != data {
+					t.Fatalf("Read(%s) = %q, %v, want %q", needleID, got, err, data)
+				}
+			}
+			if _, err := dst.Read(ctx, id, deleted); !errors.Is(err, needle.ErrNotFound) {
+				t.Fatalf("Read(deleted) = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package volume_server
+
+import "syscall"
+
+// diskUsage reports the size and free space of the filesystem holding dir.
+func diskUsage(dir string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
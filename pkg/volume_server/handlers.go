@@ -1,39 +1,160 @@
 package volume_server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
 )
 
+const (
+	// retries of a write with the same key get the needle of the first one
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+
+	// hex SHA-256 of a content-addressed upload, the master placed it by this
+	contentSHA256Header = "X-Graphene-Content-SHA256"
+)
+
+// idempotencyNamespace scopes the needle ids derived from idempotency keys.
+var idempotencyNamespace = uuid.MustParse("6f1d1c3e-54a8-4c52-9d43-0b8f2f4c7a15")
+
 type VolumeHandler struct {
-	storage StorageEngine
+	store *Store
+	// nil lets writes through without a token
+	tokens     *auth.TokenSecrets
+	usedTokens *auth.ReplayCache
 }
 
-func NewVolumeHandler(s StorageEngine) *VolumeHandler {
+func NewVolumeHandler(s *Store, tokens *auth.TokenSecrets) *VolumeHandler {
 	return &VolumeHandler{
-		storage: s,
+		store:      s,
+		tokens:     tokens,
+		usedTokens: auth.NewReplayCache(time.Minute),
 	}
 }
 
 func (v *VolumeHandler) Write(c *gin.Context) {
+	volumeId, err := parseVolumeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	var ttl uint64
+	if ttlStr := c.Query("ttl"); ttlStr != "" {
+		ttl, err = strconv.ParseUint(ttlStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ttl"})
+			return
+		}
+	}
+
+	// ?content_addressed=true names the needle after its SHA-256, so the same data is stored once
+	contentAddressed := c.Query("content_addressed") == "true"
+	key := c.GetHeader(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "idempotency key is too long"})
+		return
+	}
+	if contentAddressed && (ttl > 0 || key != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content-addressed writes can't have a ttl or an idempotency key"})
+		return
+	}
+
+	maxBytes, ok := v.authorizeWrite(c, volumeId, ttl)
+	if !ok {
+		return
+	}
+	if maxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	}
+
+	// volumes only come into being when the master creates them, see CreateVolume
+	var storage StorageEngine
+	storage, err = v.store.Volume(volumeId)
+	if err != nil {
+		if errors.Is(err, ErrVolumeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open volume"})
+		return
+	}
+
 	data, err := io.ReadAll(c.Request.Body)
+	if isTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is larger than the write token allows"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid req body"})
 		return
 	}
-	needleId := uuid.New()
-	err = v.storage.Write(needleId, data)
+	if contentAddressed {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(c.GetHeader(contentSHA256Header)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content-addressed writes need the hex SHA-256 of their data in the " + contentSHA256Header + " header"})
+			return
+		}
+	}
+
+	var expiresAt uint64
+	if ttl > 0 {
+		expiresAt = uint64(time.Now().Unix()) + ttl
+	}
+
+	var needleId uuid.UUID
+	var existing bool
+	switch {
+	case contentAddressed:
+		needleId, existing, err = storage.WriteShared(c, data)
+	case key != "":
+		needleId = uuid.NewSHA1(idempotencyNamespace, []byte(key))
+		existing, err = storage.WriteOnce(c, needleId, data, expiresAt)
+	default:
+		needleId = uuid.New()
+		err = storage.Write(c, needleId, data, expiresAt)
+	}
+	if errors.Is(err, needle.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "idempotency key was already used for different data"})
+		return
+	}
+	if errors.Is(err, needle.ErrReadOnly) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "volume is read-only"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write"})
 		return
 	}
+	if existing {
+		c.JSON(http.StatusOK, gin.H{"id": needleId.String(), "existing": true})
+		return
+	}
+	if volumeId == uuid.Nil {
+		volumeId = v.store.Primary()
+	}
+	volumeWrittenBytes.WithLabelValues(volumeId.String()).Add(float64(len(data)))
 	c.JSON(http.StatusCreated, gin.H{"id": needleId.String()})
 }
 
 func (v *VolumeHandler) Read(c *gin.Context) {
+	volumeId, err := parseVolumeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
 	uuidStr := c.Param("uuid")
 	uuid, err := uuid.Parse(uuidStr)
 	if err != nil {
@@ -41,10 +162,100 @@ func (v *VolumeHandler) Read(c *gin.Context) {
 		return
 	}
 
-	data, err := v.storage.Read(uuid)
+	data, err := v.store.Read(c, volumeId, uuid)
 	if err != nil {
+		if errors.Is(err, ErrVolumeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+			return
+		}
+		if errors.Is(err, needle.ErrNotFound) || errors.Is(err, needle.ErrExpired) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read"})
 		return
 	}
 	c.Data(http.StatusOK, "application/octet-stream", data)
 }
+
+// Stat answers a HEAD of a needle without reading its data.
+func (v *VolumeHandler) Stat(c *gin.Context) {
+	volumeId, err := parseVolumeQuery(c)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	needleId, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	err = v.store.Stat(volumeId, needleId)
+	switch {
+	case err == nil:
+		c.Status(http.StatusOK)
+	case errors.Is(err, ErrVolumeNotFound), errors.Is(err, needle.ErrNotFound), errors.Is(err, needle.ErrExpired):
+		c.Status(http.StatusNotFound)
+	default:
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+func (v *VolumeHandler) Delete(c *gin.Context) {
+	volumeId, err := parseVolumeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	needleId, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid needle id format"})
+		return
+	}
+
+	err = v.store.Delete(c, volumeId, needleId)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrVolumeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+	case errors.Is(err, needle.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+	case errors.Is(err, needle.ErrReadOnly):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "volume is read-only"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete"})
+	}
+}
+
+func (v *VolumeHandler) DropVolume(c *gin.Context) {
+	volumeId, err := uuid.Parse(c.Param("volume_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid volume id format"})
+		return
+	}
+
+	err = v.store.Drop(volumeId)
+	switch {
+	case err == nil:
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, ErrVolumeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "volume not found"})
+	case errors.Is(err, ErrVolumeNotExpired), errors.Is(err, ErrPrimaryVolume):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to drop volume"})
+	}
+}
+
+// parseVolumeQuery reads the optional ?volume= param. A missing param yields uuid.Nil,
+// which the store resolves to the primary volume.
+func parseVolumeQuery(c *gin.Context) (uuid.UUID, error) {
+	volumeStr := c.Query("volume")
+	if volumeStr == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(volumeStr)
+}
@@ -1,30 +1,70 @@
 package volume_server
 
 import (
+	"context"
 	"log"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
 	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type MasterClient struct {
-	masterAddr string
-	conn       *grpc.ClientConn
-	Client     pb.MasterServiceClient
+	masterAddr   string
+	conn         *grpc.ClientConn
+	Client       pb.MasterServiceClient
+	storageClass string // reported with every registration and heartbeat
 }
 
-func NewMasterClient(m string) (*MasterClient, error) {
-	conn, err := grpc.NewClient(m, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewMasterClient(m string, certs *tlsconfig.Certs, storageClass string) (*MasterClient, error) {
+	conn, err := grpc.NewClient(m, certs.DialOption(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
 	if err != nil {
 		log.Printf("Failed dialing master. Why: %v", err)
 	}
 
 	c := &MasterClient{
-		masterAddr: m,
-		conn:       conn,
-		Client:     pb.NewMasterServiceClient(conn),
+		masterAddr:   m,
+		conn:         conn,
+		Client:       pb.NewMasterServiceClient(conn),
+		storageClass: storageClass,
 	}
 
 	return c, nil
 }
+
+// RunHeartbeats reports the server's volumes to the master every interval until ctx is done.
+// Missed heartbeats are how the master notices the server is down.
+func (m *MasterClient) RunHeartbeats(ctx context.Context, interval time.Duration, serverID uuid.UUID, addr, grpcAddr string, s *Store) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		req := &pb.HeartbeatRequest{
+			ServerId:     serverID[:],
+			HttpAddress:  addr,
+			GrpcAddress:  grpcAddr,
+			Volumes:      volumeInfos(s),
+			StorageClass: m.storageClass,
+		}
+		if total, free, err := diskUsage(s.Dir()); err == nil {
+			req.DiskTotalBytes, req.DiskFreeBytes = total, free
+		}
+
+		hbCtx, cancel := context.WithTimeout(ctx, interval)
+		_, err := m.Client.Heartbeat(hbCtx, req)
+		cancel()
+		if err != nil {
+			log.Printf("Heartbeat to master failed. Why: %v", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package volume_server
+
+import (
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rxanders35/graphene/pkg/metrics"
+)
+
+var (
+	volumeReadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "volume_read_bytes_total",
+		Help:      "Needle bytes served, cache hits included, by volume.",
+	}, []string{"volume"})
+
+	volumeWrittenBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "volume_written_bytes_total",
+		Help:      "Needle bytes written, by volume.",
+	}, []string{"volume"})
+
+	volumeChecksumFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "volume_checksum_failures_total",
+		Help:      "Reads that found a corrupt needle, by volume.",
+	}, []string{"volume"})
+
+	volumeDataBytes = prometheus.NewDesc(metrics.Namespace+"_volume_data_bytes",
+		"Size of the volume's .dat file.", []string{"volume"}, nil)
+	volumeLiveBytes = prometheus.NewDesc(metrics.Namespace+"_volume_live_bytes",
+		"Bytes of the .dat file still referenced by the index.", []string{"volume"}, nil)
+	volumeIdxBytes = prometheus.NewDesc(metrics.Namespace+"_volume_idx_bytes",
+		"Size of the volume's .idx file.", []string{"volume"}, nil)
+	volumeIndexEntries = prometheus.NewDesc(metrics.Namespace+"_volume_index_entries",
+		"Needle ids held by the volume's index, tombstones included.", []string{"volume"}, nil)
+)
+
+// forgetVolume drops the series of a volume that left the store.
+func forgetVolume(id uuid.UUID) {
+	volumeReadBytes.DeleteLabelValues(id.String())
+	volumeWrittenBytes.DeleteLabelValues(id.String())
+	volumeChecksumFailures.DeleteLabelValues(id.String())
+}
+
+// storeCollector reports the size of every volume in a store when scraped.
+type storeCollector struct {
+	store *Store
+}
+
+func (s storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- volumeDataBytes
+	ch <- volumeLiveBytes
+	ch <- volumeIdxBytes
+	ch <- volumeIndexEntries
+}
+
+func (s storeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range s.store.Volumes() {
+		st, err := v.Stats()
+		if err != nil {
+			continue
+		}
+		volume := uuid.UUID(v.ID()).String()
+		ch <- prometheus.MustNewConstMetric(volumeDataBytes, prometheus.GaugeValue, float64(st.SizeBytes), volume)
+		ch <- prometheus.MustNewConstMetric(volumeLiveBytes, prometheus.GaugeValue, float64(st.LiveBytes), volume)
+		ch <- prometheus.MustNewConstMetric(volumeIdxBytes, prometheus.GaugeValue, float64(st.IdxBytes), volume)
+		ch <- prometheus.MustNewConstMetric(volumeIndexEntries, prometheus.GaugeValue, float64(st.IndexEntries), volume)
+	}
+}
@@ -0,0 +1,190 @@
+package needle
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Compact rewrites the volume with only its live needles, reclaiming the space held
+// by deleted, overwritten and expired ones. Writes and reads block while it runs.
+//
+// The compacted copy is written next to the volume as .cpd/.cpx files and commits by
+// renaming .cpd over .dat and then .cpx over .idx, see recoverCompaction.
+func (v *Volume) Compact() error {
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	base := strings.TrimSuffix(v.dataFile.Name(), DataFileExtension)
+	if err := writeCompacted(base, v.liveEntries(), v.readNeedle); err != nil {
+		return err
+	}
+
+	if err := v.closeFiles(); err != nil {
+		v.readOnly = true
+		return err
+	}
+	if err := commitCompaction(base); err != nil {
+		log.Printf("Volume %x failed to commit its compaction, it will be finished on restart. Why: %v", v.volumeID, err)
+		v.readOnly = true
+		return err
+	}
+	if err := v.open(base); err != nil {
+		v.readOnly = true
+		return err
+	}
+	return nil
+}
+
+// liveEntries returns the entries a compaction keeps. Callers must hold v.rw.
+func (v *Volume) liveEntries() []IndexEntry {
+	now := time.Now()
+	var entries []IndexEntry
+	v.idx.Range(func(id [16]byte, entry IndexEntry) bool {
+		if !entry.Deleted() && !entry.Expired(now) {
+			entry.ID = id
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	return entries
+}
+
+// writeCompacted writes the needles behind entries to fresh .cpd/.cpx files next to
+// base, removing them again if anything fails.
+func writeCompacted(base string, entries []IndexEntry, read func(id [16]byte, entry IndexEntry) ([]byte, error)) error {
+	if err := writeCompactedFiles(base, entries, read); err != nil {
+		os.Remove(base + CompactDataFileExtension)
+		os.Remove(base + CompactIdxFileExtension)
+		return err
+	}
+	return nil
+}
+
+func writeCompactedFiles(base string, entries []IndexEntry, read func(id [16]byte, entry IndexEntry) ([]byte, error)) error {
+	// keep needles in the order they were written
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	dataFile, err := os.OpenFile(base+CompactDataFileExtension, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	idxFile, err := os.OpenFile(base+CompactIdxFileExtension, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	dataW, idxW := bufio.NewWriter(dataFile), bufio.NewWriter(idxFile)
+	if _, err := dataW.Write(fileHeader(DataFileMagic)); err != nil {
+		return err
+	}
+	if _, err := idxW.Write(fileHeader(IdxFileMagic)); err != nil {
+		return err
+	}
+
+	idxBuf := make([]byte, IdxEntryTotalSize)
+	offset := uint64(FileHeaderSize)
+	for _, entry := range entries {
+		data, err := read(entry.ID, entry)
+		if err != nil {
+			return fmt.Errorf("needle %x: %w", entry.ID, err)
+		}
+
+		if _, err := dataW.Write(encodeNeedle(entry.ID, data, entry.ExpiresAt)); err != nil {
+			return err
+		}
+
+		entry.Offset = offset
+		encodeEntry(idxBuf, entry.ID, entry)
+		if _, err := idxW.Write(idxBuf); err != nil {
+			return err
+		}
+		offset += needleDiskSize(entry.Size)
+	}
+
+	if err := dataW.Flush(); err != nil {
+		return err
+	}
+	if err := idxW.Flush(); err != nil {
+		return err
+	}
+	if err := dataFile.Sync(); err != nil {
+		return err
+	}
+	return idxFile.Sync()
+}
+
+// closeFiles closes the index and both files. Callers must hold v.rw.
+func (v *Volume) closeFiles() error {
+	if err := v.idx.Close(); err != nil {
+		return err
+	}
+	if err := v.idxFile.Close(); err != nil {
+		return err
+	}
+	if err := v.refs.close(); err != nil {
+		return err
+	}
+	return v.dataFile.Close()
+}
+
+func commitCompaction(base string) error {
+	if err := os.Rename(base+CompactDataFileExtension, base+DataFileExtension); err != nil {
+		return err
+	}
+	return finishCompaction(base)
+}
+
+// finishCompaction swaps in the compacted .idx once the .dat has been replaced.
+// The index files built on top of the old .idx are reset first so they can't
+// be mistaken for the new one.
+func finishCompaction(base string) error {
+	idxPath := base + IdxFileExtension
+	if err := os.Remove(snapshotPath(idxPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(sortedIndexPath(idxPath)); err == nil {
+		// keep the file as the sorted backend's marker, empty so the new .idx is replayed
+		if err := writeEmptySortedIndex(sortedIndexPath(idxPath)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(base+CompactIdxFileExtension, idxPath)
+}
+
+// recoverCompaction cleans up after a compaction that was interrupted by a crash.
+// With both .cpd and .cpx around it never committed and is thrown away, with only
+// .cpx left the .dat was already replaced and the .idx swap is finished.
+func recoverCompaction(base string) error {
+	_, dataErr := os.Stat(base + CompactDataFileExtension)
+	_, idxErr := os.Stat(base + CompactIdxFileExtension)
+
+	switch {
+	case idxErr == nil && os.IsNotExist(dataErr):
+		log.Printf("Finishing interrupted compaction of %s", base)
+		return finishCompaction(base)
+	case dataErr == nil || idxErr == nil:
+		log.Printf("Discarding interrupted compaction of %s", base)
+		if err := os.Remove(base + CompactDataFileExtension); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(base + CompactIdxFileExtension); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package needle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrVolumeExists   = errors.New("volume already exists")
+	ErrOffsetMismatch = errors.New("copy doesn't continue where the volume ends")
+)
+
+// VolumeSnapshot is a consistent view of a volume's files at one point in time.
+// It reads through its own file handles, so it stays valid across a compaction.
+type VolumeSnapshot struct {
+	Data     *os.File
+	Idx      *os.File
+	DataSize int64
+	IdxSize  int64
+	// the reference counts, sent whole on every copy as they change in place
+	Refs []byte
+}
+
+func (v *Volume) Snapshot() (*VolumeSnapshot, error) {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	dataInfo, err := v.dataFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	idxInfo, err := v.idxFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.Open(v.dataFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.Open(v.idxFile.Name())
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	return &VolumeSnapshot{
+		Data:     data,
+		Idx:      idx,
+		DataSize: dataInfo.Size(),
+		IdxSize:  IdxRecordsEnd(idxInfo.Size()),
+		Refs:     v.refs.encode(),
+	}, nil
+}
+
+func (s *VolumeSnapshot) Close() error {
+	return errors.Join(s.Data.Close(), s.Idx.Close())
+}
+
+// VolumeImport stages a volume copied in from another volume server next to where
+// it will live. What was staged survives a broken transfer, so the next attempt
+// only asks the source for the rest.
+type VolumeImport struct {
+	base     string
+	data     *os.File
+	idx      *os.File
+	dataSize int64
+	idxSize  int64
+	refs     []byte
+}
+
+// OpenImport starts staging a copy of a volume, or picks up the one staged by an
+// earlier attempt.
+func OpenImport(path string, volumeID [16]byte) (*VolumeImport, error) {
+	base := filepath.Join(path, VolumeFileName(volumeID))
+	if _, err := os.Stat(base + IdxFileExtension); err == nil {
+		return nil, ErrVolumeExists
+	}
+
+	// staged as a compaction so a crash halfway through the commit is finished the same
+	// way, the .cpd goes first since a lone .cpx means the .dat is already in place
+	data, err := os.OpenFile(base+CompactDataFileExtension, os.O_CREATE|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.OpenFile(base+CompactIdxFileExtension, os.O_CREATE|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	im := &VolumeImport{base: base, data: data, idx: idx}
+	if err := im.resume(); err != nil {
+		im.closeFiles()
+		return nil, err
+	}
+	return im, nil
+}
+
+// resume drops a partial .idx record or header left by a broken transfer.
+func (im *VolumeImport) resume() error {
+	dataInfo, err := im.data.Stat()
+	if err != nil {
+		return err
+	}
+	idxInfo, err := im.idx.Stat()
+	if err != nil {
+		return err
+	}
+
+	im.dataSize = dataInfo.Size()
+	im.idxSize = IdxRecordsEnd(idxInfo.Size())
+	if im.idxSize != idxInfo.Size() {
+		return im.idx.Truncate(im.idxSize)
+	}
+	return nil
+}
+
+// Offsets returns how much of the source's .idx and .dat is staged already.
+func (im *VolumeImport) Offsets() (idxOffset, dataOffset int64) {
+	return im.idxSize, im.dataSize
+}
+
+func (im *VolumeImport) WriteIdx(p []byte) error {
+	n, err := im.idx.WriteAt(p, im.idxSize)
+	im.idxSize += int64(n)
+	return err
+}
+
+// WriteRefs receives the reference counts, which every attempt gets in full.
+func (im *VolumeImport) WriteRefs(p []byte) error {
+	im.refs = append(im.refs, p...)
+	return nil
+}
+
+func (im *VolumeImport) WriteData(p []byte) error {
+	n, err := im.data.WriteAt(p, im.dataSize)
+	im.dataSize += int64(n)
+	return err
+}
+
+// Close keeps what was staged for the next attempt.
+func (im *VolumeImport) Close() error {
+	return errors.Join(im.data.Sync(), im.idx.Sync(), im.closeFiles())
+}
+
+// Discard throws the staged copy away.
+func (im *VolumeImport) Discard() error {
+	im.closeFiles()
+	if err := os.Remove(im.base + CompactIdxFileExtension); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(im.base + CompactDataFileExtension); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (im *VolumeImport) closeFiles() error {
+	return errors.Join(im.data.Close(), im.idx.Close())
+}
+
+// Commit checks every needle the staged index points at and puts the files in place.
+// A staged copy that fails the check is discarded.
+func (im *VolumeImport) Commit() error {
+	if err := im.Close(); err != nil {
+		return err
+	}
+	if err := verifyStaged(im.base); err != nil {
+		im.Discard()
+		return err
+	}
+	if len(im.refs)%RefEntryTotalSize != 0 {
+		im.Discard()
+		return fmt.Errorf("%w: copied reference counts end with a partial record", ErrCorrupted)
+	}
+	// written before the .idx appears, a volume never opens without its counts
+	if err := writeRefFile(im.base+RefFileExtension, im.refs); err != nil {
+		return err
+	}
+	return commitCompaction(im.base)
+}
+
+func verifyStaged(base string) error {
+	idxFile, err := os.Open(base + CompactIdxFileExtension)
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	dataFile, err := os.Open(base + CompactDataFileExtension)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	entries := make(map[[16]byte]IndexEntry)
+	trailing, err := ScanIdx(idxFile, func(rec IdxRecord) error {
+		entries[rec.ID] = rec.Entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if trailing != 0 {
+		return fmt.Errorf("%w: copied .idx ends with a partial record", ErrCorrupted)
+	}
+
+	for id, entry := range entries {
+		if entry.Deleted() {
+			continue
+		}
+		if _, err := readNeedleAt(dataFile, id, entry); err != nil {
+			return fmt.Errorf("needle %x: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// AppendCopied applies the tail of another copy of this volume: data must continue the
+// .dat file exactly where it ends and idx holds the .idx records written after idxOffset.
+// refs replaces the reference counts, nil keeps them. Every needle is checked before
+// anything is written. It returns the needles whose entry was replaced or deleted, which
+// copies of them cached elsewhere no longer match.
+func (v *Volume) AppendCopied(dataOffset int64, data []byte, idxOffset int64, idx []byte, refs []byte) (replaced [][16]byte, err error) {
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	if v.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	dataInfo, err := v.dataFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	idxInfo, err := v.idxFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if dataInfo.Size() != dataOffset || idxInfo.Size() != idxOffset {
+		return nil, ErrOffsetMismatch
+	}
+	if len(idx)%IdxEntryTotalSize != 0 {
+		return nil, fmt.Errorf("%w: copied .idx tail ends with a partial record", ErrCorrupted)
+	}
+	if len(refs)%RefEntryTotalSize != 0 {
+		return nil, fmt.Errorf("%w: copied reference counts end with a partial record", ErrCorrupted)
+	}
+
+	combined := &tailReader{head: v.dataFile, tail: bytes.NewReader(data), tailOffset: dataOffset}
+	records := make([]IdxRecord, 0, len(idx)/IdxEntryTotalSize)
+	for pos := 0; pos < len(idx); pos += IdxEntryTotalSize {
+		id, entry, err := decodeEntry(idx[pos : pos+IdxEntryTotalSize])
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Deleted() {
+			if _, err := readNeedleAt(combined, id, entry); err != nil {
+				return nil, fmt.Errorf("needle %x: %w", id, err)
+			}
+		}
+		records = append(records, IdxRecord{ID: id, Entry: entry})
+	}
+
+	if _, err := v.dataFile.WriteAt(data, dataOffset); err != nil {
+		v.markReadOnly(err)
+		return nil, err
+	}
+	for _, rec := range records {
+		if _, ok := v.idx.Get(rec.ID); ok {
+			replaced = append(replaced, rec.ID)
+		}
+		if err := v.appendEntry(rec.ID, rec.Entry); err != nil {
+			return replaced, err
+		}
+		if !rec.Entry.Deleted() {
+			v.needles++
+			v.liveBytes += needleDiskSize(rec.Entry.Size)
+		}
+	}
+	if refs != nil {
+		if err := v.refs.replace(refs); err != nil {
+			v.markReadOnly(err)
+			return replaced, err
+		}
+	}
+	return replaced, nil
+}
+
+// tailReader reads a .dat file as if tail had already been appended at tailOffset.
+type tailReader struct {
+	head       io.ReaderAt
+	tail       io.ReaderAt
+	tailOffset int64
+}
+
+func (r *tailReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.tailOffset {
+		return r.tail.ReadAt(p, off-r.tailOffset)
+	}
+	if off+int64(len(p)) <= r.tailOffset {
+		return r.head.ReadAt(p, off)
+	}
+
+	split := r.tailOffset - off
+	n, err := r.head.ReadAt(p[:split], off)
+	if err != nil {
+		return n, err
+	}
+	m, err := r.tail.ReadAt(p[split:], 0)
+	return n + m, err
+}
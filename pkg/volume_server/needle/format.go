@@ -0,0 +1,174 @@
+package needle
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+var ErrLegacyFormat = errors.New("volume predates the file header, open it with the volume server to convert it")
+
+func fileHeader(magic uint32) []byte {
+	header := make([]byte, FileHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], magic)
+	binary.BigEndian.PutUint32(header[4:8], FormatVersion)
+	return header
+}
+
+// initFileHeader writes the header into a new, empty file and checks the one of an
+// existing file.
+func initFileHeader(f *os.File, magic uint32) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := f.WriteAt(fileHeader(magic), 0); err != nil {
+			return err
+		}
+		return f.Sync()
+	}
+	return checkFileHeader(f, info.Size(), magic)
+}
+
+func checkFileHeader(f io.ReaderAt, size int64, magic uint32) error {
+	header := make([]byte, FileHeaderSize)
+	if size < FileHeaderSize {
+		return fmt.Errorf("%w: %d bytes are too short for the file header", ErrCorrupted, size)
+	}
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if magic == DataFileMagic && binary.BigEndian.Uint16(header[0:2]) == NeedleMagicVal {
+		return ErrLegacyFormat
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != magic {
+		return fmt.Errorf("%w: bad file magic number", ErrCorrupted)
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != FormatVersion {
+		return fmt.Errorf("file format version %d isn't supported, expected %d", version, FormatVersion)
+	}
+	return nil
+}
+
+// IdxRecordsEnd returns where the last whole record of a .idx file of size bytes ends.
+func IdxRecordsEnd(size int64) int64 {
+	if size < FileHeaderSize {
+		return 0
+	}
+	return size - (size-FileHeaderSize)%IdxEntryTotalSize
+}
+
+// IdxOffsetAligned reports whether off is the start of a .idx file or of one of its records.
+func IdxOffsetAligned(off int64) bool {
+	return off == 0 || (off >= FileHeaderSize && (off-FileHeaderSize)%IdxEntryTotalSize == 0)
+}
+
+// legacyVolume reports whether the .dat file at base was written before the file
+// header existed, when it started straight with a needle.
+func legacyVolume(base string) (bool, error) {
+	f, err := os.Open(base + DataFileExtension)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		// too short to hold anything, the header check on open reports it
+		return false, nil
+	}
+	return binary.BigEndian.Uint16(magic) == NeedleMagicVal, nil
+}
+
+// migrateLegacy converts a volume written before the file header existed. Its needles
+// are rewritten as a compaction, so a crash halfway through is recovered like one.
+// Needles that don't hold up are dropped.
+func migrateLegacy(base string) error {
+	legacy, err := legacyVolume(base)
+	if err != nil || !legacy {
+		return err
+	}
+	log.Printf("Converting %s to file format version %d", base, FormatVersion)
+
+	entries, err := readLegacyIdx(base + IdxFileExtension)
+	if err != nil {
+		return fmt.Errorf("could not read legacy .idx file: %w", err)
+	}
+
+	dataFile, err := os.Open(base + DataFileExtension)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	kept := make([]IndexEntry, 0, len(entries))
+	for id, entry := range entries {
+		if entry.Deleted() {
+			continue
+		}
+		if _, err := readLegacyNeedleAt(dataFile, id, entry); err != nil {
+			log.Printf("Dropping needle %x of %s while converting it. Why: %v", id, base, err)
+			continue
+		}
+		entry.ID = id
+		kept = append(kept, entry)
+	}
+
+	if err := writeCompacted(base, kept, func(id [16]byte, entry IndexEntry) ([]byte, error) {
+		return readLegacyNeedleAt(dataFile, id, entry)
+	}); err != nil {
+		return err
+	}
+	return commitCompaction(base)
+}
+
+// readLegacyIdx replays a legacy .idx file, ID|OFFSET|SIZE records without expiry.
+func readLegacyIdx(path string) (map[[16]byte]IndexEntry, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries := make(map[[16]byte]IndexEntry)
+	for pos := 0; pos+LegacyIdxEntryTotalSize <= len(buf); pos += LegacyIdxEntryTotalSize {
+		rec := buf[pos : pos+LegacyIdxEntryTotalSize]
+		entries[[16]byte(rec[0:16])] = IndexEntry{
+			Offset: binary.BigEndian.Uint64(rec[16:24]),
+			Size:   binary.BigEndian.Uint32(rec[24:28]),
+		}
+	}
+	return entries, nil
+}
+
+// readLegacyNeedleAt reads a legacy MAGIC|ID|SIZE|DATA|CHECKSUM needle, whose
+// checksum only covers the data.
+func readLegacyNeedleAt(dataFile io.ReaderAt, id [16]byte, entry IndexEntry) ([]byte, error) {
+	needleBuf := make([]byte, LegacyNeedleFixedPortion+int(entry.Size))
+	if _, err := dataFile.ReadAt(needleBuf, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("couldnt read needle: %w", err)
+	}
+
+	if binary.BigEndian.Uint16(needleBuf[0:2]) != NeedleMagicVal {
+		return nil, fmt.Errorf("%w: even the magic number aint right", ErrCorrupted)
+	}
+	if [16]byte(needleBuf[2:18]) != id {
+		return nil, fmt.Errorf("%w: needle id doesn't match the index", ErrCorrupted)
+	}
+	if binary.BigEndian.Uint32(needleBuf[18:22]) != entry.Size {
+		return nil, fmt.Errorf("%w: needle size doesn't match the index", ErrCorrupted)
+	}
+
+	data := needleBuf[22 : 22+entry.Size]
+	if binary.BigEndian.Uint32(needleBuf[22+entry.Size:]) != crc32.ChecksumIEEE(data) {
+		return nil, fmt.Errorf("%w: checksums are totally different", ErrCorrupted)
+	}
+	return data, nil
+}
@@ -0,0 +1,123 @@
+package needle
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// writeLegacyVolume writes needles in the layout used before the file header.
+func writeLegacyVolume(t *testing.T, dir string, volumeID [16]byte, needles map[uuid.UUID][]byte, corrupt uuid.UUID) {
+	t.Helper()
+	base := filepath.Join(dir, VolumeFileName(volumeID))
+
+	var dat, idx []byte
+	for id, data := range needles {
+		rec := make([]byte, LegacyIdxEntryTotalSize)
+		copy(rec[0:16], id[:])
+		binary.BigEndian.PutUint64(rec[16:24], uint64(len(dat)))
+		binary.BigEndian.PutUint32(rec[24:28], uint32(len(data)))
+		idx = append(idx, rec...)
+
+		n := make([]byte, LegacyNeedleFixedPortion+len(data))
+		binary.BigEndian.PutUint16(n[0:2], NeedleMagicVal)
+		copy(n[2:18], id[:])
+		binary.BigEndian.PutUint32(n[18:22], uint32(len(data)))
+		copy(n[22:], data)
+		binary.BigEndian.PutUint32(n[22+len(data):], crc32.ChecksumIEEE(data))
+		if id == corrupt {
+			n[22] ^= 0xFF
+		}
+		dat = append(dat, n...)
+	}
+
+	if err := os.WriteFile(base+DataFileExtension, dat, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+IdxFileExtension, idx, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyVolumeIsConverted(t *testing.T) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		t.Run(string(kind), func(t *testing.T) {
+			dir := t.TempDir()
+			volumeID := uuid.New()
+			good, bad := uuid.New(), uuid.New()
+			writeLegacyVolume(t, dir, volumeID, map[uuid.UUID][]byte{
+				good: []byte("kept across the conversion"),
+				bad:  []byte("damaged before the conversion"),
+			}, bad)
+
+			v, err := NewVolume(dir, volumeID, kind)
+			if err != nil {
+				t.Fatalf("NewVolume: %v", err)
+			}
+			defer v.Close()
+
+			data, err := v.Read(context.Background(), good)
+			if err != nil || string(data) != "kept across the conversion" {
+				t.Fatalf("Read(good) = %q, %v", data, err)
+			}
+			if _, err := v.Read(context.Background(), bad); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Read(bad) = %v, want ErrNotFound", err)
+			}
+
+			report, err := CheckVolume(filepath.Join(dir, VolumeFileName(volumeID)))
+			if err != nil {
+				t.Fatalf("CheckVolume: %v", err)
+			}
+			if !report.Clean() {
+				t.Fatalf("converted volume has problems: %+v", report.Problems)
+			}
+		})
+	}
+}
+
+func TestCheckVolumeRejectsLegacy(t *testing.T) {
+	dir := t.TempDir()
+	volumeID := uuid.New()
+	writeLegacyVolume(t, dir, volumeID, map[uuid.UUID][]byte{uuid.New(): []byte("x")}, uuid.Nil)
+
+	if _, err := CheckVolume(filepath.Join(dir, VolumeFileName(volumeID))); !errors.Is(err, ErrLegacyFormat) {
+		t.Fatalf("CheckVolume = %v, want ErrLegacyFormat", err)
+	}
+}
+
+func TestChecksumCoversHeader(t *testing.T) {
+	id := uuid.New()
+	data := []byte("checksummed")
+
+	tests := []struct {
+		name    string
+		tamper  func(buf []byte)
+		wantErr bool
+	}{
+		{"intact", func([]byte) {}, false},
+		{"expiry", func(buf []byte) { buf[29] ^= 1 }, true},
+		{"data", func(buf []byte) { buf[30] ^= 1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := encodeNeedle(id, data, 1234)
+			tt.tamper(buf)
+
+			entry := IndexEntry{Size: uint32(len(data)), ExpiresAt: 1234}
+			_, err := readNeedleAt(bytes.NewReader(buf), id, entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readNeedleAt = %v, want error %v", err, tt.wantErr)
+			}
+			if _, err := checkNeedleData(bytes.NewReader(buf), 0, entry.Size); (err != nil) != tt.wantErr {
+				t.Fatalf("checkNeedleData = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package needle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type IndexKind string
+
+const (
+	// Every entry lives in a Go map, fastest lookups but RAM grows with the object count
+	MemoryIndex IndexKind = "memory"
+
+	// Entries live in a sorted, mmap'd .sdx file plus a small in-memory delta
+	SortedIndex IndexKind = "sorted"
+)
+
+func ParseIndexKind(s string) (IndexKind, error) {
+	switch k := IndexKind(s); k {
+	case MemoryIndex, SortedIndex:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown index kind %q, expected %q or %q", s, MemoryIndex, SortedIndex)
+}
+
+// DetectIndexKind returns the backend an existing volume was created with,
+// or fallback for a volume that isn't on disk yet.
+func DetectIndexKind(path string, volumeID [16]byte, fallback IndexKind) IndexKind {
+	fileName := VolumeFileName(volumeID)
+	if _, err := os.Stat(filepath.Join(path, fileName+SortedIdxFileExtension)); err == nil {
+		return SortedIndex
+	}
+	if info, err := os.Stat(filepath.Join(path, fileName+IdxFileExtension)); err == nil && info.Size() > 0 {
+		return MemoryIndex
+	}
+	return fallback
+}
+
+// Index maps needle ids to their location in the .dat file. The .idx file stays
+// the append-only source of truth, an Index is only a lookup structure on top of it.
+type Index interface {
+	Get(id [16]byte) (IndexEntry, bool)
+	Put(id [16]byte, entry IndexEntry) error
+	Len() int
+	// Range calls fn for every entry until fn returns false
+	Range(fn func(id [16]byte, entry IndexEntry) bool)
+	// RangeFrom calls fn for every entry with an id from start on, in id order,
+	// until fn returns false
+	RangeFrom(start [16]byte, fn func(id [16]byte, entry IndexEntry) bool)
+	// Checkpoint persists the index so the next open doesn't replay the whole .idx
+	Checkpoint() error
+	Close() error
+}
+
+// OpenIndex loads the index backend of the given kind on top of a volume's .idx file.
+func OpenIndex(kind IndexKind, idxFile *os.File) (Index, error) {
+	switch kind {
+	case MemoryIndex:
+		os.Remove(sortedIndexPath(idxFile.Name()))
+		return openMemIndex(idxFile)
+	case SortedIndex:
+		return openSortedIndex(idxFile)
+	}
+	return nil, fmt.Errorf("unknown index kind %q", kind)
+}
+
+// replayIndex decodes every .idx record from offset onwards, the file header is skipped.
+func replayIndex(file *os.File, offset int64, fn func(id [16]byte, entry IndexEntry) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	idxSize := info.Size()
+	offset = max(offset, FileHeaderSize)
+	if idxSize < FileHeaderSize || (idxSize-FileHeaderSize)%IdxEntryTotalSize != 0 {
+		return fmt.Errorf("index file corrupted: not evenly divisible by %d", IdxEntryTotalSize)
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	numEntries := int(idxSize-offset) / IdxEntryTotalSize
+	entryBuf := make([]byte, IdxEntryTotalSize)
+
+	for i := 0; i < numEntries; i++ {
+		_, err := io.ReadFull(file, entryBuf)
+		if err != nil {
+			return err
+		}
+		id, entry, err := decodeEntry(entryBuf)
+		if err != nil {
+			return err
+		}
+		if err := fn(id, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeEntry(buf []byte, id [16]byte, entry IndexEntry) {
+	copy(buf[0:16], id[:])
+	binary.BigEndian.PutUint64(buf[16:24], entry.Offset)
+	binary.BigEndian.PutUint32(buf[24:28], entry.Size)
+	binary.BigEndian.PutUint64(buf[28:36], entry.ExpiresAt)
+}
@@ -0,0 +1,231 @@
+package needle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// SNP: MAGIC|VERSION|IDX_OFFSET|COUNT|CHECKSUM followed by COUNT idx records, the
+// checksum covers the header fields before it and the records
+const (
+	snpMagic   uint32 = 0x47534e50 // "GSNP"
+	snpVersion uint32 = 2
+
+	snpHeaderSize     = 28
+	snpChecksumOffset = 24
+)
+
+var errBadSnapshot = errors.New("index snapshot is damaged")
+
+// memIndex keeps every entry in a map. It is checkpointed to a .snp file so a
+// restart loads the snapshot and only replays the .idx records written after it.
+type memIndex struct {
+	m        map[[16]byte]IndexEntry
+	snapPath string
+	covered  int64 // .idx offset the snapshot on disk reflects
+	pending  int   // .idx records applied since covered
+}
+
+func snapshotPath(idxPath string) string {
+	return strings.TrimSuffix(idxPath, IdxFileExtension) + IdxSnapshotFileExtension
+}
+
+func openMemIndex(idxFile *os.File) (*memIndex, error) {
+	i := &memIndex{
+		m:        make(map[[16]byte]IndexEntry),
+		snapPath: snapshotPath(idxFile.Name()),
+	}
+
+	info, err := idxFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := i.loadSnapshot(); err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Ignoring %s, replaying the whole .idx file. Why: %v", i.snapPath, err)
+		}
+		i.m = make(map[[16]byte]IndexEntry)
+		i.covered = FileHeaderSize
+	}
+	if info.Size() < i.covered {
+		// the .idx file was replaced underneath the snapshot
+		i.m = make(map[[16]byte]IndexEntry)
+		i.covered = FileHeaderSize
+	}
+
+	if err := replayIndex(idxFile, i.covered, i.Put); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *memIndex) Get(id [16]byte) (IndexEntry, bool) {
+	entry, ok := i.m[id]
+	return entry, ok
+}
+
+func (i *memIndex) Put(id [16]byte, entry IndexEntry) error {
+	i.m[id] = entry
+	i.pending++
+	return nil
+}
+
+func (i *memIndex) Len() int {
+	return len(i.m)
+}
+
+func (i *memIndex) Range(fn func(id [16]byte, entry IndexEntry) bool) {
+	for id, entry := range i.m {
+		if !fn(id, entry) {
+			return
+		}
+	}
+}
+
+// RangeFrom sorts the ids from start on first, it costs a pass over the whole map.
+func (i *memIndex) RangeFrom(start [16]byte, fn func(id [16]byte, entry IndexEntry) bool) {
+	var ids [][16]byte
+	for id := range i.m {
+		if bytes.Compare(id[:], start[:]) >= 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b [16]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	for _, id := range ids {
+		if !fn(id, i.m[id]) {
+			return
+		}
+	}
+}
+
+func (i *memIndex) Checkpoint() error {
+	if i.pending == 0 {
+		return nil
+	}
+
+	covered := i.covered + int64(i.pending)*IdxEntryTotalSize
+
+	tmpPath := i.snapPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	header := make([]byte, snpHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], snpMagic)
+	binary.BigEndian.PutUint32(header[4:8], snpVersion)
+	binary.BigEndian.PutUint64(header[8:16], uint64(covered))
+	binary.BigEndian.PutUint64(header[16:24], uint64(len(i.m)))
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+
+	// checksum the records while streaming them, then fill in the checksum
+	crc := crc32.NewIEEE()
+	crc.Write(header[:snpChecksumOffset])
+	w := bufio.NewWriter(io.MultiWriter(f, crc))
+	recBuf := make([]byte, IdxEntryTotalSize)
+	for id, entry := range i.m {
+		encodeEntry(recBuf, id, entry)
+		if _, err := w.Write(recBuf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	binary.BigEndian.PutUint32(header[snpChecksumOffset:], crc.Sum32())
+	if _, err := f.WriteAt(header[snpChecksumOffset:], snpChecksumOffset); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, i.snapPath); err != nil {
+		return err
+	}
+
+	i.covered = covered
+	i.pending = 0
+	return nil
+}
+
+func (i *memIndex) Close() error {
+	return i.Checkpoint()
+}
+
+func (i *memIndex) loadSnapshot() error {
+	f, err := os.Open(i.snapPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, snpHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fmt.Errorf("%w: short header", errBadSnapshot)
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != snpMagic || binary.BigEndian.Uint32(header[4:8]) != snpVersion {
+		return fmt.Errorf("%w: bad magic or version", errBadSnapshot)
+	}
+	covered := int64(binary.BigEndian.Uint64(header[8:16]))
+	count := binary.BigEndian.Uint64(header[16:24])
+	checksum := binary.BigEndian.Uint32(header[snpChecksumOffset:])
+	if !IdxOffsetAligned(covered) || covered < FileHeaderSize {
+		return fmt.Errorf("%w: covered offset %d isn't a record boundary", errBadSnapshot, covered)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) != snpHeaderSize+count*IdxEntryTotalSize {
+		return fmt.Errorf("%w: size doesn't match record count", errBadSnapshot)
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[:snpChecksumOffset])
+	r := bufio.NewReader(io.TeeReader(f, crc))
+	recBuf := make([]byte, IdxEntryTotalSize)
+	m := make(map[[16]byte]IndexEntry, count)
+	for n := uint64(0); n < count; n++ {
+		if _, err := io.ReadFull(r, recBuf); err != nil {
+			return err
+		}
+		id, entry, err := decodeEntry(recBuf)
+		if err != nil {
+			return err
+		}
+		m[id] = entry
+	}
+	if crc.Sum32() != checksum {
+		return fmt.Errorf("%w: checksum mismatch", errBadSnapshot)
+	}
+
+	i.m = m
+	i.covered = covered
+	return nil
+}
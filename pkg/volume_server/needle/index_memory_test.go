@@ -0,0 +1,209 @@
+package needle
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendRecords appends .idx records to f, writing the file header first if f is empty.
+func appendRecords(t *testing.T, f *os.File, records []IdxRecord) {
+	t.Helper()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 0, FileHeaderSize+len(records)*IdxEntryTotalSize)
+	if info.Size() == 0 {
+		buf = append(buf, fileHeader(IdxFileMagic)...)
+	}
+	rec := make([]byte, IdxEntryTotalSize)
+	for _, r := range records {
+		encodeEntry(rec, r.ID, r.Entry)
+		buf = append(buf, rec...)
+	}
+	if _, err := f.WriteAt(buf, info.Size()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newIdxFile(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(t.TempDir(), "volume"+IdxFileExtension), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestReplayIndex(t *testing.T) {
+	a, b := [16]byte{0xa}, [16]byte{0xb}
+	records := []IdxRecord{
+		{ID: a, Entry: IndexEntry{Offset: 8, Size: 10, ExpiresAt: 99}},
+		{ID: b, Entry: IndexEntry{Offset: 48, Size: 20}},
+		{ID: a, Entry: IndexEntry{Size: TombstoneSize}},
+	}
+
+	tests := []struct {
+		name     string
+		offset   int64
+		trailing int // bytes of a partial record after the whole ones
+		want     []IdxRecord
+		wantErr  bool
+	}{
+		{name: "from the start", offset: 0, want: records},
+		{name: "from the header", offset: FileHeaderSize, want: records},
+		{name: "from a record", offset: FileHeaderSize + IdxEntryTotalSize, want: records[1:]},
+		{name: "from the end", offset: FileHeaderSize + 3*IdxEntryTotalSize, want: nil},
+		{name: "partial record", offset: 0, trailing: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIdxFile(t)
+			appendRecords(t, f, records)
+			if tt.trailing > 0 {
+				info, _ := f.Stat()
+				if _, err := f.WriteAt(make([]byte, tt.trailing), info.Size()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []IdxRecord
+			err := replayIndex(f, tt.offset, func(id [16]byte, entry IndexEntry) error {
+				got = append(got, IdxRecord{ID: id, Entry: entry})
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replayIndex = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %d records, want %d", len(got), len(tt.want))
+			}
+			for n := range got {
+				if got[n].ID != tt.want[n].ID || got[n].Entry != tt.want[n].Entry {
+					t.Fatalf("record %d = %+v, want %+v", n, got[n], tt.want[n])
+				}
+			}
+		})
+	}
+}
+
+func TestMemIndexSnapshot(t *testing.T) {
+	a, b, c := [16]byte{0xa}, [16]byte{0xb}, [16]byte{0xc}
+	before := []IdxRecord{
+		{ID: a, Entry: IndexEntry{Offset: 8, Size: 10}},
+		{ID: b, Entry: IndexEntry{Offset: 48, Size: 20, ExpiresAt: 1234}},
+	}
+	after := []IdxRecord{
+		{ID: c, Entry: IndexEntry{Offset: 98, Size: 5}},
+		{ID: a, Entry: IndexEntry{Size: TombstoneSize}},
+	}
+	want := map[[16]byte]IndexEntry{
+		a: {Size: TombstoneSize},
+		b: {Offset: 48, Size: 20, ExpiresAt: 1234},
+		c: {Offset: 98, Size: 5},
+	}
+
+	// rechecksum recomputes the checksum so only the check under test can catch the damage
+	rechecksum := func(buf []byte) {
+		crc := crc32.NewIEEE()
+		crc.Write(buf[:snpChecksumOffset])
+		crc.Write(buf[snpHeaderSize:])
+		binary.BigEndian.PutUint32(buf[snpChecksumOffset:], crc.Sum32())
+	}
+
+	tests := []struct {
+		name      string
+		damage    func(buf []byte) []byte
+		wantValid bool
+	}{
+		{name: "intact", damage: func(buf []byte) []byte { return buf }, wantValid: true},
+		{name: "covered offset changed", damage: func(buf []byte) []byte {
+			// still a record boundary, only the checksum catches it
+			binary.BigEndian.PutUint64(buf[8:16], FileHeaderSize)
+			return buf
+		}},
+		{name: "count flipped", damage: func(buf []byte) []byte {
+			buf[23] ^= 1
+			return buf
+		}},
+		{name: "record flipped", damage: func(buf []byte) []byte {
+			buf[snpHeaderSize+20] ^= 1
+			return buf
+		}},
+		{name: "truncated", damage: func(buf []byte) []byte { return buf[:len(buf)-1] }},
+		{name: "unaligned covered offset", damage: func(buf []byte) []byte {
+			binary.BigEndian.PutUint64(buf[8:16], FileHeaderSize+IdxEntryTotalSize+4)
+			rechecksum(buf)
+			return buf
+		}},
+		{name: "covered offset inside the file header", damage: func(buf []byte) []byte {
+			binary.BigEndian.PutUint64(buf[8:16], 4)
+			rechecksum(buf)
+			return buf
+		}},
+		{name: "old version", damage: func(buf []byte) []byte {
+			binary.BigEndian.PutUint32(buf[4:8], 1)
+			rechecksum(buf)
+			return buf
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIdxFile(t)
+			appendRecords(t, f, before)
+
+			idx, err := openMemIndex(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := idx.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+			appendRecords(t, f, after)
+
+			snap, err := os.ReadFile(idx.snapPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(idx.snapPath, tt.damage(snap), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := &memIndex{snapPath: idx.snapPath}
+			err = loaded.loadSnapshot()
+			if tt.wantValid {
+				if err != nil {
+					t.Fatalf("loadSnapshot = %v", err)
+				}
+				if want := int64(FileHeaderSize + len(before)*IdxEntryTotalSize); loaded.covered != want {
+					t.Fatalf("snapshot covers %d bytes, want %d", loaded.covered, want)
+				}
+			} else if !errors.Is(err, errBadSnapshot) {
+				t.Fatalf("loadSnapshot = %v, want errBadSnapshot", err)
+			}
+
+			// a damaged snapshot is ignored and the whole .idx replayed instead
+			reopened, err := openMemIndex(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.Len() != len(want) {
+				t.Fatalf("Len() = %d, want %d", reopened.Len(), len(want))
+			}
+			for id, entry := range want {
+				if got, ok := reopened.Get(id); !ok || got != entry {
+					t.Fatalf("Get(%x) = %+v, %v, want %+v", id, got, ok, entry)
+				}
+			}
+		})
+	}
+}
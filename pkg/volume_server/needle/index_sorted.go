@@ -0,0 +1,436 @@
+package needle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SDX: MAGIC|VERSION|IDX_OFFSET|COUNT followed by COUNT idx records sorted by needle id
+const (
+	sdxMagic   uint32 = 0x47534458 // "GSDX"
+	sdxVersion uint32 = 1
+
+	sdxHeaderSize = 24
+
+	// Writes buffered in memory before they are merged into the .sdx file, or the
+	// .sdx file's count over sortedIndexDeltaRatio if that is more. A delta growing
+	// with the file keeps the total rewritten by merges linear in the entries and
+	// each merge done before the next delta fills up.
+	sortedIndexDeltaLimit = 1 << 18
+	sortedIndexDeltaRatio = 8
+)
+
+var errBadSortedIndex = errors.New("sorted index file is damaged")
+
+// sortedIndex keeps the bulk of a volume's entries in a sorted file that is
+// mmap'd and binary searched, so resident memory only holds the recent delta.
+type sortedIndex struct {
+	path    string
+	idxFile *os.File
+
+	data    []byte // mmap'd records, header excluded
+	mapped  []byte // the whole mapping, header included
+	count   int
+	covered int64 // .idx offset the .sdx file reflects
+
+	delta   map[[16]byte]IndexEntry
+	pending int // .idx records folded into delta since frozen's
+	added   int // delta keys that aren't in data or frozen
+
+	// frozen is the delta being merged in the background, it is read-only until
+	// the merge result on merged is installed
+	frozen        map[[16]byte]IndexEntry
+	frozenPending int
+	frozenAdded   int
+	merged        chan error
+}
+
+func sortedIndexPath(idxPath string) string {
+	return strings.TrimSuffix(idxPath, IdxFileExtension) + SortedIdxFileExtension
+}
+
+func openSortedIndex(idxFile *os.File) (*sortedIndex, error) {
+	i := &sortedIndex{
+		path:    sortedIndexPath(idxFile.Name()),
+		idxFile: idxFile,
+		delta:   make(map[[16]byte]IndexEntry),
+	}
+
+	if err := i.mapFile(); err != nil {
+		if !errors.Is(err, errBadSortedIndex) && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("Rebuilding %s from the .idx file. Why: %v", i.path, err)
+		}
+		if err := i.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := idxFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < i.covered {
+		// the .idx file was replaced underneath us, start over
+		if err := i.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := replayIndex(idxFile, i.covered, i.Put); err != nil {
+		return nil, err
+	}
+
+	// the .sdx file doubles as the marker that this volume uses the sorted backend
+	if _, err := os.Stat(i.path); os.IsNotExist(err) {
+		if err := i.wait(); err != nil {
+			return nil, err
+		}
+		i.freeze()
+		if err := i.install(i.writeFile()); err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+func (i *sortedIndex) Get(id [16]byte) (IndexEntry, bool) {
+	if entry, ok := i.deltaGet(id); ok {
+		return entry, true
+	}
+	return i.search(id)
+}
+
+// Put freezes a full delta and merges it into the .sdx file in the background
+// while a new delta takes writes.
+func (i *sortedIndex) Put(id [16]byte, entry IndexEntry) error {
+	i.putDelta(id, entry)
+	if err := i.collect(); err != nil {
+		return err
+	}
+	if len(i.delta) < max(sortedIndexDeltaLimit, i.count/sortedIndexDeltaRatio) {
+		return nil
+	}
+
+	// writes that outpace the merges wait here rather than grow the delta without bound
+	if err := i.wait(); err != nil {
+		return err
+	}
+	i.freeze()
+	merged := make(chan error, 1)
+	go func() { merged <- i.writeFile() }()
+	i.merged = merged
+	return nil
+}
+
+func (i *sortedIndex) Len() int {
+	return i.count + i.frozenAdded + i.added
+}
+
+func (i *sortedIndex) Range(fn func(id [16]byte, entry IndexEntry) bool) {
+	i.RangeFrom([16]byte{}, fn)
+}
+
+func (i *sortedIndex) RangeFrom(start [16]byte, fn func(id [16]byte, entry IndexEntry) bool) {
+	keys := sortedKeys(start, i.delta, i.frozen)
+	i.rangeMerged(start, keys, func(id [16]byte) IndexEntry {
+		entry, _ := i.deltaGet(id)
+		return entry
+	}, fn)
+}
+
+// rangeMerged walks the .sdx records from start with the sorted delta keys laid
+// over them, lookup gives a key's entry.
+func (i *sortedIndex) rangeMerged(start [16]byte, keys [][16]byte, lookup func(id [16]byte) IndexEntry, fn func(id [16]byte, entry IndexEntry) bool) {
+	d := 0
+	for r := i.find(start); r < i.count; r++ {
+		id, entry := i.record(r)
+		for d < len(keys) && bytes.Compare(keys[d][:], id[:]) < 0 {
+			if !fn(keys[d], lookup(keys[d])) {
+				return
+			}
+			d++
+		}
+		if d < len(keys) && keys[d] == id {
+			entry = lookup(id)
+			d++
+		}
+		if !fn(id, entry) {
+			return
+		}
+	}
+	for ; d < len(keys); d++ {
+		if !fn(keys[d], lookup(keys[d])) {
+			return
+		}
+	}
+}
+
+func (i *sortedIndex) Checkpoint() error {
+	return i.merge()
+}
+
+func (i *sortedIndex) Close() error {
+	if err := i.merge(); err != nil {
+		return err
+	}
+	if err := munmapFile(i.mapped); err != nil {
+		return err
+	}
+	i.mapped, i.data, i.count = nil, nil, 0
+	return nil
+}
+
+func (i *sortedIndex) putDelta(id [16]byte, entry IndexEntry) {
+	if _, ok := i.deltaGet(id); !ok {
+		if _, ok := i.search(id); !ok {
+			i.added++
+		}
+	}
+	i.delta[id] = entry
+	i.pending++
+}
+
+func (i *sortedIndex) deltaGet(id [16]byte) (IndexEntry, bool) {
+	if entry, ok := i.delta[id]; ok {
+		return entry, true
+	}
+	entry, ok := i.frozen[id]
+	return entry, ok
+}
+
+func (i *sortedIndex) record(r int) ([16]byte, IndexEntry) {
+	id, entry, _ := decodeEntry(i.data[r*IdxEntryTotalSize : (r+1)*IdxEntryTotalSize])
+	return id, entry
+}
+
+// find returns the first record with an id from id on.
+func (i *sortedIndex) find(id [16]byte) int {
+	return sort.Search(i.count, func(r int) bool {
+		off := r * IdxEntryTotalSize
+		return bytes.Compare(i.data[off:off+IdxObjectID], id[:]) >= 0
+	})
+}
+
+func (i *sortedIndex) search(id [16]byte) (IndexEntry, bool) {
+	r := i.find(id)
+	if r == i.count {
+		return IndexEntry{}, false
+	}
+	found, entry := i.record(r)
+	return entry, found == id
+}
+
+// sortedKeys returns the ids in the deltas from start on, each once.
+func sortedKeys(start [16]byte, deltas ...map[[16]byte]IndexEntry) [][16]byte {
+	var keys [][16]byte
+	for _, delta := range deltas {
+		for id := range delta {
+			if bytes.Compare(id[:], start[:]) >= 0 {
+				keys = append(keys, id)
+			}
+		}
+	}
+	slices.SortFunc(keys, func(a, b [16]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return slices.Compact(keys)
+}
+
+// merge folds the delta into a fresh .sdx file and swaps it in, after the
+// background merge in flight if there is one.
+func (i *sortedIndex) merge() error {
+	if err := i.wait(); err != nil {
+		return err
+	}
+	if i.pending == 0 {
+		return nil
+	}
+	i.freeze()
+	return i.install(i.writeFile())
+}
+
+// freeze hands the delta over to a merge and starts a new one.
+func (i *sortedIndex) freeze() {
+	i.frozen, i.frozenPending, i.frozenAdded = i.delta, i.pending, i.added
+	i.delta = make(map[[16]byte]IndexEntry)
+	i.pending, i.added = 0, 0
+}
+
+// collect installs the background merge if it has finished.
+func (i *sortedIndex) collect() error {
+	select {
+	case err := <-i.merged:
+		i.merged = nil
+		return i.install(err)
+	default:
+		return nil
+	}
+}
+
+// wait installs the background merge once it finishes.
+func (i *sortedIndex) wait() error {
+	if i.merged == nil {
+		return nil
+	}
+	err := <-i.merged
+	i.merged = nil
+	return i.install(err)
+}
+
+// install maps the .sdx file a merge wrote and drops the frozen delta. The old
+// mapping and the frozen delta stay in use until then, so a failed merge folds
+// the frozen delta back into the live one and the next merge tries again.
+func (i *sortedIndex) install(mergeErr error) error {
+	old := i.mapped
+	if mergeErr == nil {
+		mergeErr = i.mapFile()
+	}
+	if mergeErr != nil {
+		for id, entry := range i.frozen {
+			if _, ok := i.delta[id]; !ok {
+				i.delta[id] = entry
+			}
+		}
+		i.pending += i.frozenPending
+		i.added += i.frozenAdded
+		i.frozen, i.frozenPending, i.frozenAdded = nil, 0, 0
+		return mergeErr
+	}
+
+	i.frozen, i.frozenPending, i.frozenAdded = nil, 0, 0
+	return munmapFile(old)
+}
+
+// writeFile writes the .sdx records and the frozen delta to a fresh .sdx file.
+// It runs alongside Get and Put, so it only reads what they leave alone until
+// install.
+func (i *sortedIndex) writeFile() error {
+	tmpPath := i.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	count := i.count + i.frozenAdded
+	covered := i.covered + int64(i.frozenPending)*IdxEntryTotalSize
+
+	w := bufio.NewWriter(f)
+	header := make([]byte, sdxHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], sdxMagic)
+	binary.BigEndian.PutUint32(header[4:8], sdxVersion)
+	binary.BigEndian.PutUint64(header[8:16], uint64(covered))
+	binary.BigEndian.PutUint64(header[16:24], uint64(count))
+	if _, err := w.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+
+	recBuf := make([]byte, IdxEntryTotalSize)
+	var writeErr error
+	keys := sortedKeys([16]byte{}, i.frozen)
+	i.rangeMerged([16]byte{}, keys, func(id [16]byte) IndexEntry { return i.frozen[id] }, func(id [16]byte, entry IndexEntry) bool {
+		encodeEntry(recBuf, id, entry)
+		_, writeErr = w.Write(recBuf)
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		f.Close()
+		return writeErr
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, i.path)
+}
+
+// writeEmptySortedIndex writes a .sdx file covering none of the .idx, so the next
+// open replays all of it.
+func writeEmptySortedIndex(path string) error {
+	header := make([]byte, sdxHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], sdxMagic)
+	binary.BigEndian.PutUint32(header[4:8], sdxVersion)
+	return os.WriteFile(path, header, os.FileMode(rwrwrw))
+}
+
+// rebuild drops the .sdx file so the whole .idx is replayed into the delta.
+func (i *sortedIndex) rebuild() error {
+	if err := munmapFile(i.mapped); err != nil {
+		return err
+	}
+	i.mapped, i.data = nil, nil
+	i.count, i.covered = 0, FileHeaderSize
+	i.delta = make(map[[16]byte]IndexEntry)
+	i.pending, i.added = 0, 0
+	i.frozen, i.frozenPending, i.frozenAdded = nil, 0, 0
+
+	if err := os.Remove(i.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (i *sortedIndex) mapFile() error {
+	f, err := os.Open(i.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < sdxHeaderSize {
+		return fmt.Errorf("%w: short header", errBadSortedIndex)
+	}
+
+	header := make([]byte, sdxHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != sdxMagic || binary.BigEndian.Uint32(header[4:8]) != sdxVersion {
+		return fmt.Errorf("%w: bad magic or version", errBadSortedIndex)
+	}
+	covered := int64(binary.BigEndian.Uint64(header[8:16]))
+	count := int(binary.BigEndian.Uint64(header[16:24]))
+	if covered != 0 && !IdxOffsetAligned(covered) {
+		return fmt.Errorf("%w: covered offset %d isn't a record boundary", errBadSortedIndex, covered)
+	}
+	if info.Size() != sdxHeaderSize+int64(count)*IdxEntryTotalSize {
+		return fmt.Errorf("%w: size doesn't match record count", errBadSortedIndex)
+	}
+
+	mapped, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return err
+	}
+
+	i.mapped = mapped
+	i.data = mapped[sdxHeaderSize:]
+	i.count = count
+	i.covered = max(covered, FileHeaderSize)
+	return nil
+}
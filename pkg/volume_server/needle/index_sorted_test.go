@@ -0,0 +1,259 @@
+package needle
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// benchEntries is the size of the synthetic .idx file the index benchmarks load.
+const benchEntries = 200_000
+
+// writeIdxFile writes n records for random needle ids to a fresh .idx file in dir.
+func writeIdxFile(tb testing.TB, dir string, n int) (*os.File, [][16]byte) {
+	tb.Helper()
+
+	f, err := os.OpenFile(filepath.Join(dir, "bench"+IdxFileExtension), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(fileHeader(IdxFileMagic)); err != nil {
+		tb.Fatal(err)
+	}
+	ids := make([][16]byte, n)
+	buf := make([]byte, IdxEntryTotalSize)
+	for i := range ids {
+		rand.Read(ids[i][:])
+		encodeEntry(buf, ids[i], IndexEntry{Offset: FileHeaderSize + uint64(i)*128, Size: 100})
+		if _, err := w.Write(buf); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return f, ids
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+// resetIndexFiles removes what an index built on top of the .idx file, so the next
+// open replays all of it.
+func resetIndexFiles(tb testing.TB, f *os.File) {
+	tb.Helper()
+	for _, path := range []string{sortedIndexPath(f.Name()), snapshotPath(f.Name())} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkIndexOpenCold replays the whole .idx file, as on the first open.
+func BenchmarkIndexOpenCold(b *testing.B) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		b.Run(string(kind), func(b *testing.B) {
+			f, _ := writeIdxFile(b, b.TempDir(), benchEntries)
+
+			for b.Loop() {
+				b.StopTimer()
+				resetIndexFiles(b, f)
+				b.StartTimer()
+
+				idx, err := OpenIndex(kind, f)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				idx.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkIndexOpenWarm opens an index that was checkpointed, as on a restart, and
+// reports the heap it holds on to.
+func BenchmarkIndexOpenWarm(b *testing.B) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		b.Run(string(kind), func(b *testing.B) {
+			f, _ := writeIdxFile(b, b.TempDir(), benchEntries)
+			idx, err := OpenIndex(kind, f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := idx.Close(); err != nil {
+				b.Fatal(err)
+			}
+
+			var heap uint64
+			for b.Loop() {
+				before := heapInUse()
+				idx, err := OpenIndex(kind, f)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if after := heapInUse(); after > before {
+					heap = after - before
+				}
+				idx.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(heap)/(1<<20), "heap-MB")
+		})
+	}
+}
+
+func BenchmarkIndexGet(b *testing.B) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		b.Run(string(kind), func(b *testing.B) {
+			f, ids := writeIdxFile(b, b.TempDir(), benchEntries)
+			idx, err := OpenIndex(kind, f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			// lookups hit the mmap'd file rather than the delta
+			if err := idx.Checkpoint(); err != nil {
+				b.Fatal(err)
+			}
+			defer idx.Close()
+
+			for b.Loop() {
+				if _, ok := idx.Get(ids[rand.Intn(len(ids))]); !ok {
+					b.Fatal("lookup missed")
+				}
+			}
+		})
+	}
+}
+
+func TestSortedIndexFailedMergeKeepsEntries(t *testing.T) {
+	f, ids := writeIdxFile(t, t.TempDir(), 100)
+	idx, err := openSortedIndex(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := [16]byte{1}
+	if err := idx.Put(extra, IndexEntry{Offset: FileHeaderSize, Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a non-empty directory in place of the .sdx file makes the rename fail
+	if err := os.Remove(idx.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(idx.path, "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Checkpoint(); err == nil {
+		t.Fatal("Checkpoint succeeded with the .sdx path blocked")
+	}
+
+	for _, id := range append(ids, extra) {
+		if _, ok := idx.Get(id); !ok {
+			t.Fatalf("entry %x lost after a failed merge", id)
+		}
+	}
+	if idx.Len() != len(ids)+1 {
+		t.Fatalf("Len() = %d, want %d", idx.Len(), len(ids)+1)
+	}
+
+	if err := os.RemoveAll(idx.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint after clearing the path: %v", err)
+	}
+	if _, ok := idx.Get(extra); !ok {
+		t.Fatal("entry lost after the retried merge")
+	}
+}
+
+func TestSortedIndexMergesInBackground(t *testing.T) {
+	f, ids := writeIdxFile(t, t.TempDir(), sortedIndexDeltaLimit+100)
+	idx, err := openSortedIndex(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	// the replay filled a delta, whether its merge has finished or not every
+	// entry is there once
+	check := func(when string) {
+		t.Helper()
+		for _, id := range ids {
+			if _, ok := idx.Get(id); !ok {
+				t.Fatalf("%s: entry %x missing", when, id)
+			}
+		}
+		var ranged int
+		var last [16]byte
+		idx.Range(func(id [16]byte, _ IndexEntry) bool {
+			if ranged > 0 && string(last[:]) >= string(id[:]) {
+				t.Fatalf("%s: Range isn't in id order: %x before %x", when, last, id)
+			}
+			last = id
+			ranged++
+			return true
+		})
+		if ranged != len(ids) || idx.Len() != len(ids) {
+			t.Fatalf("%s: Range walked %d entries and Len() = %d, want %d", when, ranged, idx.Len(), len(ids))
+		}
+	}
+	check("while merging")
+
+	if err := idx.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if idx.frozen != nil || len(idx.delta) != 0 || idx.count != len(ids) {
+		t.Fatalf("after a checkpoint the .sdx file holds %d entries with %d left in the deltas, want all %d", idx.count, len(idx.delta)+len(idx.frozen), len(ids))
+	}
+	check("after a checkpoint")
+}
+
+// BenchmarkSortedIndexPut writes to an index already holding n entries and reports
+// the slowest Put, which is where merging the delta inline used to stall writes.
+func BenchmarkSortedIndexPut(b *testing.B) {
+	for _, n := range []int{1 << 20, 1 << 22} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			f, _ := writeIdxFile(b, b.TempDir(), n)
+			idx, err := openSortedIndex(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := idx.Checkpoint(); err != nil {
+				b.Fatal(err)
+			}
+			defer idx.Close()
+
+			var id [16]byte
+			var slowest time.Duration
+			for b.Loop() {
+				rand.Read(id[:])
+				start := time.Now()
+				if err := idx.Put(id, IndexEntry{Offset: FileHeaderSize, Size: 100}); err != nil {
+					b.Fatal(err)
+				}
+				slowest = max(slowest, time.Since(start))
+			}
+			b.ReportMetric(float64(slowest.Microseconds())/1000, "max-ms/op")
+		})
+	}
+}
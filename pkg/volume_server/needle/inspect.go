@@ -0,0 +1,428 @@
+package needle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Where an offline check places a needle found in the .dat file
+const (
+	NeedleLive       = "live"
+	NeedleExpired    = "expired"
+	NeedleDeleted    = "deleted"
+	NeedleSuperseded = "superseded" // an older copy, the index points elsewhere now
+	NeedleOrphan     = "orphan"     // no .idx record ever pointed at it
+	NeedleCorrupt    = "corrupt"
+)
+
+// Kinds of problems an offline check reports
+const (
+	ProblemCorrupt   = "corrupt"
+	ProblemOrphan    = "orphan"
+	ProblemDangling  = "dangling"
+	ProblemDuplicate = "duplicate"
+	ProblemTruncated = "truncated"
+)
+
+// resyncWindow is how much of the .dat file is searched at a time for the next
+// needle after a damaged one
+const resyncWindow = 1 << 20
+
+// DataNeedle is a needle found by walking a .dat file front to back.
+type DataNeedle struct {
+	Offset    uint64
+	ID        [16]byte
+	Size      uint32
+	ExpiresAt uint64
+	Checksum  uint32
+	Err       error // why the needle doesn't hold up, nil if it's intact
+	State     string
+}
+
+// IdxRecord is a single record of a .idx file.
+type IdxRecord struct {
+	Position int64 // byte offset in the .idx file
+	ID       [16]byte
+	Entry    IndexEntry
+}
+
+type Problem struct {
+	Kind   string
+	ID     [16]byte
+	Offset uint64
+	Detail string
+}
+
+// CheckReport is the result of cross-checking a volume's .idx against its .dat.
+type CheckReport struct {
+	Needles    []DataNeedle
+	IdxRecords int
+	// final state of the index after replaying every record
+	Entries  map[[16]byte]IndexEntry
+	Problems []Problem
+	DataSize int64
+}
+
+// ScanData walks every needle of a .dat file. A damaged needle is reported with Err
+// set and the walk resumes at the next offset that holds an intact needle.
+func ScanData(f *os.File, fn func(n DataNeedle) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if err := checkFileHeader(f, size, DataFileMagic); err != nil {
+		return err
+	}
+
+	header := make([]byte, NeedleFixedPortion-NeedleChecksum)
+	for offset := int64(FileHeaderSize); offset < size; {
+		if size-offset < NeedleFixedPortion {
+			return fn(DataNeedle{Offset: uint64(offset), Err: fmt.Errorf("%w: %d trailing bytes are too short for a needle", errTruncated, size-offset)})
+		}
+
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return err
+		}
+		n := DataNeedle{
+			Offset:    uint64(offset),
+			ID:        [16]byte(header[2:18]),
+			Size:      binary.BigEndian.Uint32(header[18:22]),
+			ExpiresAt: binary.BigEndian.Uint64(header[22:30]),
+		}
+
+		next := offset + int64(needleDiskSize(n.Size))
+		switch {
+		case binary.BigEndian.Uint16(header[0:2]) != NeedleMagicVal:
+			n.Err = fmt.Errorf("%w: bad magic number", ErrCorrupted)
+		case next > size:
+			n.Err = fmt.Errorf("%w: needle of %d bytes runs past the end of the file", ErrCorrupted, n.Size)
+		default:
+			n.Checksum, n.Err = checkNeedleData(f, offset, n.Size)
+		}
+
+		if n.Err != nil && !errors.Is(n.Err, errChecksum) {
+			// the header can't be trusted, so neither can the size it gives
+			n.ID, n.Size, n.ExpiresAt = [16]byte{}, 0, 0
+			if next, err = resync(f, offset+1, size); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(n); err != nil {
+			return err
+		}
+		offset = next
+	}
+	return nil
+}
+
+var (
+	errChecksum  = fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	errTruncated = fmt.Errorf("%w: truncated", ErrCorrupted)
+)
+
+// checkNeedleData checks the checksum of the needle at offset, which covers
+// everything after the magic number.
+func checkNeedleData(f io.ReaderAt, offset int64, size uint32) (uint32, error) {
+	buf := make([]byte, NeedleFixedPortion-2+int(size))
+	if _, err := f.ReadAt(buf, offset+2); err != nil {
+		return 0, err
+	}
+	checksum := binary.BigEndian.Uint32(buf[len(buf)-NeedleChecksum:])
+	if checksum != crc32.ChecksumIEEE(buf[:len(buf)-NeedleChecksum]) {
+		return checksum, errChecksum
+	}
+	return checksum, nil
+}
+
+// resync finds the first offset at or after from where an intact needle starts,
+// or size if there is none.
+func resync(f *os.File, from, size int64) (int64, error) {
+	magic := binary.BigEndian.AppendUint16(nil, NeedleMagicVal)
+	window := make([]byte, resyncWindow)
+	header := make([]byte, NeedleFixedPortion-NeedleChecksum)
+
+	for start := from; start < size; start += resyncWindow - 1 {
+		n, err := f.ReadAt(window, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := 0; i < n-1; {
+			j := bytes.Index(window[i:n], magic)
+			if j < 0 {
+				break
+			}
+			candidate := start + int64(i+j)
+			i += j + 1
+
+			if candidate+NeedleFixedPortion > size {
+				continue
+			}
+			if _, err := f.ReadAt(header, candidate); err != nil {
+				return 0, err
+			}
+			dataSize := binary.BigEndian.Uint32(header[18:22])
+			if candidate+int64(needleDiskSize(dataSize)) > size {
+				continue
+			}
+			if _, err := checkNeedleData(f, candidate, dataSize); err == nil {
+				return candidate, nil
+			}
+		}
+	}
+	return size, nil
+}
+
+// ScanIdx walks every record of a .idx file and returns how many trailing bytes
+// don't make up a whole record.
+func ScanIdx(f *os.File, fn func(r IdxRecord) error) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkFileHeader(f, info.Size(), IdxFileMagic); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, IdxEntryTotalSize)
+	pos := int64(FileHeaderSize)
+	for ; pos+IdxEntryTotalSize <= info.Size(); pos += IdxEntryTotalSize {
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			return 0, err
+		}
+		id, entry, err := decodeEntry(buf)
+		if err != nil {
+			return 0, err
+		}
+		if err := fn(IdxRecord{Position: pos, ID: id, Entry: entry}); err != nil {
+			return 0, err
+		}
+	}
+	return info.Size() - pos, nil
+}
+
+// CheckVolume cross-checks the .idx and .dat files of the volume at base (its path
+// without extension) and classifies every needle in the .dat file.
+func CheckVolume(base string) (*CheckReport, error) {
+	legacy, err := legacyVolume(base)
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		return nil, ErrLegacyFormat
+	}
+
+	dataFile, err := os.Open(base + DataFileExtension)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+
+	idxFile, err := os.Open(base + IdxFileExtension)
+	if err != nil {
+		return nil, err
+	}
+	defer idxFile.Close()
+
+	r := &CheckReport{Entries: make(map[[16]byte]IndexEntry)}
+
+	if info, err := dataFile.Stat(); err == nil {
+		r.DataSize = info.Size()
+	}
+
+	// offsets any record ever pointed at, to tell superseded needles from orphans
+	referenced := make(map[uint64][16]byte)
+	type idxRef struct {
+		id     [16]byte
+		offset uint64
+	}
+	idxRefs := make(map[idxRef]int)
+	trailing, err := ScanIdx(idxFile, func(rec IdxRecord) error {
+		r.IdxRecords++
+		r.Entries[rec.ID] = rec.Entry
+		if !rec.Entry.Deleted() {
+			referenced[rec.Entry.Offset] = rec.ID
+			idxRefs[idxRef{id: rec.ID, offset: rec.Entry.Offset}]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read .idx file: %w", err)
+	}
+	if trailing > 0 {
+		r.Problems = append(r.Problems, Problem{Kind: ProblemTruncated, Detail: fmt.Sprintf(".idx file ends with a partial record of %d bytes", trailing)})
+	}
+	for ref, n := range idxRefs {
+		if n > 1 {
+			r.Problems = append(r.Problems, Problem{Kind: ProblemDuplicate, ID: ref.id, Offset: ref.offset,
+				Detail: fmt.Sprintf("%d .idx records point at the same needle", n)})
+		}
+	}
+
+	now := time.Now()
+	byOffset := make(map[uint64]int)
+	copies := make(map[[16]byte][]uint64)
+	err = ScanData(dataFile, func(n DataNeedle) error {
+		entry, indexed := r.Entries[n.ID]
+		id, wasReferenced := referenced[n.Offset]
+
+		switch {
+		case n.Err != nil:
+			if n.ID == ([16]byte{}) && wasReferenced {
+				// the header is gone but the index still says which needle lived here
+				n.ID = id
+			}
+			n.State = NeedleCorrupt
+			kind := ProblemCorrupt
+			if errors.Is(n.Err, errTruncated) {
+				kind = ProblemTruncated
+			}
+			r.Problems = append(r.Problems, Problem{Kind: kind, ID: n.ID, Offset: n.Offset, Detail: n.Err.Error()})
+		case !wasReferenced || id != n.ID:
+			n.State = NeedleOrphan
+			r.Problems = append(r.Problems, Problem{Kind: ProblemOrphan, ID: n.ID, Offset: n.Offset, Detail: "no .idx record points at this needle"})
+		case indexed && entry.Deleted():
+			n.State = NeedleDeleted
+		case !indexed || entry.Offset != n.Offset:
+			n.State = NeedleSuperseded
+		case entry.Expired(now):
+			n.State = NeedleExpired
+		default:
+			n.State = NeedleLive
+		}
+
+		if n.Err == nil {
+			copies[n.ID] = append(copies[n.ID], n.Offset)
+		}
+		byOffset[n.Offset] = len(r.Needles)
+		r.Needles = append(r.Needles, n)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read .dat file: %w", err)
+	}
+
+	for id, offsets := range copies {
+		if len(offsets) > 1 {
+			r.Problems = append(r.Problems, Problem{Kind: ProblemDuplicate, ID: id, Offset: offsets[0],
+				Detail: fmt.Sprintf("needle is stored %d times, at offsets %v", len(offsets), offsets)})
+		}
+	}
+
+	for id, entry := range r.Entries {
+		if entry.Deleted() {
+			continue
+		}
+		i, ok := byOffset[entry.Offset]
+		switch {
+		case !ok:
+			r.Problems = append(r.Problems, Problem{Kind: ProblemDangling, ID: id, Offset: entry.Offset, Detail: "index entry doesn't point at the start of a needle"})
+		case r.Needles[i].Err == nil && (r.Needles[i].ID != id || r.Needles[i].Size != entry.Size):
+			r.Problems = append(r.Problems, Problem{Kind: ProblemDangling, ID: id, Offset: entry.Offset, Detail: "index entry points at a different needle"})
+		}
+	}
+
+	return r, nil
+}
+
+// Clean reports whether the check found nothing wrong.
+func (r *CheckReport) Clean() bool {
+	return len(r.Problems) == 0
+}
+
+// ExtractNeedle reads a single needle of the volume at base straight from its files.
+func ExtractNeedle(base string, id [16]byte) ([]byte, IndexEntry, error) {
+	idxFile, err := os.Open(base + IdxFileExtension)
+	if err != nil {
+		return nil, IndexEntry{}, err
+	}
+	defer idxFile.Close()
+
+	var entry IndexEntry
+	var found bool
+	if _, err := ScanIdx(idxFile, func(rec IdxRecord) error {
+		if rec.ID == id {
+			entry, found = rec.Entry, true
+		}
+		return nil
+	}); err != nil {
+		return nil, IndexEntry{}, err
+	}
+	if !found || entry.Deleted() {
+		return nil, IndexEntry{}, ErrNotFound
+	}
+
+	dataFile, err := os.Open(base + DataFileExtension)
+	if err != nil {
+		return nil, IndexEntry{}, err
+	}
+	defer dataFile.Close()
+
+	data, err := readNeedleAt(dataFile, id, entry)
+	return data, entry, err
+}
+
+// RepairResult describes what RepairVolume kept of a volume.
+type RepairResult struct {
+	Kept       int
+	Dropped    int
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// RepairVolume rewrites the volume at base with only the needles its index points
+// at that are intact, dropping everything else. The volume must not be open elsewhere.
+func RepairVolume(base string) (RepairResult, error) {
+	if err := recoverCompaction(base); err != nil {
+		return RepairResult{}, err
+	}
+	if err := migrateLegacy(base); err != nil {
+		return RepairResult{}, err
+	}
+
+	r, err := CheckVolume(base)
+	if err != nil {
+		return RepairResult{}, err
+	}
+	res := RepairResult{SizeBefore: r.DataSize}
+
+	var entries []IndexEntry
+	for _, n := range r.Needles {
+		if n.State == NeedleLive {
+			entry := r.Entries[n.ID]
+			entry.ID = n.ID
+			entries = append(entries, entry)
+		}
+	}
+	res.Kept = len(entries)
+	res.Dropped = len(r.Needles) - len(entries)
+
+	dataFile, err := os.Open(base + DataFileExtension)
+	if err != nil {
+		return res, err
+	}
+	defer dataFile.Close()
+
+	err = writeCompacted(base, entries, func(id [16]byte, entry IndexEntry) ([]byte, error) {
+		return readNeedleAt(dataFile, id, entry)
+	})
+	if err != nil {
+		return res, err
+	}
+	if err := commitCompaction(base); err != nil {
+		return res, err
+	}
+
+	if info, err := os.Stat(base + DataFileExtension); err == nil {
+		res.SizeAfter = info.Size()
+	}
+	return res, nil
+}
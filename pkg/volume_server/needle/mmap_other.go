@@ -0,0 +1,22 @@
+//go:build !unix
+
+package needle
+
+import (
+	"io"
+	"os"
+)
+
+// Without mmap the sorted file is read into memory, lookups still work but
+// the memory savings are lost.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func munmapFile(b []byte) error {
+	return nil
+}
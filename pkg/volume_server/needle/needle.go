@@ -1,12 +1,30 @@
 package needle
 
+import "time"
+
 /////////////////////////////////////
 
 // CONSTANTS FOR NEEDLE FORMAT ON DISK
 
 /////////////////////////////////////
 
-// NEEDLE: MAGICNUMBER|UUID|SIZE|DATA|CHECKSUM
+// FILE HEADER: MAGICNUMBER|VERSION at the start of every .dat and .idx file
+
+const (
+	// Size of the header in front of the needles and index records
+	FileHeaderSize = 8
+
+	// The .dat and .idx magic number literals
+	DataFileMagic uint32 = 0x47444154 // "GDAT"
+	IdxFileMagic  uint32 = 0x47494458 // "GIDX"
+
+	// Layout of the needles and index records below. Files without a header predate
+	// it and hold legacy needles and records, converted when the volume is opened
+	FormatVersion uint32 = 2
+)
+
+// NEEDLE: MAGICNUMBER|UUID|SIZE|EXPIRES|DATA|CHECKSUM
+// The checksum covers everything between the magic number and itself
 
 const (
 	// Size of Needle's magic number
@@ -18,14 +36,20 @@ const (
 	// Size of Needle's blob data payload
 	NeedleDataSize = 4
 
+	// Size of Needle's expiry (unix seconds, 0 means it never expires)
+	NeedleExpiresSize = 8
+
 	// Size of Needle's checksum
 	NeedleChecksum = 4
 
 	// The total fixed overhead of the Needle
-	NeedleFixedPortion = 26
+	NeedleFixedPortion = 34
 
 	// The Needle magic number literal
 	NeedleMagicVal uint16 = 0xCAFE
+
+	// Legacy needles had no expiry and only checksummed the data
+	LegacyNeedleFixedPortion = 26
 )
 
 /////////////////////////////////////
@@ -34,7 +58,7 @@ const (
 
 /////////////////////////////////////
 
-// IDX: OBJECT_ID|OFFSET|SIZE
+// IDX: OBJECT_ID|OFFSET|SIZE|EXPIRES
 const (
 	// Object ID field
 	IdxObjectID = 16
@@ -45,14 +69,34 @@ const (
 	// Size field
 	IdxSize = 4
 
+	// Expiry field
+	IdxExpires = 8
+
 	// The total size of the Index entry
-	IdxEntryTotalSize = 28
+	IdxEntryTotalSize = 36
+
+	// Legacy records had no expiry
+	LegacyIdxEntryTotalSize = 28
+
+	// Size recorded in the index for a deleted needle
+	TombstoneSize uint32 = 0xFFFFFFFF
 )
 
 type IndexEntry struct {
-	ID     [16]byte
-	Offset uint64
-	Size   uint32
+	ID        [16]byte
+	Offset    uint64
+	Size      uint32
+	ExpiresAt uint64
+}
+
+// Expired reports whether the entry carries a TTL that has passed at now.
+func (e IndexEntry) Expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && uint64(now.Unix()) >= e.ExpiresAt
+}
+
+// Deleted reports whether the entry is a tombstone left by a delete.
+func (e IndexEntry) Deleted() bool {
+	return e.Size == TombstoneSize
 }
 
 /////////////////////////////////////
@@ -70,6 +114,19 @@ const (
 	// Index file suffix
 	IdxFileExtension = ".idx"
 
+	// Sorted index file suffix
+	SortedIdxFileExtension = ".sdx"
+
+	// In-memory index checkpoint suffix
+	IdxSnapshotFileExtension = ".snp"
+
+	// Reference counts of content-addressed needles suffix
+	RefFileExtension = ".ref"
+
+	// Compacted data and index files waiting to replace the originals
+	CompactDataFileExtension = ".cpd"
+	CompactIdxFileExtension  = ".cpx"
+
 	// Directory
 	DataDir = "data/"
 )
@@ -1,6 +1,9 @@
 package needle
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,154 +13,532 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var rwrwrw int = 0666
 
+var (
+	ErrNotFound  = errors.New("needle not found")
+	ErrExpired   = errors.New("needle expired")
+	ErrCorrupted = errors.New("CORRUPTED")
+	ErrReadOnly  = errors.New("volume is read-only")
+	ErrConflict  = errors.New("needle exists with different data")
+)
+
 type Volume struct {
 	volumeID [16]byte
 	idxFile  *os.File
 	dataFile *os.File
-	idxMap   map[[16]byte]IndexEntry
+	idx      Index
+	refs     *refLog
+	kind     IndexKind
+	readOnly bool // set after a failed write so a sick disk isn't written to again
 	rw       sync.RWMutex
+
+	// bytes and needles still referenced by the index, kept up to date on writes
+	liveBytes uint64
+	needles   uint64
 }
 
-func NewVolume(path string, volumeID [16]byte) (*Volume, error) {
+type VolumeStats struct {
+	SizeBytes    uint64
+	LiveBytes    uint64
+	NeedleCount  uint64
+	IdxBytes     uint64
+	IndexEntries uint64
+}
+
+// VolumeFileName is the on-disk base name shared by a volume's .dat and .idx files.
+func VolumeFileName(volumeID [16]byte) string {
+	return fmt.Sprintf("%s%x", VolumeFilePrefix, volumeID)
+}
+
+func NewVolume(path string, volumeID [16]byte, kind IndexKind) (*Volume, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, fmt.Errorf("could not create data directory: %w", err)
 	}
 
-	fileName := fmt.Sprintf("%s%x", VolumeFilePrefix, volumeID)
-	idxFilePath := filepath.Join(path, fileName+IdxFileExtension)
-	dataFilePath := filepath.Join(path, fileName+DataFileExtension)
+	base := filepath.Join(path, VolumeFileName(volumeID))
+	if err := recoverCompaction(base); err != nil {
+		return nil, fmt.Errorf("could not recover interrupted compaction: %w", err)
+	}
+	if err := migrateLegacy(base); err != nil {
+		return nil, fmt.Errorf("could not convert legacy volume: %w", err)
+	}
+
+	v := &Volume{
+		volumeID: volumeID,
+		kind:     kind,
+	}
+	if err := v.open(base); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
 
-	idxFile, err := os.OpenFile(idxFilePath, os.O_CREATE|os.O_RDWR, os.FileMode(rwrwrw))
+// open (re)opens the volume's files and index and recounts its live needles.
+func (v *Volume) open(base string) error {
+	idxFile, err := os.OpenFile(base+IdxFileExtension, os.O_CREATE|os.O_RDWR, os.FileMode(rwrwrw))
 	if err != nil {
 		log.Fatalf("Failed to instantiate index file for volume server: %v", err)
 	}
 
-	dataFile, err := os.OpenFile(dataFilePath, os.O_CREATE|os.O_RDWR, os.FileMode(rwrwrw))
+	dataFile, err := os.OpenFile(base+DataFileExtension, os.O_CREATE|os.O_RDWR, os.FileMode(rwrwrw))
 	if err != nil {
 		log.Fatalf("Failed to instantiate data file for volume server: %v", err)
 	}
 
-	idxMap, err := loadIndex(idxFile)
+	if err := initFileHeader(dataFile, DataFileMagic); err != nil {
+		return fmt.Errorf("%s: %w", dataFile.Name(), err)
+	}
+	if err := initFileHeader(idxFile, IdxFileMagic); err != nil {
+		return fmt.Errorf("%s: %w", idxFile.Name(), err)
+	}
+
+	idx, err := OpenIndex(v.kind, idxFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Volume{
-		volumeID: volumeID,
-		idxFile:  idxFile,
-		dataFile: dataFile,
-		idxMap:   idxMap,
-	}, nil
+	refs, err := openRefLog(base + RefFileExtension)
+	if err != nil {
+		return err
+	}
+
+	// replaying may have stopped short of the end, appends must land there
+	if _, err := idxFile.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	v.idxFile, v.dataFile, v.idx, v.refs = idxFile, dataFile, idx, refs
+	v.needles, v.liveBytes = 0, 0
+
+	now := time.Now()
+	idx.Range(func(_ [16]byte, entry IndexEntry) bool {
+		if !entry.Deleted() && !entry.Expired(now) {
+			v.needles++
+			v.liveBytes += needleDiskSize(entry.Size)
+		}
+		return true
+	})
+
+	return nil
+}
+
+func (v *Volume) ID() [16]byte {
+	return v.volumeID
+}
+
+// Write appends a needle. expiresAt is in unix seconds, 0 means the needle never expires.
+func (v *Volume) Write(ctx context.Context, needleId uuid.UUID, data []byte, expiresAt uint64) (err error) {
+	span := v.startSpan(ctx, "needle.Volume.Write", needleId)
+	span.SetAttributes(attribute.Int("graphene.bytes", len(data)))
+	defer func() { endSpan(span, err) }()
+
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	return v.write(needleId, data, expiresAt)
+}
+
+// WriteOnce writes a needle unless one with the same id is live already, so a retried
+// upload isn't stored twice. existing reports the needle was there, ErrConflict that
+// it holds different data.
+func (v *Volume) WriteOnce(ctx context.Context, needleId uuid.UUID, data []byte, expiresAt uint64) (existing bool, err error) {
+	span := v.startSpan(ctx, "needle.Volume.WriteOnce", needleId)
+	span.SetAttributes(attribute.Int("graphene.bytes", len(data)))
+	defer func() { endSpan(span, err) }()
+
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	if entry, ok := v.liveEntry(needleId); ok {
+		stored, err := v.readNeedle(needleId, entry)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(stored, data) {
+			return false, ErrConflict
+		}
+		return true, nil
+	}
+	return false, v.write(needleId, data, expiresAt)
 }
 
-func (v *Volume) Write(needleId uuid.UUID, data []byte) error {
+// WriteShared stores data as a content-addressed needle. Writing the same data again
+// only adds a reference to the needle, which then takes as many deletes to remove.
+func (v *Volume) WriteShared(ctx context.Context, data []byte) (needleId uuid.UUID, existing bool, err error) {
+	needleId = ContentID(sha256.Sum256(data))
+	span := v.startSpan(ctx, "needle.Volume.WriteShared", needleId)
+	span.SetAttributes(attribute.Int("graphene.bytes", len(data)))
+	defer func() { endSpan(span, err) }()
+
 	v.rw.Lock()
 	defer v.rw.Unlock()
 
+	if v.readOnly {
+		return needleId, false, ErrReadOnly
+	}
+
+	if _, ok := v.liveEntry(needleId); ok {
+		refs := max(v.refs.get(needleId), 1)
+		if err := v.refs.set(needleId, refs+1); err != nil {
+			v.markReadOnly(err)
+			return needleId, false, err
+		}
+		return needleId, true, nil
+	}
+
+	if err := v.write(needleId, data, 0); err != nil {
+		return needleId, false, err
+	}
+	// a count left over from an earlier needle with this content starts over
+	if err := v.refs.set(needleId, 1); err != nil {
+		v.markReadOnly(err)
+		return needleId, false, err
+	}
+	return needleId, false, nil
+}
+
+// Refs returns how many uploads share a content-addressed needle, 0 for other needles.
+func (v *Volume) Refs(needleId uuid.UUID) uint32 {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	return v.refs.get(needleId)
+}
+
+// liveEntry looks up a needle that is neither deleted nor expired. Callers must hold v.rw.
+func (v *Volume) liveEntry(needleId [16]byte) (IndexEntry, bool) {
+	entry, ok := v.idx.Get(needleId)
+	if !ok || entry.Deleted() || entry.Expired(time.Now()) {
+		return IndexEntry{}, false
+	}
+	return entry, true
+}
+
+// write appends a needle. Callers must hold v.rw.
+func (v *Volume) write(needleId [16]byte, data []byte, expiresAt uint64) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
 	info, err := v.dataFile.Stat()
 	if err != nil {
 		return err
 	}
 
 	offset := info.Size()
-	checksum := crc32.ChecksumIEEE(data)
+	newNeedleBuffer := encodeNeedle(needleId, data, expiresAt)
 
-	needleSize := NeedleFixedPortion + len(data)
-	newNeedleBuffer := make([]byte, needleSize)
+	if _, err := v.dataFile.WriteAt(newNeedleBuffer, offset); err != nil {
+		v.markReadOnly(err)
+		return err
+	}
 
-	binary.BigEndian.PutUint16(newNeedleBuffer[0:2], NeedleMagicVal)
-	copy(newNeedleBuffer[2:18], needleId[:])
-	binary.BigEndian.PutUint32(newNeedleBuffer[18:22], uint32(len(data)))
-	copy(newNeedleBuffer[22:22+len(data)], data)
-	binary.BigEndian.PutUint32(newNeedleBuffer[22+len(data):], checksum)
+	entry := IndexEntry{
+		Offset:    uint64(offset),
+		Size:      uint32(len(data)),
+		ExpiresAt: expiresAt,
+	}
 
-	if _, err := v.dataFile.Write(newNeedleBuffer); err != nil {
+	if err := v.appendEntry(needleId, entry); err != nil {
 		return err
 	}
+	v.needles++
+	v.liveBytes += needleDiskSize(entry.Size)
+
+	return nil
+}
+
+// Delete records a tombstone for a needle. Its bytes stay in the .dat file until the volume is compacted.
+// A content-addressed needle shared by several uploads only loses a reference.
+func (v *Volume) Delete(ctx context.Context, needleId uuid.UUID) (err error) {
+	span := v.startSpan(ctx, "needle.Volume.Delete", needleId)
+	defer func() { endSpan(span, err) }()
+
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	entry, ok := v.idx.Get(needleId)
+	if !ok || entry.Deleted() {
+		return ErrNotFound
+	}
+
+	if refs := v.refs.get(needleId); refs > 0 {
+		if err := v.refs.set(needleId, refs-1); err != nil {
+			v.markReadOnly(err)
+			return err
+		}
+		if refs > 1 {
+			return nil
+		}
+	}
+	return v.appendEntry(needleId, IndexEntry{Size: TombstoneSize})
+}
 
+// appendEntry logs an index record and applies it, replacing whatever the needle
+// had before. Callers must hold v.rw.
+func (v *Volume) appendEntry(needleId [16]byte, entry IndexEntry) error {
 	idxBuf := make([]byte, IdxEntryTotalSize)
-	copy(idxBuf[0:16], needleId[:])
-	binary.BigEndian.PutUint64(idxBuf[16:24], uint64(offset))
-	binary.BigEndian.PutUint32(idxBuf[24:28], uint32(len(data)))
+	encodeEntry(idxBuf, needleId, entry)
 
 	if _, err := v.idxFile.Write(idxBuf); err != nil {
+		v.markReadOnly(err)
 		return err
 	}
 
-	v.idxMap[needleId] = IndexEntry{
-		Offset: uint64(offset),
-		Size:   uint32(len(data)),
+	if old, ok := v.idx.Get(needleId); ok && !old.Deleted() {
+		v.needles--
+		v.liveBytes -= needleDiskSize(old.Size)
 	}
 
-	return nil
+	return v.idx.Put(needleId, entry)
+}
+
+func encodeNeedle(needleId [16]byte, data []byte, expiresAt uint64) []byte {
+	buf := make([]byte, NeedleFixedPortion+len(data))
+
+	binary.BigEndian.PutUint16(buf[0:2], NeedleMagicVal)
+	copy(buf[2:18], needleId[:])
+	binary.BigEndian.PutUint32(buf[18:22], uint32(len(data)))
+	binary.BigEndian.PutUint64(buf[22:30], expiresAt)
+	copy(buf[30:30+len(data)], data)
+	binary.BigEndian.PutUint32(buf[30+len(data):], crc32.ChecksumIEEE(buf[2:30+len(data)]))
+
+	return buf
+}
+
+// markReadOnly stops further writes after an I/O error. Callers must hold v.rw.
+func (v *Volume) markReadOnly(err error) {
+	if !v.readOnly {
+		log.Printf("Volume %x failed a write, marking it read-only. Why: %v", v.volumeID, err)
+	}
+	v.readOnly = true
+}
+
+func (v *Volume) Stats() (VolumeStats, error) {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	info, err := v.dataFile.Stat()
+	if err != nil {
+		return VolumeStats{}, err
+	}
+	idxInfo, err := v.idxFile.Stat()
+	if err != nil {
+		return VolumeStats{}, err
+	}
+
+	return VolumeStats{
+		SizeBytes: uint64(info.Size()),
+		// the file header isn't garbage, compaction writes it again
+		LiveBytes:    FileHeaderSize + v.liveBytes,
+		NeedleCount:  v.needles,
+		IdxBytes:     uint64(idxInfo.Size()),
+		IndexEntries: uint64(v.idx.Len()),
+	}, nil
+}
+
+func needleDiskSize(dataSize uint32) uint64 {
+	return NeedleFixedPortion + uint64(dataSize)
+}
+
+func (v *Volume) ReadOnly() bool {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	return v.readOnly
+}
+
+func (v *Volume) Read(ctx context.Context, id uuid.UUID) (data []byte, err error) {
+	span := v.startSpan(ctx, "needle.Volume.Read", id)
+	defer func() {
+		span.SetAttributes(attribute.Int("graphene.bytes", len(data)))
+		endSpan(span, err)
+	}()
+
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	entry, ok := v.idx.Get(id)
+	if !ok || entry.Deleted() {
+		return nil, ErrNotFound
+	}
+	if entry.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
 
+	return v.readNeedle(id, entry)
 }
 
-func (v *Volume) Read(id uuid.UUID) ([]byte, error) {
+// Lookup returns the index entry of a needle without touching the .dat file.
+func (v *Volume) Lookup(id uuid.UUID) (IndexEntry, bool) {
 	v.rw.RLock()
 	defer v.rw.RUnlock()
 
-	entry, ok := v.idxMap[id]
-	if !ok {
-		return nil, errors.New("catastrophic error: we didn't find the object")
+	entry, ok := v.idx.Get(id)
+	entry.ID = id
+	return entry, ok && !entry.Deleted()
+}
+
+// Verify looks a needle up and re-reads it, checking its magic number, id and
+// checksum. It returns an error wrapping ErrCorrupted if they don't hold up, or
+// ErrNotFound if the needle was deleted or expired since the caller saw it. The
+// entry it returns is the one checked, a vacuum may have moved the needle.
+func (v *Volume) Verify(id [16]byte) (IndexEntry, error) {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	entry, ok := v.idx.Get(id)
+	if !ok || entry.Deleted() || entry.Expired(time.Now()) {
+		return IndexEntry{}, ErrNotFound
 	}
+	entry.ID = id
+	_, err := v.readNeedle(id, entry)
+	return entry, err
+}
+
+// readNeedle reads and checks the needle at entry. Callers must hold v.rw.
+func (v *Volume) readNeedle(id [16]byte, entry IndexEntry) ([]byte, error) {
+	return readNeedleAt(v.dataFile, id, entry)
+}
 
+func readNeedleAt(dataFile io.ReaderAt, id [16]byte, entry IndexEntry) ([]byte, error) {
 	needleSize := NeedleFixedPortion + int(entry.Size)
 	needleBuf := make([]byte, needleSize)
 
-	_, err := v.dataFile.ReadAt(needleBuf, int64(entry.Offset))
+	_, err := dataFile.ReadAt(needleBuf, int64(entry.Offset))
 	if err != nil {
 		return nil, fmt.Errorf("couldnt read needle: %w", err)
 	}
 
 	if binary.BigEndian.Uint16(needleBuf[0:2]) != NeedleMagicVal {
-		return nil, errors.New("CORRUPTED: even the magic number aint right")
+		return nil, fmt.Errorf("%w: even the magic number aint right", ErrCorrupted)
+	}
+	if [16]byte(needleBuf[2:18]) != id {
+		return nil, fmt.Errorf("%w: needle id doesn't match the index", ErrCorrupted)
+	}
+	if binary.BigEndian.Uint32(needleBuf[18:22]) != entry.Size {
+		return nil, fmt.Errorf("%w: needle size doesn't match the index", ErrCorrupted)
 	}
 
-	data := needleBuf[22 : 22+entry.Size]
-	onDiskChecksum := binary.BigEndian.Uint32(needleBuf[22+entry.Size:])
-	if onDiskChecksum != crc32.ChecksumIEEE(data) {
-		return nil, errors.New("CORRUPTED: checksums are totally different")
+	data := needleBuf[30 : 30+entry.Size]
+	onDiskChecksum := binary.BigEndian.Uint32(needleBuf[30+entry.Size:])
+	if onDiskChecksum != crc32.ChecksumIEEE(needleBuf[2:30+entry.Size]) {
+		return nil, fmt.Errorf("%w: checksums are totally different", ErrCorrupted)
 	}
 
 	return data, nil
 }
 
-func loadIndex(file *os.File) (map[[16]byte]IndexEntry, error) {
-	idxMap := make(map[[16]byte]IndexEntry)
+// EntriesFrom returns up to limit live (not deleted or expired) index entries with
+// ids from start on, in id order, so the index can be walked a batch at a time.
+func (v *Volume) EntriesFrom(start [16]byte, limit int) []IndexEntry {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
 
-	info, err := file.Stat()
-	if err != nil {
-		return nil, err
-	}
-	idxSize := info.Size()
-	if idxSize%IdxEntryTotalSize != 0 {
-		return nil, errors.New("index file corrupted: not evenly divisible by 28")
+	now := time.Now()
+	entries := make([]IndexEntry, 0, min(limit, v.idx.Len()))
+	v.idx.RangeFrom(start, func(id [16]byte, entry IndexEntry) bool {
+		if !entry.Deleted() && !entry.Expired(now) {
+			entry.ID = id
+			entries = append(entries, entry)
+		}
+		return len(entries) < limit
+	})
+	return entries
+}
+
+// NextID returns the id after id in the order EntriesFrom walks them, false after the last.
+func NextID(id [16]byte) ([16]byte, bool) {
+	for b := len(id) - 1; b >= 0; b-- {
+		id[b]++
+		if id[b] != 0 {
+			return id, true
+		}
 	}
+	return id, false
+}
 
-	numEntries := int(idxSize) / IdxEntryTotalSize
-	entryBuf := make([]byte, IdxEntryTotalSize)
+// Expired reports whether every needle in the volume was deleted or carries a TTL
+// that has passed, meaning the whole volume can be dropped.
+func (v *Volume) Expired(now time.Time) bool {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
 
-	for i := 0; i < numEntries; i++ {
-		_, err := io.ReadFull(file, entryBuf)
-		if err != nil {
-			return nil, err
+	expired := true
+	v.idx.Range(func(_ [16]byte, entry IndexEntry) bool {
+		expired = entry.Deleted() || entry.Expired(now)
+		return expired
+	})
+	return expired
+}
+
+// MaxExpiry returns the latest needle expiry in unix seconds, or 0 if any needle never expires.
+func (v *Volume) MaxExpiry() uint64 {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	var latest uint64
+	v.idx.Range(func(_ [16]byte, entry IndexEntry) bool {
+		if entry.Deleted() {
+			return true
 		}
-		id, entry, err := decodeEntry(entryBuf)
-		if err != nil {
-			return nil, errors.New("massive issue decoding index data into its tracker map fields")
+		if entry.ExpiresAt == 0 {
+			latest = 0
+			return false
 		}
+		latest = max(latest, entry.ExpiresAt)
+		return true
+	})
+	return latest
+}
+
+// Checkpoint persists the index so a restart only replays the .idx tail written after it.
+func (v *Volume) Checkpoint() error {
+	v.rw.Lock()
+	defer v.rw.Unlock()
 
-		idxMap[id] = entry
+	return v.idx.Checkpoint()
+}
 
+func (v *Volume) Close() error {
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	return v.closeFiles()
+}
+
+// Destroy closes the volume and removes its files from disk.
+func (v *Volume) Destroy() error {
+	if err := v.Close(); err != nil {
+		return err
 	}
-	return idxMap, nil
+	if err := os.Remove(v.dataFile.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(v.idxFile.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(sortedIndexPath(v.idxFile.Name())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(snapshotPath(v.idxFile.Name())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(v.refs.file.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func decodeEntry(buf []byte) ([16]byte, IndexEntry, error) {
@@ -171,6 +552,7 @@ func decodeEntry(buf []byte) ([16]byte, IndexEntry, error) {
 	var entry IndexEntry
 	entry.Offset = binary.BigEndian.Uint64(buf[16:24])
 	entry.Size = binary.BigEndian.Uint32(buf[24:28])
+	entry.ExpiresAt = binary.BigEndian.Uint64(buf[28:36])
 
 	return id, entry, nil
 }
@@ -0,0 +1,99 @@
+package needle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestVolume(t *testing.T, kind IndexKind, needles int) (*Volume, []uuid.UUID) {
+	t.Helper()
+	v, err := NewVolume(t.TempDir(), uuid.New(), kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { v.Close() })
+
+	// half the needles are checkpointed, a sorted index keeps them in its file and
+	// the rest in its delta
+	ids := make([]uuid.UUID, needles)
+	for n := range ids {
+		if n == needles/2 {
+			if err := v.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[n] = uuid.New()
+		if err := v.Write(context.Background(), ids[n], fmt.Appendf(nil, "needle %d", n), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return v, ids
+}
+
+func TestVerifyAfterCompact(t *testing.T) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		t.Run(string(kind), func(t *testing.T) {
+			v, ids := newTestVolume(t, kind, 10)
+			listed := v.EntriesFrom([16]byte{}, len(ids))
+
+			// the compaction moves every needle after the deleted one
+			if err := v.Delete(context.Background(), ids[0]); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Compact(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range listed {
+				_, err := v.Verify(entry.ID)
+				if uuid.UUID(entry.ID) == ids[0] {
+					if !errors.Is(err, ErrNotFound) {
+						t.Fatalf("Verify(deleted) = %v, want ErrNotFound", err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Verify(%x) after a compaction = %v", entry.ID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEntriesFromWalksEveryEntry(t *testing.T) {
+	for _, kind := range []IndexKind{MemoryIndex, SortedIndex} {
+		t.Run(string(kind), func(t *testing.T) {
+			v, ids := newTestVolume(t, kind, 25)
+			if err := v.Delete(context.Background(), ids[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[[16]byte]bool)
+			var next [16]byte
+			for more := true; more; {
+				batch := v.EntriesFrom(next, 4)
+				for n, entry := range batch {
+					if seen[entry.ID] {
+						t.Fatalf("entry %x listed twice", entry.ID)
+					}
+					if n > 0 && string(batch[n-1].ID[:]) >= string(entry.ID[:]) {
+						t.Fatalf("batch isn't in id order: %x before %x", batch[n-1].ID, entry.ID)
+					}
+					seen[entry.ID] = true
+				}
+				if len(batch) < 4 {
+					break
+				}
+				next, more = NextID(batch[len(batch)-1].ID)
+			}
+
+			if len(seen) != len(ids)-1 || seen[ids[0]] {
+				t.Fatalf("walked %d entries, want the %d live ones", len(seen), len(ids)-1)
+			}
+		})
+	}
+}
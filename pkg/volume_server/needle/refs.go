@@ -0,0 +1,146 @@
+package needle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// REF: OBJECT_ID|REFS, the last record of a needle wins and 0 drops it
+const RefEntryTotalSize = 20
+
+// ContentID derives the id of a content-addressed needle from the SHA-256 of its
+// data. It's the first 16 bytes of the sum, marked as a version 8 UUID.
+func ContentID(sum [sha256.Size]byte) uuid.UUID {
+	var id uuid.UUID
+	copy(id[:], sum[:16])
+	id[6] = id[6]&0x0f | 0x80
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// refLog counts the uploads sharing each content-addressed needle. Every change is
+// appended to the volume's .ref file, which is rewritten without the history when
+// the volume is opened.
+type refLog struct {
+	file   *os.File
+	counts map[[16]byte]uint32
+}
+
+func openRefLog(path string) (*refLog, error) {
+	counts := make(map[[16]byte]uint32)
+	records := 0
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// a partial record at the end is from a crash mid-append and dropped
+	for pos := 0; pos+RefEntryTotalSize <= len(data); pos += RefEntryTotalSize {
+		decodeRef(data[pos:pos+RefEntryTotalSize], counts)
+		records++
+	}
+
+	if records != len(counts) || len(data)%RefEntryTotalSize != 0 {
+		if err := writeRefFile(path, encodeRefs(counts)); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(rwrwrw))
+	if err != nil {
+		return nil, err
+	}
+	return &refLog{file: file, counts: counts}, nil
+}
+
+func (r *refLog) get(id [16]byte) uint32 {
+	return r.counts[id]
+}
+
+func (r *refLog) set(id [16]byte, refs uint32) error {
+	buf := make([]byte, RefEntryTotalSize)
+	copy(buf[0:16], id[:])
+	binary.BigEndian.PutUint32(buf[16:20], refs)
+	if _, err := r.file.Write(buf); err != nil {
+		return err
+	}
+
+	if refs == 0 {
+		delete(r.counts, id)
+	} else {
+		r.counts[id] = refs
+	}
+	return nil
+}
+
+// replace swaps every count for the ones encoded in refs.
+func (r *refLog) replace(refs []byte) error {
+	if len(refs)%RefEntryTotalSize != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	counts := make(map[[16]byte]uint32)
+	for pos := 0; pos < len(refs); pos += RefEntryTotalSize {
+		decodeRef(refs[pos:pos+RefEntryTotalSize], counts)
+	}
+
+	path := r.file.Name()
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if err := writeRefFile(path, refs); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	r.file, r.counts = file, counts
+	return nil
+}
+
+func (r *refLog) encode() []byte {
+	return encodeRefs(r.counts)
+}
+
+func (r *refLog) close() error {
+	return r.file.Close()
+}
+
+func encodeRefs(counts map[[16]byte]uint32) []byte {
+	buf := make([]byte, 0, len(counts)*RefEntryTotalSize)
+	for id, refs := range counts {
+		buf = append(buf, id[:]...)
+		buf = binary.BigEndian.AppendUint32(buf, refs)
+	}
+	return buf
+}
+
+func decodeRef(buf []byte, counts map[[16]byte]uint32) {
+	id := [16]byte(buf[0:16])
+	if refs := binary.BigEndian.Uint32(buf[16:20]); refs == 0 {
+		delete(counts, id)
+	} else {
+		counts[id] = refs
+	}
+}
+
+// writeRefFile replaces a .ref file through a temporary file so a crash leaves either version.
+func writeRefFile(path string, refs []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(rwrwrw))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(refs)
+	err = errors.Join(err, f.Sync(), f.Close())
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,32 @@
+package needle
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/rxanders35/graphene/pkg/volume_server/needle")
+
+// startSpan starts a span around disk I/O on a needle of this volume.
+func (v *Volume) startSpan(ctx context.Context, name string, needleId [16]byte) trace.Span {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("graphene.volume_id", uuid.UUID(v.volumeID).String()),
+		attribute.String("graphene.needle_id", uuid.UUID(needleId).String()),
+	))
+	return span
+}
+
+// endSpan ends a span, marking it failed if err is set. Lookups of needles
+// that aren't there are answers, not failures.
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != ErrNotFound && err != ErrExpired {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,232 @@
+package volume_server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+	pb "github.com/rxanders35/graphene/proto"
+)
+
+// needles listed from a volume's index at a time, so a pass never holds all of them
+const scrubBatchSize = 4096
+
+type CorruptNeedle struct {
+	VolumeID   uuid.UUID `json:"volume_id"`
+	NeedleID   uuid.UUID `json:"needle_id"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+type ScrubStatus struct {
+	Running          bool            `json:"running"`
+	Passes           int             `json:"passes"`
+	CurrentVolume    *uuid.UUID      `json:"current_volume,omitempty"`
+	NeedlesChecked   uint64          `json:"needles_checked"`
+	BytesChecked     uint64          `json:"bytes_checked"`
+	LastPassStarted  time.Time       `json:"last_pass_started"`
+	LastPassFinished time.Time       `json:"last_pass_finished"`
+	Corrupt          []CorruptNeedle `json:"corrupt"`
+}
+
+// Scrubber periodically walks every live needle in the store and verifies its
+// magic number and checksum, so bit rot on cold data is caught before a read hits it.
+type Scrubber struct {
+	store       *Store
+	master      *MasterClient
+	serverID    uuid.UUID
+	bytesPerSec int64
+	interval    time.Duration
+	trigger     chan struct{}
+
+	mu      sync.Mutex
+	status  ScrubStatus
+	corrupt map[[32]byte]CorruptNeedle
+}
+
+func NewScrubber(s *Store, m *MasterClient, serverID uuid.UUID, bytesPerSec int64, interval time.Duration) *Scrubber {
+	return &Scrubber{
+		store:       s,
+		master:      m,
+		serverID:    serverID,
+		bytesPerSec: bytesPerSec,
+		interval:    interval,
+		trigger:     make(chan struct{}, 1),
+		corrupt:     make(map[[32]byte]CorruptNeedle),
+	}
+}
+
+// Run scrubs every interval until ctx is done. A zero interval only scrubs on Trigger.
+func (s *Scrubber) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-s.trigger:
+		}
+
+		if err := s.scrub(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Scrub pass failed. Why: %v", err)
+		}
+	}
+}
+
+// Trigger starts a pass right away unless one is already queued.
+func (s *Scrubber) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scrubber) Status() ScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.status
+	st.Corrupt = make([]CorruptNeedle, 0, len(s.corrupt))
+	for _, c := range s.corrupt {
+		st.Corrupt = append(st.Corrupt, c)
+	}
+	return st
+}
+
+func (s *Scrubber) scrub(ctx context.Context) error {
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.NeedlesChecked = 0
+	s.status.BytesChecked = 0
+	s.status.LastPassStarted = time.Now()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.status.Running = false
+		s.status.CurrentVolume = nil
+		s.mu.Unlock()
+	}()
+
+	var found []CorruptNeedle
+	start := time.Now()
+	var scanned int64
+
+	for _, v := range s.store.Volumes() {
+		volumeID := uuid.UUID(v.ID())
+		s.mu.Lock()
+		s.status.CurrentVolume = &volumeID
+		s.mu.Unlock()
+
+		var next [16]byte
+		for more := true; more; {
+			batch := v.EntriesFrom(next, scrubBatchSize)
+			if len(batch) < scrubBatchSize {
+				more = false
+			} else {
+				next, more = needle.NextID(batch[len(batch)-1].ID)
+			}
+
+			for _, listed := range batch {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				// a vacuum since the batch was listed moves needles, Verify looks them up again
+				entry, err := v.Verify(listed.ID)
+				if errors.Is(err, needle.ErrNotFound) {
+					continue
+				}
+				if err != nil && errors.Is(err, needle.ErrCorrupted) {
+					c := CorruptNeedle{
+						VolumeID:   volumeID,
+						NeedleID:   entry.ID,
+						Reason:     err.Error(),
+						DetectedAt: time.Now(),
+					}
+					found = append(found, c)
+					log.Printf("Scrubber found corrupt needle %s in volume %s. Why: %v", c.NeedleID, volumeID, err)
+				} else if err != nil {
+					log.Printf("Scrubber couldn't verify needle %s in volume %s. Why: %v", uuid.UUID(entry.ID), volumeID, err)
+				}
+
+				size := int64(needle.NeedleFixedPortion) + int64(entry.Size)
+				scanned += size
+				s.mu.Lock()
+				s.status.NeedlesChecked++
+				s.status.BytesChecked += uint64(size)
+				s.mu.Unlock()
+
+				s.throttle(ctx, start, scanned)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.corrupt = make(map[[32]byte]CorruptNeedle, len(found))
+	for _, c := range found {
+		s.corrupt[needleKey(c.VolumeID, c.NeedleID)] = c
+	}
+	s.status.Passes++
+	s.status.LastPassFinished = time.Now()
+	s.mu.Unlock()
+
+	// a clean pass is reported too, it clears what the master kept from the last one
+	return s.report(ctx, found)
+}
+
+// throttle sleeps long enough to keep the pass under bytesPerSec.
+func (s *Scrubber) throttle(ctx context.Context, start time.Time, scanned int64) {
+	if s.bytesPerSec <= 0 {
+		return
+	}
+
+	due := time.Duration(float64(scanned) / float64(s.bytesPerSec) * float64(time.Second))
+	wait := due - time.Since(start)
+	if wait <= 0 {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
+func (s *Scrubber) report(ctx context.Context, found []CorruptNeedle) error {
+	needles := make([]*pb.CorruptNeedle, 0, len(found))
+	for _, c := range found {
+		needles = append(needles, &pb.CorruptNeedle{
+			VolumeId:   c.VolumeID[:],
+			NeedleId:   c.NeedleID[:],
+			Reason:     c.Reason,
+			DetectedAt: c.DetectedAt.Unix(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.master.Client.ReportCorruptNeedles(ctx, &pb.ReportCorruptNeedlesRequest{
+		ServerId: s.serverID[:],
+		Needles:  needles,
+	})
+	return err
+}
+
+func needleKey(volumeID, needleID uuid.UUID) [32]byte {
+	var k [32]byte
+	copy(k[:16], volumeID[:])
+	copy(k[16:], needleID[:])
+	return k
+}
@@ -2,11 +2,17 @@ package volume_server
 
 import (
 	"context"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rxanders35/graphene/pkg/auth"
+	"github.com/rxanders35/graphene/pkg/metrics"
+	"github.com/rxanders35/graphene/pkg/tlsconfig"
 	pb "github.com/rxanders35/graphene/proto"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"google.golang.org/grpc"
 )
 
@@ -18,41 +24,89 @@ type HTTPServer struct {
 	volumeHTTPaddr string
 	engine         *gin.Engine
 	handler        *VolumeHandler
+	adminHandler   *AdminHandler
+	access         *ClusterAccess
 	srv            *http.Server
 	grpcClient     *MasterClient
+	certs          *tlsconfig.Certs
 }
 
-func NewHTTPServer(v string, s StorageEngine, m *MasterClient, volSrvID uuid.UUID) (*HTTPServer, error) {
+func NewHTTPServer(v, grpcAddr string, s *Store, m *MasterClient, volSrvID uuid.UUID, scrubber *Scrubber, certs *tlsconfig.Certs, tokens *auth.TokenSecrets, trustedNames []string) (*HTTPServer, error) {
 	engine := gin.New()
-	engine.Use(gin.Logger(), gin.Recovery())
+	engine.ContextWithFallback = true
+	engine.Use(gin.Logger(), gin.Recovery(), metrics.Middleware(), otelgin.Middleware("volume_server"))
 
-	handler := NewVolumeHandler(s)
+	handler := NewVolumeHandler(s, tokens)
 
 	h := &HTTPServer{
 		volumeHTTPaddr: v,
 		engine:         engine,
 		handler:        handler,
+		adminHandler:   NewAdminHandler(s, scrubber, certs),
+		access:         NewClusterAccess(certs, tokens, trustedNames),
 		grpcClient:     m,
+		certs:          certs,
 	}
 	h.registerRoutes()
+	prometheus.MustRegister(storeCollector{store: s})
 
 	req := &pb.RegisterVolumeRequest{
-		HttpAddress: v,
-		VolumeId:    volSrvID[:],
+		HttpAddress:  v,
+		GrpcAddress:  grpcAddr,
+		VolumeId:     volSrvID[:],
+		Volumes:      volumeInfos(s),
+		StorageClass: m.storageClass,
 	}
 
-	m.Client.RegisterVolume(context.Background(), req, grpc.WaitForReady(true))
+	if _, err := m.Client.RegisterVolume(context.Background(), req, grpc.WaitForReady(true)); err != nil {
+		log.Printf("Failed to register with the master, heartbeats keep retrying. Why: %v", err)
+	}
 
 	return h, nil
 }
 
 func (h *HTTPServer) registerRoutes() {
+	h.engine.GET("/metrics", metrics.Handler())
+
 	v1 := h.engine.Group("/v1")
 
 	volume := v1.Group("/volume")
 
 	volume.POST("/write", h.handler.Write)
 	volume.GET("/read/:uuid", h.handler.Read)
+	volume.HEAD("/read/:uuid", h.handler.Stat)
+	volume.DELETE("/delete/:uuid", h.access.Require, h.handler.Delete)
+	volume.DELETE("/:volume_id", h.access.Require, h.handler.DropVolume)
+
+	admin := volume.Group("/admin", h.access.Require)
+
+	admin.GET("/scrub", h.adminHandler.ScrubStatus)
+	admin.POST("/scrub", h.adminHandler.StartScrub)
+	admin.GET("/cache", h.adminHandler.CacheStats)
+	admin.POST("/vacuum/:volume_id", h.adminHandler.Vacuum)
+	admin.POST("/pull/:volume_id", h.adminHandler.PullVolume)
+	admin.PUT("/volumes/:volume_id", h.adminHandler.CreateVolume)
+	admin.DELETE("/volumes/:volume_id", h.adminHandler.RemoveVolume)
+}
+
+func volumeInfos(s *Store) []*pb.VolumeInfo {
+	vols := s.Volumes()
+	infos := make([]*pb.VolumeInfo, 0, len(vols))
+	for _, v := range vols {
+		id := v.ID()
+		info := &pb.VolumeInfo{
+			VolumeId:  id[:],
+			ExpiresAt: v.MaxExpiry(),
+			ReadOnly:  v.ReadOnly(),
+		}
+		if st, err := v.Stats(); err == nil {
+			info.SizeBytes = st.SizeBytes
+			info.LiveBytes = st.LiveBytes
+			info.NeedleCount = st.NeedleCount
+		}
+		infos = append(infos, info)
+	}
+	return infos
 }
 
 func (h *HTTPServer) Run() error {
@@ -60,7 +114,7 @@ func (h *HTTPServer) Run() error {
 		Addr:    h.volumeHTTPaddr,
 		Handler: h.engine,
 	}
-	return h.srv.ListenAndServe()
+	return h.certs.ListenAndServe(h.srv)
 }
 
 func (h *HTTPServer) Shutdown(ctx context.Context) error {
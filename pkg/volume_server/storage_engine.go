@@ -1,8 +1,14 @@
 package volume_server
 
-import "github.com/google/uuid"
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
 
 type StorageEngine interface {
-	Write(id uuid.UUID, data []byte) error
-	Read(id uuid.UUID) ([]byte, error)
+	Write(ctx context.Context, id uuid.UUID, data []byte, expiresAt uint64) error
+	WriteOnce(ctx context.Context, id uuid.UUID, data []byte, expiresAt uint64) (bool, error)
+	WriteShared(ctx context.Context, data []byte) (uuid.UUID, bool, error)
+	Read(ctx context.Context, id uuid.UUID) ([]byte, error)
 }
@@ -0,0 +1,408 @@
+package volume_server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/volume_server/needle"
+)
+
+var (
+	ErrVolumeNotFound   = errors.New("volume not found")
+	ErrVolumeNotExpired = errors.New("volume still holds live needles")
+	ErrPrimaryVolume    = errors.New("cannot drop the primary volume")
+	ErrImportRunning    = errors.New("volume is already being copied in")
+)
+
+// Store holds every needle.Volume living in a volume server's data directory.
+// The primary volume shares its id with the volume server, extra volumes
+// (e.g. TTL volumes) are created on demand when the master assigns them.
+type Store struct {
+	dir       string
+	primary   uuid.UUID
+	indexKind needle.IndexKind // index backend for new volumes, existing ones keep theirs
+	volumes   map[uuid.UUID]*needle.Volume
+	importing map[uuid.UUID]bool
+	cache     *ReadCache
+	mu        sync.RWMutex
+}
+
+func NewStore(dir string, primary uuid.UUID, indexKind needle.IndexKind, cache *ReadCache) (*Store, error) {
+	s := &Store{
+		dir:       dir,
+		primary:   primary,
+		indexKind: indexKind,
+		volumes:   make(map[uuid.UUID]*needle.Volume),
+		importing: make(map[uuid.UUID]bool),
+		cache:     cache,
+	}
+
+	if err := s.loadVolumes(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.volumes[primary]; !ok {
+		v, err := s.openVolume(primary)
+		if err != nil {
+			return nil, err
+		}
+		s.volumes[primary] = v
+	}
+
+	return s, nil
+}
+
+func (s *Store) loadVolumes() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("could not scan data directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, needle.VolumeFilePrefix) {
+			continue
+		}
+		base, ok := strings.CutSuffix(name, needle.IdxFileExtension)
+		if !ok {
+			// a copied volume whose .dat was put in place but not its .idx yet, opening it finishes the job
+			if base, ok = strings.CutSuffix(name, needle.CompactIdxFileExtension); !ok || s.exists(base+needle.IdxFileExtension) || s.exists(base+needle.CompactDataFileExtension) {
+				continue
+			}
+		}
+
+		hexID := strings.TrimPrefix(base, needle.VolumeFilePrefix)
+		raw, err := hex.DecodeString(hexID)
+		if err != nil || len(raw) != 16 {
+			log.Printf("Skipping unrecognized volume file %s", name)
+			continue
+		}
+
+		var id uuid.UUID
+		copy(id[:], raw)
+		v, err := s.openVolume(id)
+		if err != nil {
+			return fmt.Errorf("could not load volume %s: %w", id, err)
+		}
+		s.volumes[id] = v
+	}
+	return nil
+}
+
+func (s *Store) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+func (s *Store) openVolume(id uuid.UUID) (*needle.Volume, error) {
+	return needle.NewVolume(s.dir, id, needle.DetectIndexKind(s.dir, id, s.indexKind))
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) Primary() uuid.UUID {
+	return s.primary
+}
+
+// Volume returns the volume with the given id. uuid.Nil resolves to the primary volume.
+func (s *Store) Volume(id uuid.UUID) (*needle.Volume, error) {
+	if id == uuid.Nil {
+		id = s.primary
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.volumes[id]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	return v, nil
+}
+
+// Read serves a needle from the read cache, falling back to the volume on a miss.
+func (s *Store) Read(ctx context.Context, volumeID, needleID uuid.UUID) ([]byte, error) {
+	v, err := s.Volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	volumeID = v.ID()
+
+	if data, ok := s.cache.Get(volumeID, needleID); ok {
+		volumeReadBytes.WithLabelValues(volumeID.String()).Add(float64(len(data)))
+		return data, nil
+	}
+
+	gen := s.cache.Generation()
+	data, err := v.Read(ctx, needleID)
+	if err != nil {
+		if errors.Is(err, needle.ErrCorrupted) {
+			volumeChecksumFailures.WithLabelValues(volumeID.String()).Inc()
+		}
+		return nil, err
+	}
+	if entry, ok := v.Lookup(needleID); ok {
+		s.cache.Add(gen, volumeID, needleID, data, entry.ExpiresAt)
+	}
+	volumeReadBytes.WithLabelValues(volumeID.String()).Add(float64(len(data)))
+	return data, nil
+}
+
+// Stat reports whether a volume holds a live needle, from its index alone.
+func (s *Store) Stat(volumeID, needleID uuid.UUID) error {
+	v, err := s.Volume(volumeID)
+	if err != nil {
+		return err
+	}
+
+	entry, ok := v.Lookup(needleID)
+	if !ok {
+		return needle.ErrNotFound
+	}
+	if entry.Expired(time.Now()) {
+		return needle.ErrExpired
+	}
+	return nil
+}
+
+// Delete removes a needle from a volume and evicts it from the read cache.
+func (s *Store) Delete(ctx context.Context, volumeID, needleID uuid.UUID) error {
+	v, err := s.Volume(volumeID)
+	if err != nil {
+		return err
+	}
+
+	if err := v.Delete(ctx, needleID); err != nil {
+		return err
+	}
+	s.cache.Invalidate(v.ID(), needleID)
+	return nil
+}
+
+// AppendCopied applies the tail of another copy of a volume, see needle.Volume.AppendCopied,
+// and evicts the needles it deleted or replaced from the read cache.
+func (s *Store) AppendCopied(v *needle.Volume, dataOffset int64, data []byte, idxOffset int64, idx []byte, refs []byte) error {
+	replaced, err := v.AppendCopied(dataOffset, data, idxOffset, idx, refs)
+	for _, needleID := range replaced {
+		s.cache.Invalidate(v.ID(), needleID)
+	}
+	return err
+}
+
+// Vacuum compacts a volume and returns its stats before and after.
+func (s *Store) Vacuum(volumeID uuid.UUID) (needle.VolumeStats, needle.VolumeStats, error) {
+	v, err := s.Volume(volumeID)
+	if err != nil {
+		return needle.VolumeStats{}, needle.VolumeStats{}, err
+	}
+
+	before, err := v.Stats()
+	if err != nil {
+		return needle.VolumeStats{}, needle.VolumeStats{}, err
+	}
+
+	start := time.Now()
+	if err := v.Compact(); err != nil {
+		return before, needle.VolumeStats{}, err
+	}
+
+	after, err := v.Stats()
+	if err != nil {
+		return before, needle.VolumeStats{}, err
+	}
+	log.Printf("Vacuumed volume %s in %s, %d -> %d bytes", uuid.UUID(v.ID()), time.Since(start).Round(time.Millisecond), before.SizeBytes, after.SizeBytes)
+
+	return before, after, nil
+}
+
+func (s *Store) CacheStats() CacheStats {
+	return s.cache.Stats()
+}
+
+// GetOrCreate returns the volume with the given id, creating its files if needed.
+func (s *Store) GetOrCreate(id uuid.UUID) (*needle.Volume, error) {
+	if v, err := s.Volume(id); err == nil {
+		return v, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.volumes[id]; ok {
+		return v, nil
+	}
+
+	v, err := s.openVolume(id)
+	if err != nil {
+		return nil, err
+	}
+	s.volumes[id] = v
+	log.Printf("Created volume %s", id)
+
+	return v, nil
+}
+
+// Drop removes a volume from disk once every needle in it has expired.
+func (s *Store) Drop(id uuid.UUID) error {
+	if id == s.primary {
+		return ErrPrimaryVolume
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[id]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+	if !v.Expired(time.Now()) {
+		return ErrVolumeNotExpired
+	}
+
+	if err := v.Destroy(); err != nil {
+		return err
+	}
+	delete(s.volumes, id)
+	s.cache.InvalidateVolume(id)
+	forgetVolume(id)
+	log.Printf("Dropped expired volume %s", id)
+
+	return nil
+}
+
+// OpenImport starts copying in a volume from another volume server, resuming the
+// copy an earlier attempt left staged. Finish with CommitImport or CloseImport.
+func (s *Store) OpenImport(id uuid.UUID) (*needle.VolumeImport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.volumes[id]; ok {
+		return nil, needle.ErrVolumeExists
+	}
+	if s.importing[id] {
+		return nil, ErrImportRunning
+	}
+
+	im, err := needle.OpenImport(s.dir, id)
+	if err != nil {
+		return nil, err
+	}
+	s.importing[id] = true
+	return im, nil
+}
+
+// CommitImport verifies a fully copied volume and adds it to the store.
+func (s *Store) CommitImport(id uuid.UUID, im *needle.VolumeImport) (*needle.Volume, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.importing, id)
+	if err := im.Commit(); err != nil {
+		return nil, err
+	}
+
+	v, err := s.openVolume(id)
+	if err != nil {
+		return nil, err
+	}
+	s.volumes[id] = v
+	log.Printf("Imported volume %s", id)
+
+	return v, nil
+}
+
+// CloseImport stops an unfinished import, keeping what was copied for the next
+// attempt unless discard is set.
+func (s *Store) CloseImport(id uuid.UUID, im *needle.VolumeImport, discard bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.importing, id)
+	if discard {
+		return im.Discard()
+	}
+	return im.Close()
+}
+
+// Remove deletes a volume that now lives on another volume server, primary or not.
+func (s *Store) Remove(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[id]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+	if err := v.Destroy(); err != nil {
+		return err
+	}
+	delete(s.volumes, id)
+	s.cache.InvalidateVolume(id)
+	forgetVolume(id)
+	log.Printf("Removed volume %s", id)
+
+	return nil
+}
+
+// Volumes returns a snapshot of every volume in the store.
+func (s *Store) Volumes() []*needle.Volume {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vols := make([]*needle.Volume, 0, len(s.volumes))
+	for _, v := range s.volumes {
+		vols = append(vols, v)
+	}
+	return vols
+}
+
+// RunCheckpoints checkpoints every volume's index each interval until ctx is done. A
+// zero interval leaves checkpoints to Close.
+func (s *Store) RunCheckpoints(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, v := range s.Volumes() {
+			if err := v.Checkpoint(); err != nil {
+				log.Printf("Failed to checkpoint index of volume %s. Why: %v", uuid.UUID(v.ID()), err)
+			}
+		}
+	}
+}
+
+// Close flushes and closes every volume.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, v := range s.volumes {
+		errs = append(errs, v.Close())
+	}
+	return errors.Join(errs...)
+}
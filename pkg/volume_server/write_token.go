@@ -0,0 +1,67 @@
+package volume_server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rxanders35/graphene/pkg/auth"
+)
+
+// authorizeWrite checks the write token of an upload to volumeId, answering the request
+// itself when it's missing or doesn't allow the upload. It returns the most bytes the
+// upload may have, 0 when tokens aren't required.
+func (v *VolumeHandler) authorizeWrite(c *gin.Context, volumeId uuid.UUID, ttl uint64) (int64, bool) {
+	if v.tokens == nil {
+		return 0, true
+	}
+
+	token := c.GetHeader(auth.WriteTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing " + auth.WriteTokenHeader + " header"})
+		return 0, false
+	}
+
+	now := time.Now()
+	claims, err := v.tokens.Verify(token, now)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return 0, false
+	}
+
+	if volumeId == uuid.Nil {
+		volumeId = v.store.Primary()
+	}
+	if claims.VolumeID != volumeId.String() || uint64(claims.TTLSeconds) != ttl {
+		c.JSON(http.StatusForbidden, gin.H{"error": "write token was issued for another volume or ttl"})
+		return 0, false
+	}
+	// keys are scoped by whoever got the token, a client can't pick another's
+	if claims.IdempotencyKey != c.GetHeader(idempotencyKeyHeader) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "write token was issued for another idempotency key"})
+		return 0, false
+	}
+	// the upload was placed by its hash, other data belongs elsewhere
+	if claims.ContentSHA256 != strings.ToLower(c.GetHeader(contentSHA256Header)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "write token was issued for other content"})
+		return 0, false
+	}
+	if c.Request.ContentLength > claims.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is larger than the write token allows"})
+		return 0, false
+	}
+
+	if !v.usedTokens.FirstUse(token, time.Unix(claims.ExpiresAt, 0), now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "write token was already used"})
+		return 0, false
+	}
+	return claims.MaxBytes, true
+}
+
+func isTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
@@ -0,0 +1,908 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v5.29.3
+// source: proto/ledger.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// LedgerServiceClient is the client API for LedgerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LedgerServiceClient interface {
+	// asks for a place to upload a new object (Ledger -> Master)
+	PrepareWrite(ctx context.Context, in *PrepareWriteRequest, opts ...grpc.CallOption) (*PrepareWriteResponse, error)
+	// commits metadata after a successful upload to a volume Server
+	Apply(ctx context.Context, in *ApplyRequest, opts ...grpc.CallOption) (*ApplyResponse, error)
+	// gets all the info needed to download an object, or one of its versions
+	GetObjectLocation(ctx context.Context, in *GetObjectLocationRequest, opts ...grpc.CallOption) (*GetObjectLocationResponse, error)
+	// marks an object for deletion, or removes one of its versions for good
+	DeleteObject(ctx context.Context, in *DeleteObjectRequest, opts ...grpc.CallOption) (*DeleteObjectResponse, error)
+	// queries a list of objects in a bucket
+	ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error)
+	// queries every version of the objects in a bucket, delete markers included
+	ListObjectVersions(ctx context.Context, in *ListObjectVersionsRequest, opts ...grpc.CallOption) (*ListObjectVersionsResponse, error)
+	// turns versioning of a bucket on or off and sets how many versions are kept
+	SetBucketVersioning(ctx context.Context, in *BucketVersioning, opts ...grpc.CallOption) (*BucketVersioning, error)
+	GetBucketVersioning(ctx context.Context, in *GetBucketVersioningRequest, opts ...grpc.CallOption) (*BucketVersioning, error)
+	// replaces the lifecycle rules of a bucket, no rules removes them
+	SetBucketLifecycle(ctx context.Context, in *BucketLifecycle, opts ...grpc.CallOption) (*BucketLifecycle, error)
+	GetBucketLifecycle(ctx context.Context, in *GetBucketLifecycleRequest, opts ...grpc.CallOption) (*BucketLifecycle, error)
+	// limits a bucket's usage and assigns it to the tenant whose quota it counts against
+	SetBucketQuota(ctx context.Context, in *BucketQuota, opts ...grpc.CallOption) (*BucketQuota, error)
+	GetBucketQuota(ctx context.Context, in *GetBucketQuotaRequest, opts ...grpc.CallOption) (*BucketQuota, error)
+	// limits the usage of every bucket assigned to a tenant together
+	SetTenantQuota(ctx context.Context, in *TenantQuota, opts ...grpc.CallOption) (*TenantQuota, error)
+	GetTenantQuota(ctx context.Context, in *GetTenantQuotaRequest, opts ...grpc.CallOption) (*TenantQuota, error)
+	// queries the quota and usage of every bucket and tenant
+	ListUsage(ctx context.Context, in *ListUsageRequest, opts ...grpc.CallOption) (*ListUsageResponse, error)
+}
+
+type ledgerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLedgerServiceClient(cc grpc.ClientConnInterface) LedgerServiceClient {
+	return &ledgerServiceClient{cc}
+}
+
+func (c *ledgerServiceClient) PrepareWrite(ctx context.Context, in *PrepareWriteRequest, opts ...grpc.CallOption) (*PrepareWriteResponse, error) {
+	out := new(PrepareWriteResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/PrepareWrite", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) Apply(ctx context.Context, in *ApplyRequest, opts ...grpc.CallOption) (*ApplyResponse, error) {
+	out := new(ApplyResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/Apply", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetObjectLocation(ctx context.Context, in *GetObjectLocationRequest, opts ...grpc.CallOption) (*GetObjectLocationResponse, error) {
+	out := new(GetObjectLocationResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/GetObjectLocation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) DeleteObject(ctx context.Context, in *DeleteObjectRequest, opts ...grpc.CallOption) (*DeleteObjectResponse, error) {
+	out := new(DeleteObjectResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/DeleteObject", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error) {
+	out := new(ListObjectsResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/ListObjects", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) ListObjectVersions(ctx context.Context, in *ListObjectVersionsRequest, opts ...grpc.CallOption) (*ListObjectVersionsResponse, error) {
+	out := new(ListObjectVersionsResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/ListObjectVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SetBucketVersioning(ctx context.Context, in *BucketVersioning, opts ...grpc.CallOption) (*BucketVersioning, error) {
+	out := new(BucketVersioning)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/SetBucketVersioning", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetBucketVersioning(ctx context.Context, in *GetBucketVersioningRequest, opts ...grpc.CallOption) (*BucketVersioning, error) {
+	out := new(BucketVersioning)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/GetBucketVersioning", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SetBucketLifecycle(ctx context.Context, in *BucketLifecycle, opts ...grpc.CallOption) (*BucketLifecycle, error) {
+	out := new(BucketLifecycle)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/SetBucketLifecycle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetBucketLifecycle(ctx context.Context, in *GetBucketLifecycleRequest, opts ...grpc.CallOption) (*BucketLifecycle, error) {
+	out := new(BucketLifecycle)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/GetBucketLifecycle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SetBucketQuota(ctx context.Context, in *BucketQuota, opts ...grpc.CallOption) (*BucketQuota, error) {
+	out := new(BucketQuota)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/SetBucketQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetBucketQuota(ctx context.Context, in *GetBucketQuotaRequest, opts ...grpc.CallOption) (*BucketQuota, error) {
+	out := new(BucketQuota)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/GetBucketQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) SetTenantQuota(ctx context.Context, in *TenantQuota, opts ...grpc.CallOption) (*TenantQuota, error) {
+	out := new(TenantQuota)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/SetTenantQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) GetTenantQuota(ctx context.Context, in *GetTenantQuotaRequest, opts ...grpc.CallOption) (*TenantQuota, error) {
+	out := new(TenantQuota)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/GetTenantQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerServiceClient) ListUsage(ctx context.Context, in *ListUsageRequest, opts ...grpc.CallOption) (*ListUsageResponse, error) {
+	out := new(ListUsageResponse)
+	err := c.cc.Invoke(ctx, "/cluster.LedgerService/ListUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LedgerServiceServer is the server API for LedgerService service.
+// All implementations must embed UnimplementedLedgerServiceServer
+// for forward compatibility
+type LedgerServiceServer interface {
+	// asks for a place to upload a new object (Ledger -> Master)
+	PrepareWrite(context.Context, *PrepareWriteRequest) (*PrepareWriteResponse, error)
+	// commits metadata after a successful upload to a volume Server
+	Apply(context.Context, *ApplyRequest) (*ApplyResponse, error)
+	// gets all the info needed to download an object, or one of its versions
+	GetObjectLocation(context.Context, *GetObjectLocationRequest) (*GetObjectLocationResponse, error)
+	// marks an object for deletion, or removes one of its versions for good
+	DeleteObject(context.Context, *DeleteObjectRequest) (*DeleteObjectResponse, error)
+	// queries a list of objects in a bucket
+	ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error)
+	// queries every version of the objects in a bucket, delete markers included
+	ListObjectVersions(context.Context, *ListObjectVersionsRequest) (*ListObjectVersionsResponse, error)
+	// turns versioning of a bucket on or off and sets how many versions are kept
+	SetBucketVersioning(context.Context, *BucketVersioning) (*BucketVersioning, error)
+	GetBucketVersioning(context.Context, *GetBucketVersioningRequest) (*BucketVersioning, error)
+	// replaces the lifecycle rules of a bucket, no rules removes them
+	SetBucketLifecycle(context.Context, *BucketLifecycle) (*BucketLifecycle, error)
+	GetBucketLifecycle(context.Context, *GetBucketLifecycleRequest) (*BucketLifecycle, error)
+	// limits a bucket's usage and assigns it to the tenant whose quota it counts against
+	SetBucketQuota(context.Context, *BucketQuota) (*BucketQuota, error)
+	GetBucketQuota(context.Context, *GetBucketQuotaRequest) (*BucketQuota, error)
+	// limits the usage of every bucket assigned to a tenant together
+	SetTenantQuota(context.Context, *TenantQuota) (*TenantQuota, error)
+	GetTenantQuota(context.Context, *GetTenantQuotaRequest) (*TenantQuota, error)
+	// queries the quota and usage of every bucket and tenant
+	ListUsage(context.Context, *ListUsageRequest) (*ListUsageResponse, error)
+	mustEmbedUnimplementedLedgerServiceServer()
+}
+
+// UnimplementedLedgerServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLedgerServiceServer struct {
+}
+
+func (UnimplementedLedgerServiceServer) PrepareWrite(context.Context, *PrepareWriteRequest) (*PrepareWriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PrepareWrite not implemented")
+}
+func (UnimplementedLedgerServiceServer) Apply(context.Context, *ApplyRequest) (*ApplyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Apply not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetObjectLocation(context.Context, *GetObjectLocationRequest) (*GetObjectLocationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetObjectLocation not implemented")
+}
+func (UnimplementedLedgerServiceServer) DeleteObject(context.Context, *DeleteObjectRequest) (*DeleteObjectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteObject not implemented")
+}
+func (UnimplementedLedgerServiceServer) ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListObjects not implemented")
+}
+func (UnimplementedLedgerServiceServer) ListObjectVersions(context.Context, *ListObjectVersionsRequest) (*ListObjectVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListObjectVersions not implemented")
+}
+func (UnimplementedLedgerServiceServer) SetBucketVersioning(context.Context, *BucketVersioning) (*BucketVersioning, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetBucketVersioning not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetBucketVersioning(context.Context, *GetBucketVersioningRequest) (*BucketVersioning, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketVersioning not implemented")
+}
+func (UnimplementedLedgerServiceServer) SetBucketLifecycle(context.Context, *BucketLifecycle) (*BucketLifecycle, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetBucketLifecycle not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetBucketLifecycle(context.Context, *GetBucketLifecycleRequest) (*BucketLifecycle, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketLifecycle not implemented")
+}
+func (UnimplementedLedgerServiceServer) SetBucketQuota(context.Context, *BucketQuota) (*BucketQuota, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetBucketQuota not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetBucketQuota(context.Context, *GetBucketQuotaRequest) (*BucketQuota, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketQuota not implemented")
+}
+func (UnimplementedLedgerServiceServer) SetTenantQuota(context.Context, *TenantQuota) (*TenantQuota, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTenantQuota not implemented")
+}
+func (UnimplementedLedgerServiceServer) GetTenantQuota(context.Context, *GetTenantQuotaRequest) (*TenantQuota, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTenantQuota not implemented")
+}
+func (UnimplementedLedgerServiceServer) ListUsage(context.Context, *ListUsageRequest) (*ListUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsage not implemented")
+}
+func (UnimplementedLedgerServiceServer) mustEmbedUnimplementedLedgerServiceServer() {}
+
+// UnsafeLedgerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LedgerServiceServer will
+// result in compilation errors.
+type UnsafeLedgerServiceServer interface {
+	mustEmbedUnimplementedLedgerServiceServer()
+}
+
+func RegisterLedgerServiceServer(s grpc.ServiceRegistrar, srv LedgerServiceServer) {
+	s.RegisterService(&LedgerService_ServiceDesc, srv)
+}
+
+func _LedgerService_PrepareWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrepareWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).PrepareWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/PrepareWrite",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).PrepareWrite(ctx, req.(*PrepareWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_Apply_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).Apply(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/Apply",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).Apply(ctx, req.(*ApplyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetObjectLocation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetObjectLocationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetObjectLocation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/GetObjectLocation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetObjectLocation(ctx, req.(*GetObjectLocationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_DeleteObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteObjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).DeleteObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/DeleteObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).DeleteObject(ctx, req.(*DeleteObjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_ListObjects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListObjectsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).ListObjects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/ListObjects",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).ListObjects(ctx, req.(*ListObjectsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_ListObjectVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListObjectVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).ListObjectVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/ListObjectVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).ListObjectVersions(ctx, req.(*ListObjectVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SetBucketVersioning_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketVersioning)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SetBucketVersioning(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/SetBucketVersioning",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SetBucketVersioning(ctx, req.(*BucketVersioning))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetBucketVersioning_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBucketVersioningRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetBucketVersioning(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/GetBucketVersioning",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetBucketVersioning(ctx, req.(*GetBucketVersioningRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SetBucketLifecycle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketLifecycle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SetBucketLifecycle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/SetBucketLifecycle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SetBucketLifecycle(ctx, req.(*BucketLifecycle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetBucketLifecycle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBucketLifecycleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetBucketLifecycle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/GetBucketLifecycle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetBucketLifecycle(ctx, req.(*GetBucketLifecycleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SetBucketQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketQuota)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SetBucketQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/SetBucketQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SetBucketQuota(ctx, req.(*BucketQuota))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetBucketQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBucketQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetBucketQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/GetBucketQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetBucketQuota(ctx, req.(*GetBucketQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_SetTenantQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TenantQuota)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).SetTenantQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/SetTenantQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).SetTenantQuota(ctx, req.(*TenantQuota))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_GetTenantQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTenantQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).GetTenantQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/GetTenantQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).GetTenantQuota(ctx, req.(*GetTenantQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LedgerService_ListUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServiceServer).ListUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.LedgerService/ListUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServiceServer).ListUsage(ctx, req.(*ListUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LedgerService_ServiceDesc is the grpc.ServiceDesc for LedgerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LedgerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cluster.LedgerService",
+	HandlerType: (*LedgerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PrepareWrite",
+			Handler:    _LedgerService_PrepareWrite_Handler,
+		},
+		{
+			MethodName: "Apply",
+			Handler:    _LedgerService_Apply_Handler,
+		},
+		{
+			MethodName: "GetObjectLocation",
+			Handler:    _LedgerService_GetObjectLocation_Handler,
+		},
+		{
+			MethodName: "DeleteObject",
+			Handler:    _LedgerService_DeleteObject_Handler,
+		},
+		{
+			MethodName: "ListObjects",
+			Handler:    _LedgerService_ListObjects_Handler,
+		},
+		{
+			MethodName: "ListObjectVersions",
+			Handler:    _LedgerService_ListObjectVersions_Handler,
+		},
+		{
+			MethodName: "SetBucketVersioning",
+			Handler:    _LedgerService_SetBucketVersioning_Handler,
+		},
+		{
+			MethodName: "GetBucketVersioning",
+			Handler:    _LedgerService_GetBucketVersioning_Handler,
+		},
+		{
+			MethodName: "SetBucketLifecycle",
+			Handler:    _LedgerService_SetBucketLifecycle_Handler,
+		},
+		{
+			MethodName: "GetBucketLifecycle",
+			Handler:    _LedgerService_GetBucketLifecycle_Handler,
+		},
+		{
+			MethodName: "SetBucketQuota",
+			Handler:    _LedgerService_SetBucketQuota_Handler,
+		},
+		{
+			MethodName: "GetBucketQuota",
+			Handler:    _LedgerService_GetBucketQuota_Handler,
+		},
+		{
+			MethodName: "SetTenantQuota",
+			Handler:    _LedgerService_SetTenantQuota_Handler,
+		},
+		{
+			MethodName: "GetTenantQuota",
+			Handler:    _LedgerService_GetTenantQuota_Handler,
+		},
+		{
+			MethodName: "ListUsage",
+			Handler:    _LedgerService_ListUsage_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/ledger.proto",
+}
+
+// DedupServiceClient is the client API for DedupService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DedupServiceClient interface {
+	// references the needle already holding the content, or registers the uploaded one
+	AddReference(ctx context.Context, in *AddReferenceRequest, opts ...grpc.CallOption) (*AddReferenceResponse, error)
+	// drops an upload's reference, the caller deletes the needle once none are left
+	ReleaseReference(ctx context.Context, in *ReleaseReferenceRequest, opts ...grpc.CallOption) (*ReleaseReferenceResponse, error)
+	// reports whether an upload's reference is still held, without changing it
+	LookupReference(ctx context.Context, in *LookupReferenceRequest, opts ...grpc.CallOption) (*LookupReferenceResponse, error)
+}
+
+type dedupServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDedupServiceClient(cc grpc.ClientConnInterface) DedupServiceClient {
+	return &dedupServiceClient{cc}
+}
+
+func (c *dedupServiceClient) AddReference(ctx context.Context, in *AddReferenceRequest, opts ...grpc.CallOption) (*AddReferenceResponse, error) {
+	out := new(AddReferenceResponse)
+	err := c.cc.Invoke(ctx, "/cluster.DedupService/AddReference", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dedupServiceClient) ReleaseReference(ctx context.Context, in *ReleaseReferenceRequest, opts ...grpc.CallOption) (*ReleaseReferenceResponse, error) {
+	out := new(ReleaseReferenceResponse)
+	err := c.cc.Invoke(ctx, "/cluster.DedupService/ReleaseReference", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dedupServiceClient) LookupReference(ctx context.Context, in *LookupReferenceRequest, opts ...grpc.CallOption) (*LookupReferenceResponse, error) {
+	out := new(LookupReferenceResponse)
+	err := c.cc.Invoke(ctx, "/cluster.DedupService/LookupReference", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DedupServiceServer is the server API for DedupService service.
+// All implementations must embed UnimplementedDedupServiceServer
+// for forward compatibility
+type DedupServiceServer interface {
+	// references the needle already holding the content, or registers the uploaded one
+	AddReference(context.Context, *AddReferenceRequest) (*AddReferenceResponse, error)
+	// drops an upload's reference, the caller deletes the needle once none are left
+	ReleaseReference(context.Context, *ReleaseReferenceRequest) (*ReleaseReferenceResponse, error)
+	// reports whether an upload's reference is still held, without changing it
+	LookupReference(context.Context, *LookupReferenceRequest) (*LookupReferenceResponse, error)
+	mustEmbedUnimplementedDedupServiceServer()
+}
+
+// UnimplementedDedupServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDedupServiceServer struct {
+}
+
+func (UnimplementedDedupServiceServer) AddReference(context.Context, *AddReferenceRequest) (*AddReferenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddReference not implemented")
+}
+func (UnimplementedDedupServiceServer) ReleaseReference(context.Context, *ReleaseReferenceRequest) (*ReleaseReferenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseReference not implemented")
+}
+func (UnimplementedDedupServiceServer) LookupReference(context.Context, *LookupReferenceRequest) (*LookupReferenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupReference not implemented")
+}
+func (UnimplementedDedupServiceServer) mustEmbedUnimplementedDedupServiceServer() {}
+
+// UnsafeDedupServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DedupServiceServer will
+// result in compilation errors.
+type UnsafeDedupServiceServer interface {
+	mustEmbedUnimplementedDedupServiceServer()
+}
+
+func RegisterDedupServiceServer(s grpc.ServiceRegistrar, srv DedupServiceServer) {
+	s.RegisterService(&DedupService_ServiceDesc, srv)
+}
+
+func _DedupService_AddReference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddReferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DedupServiceServer).AddReference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.DedupService/AddReference",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DedupServiceServer).AddReference(ctx, req.(*AddReferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DedupService_ReleaseReference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseReferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DedupServiceServer).ReleaseReference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.DedupService/ReleaseReference",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DedupServiceServer).ReleaseReference(ctx, req.(*ReleaseReferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DedupService_LookupReference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupReferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DedupServiceServer).LookupReference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.DedupService/LookupReference",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DedupServiceServer).LookupReference(ctx, req.(*LookupReferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DedupService_ServiceDesc is the grpc.ServiceDesc for DedupService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DedupService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cluster.DedupService",
+	HandlerType: (*DedupServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddReference",
+			Handler:    _DedupService_AddReference_Handler,
+		},
+		{
+			MethodName: "ReleaseReference",
+			Handler:    _DedupService_ReleaseReference_Handler,
+		},
+		{
+			MethodName: "LookupReference",
+			Handler:    _DedupService_LookupReference_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/ledger.proto",
+}
+
+// EventServiceClient is the client API for EventService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventServiceClient interface {
+	Subscribe(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (EventService_SubscribeClient, error)
+}
+
+type eventServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventServiceClient(cc grpc.ClientConnInterface) EventServiceClient {
+	return &eventServiceClient{cc}
+}
+
+func (c *eventServiceClient) Subscribe(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (EventService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EventService_ServiceDesc.Streams[0], "/cluster.EventService/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EventService_SubscribeClient interface {
+	Recv() (*ObjectEvent, error)
+	grpc.ClientStream
+}
+
+type eventServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *eventServiceSubscribeClient) Recv() (*ObjectEvent, error) {
+	m := new(ObjectEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EventServiceServer is the server API for EventService service.
+// All implementations must embed UnimplementedEventServiceServer
+// for forward compatibility
+type EventServiceServer interface {
+	Subscribe(*SubscribeEventsRequest, EventService_SubscribeServer) error
+	mustEmbedUnimplementedEventServiceServer()
+}
+
+// UnimplementedEventServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedEventServiceServer struct {
+}
+
+func (UnimplementedEventServiceServer) Subscribe(*SubscribeEventsRequest, EventService_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedEventServiceServer) mustEmbedUnimplementedEventServiceServer() {}
+
+// UnsafeEventServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventServiceServer will
+// result in compilation errors.
+type UnsafeEventServiceServer interface {
+	mustEmbedUnimplementedEventServiceServer()
+}
+
+func RegisterEventServiceServer(s grpc.ServiceRegistrar, srv EventServiceServer) {
+	s.RegisterService(&EventService_ServiceDesc, srv)
+}
+
+func _EventService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventServiceServer).Subscribe(m, &eventServiceSubscribeServer{stream})
+}
+
+type EventService_SubscribeServer interface {
+	Send(*ObjectEvent) error
+	grpc.ServerStream
+}
+
+type eventServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *eventServiceSubscribeServer) Send(m *ObjectEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// EventService_ServiceDesc is the grpc.ServiceDesc for EventService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cluster.EventService",
+	HandlerType: (*EventServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _EventService_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/ledger.proto",
+}
@@ -25,6 +25,20 @@ type MasterServiceClient interface {
 	RegisterVolume(ctx context.Context, in *RegisterVolumeRequest, opts ...grpc.CallOption) (*RegisterVolumeResponse, error)
 	AssignVolume(ctx context.Context, in *AssignVolumeRequest, opts ...grpc.CallOption) (*AssignVolumeResponse, error)
 	GetVolumeLocation(ctx context.Context, in *GetVolumeLocationRequest, opts ...grpc.CallOption) (*GetVolumeLocationResponse, error)
+	ReportCorruptNeedles(ctx context.Context, in *ReportCorruptNeedlesRequest, opts ...grpc.CallOption) (*ReportCorruptNeedlesResponse, error)
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// streams the full volume -> location map, then every change to it
+	WatchTopology(ctx context.Context, in *WatchTopologyRequest, opts ...grpc.CallOption) (MasterService_WatchTopologyClient, error)
+	// admin, ids are uuid strings so the JSON twins of these stay readable
+	ListVolumeServers(ctx context.Context, in *ListVolumeServersRequest, opts ...grpc.CallOption) (*ListVolumeServersResponse, error)
+	ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error)
+	DescribeVolume(ctx context.Context, in *DescribeVolumeRequest, opts ...grpc.CallOption) (*VolumeStatus, error)
+	GetClusterStatus(ctx context.Context, in *GetClusterStatusRequest, opts ...grpc.CallOption) (*ClusterStatus, error)
+	VacuumVolume(ctx context.Context, in *VacuumVolumeRequest, opts ...grpc.CallOption) (*VacuumVolumeResponse, error)
+	SealVolume(ctx context.Context, in *SealVolumeRequest, opts ...grpc.CallOption) (*VolumeStatus, error)
+	DrainServer(ctx context.Context, in *DrainServerRequest, opts ...grpc.CallOption) (*VolumeServerStatus, error)
+	CheckCluster(ctx context.Context, in *CheckClusterRequest, opts ...grpc.CallOption) (*CheckClusterResponse, error)
+	RebalanceVolumes(ctx context.Context, in *RebalanceVolumesRequest, opts ...grpc.CallOption) (*RebalancePlan, error)
 }
 
 type masterServiceClient struct {
@@ -62,6 +76,137 @@ func (c *masterServiceClient) GetVolumeLocation(ctx context.Context, in *GetVolu
 	return out, nil
 }
 
+func (c *masterServiceClient) ReportCorruptNeedles(ctx context.Context, in *ReportCorruptNeedlesRequest, opts ...grpc.CallOption) (*ReportCorruptNeedlesResponse, error) {
+	out := new(ReportCorruptNeedlesResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/ReportCorruptNeedles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) WatchTopology(ctx context.Context, in *WatchTopologyRequest, opts ...grpc.CallOption) (MasterService_WatchTopologyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MasterService_ServiceDesc.Streams[0], "/cluster.MasterService/WatchTopology", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &masterServiceWatchTopologyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MasterService_WatchTopologyClient interface {
+	Recv() (*TopologyEvent, error)
+	grpc.ClientStream
+}
+
+type masterServiceWatchTopologyClient struct {
+	grpc.ClientStream
+}
+
+func (x *masterServiceWatchTopologyClient) Recv() (*TopologyEvent, error) {
+	m := new(TopologyEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *masterServiceClient) ListVolumeServers(ctx context.Context, in *ListVolumeServersRequest, opts ...grpc.CallOption) (*ListVolumeServersResponse, error) {
+	out := new(ListVolumeServersResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/ListVolumeServers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error) {
+	out := new(ListVolumesResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/ListVolumes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) DescribeVolume(ctx context.Context, in *DescribeVolumeRequest, opts ...grpc.CallOption) (*VolumeStatus, error) {
+	out := new(VolumeStatus)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/DescribeVolume", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) GetClusterStatus(ctx context.Context, in *GetClusterStatusRequest, opts ...grpc.CallOption) (*ClusterStatus, error) {
+	out := new(ClusterStatus)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/GetClusterStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) VacuumVolume(ctx context.Context, in *VacuumVolumeRequest, opts ...grpc.CallOption) (*VacuumVolumeResponse, error) {
+	out := new(VacuumVolumeResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/VacuumVolume", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) SealVolume(ctx context.Context, in *SealVolumeRequest, opts ...grpc.CallOption) (*VolumeStatus, error) {
+	out := new(VolumeStatus)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/SealVolume", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) DrainServer(ctx context.Context, in *DrainServerRequest, opts ...grpc.CallOption) (*VolumeServerStatus, error) {
+	out := new(VolumeServerStatus)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/DrainServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) CheckCluster(ctx context.Context, in *CheckClusterRequest, opts ...grpc.CallOption) (*CheckClusterResponse, error) {
+	out := new(CheckClusterResponse)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/CheckCluster", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterServiceClient) RebalanceVolumes(ctx context.Context, in *RebalanceVolumesRequest, opts ...grpc.CallOption) (*RebalancePlan, error) {
+	out := new(RebalancePlan)
+	err := c.cc.Invoke(ctx, "/cluster.MasterService/RebalanceVolumes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MasterServiceServer is the server API for MasterService service.
 // All implementations must embed UnimplementedMasterServiceServer
 // for forward compatibility
@@ -69,6 +214,20 @@ type MasterServiceServer interface {
 	RegisterVolume(context.Context, *RegisterVolumeRequest) (*RegisterVolumeResponse, error)
 	AssignVolume(context.Context, *AssignVolumeRequest) (*AssignVolumeResponse, error)
 	GetVolumeLocation(context.Context, *GetVolumeLocationRequest) (*GetVolumeLocationResponse, error)
+	ReportCorruptNeedles(context.Context, *ReportCorruptNeedlesRequest) (*ReportCorruptNeedlesResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// streams the full volume -> location map, then every change to it
+	WatchTopology(*WatchTopologyRequest, MasterService_WatchTopologyServer) error
+	// admin, ids are uuid strings so the JSON twins of these stay readable
+	ListVolumeServers(context.Context, *ListVolumeServersRequest) (*ListVolumeServersResponse, error)
+	ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error)
+	DescribeVolume(context.Context, *DescribeVolumeRequest) (*VolumeStatus, error)
+	GetClusterStatus(context.Context, *GetClusterStatusRequest) (*ClusterStatus, error)
+	VacuumVolume(context.Context, *VacuumVolumeRequest) (*VacuumVolumeResponse, error)
+	SealVolume(context.Context, *SealVolumeRequest) (*VolumeStatus, error)
+	DrainServer(context.Context, *DrainServerRequest) (*VolumeServerStatus, error)
+	CheckCluster(context.Context, *CheckClusterRequest) (*CheckClusterResponse, error)
+	RebalanceVolumes(context.Context, *RebalanceVolumesRequest) (*RebalancePlan, error)
 	mustEmbedUnimplementedMasterServiceServer()
 }
 
@@ -85,6 +244,42 @@ func (UnimplementedMasterServiceServer) AssignVolume(context.Context, *AssignVol
 func (UnimplementedMasterServiceServer) GetVolumeLocation(context.Context, *GetVolumeLocationRequest) (*GetVolumeLocationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetVolumeLocation not implemented")
 }
+func (UnimplementedMasterServiceServer) ReportCorruptNeedles(context.Context, *ReportCorruptNeedlesRequest) (*ReportCorruptNeedlesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportCorruptNeedles not implemented")
+}
+func (UnimplementedMasterServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedMasterServiceServer) WatchTopology(*WatchTopologyRequest, MasterService_WatchTopologyServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTopology not implemented")
+}
+func (UnimplementedMasterServiceServer) ListVolumeServers(context.Context, *ListVolumeServersRequest) (*ListVolumeServersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVolumeServers not implemented")
+}
+func (UnimplementedMasterServiceServer) ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVolumes not implemented")
+}
+func (UnimplementedMasterServiceServer) DescribeVolume(context.Context, *DescribeVolumeRequest) (*VolumeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeVolume not implemented")
+}
+func (UnimplementedMasterServiceServer) GetClusterStatus(context.Context, *GetClusterStatusRequest) (*ClusterStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClusterStatus not implemented")
+}
+func (UnimplementedMasterServiceServer) VacuumVolume(context.Context, *VacuumVolumeRequest) (*VacuumVolumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VacuumVolume not implemented")
+}
+func (UnimplementedMasterServiceServer) SealVolume(context.Context, *SealVolumeRequest) (*VolumeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SealVolume not implemented")
+}
+func (UnimplementedMasterServiceServer) DrainServer(context.Context, *DrainServerRequest) (*VolumeServerStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainServer not implemented")
+}
+func (UnimplementedMasterServiceServer) CheckCluster(context.Context, *CheckClusterRequest) (*CheckClusterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckCluster not implemented")
+}
+func (UnimplementedMasterServiceServer) RebalanceVolumes(context.Context, *RebalanceVolumesRequest) (*RebalancePlan, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RebalanceVolumes not implemented")
+}
 func (UnimplementedMasterServiceServer) mustEmbedUnimplementedMasterServiceServer() {}
 
 // UnsafeMasterServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -152,6 +347,225 @@ func _MasterService_GetVolumeLocation_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MasterService_ReportCorruptNeedles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportCorruptNeedlesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).ReportCorruptNeedles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/ReportCorruptNeedles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).ReportCorruptNeedles(ctx, req.(*ReportCorruptNeedlesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_WatchTopology_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTopologyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MasterServiceServer).WatchTopology(m, &masterServiceWatchTopologyServer{stream})
+}
+
+type MasterService_WatchTopologyServer interface {
+	Send(*TopologyEvent) error
+	grpc.ServerStream
+}
+
+type masterServiceWatchTopologyServer struct {
+	grpc.ServerStream
+}
+
+func (x *masterServiceWatchTopologyServer) Send(m *TopologyEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MasterService_ListVolumeServers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVolumeServersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).ListVolumeServers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/ListVolumeServers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).ListVolumeServers(ctx, req.(*ListVolumeServersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_ListVolumes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVolumesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).ListVolumes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/ListVolumes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).ListVolumes(ctx, req.(*ListVolumesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_DescribeVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).DescribeVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/DescribeVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).DescribeVolume(ctx, req.(*DescribeVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_GetClusterStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetClusterStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).GetClusterStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/GetClusterStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).GetClusterStatus(ctx, req.(*GetClusterStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_VacuumVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VacuumVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).VacuumVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/VacuumVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).VacuumVolume(ctx, req.(*VacuumVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_SealVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SealVolumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).SealVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/SealVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).SealVolume(ctx, req.(*SealVolumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_DrainServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).DrainServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/DrainServer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).DrainServer(ctx, req.(*DrainServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_CheckCluster_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).CheckCluster(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/CheckCluster",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).CheckCluster(ctx, req.(*CheckClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MasterService_RebalanceVolumes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RebalanceVolumesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServiceServer).RebalanceVolumes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cluster.MasterService/RebalanceVolumes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServiceServer).RebalanceVolumes(ctx, req.(*RebalanceVolumesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MasterService_ServiceDesc is the grpc.ServiceDesc for MasterService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -171,7 +585,174 @@ var MasterService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetVolumeLocation",
 			Handler:    _MasterService_GetVolumeLocation_Handler,
 		},
+		{
+			MethodName: "ReportCorruptNeedles",
+			Handler:    _MasterService_ReportCorruptNeedles_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _MasterService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "ListVolumeServers",
+			Handler:    _MasterService_ListVolumeServers_Handler,
+		},
+		{
+			MethodName: "ListVolumes",
+			Handler:    _MasterService_ListVolumes_Handler,
+		},
+		{
+			MethodName: "DescribeVolume",
+			Handler:    _MasterService_DescribeVolume_Handler,
+		},
+		{
+			MethodName: "GetClusterStatus",
+			Handler:    _MasterService_GetClusterStatus_Handler,
+		},
+		{
+			MethodName: "VacuumVolume",
+			Handler:    _MasterService_VacuumVolume_Handler,
+		},
+		{
+			MethodName: "SealVolume",
+			Handler:    _MasterService_SealVolume_Handler,
+		},
+		{
+			MethodName: "DrainServer",
+			Handler:    _MasterService_DrainServer_Handler,
+		},
+		{
+			MethodName: "CheckCluster",
+			Handler:    _MasterService_CheckCluster_Handler,
+		},
+		{
+			MethodName: "RebalanceVolumes",
+			Handler:    _MasterService_RebalanceVolumes_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTopology",
+			Handler:       _MasterService_WatchTopology_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/transport.proto",
+}
+
+// VolumeServiceClient is the client API for VolumeService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VolumeServiceClient interface {
+	// streams a consistent snapshot of a volume: a header with the sizes, then its .idx
+	// from idx_offset, then its .dat from data_offset
+	ExportVolume(ctx context.Context, in *ExportVolumeRequest, opts ...grpc.CallOption) (VolumeService_ExportVolumeClient, error)
+}
+
+type volumeServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVolumeServiceClient(cc grpc.ClientConnInterface) VolumeServiceClient {
+	return &volumeServiceClient{cc}
+}
+
+func (c *volumeServiceClient) ExportVolume(ctx context.Context, in *ExportVolumeRequest, opts ...grpc.CallOption) (VolumeService_ExportVolumeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &VolumeService_ServiceDesc.Streams[0], "/cluster.VolumeService/ExportVolume", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &volumeServiceExportVolumeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type VolumeService_ExportVolumeClient interface {
+	Recv() (*VolumeChunk, error)
+	grpc.ClientStream
+}
+
+type volumeServiceExportVolumeClient struct {
+	grpc.ClientStream
+}
+
+func (x *volumeServiceExportVolumeClient) Recv() (*VolumeChunk, error) {
+	m := new(VolumeChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VolumeServiceServer is the server API for VolumeService service.
+// All implementations must embed UnimplementedVolumeServiceServer
+// for forward compatibility
+type VolumeServiceServer interface {
+	// streams a consistent snapshot of a volume: a header with the sizes, then its .idx
+	// from idx_offset, then its .dat from data_offset
+	ExportVolume(*ExportVolumeRequest, VolumeService_ExportVolumeServer) error
+	mustEmbedUnimplementedVolumeServiceServer()
+}
+
+// UnimplementedVolumeServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedVolumeServiceServer struct {
+}
+
+func (UnimplementedVolumeServiceServer) ExportVolume(*ExportVolumeRequest, VolumeService_ExportVolumeServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportVolume not implemented")
+}
+func (UnimplementedVolumeServiceServer) mustEmbedUnimplementedVolumeServiceServer() {}
+
+// UnsafeVolumeServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VolumeServiceServer will
+// result in compilation errors.
+type UnsafeVolumeServiceServer interface {
+	mustEmbedUnimplementedVolumeServiceServer()
+}
+
+func RegisterVolumeServiceServer(s grpc.ServiceRegistrar, srv VolumeServiceServer) {
+	s.RegisterService(&VolumeService_ServiceDesc, srv)
+}
+
+func _VolumeService_ExportVolume_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportVolumeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VolumeServiceServer).ExportVolume(m, &volumeServiceExportVolumeServer{stream})
+}
+
+type VolumeService_ExportVolumeServer interface {
+	Send(*VolumeChunk) error
+	grpc.ServerStream
+}
+
+type volumeServiceExportVolumeServer struct {
+	grpc.ServerStream
+}
+
+func (x *volumeServiceExportVolumeServer) Send(m *VolumeChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// VolumeService_ServiceDesc is the grpc.ServiceDesc for VolumeService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VolumeService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cluster.VolumeService",
+	HandlerType: (*VolumeServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportVolume",
+			Handler:       _VolumeService_ExportVolume_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/transport.proto",
 }